@@ -0,0 +1,79 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+// Package archive writes exported chats to a bagoup archive, a SQLite database
+// with a documented schema (see schema.sql) and a full-text search index, and
+// reads them back as a chatdb.ChatDB, so that they can be exported again
+// without the original chat.db.
+//
+// The full-text search index requires SQLite's FTS5 extension, which is
+// built into bagoup with the sqlite_fts5 build tag.
+package archive
+
+import (
+	"database/sql"
+	_ "embed"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/tagatac/bagoup/v2/chatdb"
+	"github.com/tagatac/bagoup/v2/opsys"
+)
+
+// SchemaVersion is the version of the archive schema written by this version
+// of bagoup.
+const SchemaVersion = "1"
+
+//go:embed schema.sql
+var _schema string
+
+//go:generate mockgen -destination=mock_archive/mock_archive.go github.com/tagatac/bagoup/v2/archive Archive
+
+type (
+	// Archive adds exported chats to a bagoup archive.
+	Archive interface {
+		// NewOutFile returns an OutFile which adds the messages of the given
+		// chats of the entity to the archive when flushed.
+		NewOutFile(entity chatdb.EntityChats, guids []string) opsys.OutFile
+		// Close closes the database of the archive.
+		Close() error
+	}
+
+	archive struct {
+		*sql.DB
+		path string
+	}
+)
+
+// Create creates a bagoup archive at the given path, recording the version of
+// bagoup writing it.
+func Create(path, bagoupVersion string) (Archive, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("open archive %q: %w", path, err)
+	}
+	a := &archive{DB: db, path: path}
+	if err := a.init(bagoupVersion, time.Now()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *archive) init(bagoupVersion string, created time.Time) error {
+	if _, err := a.DB.Exec(_schema); err != nil {
+		if strings.Contains(err.Error(), "no such module: fts5") {
+			return fmt.Errorf("create archive schema - FIX: build bagoup with the sqlite_fts5 build tag: %w", err)
+		}
+		return fmt.Errorf("create archive schema: %w", err)
+	}
+	if _, err := a.DB.Exec(
+		"INSERT INTO metadata (key, value) VALUES ('schema_version', ?), ('generator', ?), ('created', ?)",
+		SchemaVersion, fmt.Sprintf("bagoup %s", bagoupVersion), created.Format(time.RFC3339),
+	); err != nil {
+		return fmt.Errorf("write archive metadata: %w", err)
+	}
+	return nil
+}
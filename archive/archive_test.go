@@ -0,0 +1,75 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package archive
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"gotest.tools/v3/assert"
+)
+
+func TestInit(t *testing.T) {
+	created := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	const metadataQuery = "INSERT INTO metadata (key, value) VALUES ('schema_version', ?), ('generator', ?), ('created', ?)"
+
+	tests := []struct {
+		msg        string
+		setupMocks func(sqlmock.Sqlmock)
+		wantErr    string
+	}{
+		{
+			msg: "success",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectExec(regexp.QuoteMeta(_schema)).WillReturnResult(sqlmock.NewResult(0, 0))
+				sMock.ExpectExec(regexp.QuoteMeta(metadataQuery)).
+					WithArgs("1", "bagoup v1.2.3", "2026-10-18T12:00:00Z").
+					WillReturnResult(sqlmock.NewResult(0, 3))
+			},
+		},
+		{
+			msg: "no FTS5",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectExec("CREATE TABLE metadata").WillReturnError(errors.New("no such module: fts5"))
+			},
+			wantErr: "create archive schema - FIX: build bagoup with the sqlite_fts5 build tag: no such module: fts5",
+		},
+		{
+			msg: "schema error",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectExec("CREATE TABLE metadata").WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "create archive schema: this is a DB error",
+		},
+		{
+			msg: "metadata error",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectExec("CREATE TABLE metadata").WillReturnResult(sqlmock.NewResult(0, 0))
+				sMock.ExpectExec("INSERT INTO metadata").WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "write archive metadata: this is a DB error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			tt.setupMocks(sMock)
+			a := &archive{DB: db, path: "messages.sqlite"}
+
+			err = a.init("v1.2.3", created)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.NilError(t, sMock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package archive
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/emersion/go-vcard"
+	"github.com/tagatac/bagoup/v2/chatdb"
+	"github.com/tagatac/bagoup/v2/pathtools"
+)
+
+// archiveDB reads the chats of a bagoup archive. The names of the entities
+// and senders are those resolved when the archive was written, so contacts
+// and aliases are ignored.
+type archiveDB struct {
+	*sql.DB
+	selfHandle string
+	loc        *time.Location
+}
+
+// NewChatDB returns a ChatDB reading the bagoup archive in the given DB. Init
+// must be called on it before use. Messages from me are attributed to the
+// self handle.
+func NewChatDB(db *sql.DB, selfHandle string) chatdb.ChatDB {
+	return &archiveDB{DB: db, selfHandle: selfHandle}
+}
+
+// Init checks that the archive has a schema which can be read. The version of
+// macOS is not needed.
+func (d *archiveDB) Init(_ *semver.Version, loc *time.Location) error {
+	d.loc = loc
+	var version string
+	if err := d.DB.QueryRow("SELECT value FROM metadata WHERE key = 'schema_version'").Scan(&version); err != nil {
+		return fmt.Errorf("get archive schema version - FIX: render an archive written with the --format sqlite flag: %w", err)
+	}
+	if version != SchemaVersion {
+		return fmt.Errorf("unsupported archive schema version %q - FIX: upgrade bagoup", version)
+	}
+	return nil
+}
+
+// GetHandleMap returns an empty map, since the messages in the archive name
+// their senders.
+func (d *archiveDB) GetHandleMap(contactMap map[string]*vcard.Card, aliases chatdb.Aliases) (map[int]string, error) {
+	return map[int]string{}, nil
+}
+
+func (d *archiveDB) GetChats(contactMap map[string]*vcard.Card, aliases chatdb.Aliases) ([]chatdb.EntityChats, error) {
+	participants, err := d.getParticipants()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := d.DB.Query("SELECT chats.id, chats.guid, chats.is_group, entities.name FROM chats JOIN entities ON entities.id = chats.entity_id ORDER BY entities.name, chats.id")
+	if err != nil {
+		return nil, fmt.Errorf("query chats table: %w", err)
+	}
+	defer rows.Close()
+	var chats []chatdb.EntityChats
+	for rows.Next() {
+		var chat chatdb.Chat
+		var name string
+		if err := rows.Scan(&chat.ID, &chat.GUID, &chat.Group, &name); err != nil {
+			return nil, fmt.Errorf("read chat: %w", err)
+		}
+		chat.Participants = participants[chat.ID]
+		if len(chats) == 0 || chats[len(chats)-1].Name != name {
+			chats = append(chats, chatdb.EntityChats{Name: name})
+		}
+		chats[len(chats)-1].Chats = append(chats[len(chats)-1].Chats, chat)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read chats: %w", err)
+	}
+	return chats, nil
+}
+
+// getParticipants returns the names of the participants of each chat, by chat
+// ID.
+func (d *archiveDB) getParticipants() (map[int][]string, error) {
+	rows, err := d.DB.Query("SELECT chat_id, name FROM participants ORDER BY chat_id, rowid")
+	if err != nil {
+		return nil, fmt.Errorf("query participants table: %w", err)
+	}
+	defer rows.Close()
+	participants := map[int][]string{}
+	for rows.Next() {
+		var chatID int
+		var name string
+		if err := rows.Scan(&chatID, &name); err != nil {
+			return nil, fmt.Errorf("read participant: %w", err)
+		}
+		participants[chatID] = append(participants[chatID], name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read participants: %w", err)
+	}
+	return participants, nil
+}
+
+// dateConditions returns SQL conditions limiting the date column of the
+// messages table to the range.
+func dateConditions(dates chatdb.DateRange) []string {
+	var conds []string
+	if !dates.Since.IsZero() {
+		conds = append(conds, fmt.Sprintf("date >= %d", dates.Since.Unix()))
+	}
+	if !dates.Until.IsZero() {
+		conds = append(conds, fmt.Sprintf("date < %d", dates.Until.Unix()))
+	}
+	return conds
+}
+
+// GetMessageIDs returns the IDs of the messages in the chat, dated in Unix
+// time.
+func (d *archiveDB) GetMessageIDs(chatID int, dates chatdb.DateRange) ([]chatdb.DatedMessageID, error) {
+	conds := append([]string{fmt.Sprintf("chat_id = %d", chatID)}, dateConditions(dates)...)
+	rows, err := d.DB.Query(fmt.Sprintf("SELECT id, date FROM messages WHERE %s ORDER BY date, id", strings.Join(conds, " AND ")))
+	if err != nil {
+		return nil, fmt.Errorf("query messages table for chat ID %d: %w", chatID, err)
+	}
+	defer rows.Close()
+	messageIDs := []chatdb.DatedMessageID{}
+	for rows.Next() {
+		var messageID chatdb.DatedMessageID
+		if err := rows.Scan(&messageID.ID, &messageID.Date); err != nil {
+			return nil, fmt.Errorf("read message ID for chat ID %d: %w", chatID, err)
+		}
+		messageIDs = append(messageIDs, messageID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read message IDs for chat ID %d: %w", chatID, err)
+	}
+	return messageIDs, nil
+}
+
+func (d *archiveDB) GetRecentMessageTexts(chatID int, dates chatdb.DateRange, limit int) ([]chatdb.Message, error) {
+	conds := append([]string{fmt.Sprintf("chat_id = %d", chatID)}, dateConditions(dates)...)
+	rows, err := d.DB.Query(fmt.Sprintf("SELECT id, is_from_me, text FROM messages WHERE %s ORDER BY date DESC, id DESC LIMIT %d", strings.Join(conds, " AND "), limit))
+	if err != nil {
+		return nil, fmt.Errorf("query recent messages of chat ID %d: %w", chatID, err)
+	}
+	defer rows.Close()
+	msgs := []chatdb.Message{}
+	for rows.Next() {
+		msg := chatdb.Message{Status: chatdb.TextValid}
+		if err := rows.Scan(&msg.ID, &msg.FromMe, &msg.Text); err != nil {
+			return nil, fmt.Errorf("read recent message of chat ID %d: %w", chatID, err)
+		}
+		msgs = append(msgs, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read recent messages of chat ID %d: %w", chatID, err)
+	}
+	return msgs, nil
+}
+
+func (d *archiveDB) GetChatActivity(dates chatdb.DateRange) (map[int]chatdb.ChatActivity, error) {
+	query := "SELECT chat_id, COUNT(*), MAX(date), SUM(EXISTS (SELECT 1 FROM attachments WHERE attachments.message_id = messages.id)) FROM messages"
+	if conds := dateConditions(dates); len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " GROUP BY chat_id"
+	rows, err := d.DB.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("query chat activity: %w", err)
+	}
+	defer rows.Close()
+	activity := map[int]chatdb.ChatActivity{}
+	for rows.Next() {
+		var chatID, messages, attachments int
+		var lastDate int64
+		if err := rows.Scan(&chatID, &messages, &lastDate, &attachments); err != nil {
+			return nil, fmt.Errorf("read chat activity: %w", err)
+		}
+		activity[chatID] = chatdb.ChatActivity{
+			Messages:    messages,
+			LastMessage: time.Unix(lastDate, 0).In(d.loc),
+			Attachments: attachments,
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read chat activity: %w", err)
+	}
+	return activity, nil
+}
+
+func (d *archiveDB) GetMessage(messageID int, handleMap map[int]string) (chatdb.Message, error) {
+	msg := chatdb.Message{ID: messageID}
+	var date int64
+	var status string
+	err := d.DB.QueryRow(fmt.Sprintf("SELECT chats.guid, messages.date, messages.sender, messages.sender_handle, messages.is_from_me, messages.text, messages.text_status, messages.is_voice_memo, messages.audio_transcription FROM messages JOIN chats ON chats.id = messages.chat_id WHERE messages.id = %d", messageID)).
+		Scan(&msg.ChatGUID, &date, &msg.Sender, &msg.SenderHandle, &msg.FromMe, &msg.Text, &status, &msg.IsAudio, &msg.AudioTranscription)
+	if err != nil {
+		return chatdb.Message{}, fmt.Errorf("read data for message ID %d: %w", messageID, err)
+	}
+	msg.Date = time.Unix(date, 0).In(d.loc)
+	if msg.FromMe {
+		msg.Sender = d.selfHandle
+	}
+	switch status {
+	case chatdb.TextValid.String():
+		msg.Status = chatdb.TextValid
+	case chatdb.TextRecovered.String():
+		msg.Status = chatdb.TextRecovered
+	default:
+		msg.Status = chatdb.TextInvalid
+	}
+	return msg, nil
+}
+
+// GetAttachmentPaths returns the attachments of each message, at their original
+// paths, with the paths of their copies in the export if they were copied.
+// Attachments which had no path in chat.db have no filename.
+func (d *archiveDB) GetAttachmentPaths(ptools pathtools.PathTools) (map[int][]chatdb.Attachment, error) {
+	rows, err := d.DB.Query("SELECT message_id, id, original_path, path, mime_type, transfer_name FROM attachments ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("query attachments table: %w", err)
+	}
+	defer rows.Close()
+	atts := map[int][]chatdb.Attachment{}
+	for rows.Next() {
+		var msgID int
+		var att chatdb.Attachment
+		if err := rows.Scan(&msgID, &att.ID, &att.Filename, &att.CopiedPath, &att.MIMEType, &att.TransferName); err != nil {
+			return atts, fmt.Errorf("read attachment: %w", err)
+		}
+		atts[msgID] = append(atts[msgID], att)
+	}
+	if err := rows.Err(); err != nil {
+		return atts, fmt.Errorf("read attachments: %w", err)
+	}
+	return atts, nil
+}
@@ -0,0 +1,536 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package archive
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/tagatac/bagoup/v2/chatdb"
+	"gotest.tools/v3/assert"
+)
+
+func TestArchiveInit(t *testing.T) {
+	const query = "SELECT value FROM metadata WHERE key = 'schema_version'"
+	tests := []struct {
+		msg        string
+		setupMocks func(sqlmock.Sqlmock)
+		wantErr    string
+	}{
+		{
+			msg: "success",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow("1"))
+			},
+		},
+		{
+			msg: "not an archive",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnError(errors.New("no such table: metadata"))
+			},
+			wantErr: "get archive schema version - FIX: render an archive written with the --format sqlite flag: no such table: metadata",
+		},
+		{
+			msg: "newer schema",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow("2"))
+			},
+			wantErr: `unsupported archive schema version "2" - FIX: upgrade bagoup`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			tt.setupMocks(sMock)
+			cdb := NewChatDB(db, "Me")
+
+			err = cdb.Init(nil, time.UTC)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			handleMap, err := cdb.GetHandleMap(nil, nil)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, handleMap, map[int]string{})
+		})
+	}
+}
+
+func TestArchiveGetChats(t *testing.T) {
+	const (
+		participantsQuery = "SELECT chat_id, name FROM participants ORDER BY chat_id, rowid"
+		query             = "SELECT chats.id, chats.guid, chats.is_group, entities.name FROM chats JOIN entities ON entities.id = chats.entity_id ORDER BY entities.name, chats.id"
+	)
+	tests := []struct {
+		msg        string
+		setupMocks func(sqlmock.Sqlmock)
+		wantChats  []chatdb.EntityChats
+		wantErr    string
+	}{
+		{
+			msg: "two entities",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(participantsQuery)).WillReturnRows(sqlmock.NewRows([]string{"chat_id", "name"}).
+					AddRow(1, "friend").
+					AddRow(3, "Alex").
+					AddRow(3, "Sam"))
+				rows := sqlmock.NewRows([]string{"id", "guid", "is_group", "name"}).
+					AddRow(1, "iMessage;-;friend@gmail.com", false, "friend").
+					AddRow(2, "SMS;-;+15551234567", false, "friend").
+					AddRow(3, "iMessage;+;chat123", true, "tennis club")
+				sMock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnRows(rows)
+			},
+			wantChats: []chatdb.EntityChats{
+				{
+					Name: "friend",
+					Chats: []chatdb.Chat{
+						{ID: 1, GUID: "iMessage;-;friend@gmail.com", Participants: []string{"friend"}},
+						{ID: 2, GUID: "SMS;-;+15551234567"},
+					},
+				},
+				{
+					Name:  "tennis club",
+					Chats: []chatdb.Chat{{ID: 3, GUID: "iMessage;+;chat123", Group: true, Participants: []string{"Alex", "Sam"}}},
+				},
+			},
+		},
+		{
+			msg: "participants query error",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(participantsQuery)).WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "query participants table: this is a DB error",
+		},
+		{
+			msg: "participant scan error",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(participantsQuery)).WillReturnRows(sqlmock.NewRows([]string{"chat_id", "name"}).AddRow(1, nil))
+			},
+			wantErr: `read participant: sql: Scan error on column index 1, name "name": converting NULL to string is unsupported`,
+		},
+		{
+			msg: "participant iteration error",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(participantsQuery)).WillReturnRows(sqlmock.NewRows([]string{"chat_id", "name"}).
+					AddRow(1, "friend").
+					AddRow(3, "Alex").
+					RowError(1, errors.New("this is a row error")))
+			},
+			wantErr: "read participants: this is a row error",
+		},
+		{
+			msg: "query error",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(participantsQuery)).WillReturnRows(sqlmock.NewRows([]string{"chat_id", "name"}))
+				sMock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "query chats table: this is a DB error",
+		},
+		{
+			msg: "row scan error",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(participantsQuery)).WillReturnRows(sqlmock.NewRows([]string{"chat_id", "name"}))
+				rows := sqlmock.NewRows([]string{"id", "guid", "is_group", "name"}).AddRow("one", "iMessage;-;friend@gmail.com", false, "friend")
+				sMock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnRows(rows)
+			},
+			wantErr: `read chat: sql: Scan error on column index 0, name "id": converting driver.Value type string ("one") to a int: invalid syntax`,
+		},
+		{
+			msg: "row iteration error",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(participantsQuery)).WillReturnRows(sqlmock.NewRows([]string{"chat_id", "name"}))
+				rows := sqlmock.NewRows([]string{"id", "guid", "is_group", "name"}).
+					AddRow(1, "iMessage;-;friend@gmail.com", false, "friend").
+					AddRow(2, "SMS;-;+15551234567", false, "friend").
+					RowError(1, errors.New("this is a row error"))
+				sMock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnRows(rows)
+			},
+			wantErr: "read chats: this is a row error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			tt.setupMocks(sMock)
+			cdb := NewChatDB(db, "Me")
+
+			chats, err := cdb.GetChats(nil, nil)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, chats, tt.wantChats)
+		})
+	}
+}
+
+func TestArchiveGetMessageIDs(t *testing.T) {
+	tests := []struct {
+		msg        string
+		dates      chatdb.DateRange
+		setupMocks func(sqlmock.Sqlmock)
+		wantIDs    []chatdb.DatedMessageID
+		wantErr    string
+	}{
+		{
+			msg: "all messages",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"id", "date"}).AddRow(192, 1583073245).AddRow(200, 1583073300)
+				sMock.ExpectQuery(regexp.QuoteMeta("SELECT id, date FROM messages WHERE chat_id = 42 ORDER BY date, id")).WillReturnRows(rows)
+			},
+			wantIDs: []chatdb.DatedMessageID{{ID: 192, Date: 1583073245}, {ID: 200, Date: 1583073300}},
+		},
+		{
+			msg: "date range",
+			dates: chatdb.DateRange{
+				Since: time.Unix(1583000000, 0),
+				Until: time.Unix(1584000000, 0),
+			},
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"id", "date"})
+				sMock.ExpectQuery(regexp.QuoteMeta("SELECT id, date FROM messages WHERE chat_id = 42 AND date >= 1583000000 AND date < 1584000000 ORDER BY date, id")).WillReturnRows(rows)
+			},
+			wantIDs: []chatdb.DatedMessageID{},
+		},
+		{
+			msg: "query error",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery("SELECT id, date FROM messages").WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "query messages table for chat ID 42: this is a DB error",
+		},
+		{
+			msg: "row scan error",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"id", "date"}).AddRow("one", 1583073245)
+				sMock.ExpectQuery("SELECT id, date FROM messages").WillReturnRows(rows)
+			},
+			wantErr: `read message ID for chat ID 42: sql: Scan error on column index 0, name "id": converting driver.Value type string ("one") to a int: invalid syntax`,
+		},
+		{
+			msg: "row iteration error",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"id", "date"}).
+					AddRow(192, 1583073245).
+					AddRow(193, 1583073246).
+					RowError(1, errors.New("this is a row error"))
+				sMock.ExpectQuery("SELECT id, date FROM messages").WillReturnRows(rows)
+			},
+			wantErr: "read message IDs for chat ID 42: this is a row error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			tt.setupMocks(sMock)
+			cdb := NewChatDB(db, "Me")
+
+			ids, err := cdb.GetMessageIDs(42, tt.dates)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, ids, tt.wantIDs)
+		})
+	}
+}
+
+func TestArchiveGetRecentMessageTexts(t *testing.T) {
+	const query = "SELECT id, is_from_me, text FROM messages WHERE chat_id = 42 ORDER BY date DESC, id DESC LIMIT 10"
+	tests := []struct {
+		msg        string
+		setupMocks func(sqlmock.Sqlmock)
+		wantMsgs   []chatdb.Message
+		wantErr    string
+	}{
+		{
+			msg: "recent messages",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"id", "is_from_me", "text"}).
+					AddRow(200, 0, "Your code is 123456").
+					AddRow(192, 1, "thanks")
+				sMock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnRows(rows)
+			},
+			wantMsgs: []chatdb.Message{
+				{ID: 200, Text: "Your code is 123456", Status: chatdb.TextValid},
+				{ID: 192, FromMe: true, Text: "thanks", Status: chatdb.TextValid},
+			},
+		},
+		{
+			msg: "query error",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "query recent messages of chat ID 42: this is a DB error",
+		},
+		{
+			msg: "row scan error",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"id", "is_from_me", "text"}).AddRow("one", 0, "hi")
+				sMock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnRows(rows)
+			},
+			wantErr: `read recent message of chat ID 42: sql: Scan error on column index 0, name "id": converting driver.Value type string ("one") to a int: invalid syntax`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			tt.setupMocks(sMock)
+			cdb := NewChatDB(db, "Me")
+
+			msgs, err := cdb.GetRecentMessageTexts(42, chatdb.DateRange{}, 10)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, msgs, tt.wantMsgs)
+		})
+	}
+}
+
+func TestArchiveGetChatActivity(t *testing.T) {
+	const query = "SELECT chat_id, COUNT(*), MAX(date), SUM(EXISTS (SELECT 1 FROM attachments WHERE attachments.message_id = messages.id)) FROM messages"
+	tests := []struct {
+		msg          string
+		dates        chatdb.DateRange
+		setupMocks   func(sqlmock.Sqlmock)
+		wantActivity map[int]chatdb.ChatActivity
+		wantErr      string
+	}{
+		{
+			msg:   "since a date",
+			dates: chatdb.DateRange{Since: time.Unix(1583000000, 0)},
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"chat_id", "count", "max", "sum"}).AddRow(1, 10, 1583073245, 2)
+				sMock.ExpectQuery(regexp.QuoteMeta(query + " WHERE date >= 1583000000 GROUP BY chat_id")).WillReturnRows(rows)
+			},
+			wantActivity: map[int]chatdb.ChatActivity{
+				1: {Messages: 10, LastMessage: time.Unix(1583073245, 0).UTC(), Attachments: 2},
+			},
+		},
+		{
+			msg: "query error",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(query + " GROUP BY chat_id")).WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "query chat activity: this is a DB error",
+		},
+		{
+			msg: "row scan error",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"chat_id", "count", "max", "sum"}).AddRow("one", 10, 1583073245, 2)
+				sMock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnRows(rows)
+			},
+			wantErr: `read chat activity: sql: Scan error on column index 0, name "chat_id": converting driver.Value type string ("one") to a int: invalid syntax`,
+		},
+		{
+			msg: "row iteration error",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"chat_id", "count", "max", "sum"}).
+					AddRow(1, 10, 1583073245, 2).
+					AddRow(2, 1, 1583073245, 0).
+					RowError(1, errors.New("this is a row error"))
+				sMock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnRows(rows)
+			},
+			wantErr: "read chat activity: this is a row error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			tt.setupMocks(sMock)
+			cdb := &archiveDB{DB: db, loc: time.UTC}
+
+			activity, err := cdb.GetChatActivity(tt.dates)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, activity, tt.wantActivity)
+		})
+	}
+}
+
+func TestArchiveGetMessage(t *testing.T) {
+	const query = "SELECT chats.guid, messages.date, messages.sender, messages.sender_handle, messages.is_from_me, messages.text, messages.text_status, messages.is_voice_memo, messages.audio_transcription FROM messages JOIN chats ON chats.id = messages.chat_id WHERE messages.id = 192"
+	columns := []string{"guid", "date", "sender", "sender_handle", "is_from_me", "text", "text_status", "is_voice_memo", "audio_transcription"}
+	date := time.Unix(1583073245, 0).UTC()
+	tests := []struct {
+		msg        string
+		setupMocks func(sqlmock.Sqlmock)
+		wantMsg    chatdb.Message
+		wantErr    string
+	}{
+		{
+			msg: "from a friend",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows(columns).AddRow("SMS;-;+15551234567", 1583073245, "Novak", "+15551234567", false, "sure", "recovered", true, "see you soon")
+				sMock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnRows(rows)
+			},
+			wantMsg: chatdb.Message{
+				ID:                 192,
+				ChatGUID:           "SMS;-;+15551234567",
+				Date:               date,
+				Sender:             "Novak",
+				SenderHandle:       "+15551234567",
+				Text:               "sure",
+				Status:             chatdb.TextRecovered,
+				IsAudio:            true,
+				AudioTranscription: "see you soon",
+			},
+		},
+		{
+			msg: "from me",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows(columns).AddRow("SMS;-;+15551234567", 1583073245, "Me", "", true, "want to play tennis?", "valid", false, "")
+				sMock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnRows(rows)
+			},
+			wantMsg: chatdb.Message{
+				ID:       192,
+				ChatGUID: "SMS;-;+15551234567",
+				Date:     date,
+				Sender:   "me@example.com",
+				FromMe:   true,
+				Text:     "want to play tennis?",
+				Status:   chatdb.TextValid,
+			},
+		},
+		{
+			msg: "invalid text",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows(columns).AddRow("SMS;-;+15551234567", 1583073245, "Novak", "+15551234567", false, "", "invalid", false, "")
+				sMock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnRows(rows)
+			},
+			wantMsg: chatdb.Message{
+				ID:           192,
+				ChatGUID:     "SMS;-;+15551234567",
+				Date:         date,
+				Sender:       "Novak",
+				SenderHandle: "+15551234567",
+				Status:       chatdb.TextInvalid,
+			},
+		},
+		{
+			msg: "query error",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "read data for message ID 192: this is a DB error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			tt.setupMocks(sMock)
+			cdb := &archiveDB{DB: db, selfHandle: "me@example.com", loc: time.UTC}
+
+			msg, err := cdb.GetMessage(192, nil)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, msg, tt.wantMsg)
+		})
+	}
+}
+
+func TestArchiveGetAttachmentPaths(t *testing.T) {
+	const query = "SELECT message_id, id, original_path, path, mime_type, transfer_name FROM attachments ORDER BY id"
+	columns := []string{"message_id", "id", "original_path", "path", "mime_type", "transfer_name"}
+	tests := []struct {
+		msg        string
+		setupMocks func(sqlmock.Sqlmock)
+		wantAtts   map[int][]chatdb.Attachment
+		wantErr    string
+	}{
+		{
+			msg: "copied and referenced",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows(columns).
+					AddRow(192, 1, "/attachments/tennisballs.jpeg", "/export/friend/attachments/tennisballs.jpeg", "image/jpeg", "tennisballs.jpeg").
+					AddRow(192, 2, "/attachments/IMG_0001.png", "", "image/png", "IMG_0001.png").
+					AddRow(200, 3, "", "", "image/png", "missing.png")
+				sMock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnRows(rows)
+			},
+			wantAtts: map[int][]chatdb.Attachment{
+				192: {
+					{ID: 1, Filename: "/attachments/tennisballs.jpeg", CopiedPath: "/export/friend/attachments/tennisballs.jpeg", MIMEType: "image/jpeg", TransferName: "tennisballs.jpeg"},
+					{ID: 2, Filename: "/attachments/IMG_0001.png", MIMEType: "image/png", TransferName: "IMG_0001.png"},
+				},
+				200: {{ID: 3, MIMEType: "image/png", TransferName: "missing.png"}},
+			},
+		},
+		{
+			msg: "query error",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "query attachments table: this is a DB error",
+		},
+		{
+			msg: "row scan error",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows(columns).AddRow("one", 1, "", "", "image/png", "missing.png")
+				sMock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnRows(rows)
+			},
+			wantErr: `read attachment: sql: Scan error on column index 0, name "message_id": converting driver.Value type string ("one") to a int: invalid syntax`,
+		},
+		{
+			msg: "row iteration error",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows(columns).
+					AddRow(192, 1, "/attachments/tennisballs.jpeg", "", "image/jpeg", "tennisballs.jpeg").
+					AddRow(192, 2, "/attachments/IMG_0001.png", "", "image/png", "IMG_0001.png").
+					RowError(1, errors.New("this is a row error"))
+				sMock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnRows(rows)
+			},
+			wantErr: "read attachments: this is a row error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			tt.setupMocks(sMock)
+			cdb := NewChatDB(db, "Me")
+
+			atts, err := cdb.GetAttachmentPaths(nil)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, atts, tt.wantAtts)
+		})
+	}
+}
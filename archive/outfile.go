@@ -0,0 +1,171 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package archive
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+
+	"github.com/tagatac/bagoup/v2/chatdb"
+	"github.com/tagatac/bagoup/v2/opsys"
+)
+
+type (
+	// outFile holds the messages of an entity's chats until Flush, which adds
+	// them all to the archive in one transaction.
+	outFile struct {
+		*archive
+		entity   chatdb.EntityChats
+		guids    []string
+		messages []archivedMessage
+	}
+
+	archivedMessage struct {
+		chatdb.Message
+		attachments []chatdb.Attachment
+	}
+)
+
+func (a *archive) NewOutFile(entity chatdb.EntityChats, guids []string) opsys.OutFile {
+	return &outFile{archive: a, entity: entity, guids: guids}
+}
+
+// Name returns the path of the archive.
+func (f *outFile) Name() string {
+	return f.path
+}
+
+func (f *outFile) WriteMessage(msg chatdb.Message) error {
+	f.messages = append(f.messages, archivedMessage{Message: msg})
+	return nil
+}
+
+func (f *outFile) lastMessage(what string) (*archivedMessage, error) {
+	if len(f.messages) == 0 {
+		return nil, fmt.Errorf("no message in %q to attach %s to", f.Name(), what)
+	}
+	return &f.messages[len(f.messages)-1], nil
+}
+
+// WriteAttachment records the attachment, and its copy if any. Attachments
+// are not stored in the archive.
+func (f *outFile) WriteAttachment(att chatdb.Attachment) (bool, error) {
+	msg, err := f.lastMessage(fmt.Sprintf("%q", att.Filepath))
+	if err != nil {
+		return false, err
+	}
+	msg.attachments = append(msg.attachments, att)
+	return false, nil
+}
+
+// ReferenceAttachment records a missing attachment at its original path, so
+// that it can be found if the archive is rendered with a different
+// attachments path.
+func (f *outFile) ReferenceAttachment(att chatdb.Attachment) error {
+	msg, err := f.lastMessage(fmt.Sprintf("%q", att.TransferName))
+	if err != nil {
+		return err
+	}
+	msg.attachments = append(msg.attachments, att)
+	return nil
+}
+
+func (f *outFile) WriteTranscription(transcription string) error {
+	msg, err := f.lastMessage("the transcription")
+	if err != nil {
+		return err
+	}
+	msg.AudioTranscription = transcription
+	return nil
+}
+
+func (f *outFile) SetAvatar(avatarPath string) {}
+
+func (f *outFile) WriteAvatar(avatarPath string) error {
+	return nil
+}
+
+func (f *outFile) WriteSeparator() error {
+	return nil
+}
+
+func (f *outFile) Stage() (int, error) {
+	return 0, nil
+}
+
+func (f *outFile) Flush() error {
+	tx, err := f.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	chatIDs, err := f.addChats(tx)
+	if err != nil {
+		return err
+	}
+	for _, msg := range f.messages {
+		chatID, ok := chatIDs[msg.ChatGUID]
+		if !ok {
+			return fmt.Errorf("message %d is from chat %q, not of entity %q", msg.ID, msg.ChatGUID, f.entity.Name)
+		}
+		if err := addMessage(tx, chatID, msg); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit messages of entity %q: %w", f.entity.Name, err)
+	}
+	return nil
+}
+
+// addChats adds the entity, if it is new, and its chats with the GUIDs of the
+// OutFile, returning the IDs of the chats by GUID.
+func (f *outFile) addChats(tx *sql.Tx) (map[string]int64, error) {
+	if _, err := tx.Exec("INSERT OR IGNORE INTO entities (name) VALUES (?)", f.entity.Name); err != nil {
+		return nil, fmt.Errorf("add entity %q: %w", f.entity.Name, err)
+	}
+	var entityID int64
+	if err := tx.QueryRow("SELECT id FROM entities WHERE name = ?", f.entity.Name).Scan(&entityID); err != nil {
+		return nil, fmt.Errorf("get ID of entity %q: %w", f.entity.Name, err)
+	}
+	chatIDs := map[string]int64{}
+	for _, chat := range f.entity.Chats {
+		if !slices.Contains(f.guids, chat.GUID) {
+			continue
+		}
+		service := chatdb.Message{ChatGUID: chat.GUID}.Service()
+		res, err := tx.Exec("INSERT INTO chats (entity_id, guid, service, is_group) VALUES (?, ?, ?, ?)", entityID, chat.GUID, service, chat.Group)
+		if err != nil {
+			return nil, fmt.Errorf("add chat %q: %w", chat.GUID, err)
+		}
+		if chatIDs[chat.GUID], err = res.LastInsertId(); err != nil {
+			return nil, fmt.Errorf("get ID of chat %q: %w", chat.GUID, err)
+		}
+	}
+	return chatIDs, nil
+}
+
+func addMessage(tx *sql.Tx, chatID int64, msg archivedMessage) error {
+	if !msg.FromMe && msg.SenderHandle != "" {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO participants (chat_id, handle, name) VALUES (?, ?, ?)", chatID, msg.SenderHandle, msg.Sender); err != nil {
+			return fmt.Errorf("add participant %q: %w", msg.SenderHandle, err)
+		}
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO messages (id, chat_id, date, sender, sender_handle, is_from_me, text, text_status, is_voice_memo, audio_transcription) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		msg.ID, chatID, msg.Date.Unix(), msg.Sender, msg.SenderHandle, msg.FromMe, msg.Text, msg.Status.String(), msg.IsAudio, msg.AudioTranscription,
+	); err != nil {
+		return fmt.Errorf("add message %d: %w", msg.ID, err)
+	}
+	for _, att := range msg.attachments {
+		if _, err := tx.Exec(
+			"INSERT INTO attachments (message_id, original_path, path, mime_type, transfer_name) VALUES (?, ?, ?, ?, ?)",
+			msg.ID, att.Filename, att.CopiedPath, att.MIMEType, att.TransferName,
+		); err != nil {
+			return fmt.Errorf("add attachment %q of message %d: %w", att.TransferName, msg.ID, err)
+		}
+	}
+	return nil
+}
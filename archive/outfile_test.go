@@ -0,0 +1,193 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package archive
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/tagatac/bagoup/v2/chatdb"
+	"gotest.tools/v3/assert"
+)
+
+func TestOutFile(t *testing.T) {
+	entity := chatdb.EntityChats{
+		Name: "friend",
+		Chats: []chatdb.Chat{
+			{ID: 1, GUID: "iMessage;-;friend@gmail.com"},
+			{ID: 2, GUID: "SMS;-;+15551234567"},
+			{ID: 3, GUID: "iMessage;-;friend@hotmail.com"},
+		},
+	}
+	guids := []string{"iMessage;-;friend@gmail.com", "SMS;-;+15551234567"}
+	date := time.Date(2020, 3, 1, 15, 34, 5, 0, time.UTC)
+	const (
+		participantQuery = "INSERT OR IGNORE INTO participants (chat_id, handle, name) VALUES (?, ?, ?)"
+		messageQuery     = "INSERT INTO messages (id, chat_id, date, sender, sender_handle, is_from_me, text, text_status, is_voice_memo, audio_transcription) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+		attachmentQuery  = "INSERT INTO attachments (message_id, original_path, path, mime_type, transfer_name) VALUES (?, ?, ?, ?, ?)"
+	)
+	expectChats := func(sMock sqlmock.Sqlmock) {
+		sMock.ExpectBegin()
+		sMock.ExpectExec(regexp.QuoteMeta("INSERT OR IGNORE INTO entities (name) VALUES (?)")).WithArgs("friend").WillReturnResult(sqlmock.NewResult(1, 1))
+		sMock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM entities WHERE name = ?")).WithArgs("friend").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		sMock.ExpectExec(regexp.QuoteMeta("INSERT INTO chats (entity_id, guid, service, is_group) VALUES (?, ?, ?, ?)")).WithArgs(1, "iMessage;-;friend@gmail.com", "iMessage", false).WillReturnResult(sqlmock.NewResult(10, 1))
+		sMock.ExpectExec(regexp.QuoteMeta("INSERT INTO chats (entity_id, guid, service, is_group) VALUES (?, ?, ?, ?)")).WithArgs(1, "SMS;-;+15551234567", "SMS", false).WillReturnResult(sqlmock.NewResult(11, 1))
+	}
+
+	tests := []struct {
+		msg        string
+		chatGUID   string
+		setupMocks func(sqlmock.Sqlmock)
+		wantErr    string
+	}{
+		{
+			msg:      "success",
+			chatGUID: "SMS;-;+15551234567",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				expectChats(sMock)
+				sMock.ExpectExec(regexp.QuoteMeta(messageQuery)).
+					WithArgs(1, 10, date.Unix(), "Me", "", true, "want to play tennis?￼", "valid", false, "").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				sMock.ExpectExec(regexp.QuoteMeta(attachmentQuery)).
+					WithArgs(1, "/Users/me/Library/Messages/Attachments/tennisballs.jpeg", "/export/friend/attachments/tennisballs.jpeg", "image/jpeg", "tennisballs.jpeg").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				sMock.ExpectExec(regexp.QuoteMeta(attachmentQuery)).
+					WithArgs(1, "/Users/me/Library/Messages/Attachments/IMG_0001.png", "", "image/png", "IMG_0001.png").
+					WillReturnResult(sqlmock.NewResult(2, 1))
+				sMock.ExpectExec(regexp.QuoteMeta(participantQuery)).
+					WithArgs(11, "+15551234567", "Novak").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				sMock.ExpectExec(regexp.QuoteMeta(messageQuery)).
+					WithArgs(2, 11, date.Unix(), "Novak", "+15551234567", false, "sure", "recovered", true, "see you soon").
+					WillReturnResult(sqlmock.NewResult(2, 1))
+				sMock.ExpectCommit()
+			},
+		},
+		{
+			msg: "begin error",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectBegin().WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "begin transaction: this is a DB error",
+		},
+		{
+			msg: "entity error",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectBegin()
+				sMock.ExpectExec("INSERT OR IGNORE INTO entities").WillReturnError(errors.New("this is a DB error"))
+				sMock.ExpectRollback()
+			},
+			wantErr: `add entity "friend": this is a DB error`,
+		},
+		{
+			msg: "entity ID error",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectBegin()
+				sMock.ExpectExec("INSERT OR IGNORE INTO entities").WillReturnResult(sqlmock.NewResult(1, 1))
+				sMock.ExpectQuery("SELECT id FROM entities").WillReturnError(errors.New("this is a DB error"))
+				sMock.ExpectRollback()
+			},
+			wantErr: `get ID of entity "friend": this is a DB error`,
+		},
+		{
+			msg: "duplicate chat",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectBegin()
+				sMock.ExpectExec("INSERT OR IGNORE INTO entities").WillReturnResult(sqlmock.NewResult(1, 1))
+				sMock.ExpectQuery("SELECT id FROM entities").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				sMock.ExpectExec("INSERT INTO chats").WillReturnError(errors.New("UNIQUE constraint failed: chats.guid"))
+				sMock.ExpectRollback()
+			},
+			wantErr: `add chat "iMessage;-;friend@gmail.com": UNIQUE constraint failed: chats.guid`,
+		},
+		{
+			msg:      "message from another chat",
+			chatGUID: "iMessage;-;friend@hotmail.com",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				expectChats(sMock)
+				sMock.ExpectExec(regexp.QuoteMeta(messageQuery)).WillReturnResult(sqlmock.NewResult(1, 1))
+				sMock.ExpectExec(regexp.QuoteMeta(attachmentQuery)).WillReturnResult(sqlmock.NewResult(1, 1))
+				sMock.ExpectExec(regexp.QuoteMeta(attachmentQuery)).WillReturnResult(sqlmock.NewResult(2, 1))
+				sMock.ExpectRollback()
+			},
+			wantErr: `message 2 is from chat "iMessage;-;friend@hotmail.com", not of entity "friend"`,
+		},
+		{
+			msg:      "attachment error",
+			chatGUID: "SMS;-;+15551234567",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				expectChats(sMock)
+				sMock.ExpectExec(regexp.QuoteMeta(messageQuery)).WillReturnResult(sqlmock.NewResult(1, 1))
+				sMock.ExpectExec(regexp.QuoteMeta(attachmentQuery)).WillReturnError(errors.New("this is a DB error"))
+				sMock.ExpectRollback()
+			},
+			wantErr: `add attachment "tennisballs.jpeg" of message 1: this is a DB error`,
+		},
+		{
+			msg:      "participant error",
+			chatGUID: "SMS;-;+15551234567",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				expectChats(sMock)
+				sMock.ExpectExec(regexp.QuoteMeta(messageQuery)).WillReturnResult(sqlmock.NewResult(1, 1))
+				sMock.ExpectExec(regexp.QuoteMeta(attachmentQuery)).WillReturnResult(sqlmock.NewResult(1, 1))
+				sMock.ExpectExec(regexp.QuoteMeta(attachmentQuery)).WillReturnResult(sqlmock.NewResult(2, 1))
+				sMock.ExpectExec(regexp.QuoteMeta(participantQuery)).WillReturnError(errors.New("this is a DB error"))
+				sMock.ExpectRollback()
+			},
+			wantErr: `add participant "+15551234567": this is a DB error`,
+		},
+		{
+			msg:      "commit error",
+			chatGUID: "SMS;-;+15551234567",
+			setupMocks: func(sMock sqlmock.Sqlmock) {
+				expectChats(sMock)
+				sMock.ExpectExec(regexp.QuoteMeta(messageQuery)).WillReturnResult(sqlmock.NewResult(1, 1))
+				sMock.ExpectExec(regexp.QuoteMeta(attachmentQuery)).WillReturnResult(sqlmock.NewResult(1, 1))
+				sMock.ExpectExec(regexp.QuoteMeta(attachmentQuery)).WillReturnResult(sqlmock.NewResult(2, 1))
+				sMock.ExpectExec(regexp.QuoteMeta(participantQuery)).WillReturnResult(sqlmock.NewResult(1, 1))
+				sMock.ExpectExec(regexp.QuoteMeta(messageQuery)).WillReturnResult(sqlmock.NewResult(2, 1))
+				sMock.ExpectCommit().WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: `commit messages of entity "friend": this is a DB error`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			tt.setupMocks(sMock)
+			a := &archive{DB: db, path: "messages.sqlite"}
+
+			of := a.NewOutFile(entity, guids)
+			assert.Equal(t, of.Name(), "messages.sqlite")
+			assert.Error(t, of.ReferenceAttachment(chatdb.Attachment{TransferName: "IMG_0001.png"}), `no message in "messages.sqlite" to attach "IMG_0001.png" to`)
+			assert.NilError(t, of.WriteMessage(chatdb.Message{ID: 1, Date: date, Sender: "Me", FromMe: true, Text: "want to play tennis?￼", Status: chatdb.TextValid, ChatGUID: "iMessage;-;friend@gmail.com"}))
+			embedded, err := of.WriteAttachment(chatdb.Attachment{Filename: "/Users/me/Library/Messages/Attachments/tennisballs.jpeg", Filepath: "/export/friend/attachments/tennisballs.jpeg", CopiedPath: "/export/friend/attachments/tennisballs.jpeg", MIMEType: "image/jpeg", TransferName: "tennisballs.jpeg"})
+			assert.NilError(t, err)
+			assert.Equal(t, embedded, false)
+			assert.NilError(t, of.ReferenceAttachment(chatdb.Attachment{Filename: "/Users/me/Library/Messages/Attachments/IMG_0001.png", Filepath: "/mnt/mac/Users/me/Library/Messages/Attachments/IMG_0001.png", MIMEType: "image/png", TransferName: "IMG_0001.png"}))
+			assert.NilError(t, of.WriteSeparator())
+			assert.NilError(t, of.WriteMessage(chatdb.Message{ID: 2, Date: date, Sender: "Novak", SenderHandle: "+15551234567", Text: "sure", Status: chatdb.TextRecovered, IsAudio: true, ChatGUID: tt.chatGUID}))
+			assert.NilError(t, of.WriteTranscription("see you soon"))
+			of.SetAvatar("avatar.jpg")
+			assert.NilError(t, of.WriteAvatar("avatar.jpg"))
+			imgCount, err := of.Stage()
+			assert.NilError(t, err)
+			assert.Equal(t, imgCount, 0)
+
+			err = of.Flush()
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.NilError(t, sMock.ExpectationsWereMet())
+		})
+	}
+}
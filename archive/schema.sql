@@ -0,0 +1,96 @@
+-- Copyright (C) 2026  David Tagatac <david@tagatac.net>
+-- See cmd/bagoup/main.go for usage terms.
+
+-- The schema of a bagoup archive (version 1), a normalized copy of exported
+-- Messages chats which does not depend on the schema of chat.db. Dates are
+-- Unix times in seconds, and names are those of the contacts or aliases of
+-- the senders at the time of the export.
+
+-- metadata describes the archive by key: schema_version, generator (the
+-- version of bagoup which wrote it), and created (RFC 3339).
+CREATE TABLE metadata (
+    key TEXT PRIMARY KEY,
+    value TEXT NOT NULL
+);
+
+-- entities are the contacts, groups, or other senders whose chats were
+-- exported together, by name.
+CREATE TABLE entities (
+    id INTEGER PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE
+);
+
+-- chats are the conversations of the Messages app, each over one service.
+CREATE TABLE chats (
+    id INTEGER PRIMARY KEY,
+    entity_id INTEGER NOT NULL REFERENCES entities (id),
+    -- guid identifies the chat in Messages, e.g. iMessage;-;friend@gmail.com.
+    guid TEXT NOT NULL UNIQUE,
+    -- service is the prefix of the GUID, e.g. iMessage, SMS, or RCS.
+    service TEXT NOT NULL,
+    is_group INTEGER NOT NULL
+);
+
+-- participants are the senders of the messages in each chat, other than me.
+CREATE TABLE participants (
+    chat_id INTEGER NOT NULL REFERENCES chats (id),
+    -- handle is the phone number or email address of the participant.
+    handle TEXT NOT NULL,
+    name TEXT NOT NULL,
+    PRIMARY KEY (chat_id, handle)
+);
+
+CREATE TABLE messages (
+    -- id is the ROWID of the message in chat.db.
+    id INTEGER PRIMARY KEY,
+    chat_id INTEGER NOT NULL REFERENCES chats (id),
+    date INTEGER NOT NULL,
+    sender TEXT NOT NULL,
+    -- sender_handle is the phone number or email address of the sender, or
+    -- empty for messages from me.
+    sender_handle TEXT NOT NULL,
+    is_from_me INTEGER NOT NULL,
+    -- text has an object replacement character (U+FFFC) in place of each
+    -- attachment.
+    text TEXT NOT NULL,
+    -- text_status is valid, recovered (heuristically, from a message which
+    -- could not be decoded), or invalid (no text was found).
+    text_status TEXT NOT NULL,
+    is_voice_memo INTEGER NOT NULL,
+    audio_transcription TEXT NOT NULL
+);
+CREATE INDEX messages_chat_date ON messages (chat_id, date);
+
+-- attachments are the files attached to each message, in order. Handwritten
+-- messages and Digital Touch sketches, which are drawn from stroke data rather
+-- than attached as files, are not included.
+CREATE TABLE attachments (
+    id INTEGER PRIMARY KEY,
+    message_id INTEGER NOT NULL REFERENCES messages (id),
+    -- original_path is the path of the attachment in chat.db, under the root
+    -- given by the --attachments-path flag, even if it was missing, or empty if
+    -- chat.db had no path for it.
+    original_path TEXT NOT NULL,
+    -- path is where the attachment was copied in the export (with the
+    -- --copy-attachments flag), or empty.
+    path TEXT NOT NULL,
+    mime_type TEXT NOT NULL,
+    transfer_name TEXT NOT NULL
+);
+CREATE INDEX attachments_message ON attachments (message_id);
+
+-- messages_fts indexes the text and transcriptions of the messages for
+-- full-text search, e.g.
+--     SELECT messages.* FROM messages_fts
+--     JOIN messages ON messages.id = messages_fts.rowid
+--     WHERE messages_fts MATCH 'tennis' ORDER BY rank;
+CREATE VIRTUAL TABLE messages_fts USING fts5 (
+    text,
+    audio_transcription,
+    content = 'messages',
+    content_rowid = 'id'
+);
+CREATE TRIGGER messages_fts_insert AFTER INSERT ON messages BEGIN
+    INSERT INTO messages_fts (rowid, text, audio_transcription)
+    VALUES (new.id, new.text, new.audio_transcription);
+END;
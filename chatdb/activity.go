@@ -0,0 +1,59 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package chatdb
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ChatActivity summarizes the messages in a chat.
+type ChatActivity struct {
+	Messages int
+	// LastMessage is the time of the most recent message.
+	LastMessage time.Time
+	// Attachments is the number of messages with attachments.
+	Attachments int
+}
+
+// Add combines the activity of two chats, e.g. the chats of an entity.
+func (a ChatActivity) Add(b ChatActivity) ChatActivity {
+	a.Messages += b.Messages
+	if b.LastMessage.After(a.LastMessage) {
+		a.LastMessage = b.LastMessage
+	}
+	a.Attachments += b.Attachments
+	return a
+}
+
+func (d chatDB) GetChatActivity(dates DateRange) (map[int]ChatActivity, error) {
+	query := "SELECT chat_message_join.chat_id, COUNT(*), MAX(message.date), SUM(EXISTS (SELECT 1 FROM message_attachment_join WHERE message_attachment_join.message_id = message.ROWID)) FROM chat_message_join JOIN message ON message.ROWID = chat_message_join.message_id"
+	if conds := dates.conditions("message.date", d.dateDivisor); len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " GROUP BY chat_message_join.chat_id"
+	rows, err := d.DB.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("query chat activity: %w", err)
+	}
+	defer rows.Close()
+	activity := map[int]ChatActivity{}
+	for rows.Next() {
+		var chatID, messages, attachments int
+		var lastDate int64
+		if err := rows.Scan(&chatID, &messages, &lastDate, &attachments); err != nil {
+			return nil, fmt.Errorf("read chat activity: %w", err)
+		}
+		activity[chatID] = ChatActivity{
+			Messages:    messages,
+			LastMessage: time.Unix(lastDate/int64(d.dateDivisor)+appleEpochUnixSec, 0).In(d.loc),
+			Attachments: attachments,
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read chat activity: %w", err)
+	}
+	return activity, nil
+}
@@ -0,0 +1,105 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package chatdb
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"gotest.tools/v3/assert"
+)
+
+func TestGetChatActivity(t *testing.T) {
+	const query = "SELECT chat_message_join.chat_id, COUNT(*), MAX(message.date), SUM(EXISTS (SELECT 1 FROM message_attachment_join WHERE message_attachment_join.message_id = message.ROWID)) FROM chat_message_join JOIN message ON message.ROWID = chat_message_join.message_id"
+	since := time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		msg          string
+		dates        DateRange
+		setupQuery   func(sqlmock.Sqlmock)
+		wantActivity map[int]ChatActivity
+		wantErr      string
+	}{
+		{
+			msg: "all messages",
+			setupQuery: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(query + " GROUP BY chat_message_join.chat_id")).
+					WillReturnRows(sqlmock.NewRows([]string{"chat_id", "count", "max", "sum"}).
+						AddRow(1, 120, 694224000*_modernVersionDateDivisor, 4).
+						AddRow(2, 1, 0, 0))
+			},
+			wantActivity: map[int]ChatActivity{
+				1: {Messages: 120, LastMessage: time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC), Attachments: 4},
+				2: {Messages: 1, LastMessage: time.Date(2001, time.January, 1, 0, 0, 0, 0, time.UTC)},
+			},
+		},
+		{
+			msg:   "date range",
+			dates: DateRange{Since: since},
+			setupQuery: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(query + " WHERE message.date >= 694224000000000000 GROUP BY chat_message_join.chat_id")).
+					WillReturnRows(sqlmock.NewRows([]string{"chat_id", "count", "max", "sum"}))
+			},
+			wantActivity: map[int]ChatActivity{},
+		},
+		{
+			msg: "DB error",
+			setupQuery: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery("SELECT chat_message_join.chat_id").WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "query chat activity: this is a DB error",
+		},
+		{
+			msg: "row scan error",
+			setupQuery: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery("SELECT chat_message_join.chat_id").
+					WillReturnRows(sqlmock.NewRows([]string{"chat_id", "count", "max", "sum"}).AddRow(1, 1, nil, 0))
+			},
+			wantErr: "read chat activity: sql: Scan error on column index 2, name \"max\": converting NULL to int64 is unsupported",
+		},
+		{
+			msg: "row iteration error",
+			setupQuery: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery("SELECT chat_message_join.chat_id").
+					WillReturnRows(sqlmock.NewRows([]string{"chat_id", "count", "max", "sum"}).
+						AddRow(1, 1, 0, 0).
+						AddRow(2, 1, 0, 0).
+						RowError(1, errors.New("this is a row error")))
+			},
+			wantErr: "read chat activity: this is a row error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			tt.setupQuery(sMock)
+			cdb := &chatDB{DB: db, dateDivisor: _modernVersionDateDivisor, loc: time.UTC}
+
+			activity, err := cdb.GetChatActivity(tt.dates)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, tt.wantActivity, activity)
+		})
+	}
+}
+
+func TestChatActivityAdd(t *testing.T) {
+	earlier := time.Date(2022, time.June, 1, 0, 0, 0, 0, time.UTC)
+	later := time.Date(2023, time.June, 1, 0, 0, 0, 0, time.UTC)
+	a := ChatActivity{Messages: 3, LastMessage: later, Attachments: 1}
+	b := ChatActivity{Messages: 5, LastMessage: earlier, Attachments: 2}
+	want := ChatActivity{Messages: 8, LastMessage: later, Attachments: 3}
+	assert.DeepEqual(t, a.Add(b), want)
+	assert.DeepEqual(t, b.Add(a), want)
+	assert.DeepEqual(t, ChatActivity{}.Add(b), b)
+}
@@ -0,0 +1,46 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package chatdb
+
+type (
+	// Alias overrides the naming of a handle or chat, taking precedence over
+	// the contact map.
+	Alias struct {
+		// Name replaces the handle in message prefixes. If empty, Entity is
+		// used.
+		Name string `yaml:"name" toml:"name"`
+		// Entity is the name of the entity (i.e. the export folder) that the
+		// chats are exported under. Chats with the same entity are merged. If
+		// empty, Name is used.
+		Entity string `yaml:"entity" toml:"entity"`
+	}
+
+	// Aliases maps normalized handles (see phonenum.Normalize) and chat GUIDs
+	// to Aliases.
+	Aliases map[string]Alias
+)
+
+func (a Alias) name() string {
+	if a.Name != "" {
+		return a.Name
+	}
+	return a.Entity
+}
+
+func (a Alias) entity() string {
+	if a.Entity != "" {
+		return a.Entity
+	}
+	return a.Name
+}
+
+// chatAlias finds the alias for a chat, by its GUID or by its normalized
+// identifier.
+func (a Aliases) chatAlias(guid, address string) (Alias, bool) {
+	if alias, ok := a[guid]; ok {
+		return alias, true
+	}
+	alias, ok := a[address]
+	return alias, ok
+}
@@ -19,6 +19,9 @@ type Attachment struct {
 	Filepath     string
 	MIMEType     string
 	TransferName string
+	// CopiedPath is the path of the copy of the attachment in the export, if
+	// it was copied.
+	CopiedPath string
 }
 
 func (d *chatDB) GetAttachmentPaths(ptools pathtools.PathTools) (map[int][]Attachment, error) {
@@ -5,10 +5,12 @@ package chatdb
 
 import (
 	"fmt"
+	"slices"
 	"sort"
 	"strings"
 
 	"github.com/emersion/go-vcard"
+	"github.com/tagatac/bagoup/v2/phonenum"
 )
 
 type (
@@ -16,43 +18,75 @@ type (
 	// with the same vCard, phone number, or email address). In the case of group
 	// chats, this struct will only contain a single Chat.
 	EntityChats struct {
-		Name  string
-		Chats []Chat
+		Name string
+		// Avatar is the path to the photo of the entity's contact, if any (see
+		// FieldAvatar).
+		Avatar string
+		Chats  []Chat
+		// Automated marks the entity collecting the chats of unrelated
+		// automated senders, which are never merged.
+		Automated bool
 	}
 
 	// Chat represents a row from the chat table.
 	Chat struct {
-		ID   int
-		GUID string
+		ID    int
+		GUID  string
+		Group bool
+		// SenderNames overrides the handle map for participants of a group
+		// chat who share a name, e.g. "Alex K." and "Alex M.".
+		SenderNames map[int]string
+		// Participants are the names of the members of the chat other than
+		// me, from the chat_handle_join table.
+		Participants []string
 	}
 )
 
-func (d chatDB) GetChats(contactMap map[string]*vcard.Card) ([]EntityChats, error) {
-	chatRows, err := d.DB.Query("SELECT ROWID, guid, chat_identifier, COALESCE(display_name, '') FROM chat")
+// The style of group chats in the chat table, as opposed to 45 for one-on-one
+// chats.
+const _groupChatStyle = 43
+
+func (d chatDB) GetChats(contactMap map[string]*vcard.Card, aliases Aliases) ([]EntityChats, error) {
+	participants, err := d.getParticipants()
+	if err != nil {
+		return nil, err
+	}
+	chatRows, err := d.DB.Query("SELECT ROWID, guid, chat_identifier, COALESCE(display_name, ''), style FROM chat")
 	if err != nil {
 		return nil, fmt.Errorf("query chats table: %w", err)
 	}
 	defer chatRows.Close()
 	contactChats := map[*vcard.Card]EntityChats{}
 	addressChats := map[string]EntityChats{}
+	aliasChats := map[string]EntityChats{}
 	for chatRows.Next() {
-		var id int
+		var id, style int
 		var guid, chatIdentifier, displayName string
-		if err := chatRows.Scan(&id, &guid, &chatIdentifier, &displayName); err != nil {
+		if err := chatRows.Scan(&id, &guid, &chatIdentifier, &displayName, &style); err != nil {
 			return nil, fmt.Errorf("read chat: %w", err)
 		}
 		if displayName == "" {
 			displayName = chatIdentifier
 		}
 		chat := Chat{
-			ID:   id,
-			GUID: guid,
+			ID:    id,
+			GUID:  guid,
+			Group: style == _groupChatStyle,
+		}
+		if chat.Group && len(d.handleCards) > 0 {
+			chat.SenderNames = d.disambiguateSenders(participants[id])
 		}
-		if card, ok := contactMap[chatIdentifier]; ok {
+		chat.Participants = d.participantNames(participants[id], chat.SenderNames)
+		address := phonenum.Normalize(chatIdentifier, d.defaultRegion)
+		if alias, ok := aliases.chatAlias(guid, address); ok {
+			addAddressChat(alias.entity(), alias.entity(), chat, aliasChats)
+			continue
+		}
+		if card, ok := contactMap[address]; ok {
 			addContactChat(card, displayName, chat, contactChats)
 			continue
 		}
-		addAddressChat(chatIdentifier, displayName, chat, addressChats)
+		addAddressChat(address, displayName, chat, addressChats)
 	}
 	chats := []EntityChats{}
 	for _, entityChats := range contactChats {
@@ -61,10 +95,46 @@ func (d chatDB) GetChats(contactMap map[string]*vcard.Card) ([]EntityChats, erro
 	for _, entityChats := range addressChats {
 		chats = append(chats, entityChats)
 	}
+	chats = mergeAliasChats(chats, aliasChats)
 	sort.SliceStable(chats, func(i, j int) bool { return chats[i].Name < chats[j].Name })
 	return chats, nil
 }
 
+// getParticipants returns the handle IDs of the participants of each chat, by
+// chat ID.
+func (d chatDB) getParticipants() (map[int][]int, error) {
+	rows, err := d.DB.Query("SELECT chat_id, handle_id FROM chat_handle_join ORDER BY chat_id, handle_id")
+	if err != nil {
+		return nil, fmt.Errorf("query chat participants: %w", err)
+	}
+	defer rows.Close()
+	participants := map[int][]int{}
+	for rows.Next() {
+		var chatID, handleID int
+		if err := rows.Scan(&chatID, &handleID); err != nil {
+			return nil, fmt.Errorf("read chat participant: %w", err)
+		}
+		participants[chatID] = append(participants[chatID], handleID)
+	}
+	return participants, nil
+}
+
+// participantNames returns the names of the participants with the given
+// handle IDs, as renamed by senderNames, without duplicates.
+func (d chatDB) participantNames(handleIDs []int, senderNames map[int]string) []string {
+	var names []string
+	for _, handleID := range handleIDs {
+		name, ok := senderNames[handleID]
+		if !ok {
+			name = d.handleNames[handleID]
+		}
+		if name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
 func addContactChat(card *vcard.Card, displayName string, chat Chat, contactChats map[*vcard.Card]EntityChats) {
 	if entityChats, ok := contactChats[card]; ok {
 		// We have contact info, and we have seen this contact before.
@@ -73,13 +143,13 @@ func addContactChat(card *vcard.Card, displayName string, chat Chat, contactChat
 		return
 	}
 	// We have contact info, but we haven't seen this contact before.
-	contactName := card.PreferredValue(vcard.FieldFormattedName)
-	if contactName != "" {
-		displayName = contactName
+	if name := entityName(card); name != "" {
+		displayName = name
 	}
 	contactChats[card] = EntityChats{
-		Name:  strings.TrimRight(displayName, ". "),
-		Chats: []Chat{chat},
+		Name:   strings.TrimRight(displayName, ". "),
+		Avatar: card.Value(FieldAvatar),
+		Chats:  []Chat{chat},
 	}
 }
 
@@ -97,3 +167,18 @@ func addAddressChat(address, displayName string, chat Chat, addressChats map[str
 		Chats: []Chat{chat},
 	}
 }
+
+// mergeAliasChats adds the aliased chats to the entity with the same name, if
+// there is one, so that an alias can merge chats into a contact's folder.
+func mergeAliasChats(chats []EntityChats, aliasChats map[string]EntityChats) []EntityChats {
+	for i, entityChats := range chats {
+		if ac, ok := aliasChats[entityChats.Name]; ok {
+			chats[i].Chats = append(chats[i].Chats, ac.Chats...)
+			delete(aliasChats, entityChats.Name)
+		}
+	}
+	for _, entityChats := range aliasChats {
+		chats = append(chats, entityChats)
+	}
+	return chats
+}
@@ -16,6 +16,7 @@ func TestGetChats(t *testing.T) {
 	tests := []struct {
 		msg        string
 		contactMap map[string]*vcard.Card
+		aliases    Aliases
 		setupQuery func(*sqlmock.ExpectedQuery)
 		wantChats  []EntityChats
 		wantErr    string
@@ -23,10 +24,10 @@ func TestGetChats(t *testing.T) {
 		{
 			msg: "empty contact map",
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
-				rows := sqlmock.NewRows([]string{"ROWID", "guid", "chat_identifier", "display_name"}).
-					AddRow(1, "testguid1", "testchatname1", "testdisplayname1").
-					AddRow(2, "testguid2", "testchatname2", "").
-					AddRow(3, "testguid3", "testchatname2", "")
+				rows := sqlmock.NewRows([]string{"ROWID", "guid", "chat_identifier", "display_name", "style"}).
+					AddRow(1, "testguid1", "testchatname1", "testdisplayname1", 45).
+					AddRow(2, "testguid2", "testchatname2", "", 45).
+					AddRow(3, "testguid3", "testchatname2", "", 45)
 				query.WillReturnRows(rows)
 			},
 			wantChats: []EntityChats{
@@ -61,18 +62,22 @@ func TestGetChats(t *testing.T) {
 					"FN": []*vcard.Field{
 						{Value: "Contactgiven Contactsurname", Params: vcard.Params{"TYPE": []string{"pref"}}},
 					},
+					FieldAvatar: []*vcard.Field{
+						{Value: "/tmp/bagoup/avatars/avatar-1.jpg"},
+					},
 				},
 			},
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
-				rows := sqlmock.NewRows([]string{"ROWID", "guid", "chat_identifier", "display_name"}).
-					AddRow(1, "testguid1", "testchatname1", "testdisplayname1").
-					AddRow(2, "testguid2", "testchatname2", "testdisplayname2").
-					AddRow(3, "testguid3", "testchatname2", "testdisplayname2")
+				rows := sqlmock.NewRows([]string{"ROWID", "guid", "chat_identifier", "display_name", "style"}).
+					AddRow(1, "testguid1", "testchatname1", "testdisplayname1", 45).
+					AddRow(2, "testguid2", "testchatname2", "testdisplayname2", 45).
+					AddRow(3, "testguid3", "testchatname2", "testdisplayname2", 45)
 				query.WillReturnRows(rows)
 			},
 			wantChats: []EntityChats{
 				{
-					Name: "Contactgiven Contactsurname",
+					Name:   "Contactgiven Contactsurname",
+					Avatar: "/tmp/bagoup/avatars/avatar-1.jpg",
 					Chats: []Chat{
 						{
 							ID:   2,
@@ -98,8 +103,8 @@ func TestGetChats(t *testing.T) {
 		{
 			msg: "chat identifier sanitized",
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
-				rows := sqlmock.NewRows([]string{"ROWID", "guid", "chat_identifier", "display_name"}).
-					AddRow(1, "testguid1", "testchatname1", "testdisplayname1. ")
+				rows := sqlmock.NewRows([]string{"ROWID", "guid", "chat_identifier", "display_name", "style"}).
+					AddRow(1, "testguid1", "testchatname1", "testdisplayname1. ", 45)
 				query.WillReturnRows(rows)
 			},
 			wantChats: []EntityChats{
@@ -124,8 +129,8 @@ func TestGetChats(t *testing.T) {
 				},
 			},
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
-				rows := sqlmock.NewRows([]string{"ROWID", "guid", "chat_identifier", "display_name"}).
-					AddRow(2, "testguid2", "testchatname2", "testdisplayname2")
+				rows := sqlmock.NewRows([]string{"ROWID", "guid", "chat_identifier", "display_name", "style"}).
+					AddRow(2, "testguid2", "testchatname2", "testdisplayname2", 45)
 				query.WillReturnRows(rows)
 			},
 			wantChats: []EntityChats{
@@ -140,6 +145,120 @@ func TestGetChats(t *testing.T) {
 				},
 			},
 		},
+		{
+			msg: "chat identifiers normalized",
+			contactMap: map[string]*vcard.Card{
+				"+15551234567": {
+					"FN": []*vcard.Field{
+						{Value: "Contactgiven Contactsurname", Params: vcard.Params{"TYPE": []string{"pref"}}},
+					},
+				},
+			},
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"ROWID", "guid", "chat_identifier", "display_name", "style"}).
+					AddRow(1, "iMessage;-;+15551234567", "+15551234567", "", 45).
+					AddRow(2, "SMS;-;5551234567", "5551234567", "", 45).
+					AddRow(3, "iMessage;-;+15559876543", "+15559876543", "", 45).
+					AddRow(4, "SMS;-;15559876543", "15559876543", "", 45)
+				query.WillReturnRows(rows)
+			},
+			wantChats: []EntityChats{
+				{
+					Name: "+15559876543",
+					Chats: []Chat{
+						{
+							ID:   3,
+							GUID: "iMessage;-;+15559876543",
+						},
+						{
+							ID:   4,
+							GUID: "SMS;-;15559876543",
+						},
+					},
+				},
+				{
+					Name: "Contactgiven Contactsurname",
+					Chats: []Chat{
+						{
+							ID:   1,
+							GUID: "iMessage;-;+15551234567",
+						},
+						{
+							ID:   2,
+							GUID: "SMS;-;5551234567",
+						},
+					},
+				},
+			},
+		},
+		{
+			msg: "aliases",
+			contactMap: map[string]*vcard.Card{
+				"+15551234567": {
+					"FN": []*vcard.Field{
+						{Value: "Contactgiven Contactsurname"},
+					},
+				},
+				"+15559876543": {
+					"FN": []*vcard.Field{
+						{Value: "Othergiven Othersurname"},
+					},
+				},
+			},
+			aliases: Aliases{
+				"+15559876543":       {Entity: "Contactgiven Contactsurname"},
+				"262966":             {Name: "Acme Bank"},
+				"chat123456789":      {Entity: "Book Club"},
+				"SMS;-;+15551230000": {Entity: "Book Club"},
+			},
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"ROWID", "guid", "chat_identifier", "display_name", "style"}).
+					AddRow(1, "iMessage;-;+15551234567", "+15551234567", "", 45).
+					AddRow(2, "iMessage;-;+15559876543", "+15559876543", "", 45).
+					AddRow(3, "SMS;-;262966", "262966", "", 45).
+					AddRow(4, "iMessage;+;chat123456789", "chat123456789", "Readers", 43).
+					AddRow(5, "SMS;-;+15551230000", "+15551230000", "", 45)
+				query.WillReturnRows(rows)
+			},
+			wantChats: []EntityChats{
+				{
+					Name: "Acme Bank",
+					Chats: []Chat{
+						{
+							ID:   3,
+							GUID: "SMS;-;262966",
+						},
+					},
+				},
+				{
+					Name: "Book Club",
+					Chats: []Chat{
+						{
+							ID:    4,
+							GUID:  "iMessage;+;chat123456789",
+							Group: true,
+						},
+						{
+							ID:   5,
+							GUID: "SMS;-;+15551230000",
+						},
+					},
+				},
+				{
+					Name: "Contactgiven Contactsurname",
+					Chats: []Chat{
+						{
+							ID:   1,
+							GUID: "iMessage;-;+15551234567",
+						},
+						{
+							ID:   2,
+							GUID: "iMessage;-;+15559876543",
+						},
+					},
+				},
+			},
+		},
 		{
 			msg: "DB error",
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
@@ -150,9 +269,9 @@ func TestGetChats(t *testing.T) {
 		{
 			msg: "row scan error",
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
-				rows := sqlmock.NewRows([]string{"ROWID", "guid", "chat_identifier", "display_name"}).
-					AddRow(1, "testguid1", "testchatname1", "testdisplayname1").
-					AddRow(2, "testguid2", "testchatname2", nil)
+				rows := sqlmock.NewRows([]string{"ROWID", "guid", "chat_identifier", "display_name", "style"}).
+					AddRow(1, "testguid1", "testchatname1", "testdisplayname1", 45).
+					AddRow(2, "testguid2", "testchatname2", nil, 45)
 				query.WillReturnRows(rows)
 			},
 			wantErr: "read chat: sql: Scan error on column index 3, name \"display_name\": converting NULL to string is unsupported",
@@ -164,11 +283,13 @@ func TestGetChats(t *testing.T) {
 			db, sMock, err := sqlmock.New()
 			assert.NilError(t, err)
 			defer db.Close()
-			query := sMock.ExpectQuery(`SELECT ROWID, guid, chat_identifier, COALESCE\(display_name, ''\) FROM chat`)
+			sMock.ExpectQuery("SELECT chat_id, handle_id FROM chat_handle_join ORDER BY chat_id, handle_id").
+				WillReturnRows(sqlmock.NewRows([]string{"chat_id", "handle_id"}))
+			query := sMock.ExpectQuery(`SELECT ROWID, guid, chat_identifier, COALESCE\(display_name, ''\), style FROM chat`)
 			tt.setupQuery(query)
-			cdb := NewChatDB(db, "Me")
+			cdb := NewChatDB(db, "Me", "US", "")
 
-			chats, err := cdb.GetChats(tt.contactMap)
+			chats, err := cdb.GetChats(tt.contactMap, tt.aliases)
 			if tt.wantErr != "" {
 				assert.Error(t, err, tt.wantErr)
 				return
@@ -18,8 +18,13 @@ import (
 	"github.com/Masterminds/semver/v3"
 	"github.com/emersion/go-vcard"
 	"github.com/tagatac/bagoup/v2/pathtools"
+	"github.com/tagatac/bagoup/v2/phonenum"
 )
 
+// FieldAvatar is the vCard field holding the path to an image file of the
+// contact's photo, set by opsys.OS.GetContactMap when extracting avatars.
+const FieldAvatar = "X-BAGOUP-AVATAR"
+
 const _githubIssueMsg = "open an issue at https://github.com/tagatac/bagoup/issues"
 
 // The modern version of macOS as it pertains to date representation in chat.db
@@ -37,18 +42,33 @@ type (
 		Init(macOSVersion *semver.Version, loc *time.Location) error
 		// GetHandleMap returns a mapping from handle ID to phone number or email
 		// address. If a contact map is supplied, it will attempt to resolve these
-		// handles to formatted names.
-		GetHandleMap(contactMap map[string]*vcard.Card) (map[int]string, error)
+		// handles to contact names in the sender name style, matching on the
+		// normalized handle (see phonenum.Normalize). Aliases for handles
+		// override the contact map.
+		// The avatars of the matched contacts (see FieldAvatar) are remembered
+		// for the senders of messages returned by GetMessage.
+		GetHandleMap(contactMap map[string]*vcard.Card, aliases Aliases) (map[int]string, error)
 		// GetChats returns a slice of EntityChats, effectively a table scan of
-		// the chat table.
-		GetChats(contactMap map[string]*vcard.Card) ([]EntityChats, error)
+		// the chat table. Aliases for chat GUIDs or identifiers override the
+		// contact map.
+		GetChats(contactMap map[string]*vcard.Card, aliases Aliases) ([]EntityChats, error)
 		// GetMessageIDs returns a slice of DatedMessageIDs corresponding to a
-		// given chat ID.
-		GetMessageIDs(chatID int) ([]DatedMessageID, error)
-		// GetMessage returns a message retrieved from the database formatted for
-		// writing to a chat file, as well as flag indicating the validity of the
-		// text in the message.
-		GetMessage(messageID int, handleMap map[int]string) (string, bool, error)
+		// given chat ID, limited to messages sent in the given date range.
+		GetMessageIDs(chatID int, dates DateRange) ([]DatedMessageID, error)
+		// GetChatActivity summarizes the messages sent in the given date range in
+		// each chat, by chat ID, with a single aggregate query. Chats without
+		// messages in the range are omitted.
+		GetChatActivity(dates DateRange) (map[int]ChatActivity, error)
+		// GetMessage returns a message retrieved from the database, including a
+		// status indicating whether the text in the message was decoded,
+		// recovered heuristically, or not found.
+		GetMessage(messageID int, handleMap map[int]string) (Message, error)
+		// GetRecentMessageTexts returns up to limit of the most recent messages
+		// sent in the given chat in the given date range, newest first, with
+		// only their IDs, whether I sent them, and, for the messages of other
+		// senders, their texts. It takes a single query, so it is much cheaper
+		// than GetMessage for sampling a chat.
+		GetRecentMessageTexts(chatID int, dates DateRange, limit int) ([]Message, error)
 		// GetAttachmentPaths returns a list of attachment filepaths associated with
 		// each message ID.
 		GetAttachmentPaths(ptools pathtools.PathTools) (map[int][]Attachment, error)
@@ -56,21 +76,37 @@ type (
 
 	chatDB struct {
 		*sql.DB
-		selfHandle     string
-		dateDivisor    int
-		cmJoinHasDates bool
+		selfHandle      string
+		defaultRegion   string
+		senderNameStyle string
+		handleNames     map[int]string
+		handleCards     map[int]*vcard.Card
+		handleAvatars   map[int]string
+		handleAddrs     map[int]string
+		dateDivisor     int
+		cmJoinHasDates  bool
+		// msgHasAudio and msgHasSketches record whether the message table has
+		// the is_audio_message column, and the balloon_bundle_id and
+		// payload_data columns, which older versions of macOS lack.
+		msgHasAudio    bool
+		msgHasSketches bool
 		loc            *time.Location
 		execCommand    func(string, ...string) *exec.Cmd
 	}
 )
 
 // NewChatDB returns a ChatDB interface using the given DB. Init must be called
-// on it before use.
-func NewChatDB(db *sql.DB, selfHandle string) ChatDB {
+// on it before use. The default region is used to normalize phone numbers
+// without a country code when matching handles to contacts, and the sender
+// name style (one of SenderNameStyles) chooses how senders are named after
+// their contacts.
+func NewChatDB(db *sql.DB, selfHandle, defaultRegion, senderNameStyle string) ChatDB {
 	return &chatDB{
-		DB:          db,
-		selfHandle:  selfHandle,
-		execCommand: exec.Command,
+		DB:              db,
+		selfHandle:      selfHandle,
+		defaultRegion:   defaultRegion,
+		senderNameStyle: senderNameStyle,
+		execCommand:     exec.Command,
 	}
 }
 
@@ -85,28 +121,47 @@ func (d *chatDB) Init(macOSVersion *semver.Version, loc *time.Location) error {
 
 	// Check if the chat_message_join table has a message_date column. See
 	// https://github.com/tagatac/bagoup/issues/24.
-	columns, err := d.DB.Query("PRAGMA table_info(chat_message_join)")
+	cmJoinColumns, err := d.tableColumns("chat_message_join")
+	if err != nil {
+		return err
+	}
+	d.cmJoinHasDates = cmJoinColumns["message_date"]
+
+	messageColumns, err := d.tableColumns("message")
 	if err != nil {
-		return fmt.Errorf("get chat_message_join table info: %w", err)
+		return err
+	}
+	d.msgHasAudio = messageColumns["is_audio_message"]
+	d.msgHasSketches = messageColumns["balloon_bundle_id"] && messageColumns["payload_data"]
+
+	return nil
+}
+
+// tableColumns returns the set of the names of the columns in the table.
+func (d *chatDB) tableColumns(table string) (map[string]bool, error) {
+	columns, err := d.DB.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, fmt.Errorf("get %s table info: %w", table, err)
 	}
 	defer columns.Close()
+	names := map[string]bool{}
 	for columns.Next() {
 		var cid, notnull, pk int
 		var name, typ, dflt_value sql.NullString
 		if err := columns.Scan(&cid, &name, &typ, &notnull, &dflt_value, &pk); err != nil {
-			return fmt.Errorf("read chat_message_join column info: %w", err)
-		}
-		if name.String == "message_date" {
-			d.cmJoinHasDates = true
-			break
+			return nil, fmt.Errorf("read %s column info: %w", table, err)
 		}
+		names[name.String] = true
 	}
-
-	return nil
+	return names, nil
 }
 
-func (d chatDB) GetHandleMap(contactMap map[string]*vcard.Card) (map[int]string, error) {
+func (d *chatDB) GetHandleMap(contactMap map[string]*vcard.Card, aliases Aliases) (map[int]string, error) {
 	handleMap := make(map[int]string)
+	d.handleNames = handleMap
+	d.handleCards = make(map[int]*vcard.Card)
+	d.handleAvatars = make(map[int]string)
+	d.handleAddrs = make(map[int]string)
 	handles, err := d.DB.Query("SELECT ROWID, id FROM handle")
 	if err != nil {
 		return nil, fmt.Errorf("get handles from DB: %w", err)
@@ -121,12 +176,22 @@ func (d chatDB) GetHandleMap(contactMap map[string]*vcard.Card) (map[int]string,
 		if _, ok := handleMap[handleID]; ok {
 			return nil, fmt.Errorf("multiple handles with the same ID: %d - handle ID uniqueness assumption violated - %s", handleID, _githubIssueMsg)
 		}
-		if card, ok := contactMap[handle]; ok {
-			name := card.Name()
-			if name != nil && name.GivenName != "" {
-				handle = name.GivenName
+		d.handleAddrs[handleID] = handle
+		address := phonenum.Normalize(handle, d.defaultRegion)
+		if card, ok := contactMap[address]; ok {
+			if name := senderName(card, d.senderNameStyle); name != "" {
+				handle = name
+			}
+			d.handleCards[handleID] = card
+			if avatar := card.Value(FieldAvatar); avatar != "" {
+				d.handleAvatars[handleID] = avatar
 			}
 		}
+		if alias, ok := aliases[address]; ok {
+			handle = alias.name()
+			delete(d.handleCards, handleID)
+			delete(d.handleAvatars, handleID)
+		}
 		handleMap[handleID] = handle
 	}
 	return handleMap, nil
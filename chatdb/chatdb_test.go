@@ -17,12 +17,15 @@ import (
 
 func TestInit(t *testing.T) {
 	tests := []struct {
-		msg              string
-		macOSVersion     *semver.Version
-		setupQuery       func(*sqlmock.ExpectedQuery)
-		wantDivisor      int
-		wantJoinHasDates bool
-		wantErr          string
+		msg               string
+		macOSVersion      *semver.Version
+		setupQuery        func(*sqlmock.ExpectedQuery)
+		setupMessageQuery func(*sqlmock.ExpectedQuery)
+		wantDivisor       int
+		wantJoinHasDates  bool
+		wantHasAudio      bool
+		wantHasSketches   bool
+		wantErr           string
 	}{
 		{
 			msg:          "modern version",
@@ -34,8 +37,18 @@ func TestInit(t *testing.T) {
 					AddRow(3, "message_date", "INTEGER", 0, 0, 0)
 				query.WillReturnRows(rows)
 			},
+			setupMessageQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"cid", "name", "type", "notnull", "dflt_value", "pk"}).
+					AddRow(0, "ROWID", "INTEGER", 0, nil, 1).
+					AddRow(1, "is_audio_message", "INTEGER", 0, 0, 0).
+					AddRow(2, "balloon_bundle_id", "TEXT", 0, nil, 0).
+					AddRow(3, "payload_data", "BLOB", 0, nil, 0)
+				query.WillReturnRows(rows)
+			},
 			wantDivisor:      _modernVersionDateDivisor,
 			wantJoinHasDates: true,
+			wantHasAudio:     true,
+			wantHasSketches:  true,
 		},
 		{
 			msg:          "older version",
@@ -46,6 +59,12 @@ func TestInit(t *testing.T) {
 					AddRow(2, "message_id", "INTEGER", 0, nil, 0)
 				query.WillReturnRows(rows)
 			},
+			setupMessageQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"cid", "name", "type", "notnull", "dflt_value", "pk"}).
+					AddRow(0, "ROWID", "INTEGER", 0, nil, 1).
+					AddRow(1, "balloon_bundle_id", "TEXT", 0, nil, 0)
+				query.WillReturnRows(rows)
+			},
 			wantDivisor:      1,
 			wantJoinHasDates: false,
 		},
@@ -67,6 +86,17 @@ func TestInit(t *testing.T) {
 			},
 			wantErr: `read chat_message_join column info: sql: Scan error on column index 0, name "cid": converting driver.Value type string ("one") to a int: invalid syntax`,
 		},
+		{
+			msg:          "message table PRAGMA query error",
+			macOSVersion: semver.MustParse("12.5"),
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				query.WillReturnRows(sqlmock.NewRows([]string{"cid", "name", "type", "notnull", "dflt_value", "pk"}))
+			},
+			setupMessageQuery: func(query *sqlmock.ExpectedQuery) {
+				query.WillReturnError(errors.New("this is a database error"))
+			},
+			wantErr: "get message table info: this is a database error",
+		},
 	}
 
 	for _, tt := range tests {
@@ -76,6 +106,9 @@ func TestInit(t *testing.T) {
 			defer db.Close()
 			query := sMock.ExpectQuery(`PRAGMA table_info\(chat_message_join\)`)
 			tt.setupQuery(query)
+			if tt.setupMessageQuery != nil {
+				tt.setupMessageQuery(sMock.ExpectQuery(`PRAGMA table_info\(message\)`))
+			}
 
 			cdb := &chatDB{DB: db}
 			err = cdb.Init(tt.macOSVersion, time.UTC)
@@ -86,6 +119,8 @@ func TestInit(t *testing.T) {
 			assert.NilError(t, err)
 			assert.Equal(t, cdb.dateDivisor, tt.wantDivisor)
 			assert.Equal(t, cdb.cmJoinHasDates, tt.wantJoinHasDates)
+			assert.Equal(t, cdb.msgHasAudio, tt.wantHasAudio)
+			assert.Equal(t, cdb.msgHasSketches, tt.wantHasSketches)
 			assert.Equal(t, cdb.loc, time.UTC)
 		})
 	}
@@ -93,11 +128,15 @@ func TestInit(t *testing.T) {
 
 func TestGetHandleMap(t *testing.T) {
 	tests := []struct {
-		msg        string
-		contactMap map[string]*vcard.Card
-		setupQuery func(*sqlmock.ExpectedQuery)
-		wantMap    map[int]string
-		wantErr    string
+		msg         string
+		style       string
+		contactMap  map[string]*vcard.Card
+		aliases     Aliases
+		setupQuery  func(*sqlmock.ExpectedQuery)
+		wantMap     map[int]string
+		wantAvatars map[int]string
+		wantAddrs   map[int]string
+		wantErr     string
 	}{
 		{
 			msg: "empty contact map",
@@ -132,6 +171,119 @@ func TestGetHandleMap(t *testing.T) {
 				2: "testhandle2",
 			},
 		},
+		{
+			msg: "normalized handles",
+			contactMap: map[string]*vcard.Card{
+				"+15551234567": {
+					"N": []*vcard.Field{
+						{Value: "contactsurname;contactgiven;;;"},
+					},
+				},
+				"friend@example.com": {
+					"N": []*vcard.Field{
+						{Value: "friendsurname;friendgiven;;;"},
+					},
+				},
+			},
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"ROWID", "id"}).
+					AddRow(1, "5551234567").
+					AddRow(2, "Friend@Example.com")
+				query.WillReturnRows(rows)
+			},
+			wantMap: map[int]string{
+				1: "contactgiven",
+				2: "friendgiven",
+			},
+		},
+		{
+			msg:   "sender name style",
+			style: SenderNameFull,
+			contactMap: map[string]*vcard.Card{
+				"testhandle1": {
+					"FN": []*vcard.Field{{Value: "contactgiven contactsurname"}},
+					"N":  []*vcard.Field{{Value: "contactsurname;contactgiven;;;"}},
+				},
+				"testhandle2": {
+					"ORG": []*vcard.Field{{Value: "Acme Bank"}},
+				},
+			},
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"ROWID", "id"}).
+					AddRow(1, "testhandle1").
+					AddRow(2, "testhandle2")
+				query.WillReturnRows(rows)
+			},
+			wantMap: map[int]string{
+				1: "contactgiven contactsurname",
+				2: "Acme Bank",
+			},
+		},
+		{
+			msg: "aliases",
+			contactMap: map[string]*vcard.Card{
+				"+15551234567": {
+					"N": []*vcard.Field{
+						{Value: "contactsurname;contactgiven;;;"},
+					},
+				},
+			},
+			aliases: Aliases{
+				"+15551234567": {Name: "aliasname"},
+				"262966":       {Entity: "Acme Bank"},
+			},
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"ROWID", "id"}).
+					AddRow(1, "5551234567").
+					AddRow(2, "262966").
+					AddRow(3, "testhandle3")
+				query.WillReturnRows(rows)
+			},
+			wantMap: map[int]string{
+				1: "aliasname",
+				2: "Acme Bank",
+				3: "testhandle3",
+			},
+		},
+		{
+			msg: "contact avatars",
+			contactMap: map[string]*vcard.Card{
+				"+15551234567": {
+					"N":         []*vcard.Field{{Value: "contactsurname;contactgiven;;;"}},
+					FieldAvatar: []*vcard.Field{{Value: "avatar-1.jpg"}},
+				},
+				"+15559876543": {
+					"N":         []*vcard.Field{{Value: "othersurname;othergiven;;;"}},
+					FieldAvatar: []*vcard.Field{{Value: "avatar-2.jpg"}},
+				},
+				"+15550000000": {
+					"N": []*vcard.Field{{Value: "nophotosurname;nophotogiven;;;"}},
+				},
+			},
+			aliases: Aliases{
+				"+15559876543": {Name: "aliasname"},
+			},
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"ROWID", "id"}).
+					AddRow(1, "+15551234567").
+					AddRow(2, "+15559876543").
+					AddRow(3, "+15550000000")
+				query.WillReturnRows(rows)
+			},
+			wantMap: map[int]string{
+				1: "contactgiven",
+				2: "aliasname",
+				3: "nophotogiven",
+			},
+			wantAvatars: map[int]string{
+				1: "avatar-1.jpg",
+			},
+			wantAddrs: map[int]string{
+				1: "+15551234567",
+				2: "+15559876543",
+				3: "+15550000000",
+			},
+		},
 		{
 			msg: "DB error",
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
@@ -169,14 +321,20 @@ func TestGetHandleMap(t *testing.T) {
 			query := sMock.ExpectQuery("SELECT ROWID, id FROM handle")
 			tt.setupQuery(query)
 
-			cdb := NewChatDB(db, "Me")
-			handleMap, err := cdb.GetHandleMap(tt.contactMap)
+			cdb := NewChatDB(db, "Me", "US", tt.style)
+			handleMap, err := cdb.GetHandleMap(tt.contactMap, tt.aliases)
 			if tt.wantErr != "" {
 				assert.Error(t, err, tt.wantErr)
 				return
 			}
 			assert.NilError(t, err)
 			assert.DeepEqual(t, tt.wantMap, handleMap)
+			if tt.wantAvatars != nil {
+				assert.DeepEqual(t, tt.wantAvatars, cdb.(*chatDB).handleAvatars)
+			}
+			if tt.wantAddrs != nil {
+				assert.DeepEqual(t, tt.wantAddrs, cdb.(*chatDB).handleAddrs)
+			}
 		})
 	}
 }
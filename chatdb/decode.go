@@ -1,34 +1,62 @@
 package chatdb
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"strings"
+	"unicode/utf8"
 
 	ts "github.com/tagatac/go-typedstream"
 )
 
-func (d *chatDB) decodeTypedStream(s string) (string, error) {
+// The typedstream encoding of an NSString's class name and its string value
+// are separated by a handful of bytes, the last of which is the '+' type code
+// for a UTF-8 string. Don't look any further than this for the type code.
+const _nsStringTypeCodeMaxOffset = 8
+
+// _audioTranscriptionKey is the attribute under which Messages stores the
+// transcription of an audio message.
+const _audioTranscriptionKey = "IMAudioTranscription"
+
+// typedStreamBody holds the parts of a decoded attributedBody.
+type typedStreamBody struct {
+	text               string
+	audioTranscription string
+}
+
+func (d *chatDB) decodeTypedStream(s string) (typedStreamBody, error) {
 	u, err := ts.NewUnarchiverFromData([]byte(s))
 	if err != nil {
-		return "", fmt.Errorf("create unarchiver: %w", err)
+		return typedStreamBody{}, fmt.Errorf("create unarchiver: %w", err)
 	}
 	groups, err := u.DecodeAll()
 	if err != nil {
-		return "", fmt.Errorf("decode all: %w", err)
+		return typedStreamBody{}, fmt.Errorf("decode all: %w", err)
 	}
 	obj, err := extractArchivedObject(groups)
 	if err != nil {
-		return "", err
+		return typedStreamBody{}, err
 	}
 	baseStr, err := extractBaseString(obj)
 	if err != nil {
-		return "", err
+		return typedStreamBody{}, err
+	}
+	body := typedStreamBody{text: baseStr}
+	// Remaining attributes are appended in ObjC description style.
+	var extras []string
+	for _, attr := range collectAttributes(obj) {
+		if attr.key == _audioTranscriptionKey {
+			body.audioTranscription = attr.value
+			continue
+		}
+		extras = append(extras, fmt.Sprintf(`    %s = "%s"`, attr.key, attr.value))
 	}
-	extras := collectExtras(obj)
 	if len(extras) > 0 {
-		return baseStr + "{\n" + strings.Join(extras, "\n") + "\n}", nil
+		body.text += "{\n" + strings.Join(extras, "\n") + "\n}"
 	}
-	return baseStr, nil
+	return body, nil
 }
 
 // extractArchivedObject pulls the top-level NSMutableAttributedString object
@@ -60,11 +88,17 @@ func extractBaseString(obj *ts.GenericArchivedObject) (string, error) {
 	}
 }
 
-// collectExtras scans remaining content groups for NSDictionary attributes,
-// returning formatted key=value pairs for keys that don't start with "__kIM"
-// (e.g. IMAudioTranscription). Format mirrors ObjC description style.
-func collectExtras(obj *ts.GenericArchivedObject) []string {
-	var extras []string
+// stringAttribute is a string-valued key/value pair from an NSDictionary of
+// attributes.
+type stringAttribute struct {
+	key, value string
+}
+
+// collectAttributes scans remaining content groups for NSDictionary
+// attributes, returning string-valued pairs for keys that don't start with
+// "__kIM" (e.g. IMAudioTranscription).
+func collectAttributes(obj *ts.GenericArchivedObject) []stringAttribute {
+	var attrs []stringAttribute
 	for _, group := range obj.Contents[1:] {
 		for _, val := range group.Values {
 			dict, ok := val.(*ts.NSDictionary)
@@ -77,10 +111,65 @@ func collectExtras(obj *ts.GenericArchivedObject) []string {
 					continue
 				}
 				if v, ok := kv.Value.(*ts.NSString); ok {
-					extras = append(extras, fmt.Sprintf(`    %s = "%s"`, k.Value, v.Value))
+					attrs = append(attrs, stringAttribute{key: k.Value, value: v.Value})
 				}
 			}
 		}
 	}
-	return extras
+	return attrs
+}
+
+// extractNSStringFallback scans a raw attributedBody for the first NSString
+// payload: the '+' type code following the NSString class name, then a
+// length-prefixed run of UTF-8 bytes. It is a heuristic used only when the
+// typedstream cannot be unarchived in full.
+func extractNSStringFallback(b []byte) (string, error) {
+	classIdx := bytes.Index(b, []byte("NSString"))
+	if classIdx < 0 {
+		return "", errors.New("no NSString class found")
+	}
+	b = b[classIdx+len("NSString"):]
+	typeIdx := bytes.IndexByte(b[:min(len(b), _nsStringTypeCodeMaxOffset)], '+')
+	if typeIdx < 0 {
+		return "", errors.New("no string type code found after NSString class")
+	}
+	b = b[typeIdx+1:]
+	length, n, err := decodeTypedStreamLength(b)
+	if err != nil {
+		return "", err
+	}
+	b = b[n:]
+	if length > len(b) {
+		return "", fmt.Errorf("string length %d exceeds remaining %d bytes", length, len(b))
+	}
+	if !utf8.Valid(b[:length]) {
+		return "", errors.New("string is not valid UTF-8")
+	}
+	return string(b[:length]), nil
+}
+
+// decodeTypedStreamLength decodes a typedstream integer: a single byte, or a
+// 0x81 or 0x82 tag followed by a little-endian 16- or 32-bit integer. It
+// returns the integer and the number of bytes consumed.
+func decodeTypedStreamLength(b []byte) (int, int, error) {
+	if len(b) == 0 {
+		return 0, 0, errors.New("missing string length")
+	}
+	switch b[0] {
+	case 0x81:
+		if len(b) < 3 {
+			return 0, 0, errors.New("truncated 16-bit string length")
+		}
+		return int(binary.LittleEndian.Uint16(b[1:3])), 3, nil
+	case 0x82:
+		if len(b) < 5 {
+			return 0, 0, errors.New("truncated 32-bit string length")
+		}
+		return int(binary.LittleEndian.Uint32(b[1:5])), 5, nil
+	default:
+		if b[0] > 0x7f {
+			return 0, 0, fmt.Errorf("unexpected string length tag 0x%x", b[0])
+		}
+		return int(b[0]), 1, nil
+	}
 }
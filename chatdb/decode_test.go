@@ -36,57 +36,61 @@ func TestDecode(t *testing.T) {
 	)
 
 	tests := []struct {
-		msg         string
-		setupQuery  func(*sqlmock.ExpectedQuery)
-		wantMessage string
-		wantValid   bool
-		wantErr     string
+		msg               string
+		setupQuery        func(*sqlmock.ExpectedQuery)
+		wantMessage       string
+		wantStatus        TextStatus
+		wantAudio         bool
+		wantTranscription string
+		wantErr           string
 	}{
 		{
 			msg: "typical iMessage",
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
-				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date"}).
-					AddRow(0, 10, nil, string(_attributedBodyNSString), date20191004)
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date", "is_audio_message", "balloon_bundle_id", "payload_data"}).
+					AddRow(0, 10, nil, string(_attributedBodyNSString), date20191004, 0, nil, nil)
 				query.WillReturnRows(rows)
 			},
 			wantMessage: "[2019-10-04 18:26:31] testhandle1: no, should i?\n",
-			wantValid:   true,
+			wantStatus:  TextValid,
 		},
 		{
 			msg: "2FA code",
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
-				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date"}).
-					AddRow(0, 10, nil, string(_attributedBodyVenmo), date20191004)
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date", "is_audio_message", "balloon_bundle_id", "payload_data"}).
+					AddRow(0, 10, nil, string(_attributedBodyVenmo), date20191004, 0, nil, nil)
 				query.WillReturnRows(rows)
 			},
 			wantMessage: "[2019-10-04 18:26:31] testhandle1: Venmo here! NEVER share this code via call/text. ONLY YOU should enter the code. BEWARE: If someone asks for the code, it's a scam. Code: 975002\n",
-			wantValid:   true,
+			wantStatus:  TextValid,
 		},
 		{
 			msg: "Google 2FA code",
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
-				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date"}).
-					AddRow(0, 10, nil, string(_attributedBodyGoogle), date20231217)
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date", "is_audio_message", "balloon_bundle_id", "payload_data"}).
+					AddRow(0, 10, nil, string(_attributedBodyGoogle), date20231217, 0, nil, nil)
 				query.WillReturnRows(rows)
 			},
 			wantMessage: "[2023-12-17 21:27:07] testhandle1: G-913121 is your Google verification code.\n",
-			wantValid:   true,
+			wantStatus:  TextValid,
 		},
 		{
 			msg: "audio transcription",
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
-				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date"}).
-					AddRow(0, 10, nil, string(_attributedBodyAudio), date20240101)
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date", "is_audio_message", "balloon_bundle_id", "payload_data"}).
+					AddRow(0, 10, nil, string(_attributedBodyAudio), date20240101, 1, nil, nil)
 				query.WillReturnRows(rows)
 			},
-			wantMessage: "[2024-01-01 12:00:00] testhandle1: ￼{\n    IMAudioTranscription = \"I don't think it's correct that I have the option to buy whatever number shares at the same price as the other doesn't make any sense How am I winning here? Am I getting those chairs?\"\n}\n",
-			wantValid:   true,
+			wantMessage:       "[2024-01-01 12:00:00] testhandle1: 🎤 Voice memo \uFFFC\n",
+			wantStatus:        TextValid,
+			wantAudio:         true,
+			wantTranscription: "I don't think it's correct that I have the option to buy whatever number shares at the same price as the other doesn't make any sense How am I winning here? Am I getting those chairs?",
 		},
 		{
 			msg: "failure creating unarchiver",
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
-				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date"}).
-					AddRow(0, 10, nil, "", date20191004)
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date", "is_audio_message", "balloon_bundle_id", "payload_data"}).
+					AddRow(0, 10, nil, "", date20191004, 0, nil, nil)
 				query.WillReturnRows(rows)
 			},
 			wantMessage: "[2019-10-04 18:26:31] testhandle1: \n",
@@ -94,8 +98,8 @@ func TestDecode(t *testing.T) {
 		{
 			msg: "failure to decode all",
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
-				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date"}).
-					AddRow(0, 10, nil, "\x04\x0bstreamtyped\x62\x84\x85", date20191004)
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date", "is_audio_message", "balloon_bundle_id", "payload_data"}).
+					AddRow(0, 10, nil, "\x04\x0bstreamtyped\x62\x84\x85", date20191004, 0, nil, nil)
 				query.WillReturnRows(rows)
 			},
 			wantMessage: "[2019-10-04 18:26:31] testhandle1: \n",
@@ -103,8 +107,8 @@ func TestDecode(t *testing.T) {
 		{
 			msg: "empty stream",
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
-				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date"}).
-					AddRow(0, 10, nil, "\x04\x0bstreamtyped\x62", date20191004)
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date", "is_audio_message", "balloon_bundle_id", "payload_data"}).
+					AddRow(0, 10, nil, "\x04\x0bstreamtyped\x62", date20191004, 0, nil, nil)
 				query.WillReturnRows(rows)
 			},
 			wantMessage: "[2019-10-04 18:26:31] testhandle1: \n",
@@ -112,8 +116,8 @@ func TestDecode(t *testing.T) {
 		{
 			msg: "wrong top-level value type",
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
-				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date"}).
-					AddRow(0, 10, nil, "\x04\x0bstreamtyped\x62\x84\x01i\x01", date20191004)
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date", "is_audio_message", "balloon_bundle_id", "payload_data"}).
+					AddRow(0, 10, nil, "\x04\x0bstreamtyped\x62\x84\x01i\x01", date20191004, 0, nil, nil)
 				query.WillReturnRows(rows)
 			},
 			wantMessage: "[2019-10-04 18:26:31] testhandle1: \n",
@@ -121,8 +125,8 @@ func TestDecode(t *testing.T) {
 		{
 			msg: "no contents in the first group",
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
-				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date"}).
-					AddRow(0, 10, nil, "\x04\x0bstreamtyped\x62\x84\x01@\x84\x84\x84\x01Z\x00\x85\x86", date20191004)
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date", "is_audio_message", "balloon_bundle_id", "payload_data"}).
+					AddRow(0, 10, nil, "\x04\x0bstreamtyped\x62\x84\x01@\x84\x84\x84\x01Z\x00\x85\x86", date20191004, 0, nil, nil)
 				query.WillReturnRows(rows)
 			},
 			wantMessage: "[2019-10-04 18:26:31] testhandle1: \n",
@@ -130,8 +134,37 @@ func TestDecode(t *testing.T) {
 		{
 			msg: "no string in the contents",
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
-				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date"}).
-					AddRow(0, 10, nil, "\x04\x0bstreamtyped\x62\x84\x01@\x84\x84\x84\x01Z\x00\x85\x84\x01i\x01\x86", date20191004)
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date", "is_audio_message", "balloon_bundle_id", "payload_data"}).
+					AddRow(0, 10, nil, "\x04\x0bstreamtyped\x62\x84\x01@\x84\x84\x84\x01Z\x00\x85\x84\x01i\x01\x86", date20191004, 0, nil, nil)
+				query.WillReturnRows(rows)
+			},
+			wantMessage: "[2019-10-04 18:26:31] testhandle1: \n",
+		},
+		{
+			msg: "truncated stream - NSString recovered",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date", "is_audio_message", "balloon_bundle_id", "payload_data"}).
+					AddRow(0, 10, nil, string(_attributedBodyNSString[:0x57]), date20191004, 0, nil, nil)
+				query.WillReturnRows(rows)
+			},
+			wantMessage: "[2019-10-04 18:26:31] testhandle1: no, should i?\n",
+			wantStatus:  TextRecovered,
+		},
+		{
+			msg: "truncated stream - NSString with 16-bit length recovered",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date", "is_audio_message", "balloon_bundle_id", "payload_data"}).
+					AddRow(0, 10, nil, string(_attributedBodyVenmo[:0x10c]), date20191004, 0, nil, nil)
+				query.WillReturnRows(rows)
+			},
+			wantMessage: "[2019-10-04 18:26:31] testhandle1: Venmo here! NEVER share this code via call/text. ONLY YOU should enter the code. BEWARE: If someone asks for the code, it's a scam. Code: 975002\n",
+			wantStatus:  TextRecovered,
+		},
+		{
+			msg: "truncated stream - NSString cut off",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date", "is_audio_message", "balloon_bundle_id", "payload_data"}).
+					AddRow(0, 10, nil, string(_attributedBodyVenmo[:0x100]), date20191004, 0, nil, nil)
 				query.WillReturnRows(rows)
 			},
 			wantMessage: "[2019-10-04 18:26:31] testhandle1: \n",
@@ -143,7 +176,7 @@ func TestDecode(t *testing.T) {
 			db, sMock, err := sqlmock.New()
 			assert.NilError(t, err)
 			defer db.Close()
-			query := sMock.ExpectQuery(`SELECT is_from_me, handle_id, text, attributedBody, date FROM message WHERE ROWID\=42`)
+			query := sMock.ExpectQuery(`SELECT is_from_me, handle_id, text, attributedBody, date, is_audio_message, balloon_bundle_id, payload_data FROM message WHERE ROWID\=42`)
 			tt.setupQuery(query)
 			cdb := &chatDB{
 				DB:             db,
@@ -151,16 +184,97 @@ func TestDecode(t *testing.T) {
 				dateDivisor:    _modernVersionDateDivisor,
 				loc:            time.UTC,
 				cmJoinHasDates: true,
+				msgHasAudio:    true,
+				msgHasSketches: true,
+			}
+
+			message, err := cdb.GetMessage(42, handleMap)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
 			}
+			assert.NilError(t, err)
+			assert.Equal(t, message.Status, tt.wantStatus)
+			assert.Equal(t, message.String(), tt.wantMessage)
+			assert.Equal(t, message.IsAudio, tt.wantAudio)
+			assert.Equal(t, message.AudioTranscription, tt.wantTranscription)
+		})
+	}
+}
 
-			message, ok, err := cdb.GetMessage(42, handleMap)
+func TestExtractNSStringFallback(t *testing.T) {
+	tests := []struct {
+		msg     string
+		data    string
+		wantStr string
+		wantErr string
+	}{
+		{
+			msg:     "single-byte length",
+			data:    "NSString\x01\x94\x84\x01+\x05hello\x86",
+			wantStr: "hello",
+		},
+		{
+			msg:     "16-bit length",
+			data:    "NSString\x01\x94\x84\x01+\x81\x05\x00hello",
+			wantStr: "hello",
+		},
+		{
+			msg:     "32-bit length",
+			data:    "NSString\x01\x94\x84\x01+\x82\x05\x00\x00\x00hello",
+			wantStr: "hello",
+		},
+		{
+			msg:     "no NSString class",
+			data:    "NSNumber\x01\x94\x84\x01+\x05hello",
+			wantErr: "no NSString class found",
+		},
+		{
+			msg:     "no type code",
+			data:    "NSString\x01\x94\x84\x01i\x05hello+",
+			wantErr: "no string type code found after NSString class",
+		},
+		{
+			msg:     "missing length",
+			data:    "NSString\x01\x94\x84\x01+",
+			wantErr: "missing string length",
+		},
+		{
+			msg:     "truncated 16-bit length",
+			data:    "NSString\x01\x94\x84\x01+\x81\x05",
+			wantErr: "truncated 16-bit string length",
+		},
+		{
+			msg:     "truncated 32-bit length",
+			data:    "NSString\x01\x94\x84\x01+\x82\x05\x00",
+			wantErr: "truncated 32-bit string length",
+		},
+		{
+			msg:     "unexpected length tag",
+			data:    "NSString\x01\x94\x84\x01+\x85hello",
+			wantErr: "unexpected string length tag 0x85",
+		},
+		{
+			msg:     "string longer than data",
+			data:    "NSString\x01\x94\x84\x01+\x09hello",
+			wantErr: "string length 9 exceeds remaining 5 bytes",
+		},
+		{
+			msg:     "invalid UTF-8",
+			data:    "NSString\x01\x94\x84\x01+\x02\xff\xfe",
+			wantErr: "string is not valid UTF-8",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			str, err := extractNSStringFallback([]byte(tt.data))
 			if tt.wantErr != "" {
 				assert.Error(t, err, tt.wantErr)
 				return
 			}
 			assert.NilError(t, err)
-			assert.Equal(t, ok, tt.wantValid)
-			assert.Equal(t, message, tt.wantMessage)
+			assert.Equal(t, str, tt.wantStr)
 		})
 	}
 }
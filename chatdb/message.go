@@ -7,23 +7,131 @@ import (
 	"database/sql"
 	"fmt"
 	"log/slog"
+	"strings"
 	"time"
 )
 
 // appleEpochUnixSec is the Unix timestamp of Apple's reference date 2001-01-01 00:00:00 UTC.
 const appleEpochUnixSec int64 = 978307200
 
+// TextStatus indicates how the text of a message was retrieved.
+type TextStatus int
+
+const (
+	// TextInvalid means no text could be retrieved for the message.
+	TextInvalid TextStatus = iota
+	// TextValid means the text was read directly or fully decoded.
+	TextValid
+	// TextRecovered means the typedstream could not be decoded, but the text
+	// was recovered heuristically from the raw attributedBody.
+	TextRecovered
+)
+
+// String names the status: valid, recovered, or invalid.
+func (s TextStatus) String() string {
+	switch s {
+	case TextValid:
+		return "valid"
+	case TextRecovered:
+		return "recovered"
+	default:
+		return "invalid"
+	}
+}
+
+// VoiceMemoLabel marks voice memos (see Message.IsAudio) in chat files for
+// reading.
+const VoiceMemoLabel = "🎤 Voice memo"
+
+// Message represents a row from the message table, resolved for writing to a
+// chat file.
+type Message struct {
+	ID     int
+	Date   time.Time
+	Sender string
+	// SenderAvatar is the path to the sender's contact photo, if any.
+	SenderAvatar string
+	// SenderHandle is the phone number or email address of the sender, or
+	// empty for messages sent by me.
+	SenderHandle string
+	FromMe       bool
+	Text         string
+	Status       TextStatus
+	// IsAudio marks a voice memo recorded in Messages.
+	IsAudio            bool
+	AudioTranscription string
+	// Sketch is the drawing in a handwritten message or Digital Touch sketch,
+	// or nil if the message has none.
+	Sketch *Sketch
+	// ChatGUID is the GUID of the chat in which the message was sent, if
+	// known (see DatedMessageID).
+	ChatGUID string
+}
+
+// Service returns the service over which the message was sent, e.g. iMessage
+// or SMS, from its chat GUID.
+func (m Message) Service() string {
+	service, _, _ := strings.Cut(m.ChatGUID, ";")
+	return service
+}
+
+// String formats the message as a line of a chat file, with voice memos
+// labeled.
+func (m Message) String() string {
+	text := m.Text
+	if m.IsAudio {
+		text = VoiceMemoLabel + " " + text
+	}
+	return fmt.Sprintf("[%s] %s: %s\n", m.Date.Format(time.DateTime), m.Sender, text)
+}
+
 // DatedMessageID pairs a message ID and its date, in the legacy date format.
 type DatedMessageID struct {
 	ID   int
 	Date int
+	// ChatGUID is the GUID of the chat in which the message was sent, for
+	// telling apart the messages of merged chats. It is not set by ChatDB.
+	ChatGUID string
 }
 
-func (d chatDB) GetMessageIDs(chatID int) ([]DatedMessageID, error) {
+// DateRange limits messages to those sent at or after Since and before Until.
+// A zero time leaves that end of the range open.
+type DateRange struct {
+	Since time.Time
+	Until time.Time
+}
+
+// conditions returns SQL conditions limiting the given date column to the
+// range, where dates are counted from the Apple epoch in units of 1/divisor
+// seconds.
+func (r DateRange) conditions(column string, divisor int) []string {
+	var conds []string
+	if !r.Since.IsZero() {
+		conds = append(conds, fmt.Sprintf("%s >= %d", column, appleDate(r.Since, divisor)))
+	}
+	if !r.Until.IsZero() {
+		conds = append(conds, fmt.Sprintf("%s < %d", column, appleDate(r.Until, divisor)))
+	}
+	return conds
+}
+
+func appleDate(t time.Time, divisor int) int64 {
+	return (t.Unix() - appleEpochUnixSec) * int64(divisor)
+}
+
+// The message_date column of the chat_message_join table, converted to
+// nanoseconds where it is in seconds (see GetMessageIDs).
+const _cmJoinMessageDate = "(CASE WHEN message_date < 1000000000000 THEN message_date * 1000000000 ELSE message_date END)"
+
+func (d chatDB) GetMessageIDs(chatID int, dates DateRange) ([]DatedMessageID, error) {
 	if !d.cmJoinHasDates {
-		return d.getMessageIDsLegacy(chatID)
+		return d.getMessageIDsLegacy(chatID, dates)
+	}
+	query := fmt.Sprintf("SELECT message_id, message_date FROM chat_message_join WHERE chat_id=%d", chatID)
+	for _, cond := range dates.conditions(_cmJoinMessageDate, _modernVersionDateDivisor) {
+		query += " AND " + cond
 	}
-	rows, err := d.DB.Query(fmt.Sprintf("SELECT message_id, message_date FROM chat_message_join WHERE chat_id=%d", chatID))
+	rows, err := d.DB.Query(query)
 	if err != nil {
 		return nil, fmt.Errorf("query chat_message_join table for chat ID %d: %w", chatID, err)
 	}
@@ -40,15 +148,19 @@ func (d chatDB) GetMessageIDs(chatID int) ([]DatedMessageID, error) {
 		if date < 1_000*_modernVersionDateDivisor {
 			date *= _modernVersionDateDivisor
 		}
-		msgIDs = append(msgIDs, DatedMessageID{id, date})
+		msgIDs = append(msgIDs, DatedMessageID{ID: id, Date: date})
 	}
 	return msgIDs, nil
 }
 
 // Older chat.db files do not have the chat_message_join.message_date column, so
 // we need to also query the message table in this case to get dates.
-func (d chatDB) getMessageIDsLegacy(chatID int) ([]DatedMessageID, error) {
-	rows, err := d.DB.Query(fmt.Sprintf("SELECT message_id FROM chat_message_join WHERE chat_id=%d", chatID))
+func (d chatDB) getMessageIDsLegacy(chatID int, dates DateRange) ([]DatedMessageID, error) {
+	query := fmt.Sprintf("SELECT message_id FROM chat_message_join WHERE chat_id=%d", chatID)
+	if conds := dates.conditions("date", d.dateDivisor); len(conds) > 0 {
+		query += fmt.Sprintf(" AND message_id IN (SELECT ROWID FROM message WHERE %s)", strings.Join(conds, " AND "))
+	}
+	rows, err := d.DB.Query(query)
 	if err != nil {
 		return nil, fmt.Errorf("query chat_message_join table for chat ID %d: %w", chatID, err)
 	}
@@ -82,44 +194,138 @@ func (d chatDB) getMessageIDsLegacy(chatID int) ([]DatedMessageID, error) {
 	return msgIDs, nil
 }
 
-func (d *chatDB) GetMessage(messageID int, handleMap map[int]string) (string, bool, error) {
-	messages, err := d.DB.Query(fmt.Sprintf("SELECT is_from_me, handle_id, text, attributedBody, date FROM message WHERE ROWID=%d", messageID))
+func (d *chatDB) GetMessage(messageID int, handleMap map[int]string) (Message, error) {
+	// Columns which the message table lacks on older versions of macOS are
+	// selected as constants.
+	isAudioColumn, bundleIDColumn, payloadColumn := "0", "NULL", "NULL"
+	if d.msgHasAudio {
+		isAudioColumn = "is_audio_message"
+	}
+	if d.msgHasSketches {
+		bundleIDColumn, payloadColumn = "balloon_bundle_id", "payload_data"
+	}
+	messages, err := d.DB.Query(fmt.Sprintf("SELECT is_from_me, handle_id, text, attributedBody, date, %s, %s, %s FROM message WHERE ROWID=%d", isAudioColumn, bundleIDColumn, payloadColumn, messageID))
 	if err != nil {
-		return "", false, fmt.Errorf("query message table for ID %d: %w", messageID, err)
+		return Message{}, fmt.Errorf("query message table for ID %d: %w", messageID, err)
 	}
 	defer messages.Close()
 	messages.Next()
-	var fromMe, handleID int
-	var text, attributedBody sql.NullString
+	var fromMe, handleID, isAudio int
+	var text, attributedBody, bundleID sql.NullString
 	var rawDate int64
-	if err := messages.Scan(&fromMe, &handleID, &text, &attributedBody, &rawDate); err != nil {
-		return "", false, fmt.Errorf("read data for message ID %d: %w", messageID, err)
+	var payload []byte
+	if err := messages.Scan(&fromMe, &handleID, &text, &attributedBody, &rawDate, &isAudio, &bundleID, &payload); err != nil {
+		return Message{}, fmt.Errorf("read data for message ID %d: %w", messageID, err)
 	}
 	if messages.Next() {
-		return "", false, fmt.Errorf("multiple messages with the same ID: %d - message ID uniqueness assumption violated - %s", messageID, _githubIssueMsg)
+		return Message{}, fmt.Errorf("multiple messages with the same ID: %d - message ID uniqueness assumption violated - %s", messageID, _githubIssueMsg)
 	}
 	unixSec := rawDate/int64(d.dateDivisor) + appleEpochUnixSec
-	date := time.Unix(unixSec, 0).In(d.loc).Format(time.DateTime)
-	handle := handleMap[handleID]
-	if fromMe == 1 {
-		handle = d.selfHandle
+	msg := Message{
+		ID:      messageID,
+		Date:    time.Unix(unixSec, 0).In(d.loc),
+		Sender:  handleMap[handleID],
+		FromMe:  fromMe == 1,
+		Status:  TextValid,
+		IsAudio: isAudio == 1,
+	}
+	if msg.FromMe {
+		msg.Sender = d.selfHandle
+	} else {
+		msg.SenderAvatar = d.handleAvatars[handleID]
+		msg.SenderHandle = d.handleAddrs[handleID]
 	}
-	var msg string
-	valid := true
 	if text.Valid {
-		msg = text.String
+		msg.Text = text.String
 	} else if attributedBody.Valid {
-		msg, err = d.decodeTypedStream(attributedBody.String)
-		if err != nil {
-			valid = false
-			slog.Warn("failed to get plain text for message",
-				"messageID", messageID,
-				"err", fmt.Errorf("decode typedstream: %w", err),
-			)
-		}
+		d.decodeAttributedBody(&msg, attributedBody.String)
 	} else {
-		valid = false
+		msg.Status = TextInvalid
 		slog.Warn("no valid text or attributedBody for message", "messageID", messageID)
 	}
-	return fmt.Sprintf("[%s] %s: %s\n", date, handle, msg), valid, nil
+	if isSketchBundleID(bundleID.String) {
+		decodeSketchPayload(&msg, bundleID.String, payload)
+	}
+	return msg, nil
+}
+
+func (d *chatDB) GetRecentMessageTexts(chatID int, dates DateRange, limit int) ([]Message, error) {
+	query := fmt.Sprintf("SELECT message.ROWID, message.is_from_me, message.text, message.attributedBody FROM chat_message_join JOIN message ON message.ROWID = chat_message_join.message_id WHERE chat_message_join.chat_id=%d", chatID)
+	for _, cond := range dates.conditions("message.date", d.dateDivisor) {
+		query += " AND " + cond
+	}
+	query += fmt.Sprintf(" ORDER BY message.date DESC LIMIT %d", limit)
+	rows, err := d.DB.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("query recent messages of chat ID %d: %w", chatID, err)
+	}
+	defer rows.Close()
+	msgs := []Message{}
+	for rows.Next() {
+		var fromMe int
+		var text, attributedBody sql.NullString
+		msg := Message{Status: TextValid}
+		if err := rows.Scan(&msg.ID, &fromMe, &text, &attributedBody); err != nil {
+			return nil, fmt.Errorf("read recent message of chat ID %d: %w", chatID, err)
+		}
+		msg.FromMe = fromMe == 1
+		if msg.FromMe {
+			// My messages are only counted.
+			msg.Status = TextInvalid
+		} else if text.Valid {
+			msg.Text = text.String
+		} else if attributedBody.Valid {
+			d.decodeAttributedBody(&msg, attributedBody.String)
+		} else {
+			msg.Status = TextInvalid
+		}
+		msgs = append(msgs, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read recent messages of chat ID %d: %w", chatID, err)
+	}
+	return msgs, nil
+}
+
+// decodeSketchPayload decodes the stroke data of a handwritten message or
+// Digital Touch sketch into the given message. Payloads which cannot be decoded
+// are logged and skipped so that the rest of the message is still exported.
+func decodeSketchPayload(msg *Message, bundleID string, payload []byte) {
+	if len(payload) == 0 {
+		slog.Warn("no payload data for sketch message", "messageID", msg.ID, "bundleID", bundleID)
+		return
+	}
+	sketch, err := decodeSketch(bundleID, payload)
+	if err != nil {
+		slog.Warn("failed to decode sketch", "messageID", msg.ID, "bundleID", bundleID, "err", err)
+		return
+	}
+	msg.Sketch = sketch
+}
+
+// decodeAttributedBody decodes the attributedBody typedstream into the given
+// message, falling back to scanning the raw bytes for the NSString payload if
+// decoding fails.
+func (d *chatDB) decodeAttributedBody(msg *Message, attributedBody string) {
+	body, err := d.decodeTypedStream(attributedBody)
+	if err == nil {
+		msg.Text, msg.AudioTranscription = body.text, body.audioTranscription
+		return
+	}
+	decodeErr := fmt.Errorf("decode typedstream: %w", err)
+	text, err := extractNSStringFallback([]byte(attributedBody))
+	if err != nil {
+		msg.Status = TextInvalid
+		slog.Warn("failed to get plain text for message",
+			"messageID", msg.ID,
+			"err", decodeErr,
+			"fallbackErr", fmt.Errorf("scan for NSString: %w", err),
+		)
+		return
+	}
+	msg.Text, msg.Status = text, TextRecovered
+	slog.Warn("recovered plain text for message heuristically; attributes may be missing",
+		"messageID", msg.ID,
+		"err", decodeErr,
+	)
 }
@@ -5,6 +5,7 @@ package chatdb
 
 import (
 	"errors"
+	"regexp"
 	"testing"
 	"time"
 
@@ -14,9 +15,15 @@ import (
 )
 
 func TestGetMessageIDs(t *testing.T) {
+	dates := DateRange{
+		Since: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+		Until: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
 	tests := []struct {
 		msg       string
 		legacyDB  bool
+		dates     DateRange
 		setupMock func(sqlmock.Sqlmock)
 		wantIDs   []DatedMessageID
 		wantErr   string
@@ -30,8 +37,8 @@ func TestGetMessageIDs(t *testing.T) {
 				sMock.ExpectQuery("SELECT message_id, message_date FROM chat_message_join WHERE chat_id=42").WillReturnRows(rows)
 			},
 			wantIDs: []DatedMessageID{
-				{192, 593720716622331392},
-				{168, 601412272000000000},
+				{ID: 192, Date: 593720716622331392},
+				{ID: 168, Date: 601412272000000000},
 			},
 		},
 		{
@@ -50,8 +57,36 @@ func TestGetMessageIDs(t *testing.T) {
 				sMock.ExpectQuery("SELECT date FROM message WHERE ROWID=168").WillReturnRows(rows)
 			},
 			wantIDs: []DatedMessageID{
-				{192, 593720716622331392},
-				{168, 601412272470654464},
+				{ID: 192, Date: 593720716622331392},
+				{ID: 168, Date: 601412272470654464},
+			},
+		},
+		{
+			msg:   "date range",
+			dates: dates,
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"message_id", "message_date"}).
+					AddRow(192, 593720716622331392)
+				sMock.ExpectQuery(regexp.QuoteMeta("SELECT message_id, message_date FROM chat_message_join WHERE chat_id=42 AND (CASE WHEN message_date < 1000000000000 THEN message_date * 1000000000 ELSE message_date END) >= 567993600000000000 AND (CASE WHEN message_date < 1000000000000 THEN message_date * 1000000000 ELSE message_date END) < 599529600000000000")).WillReturnRows(rows)
+			},
+			wantIDs: []DatedMessageID{
+				{ID: 192, Date: 593720716622331392},
+			},
+		},
+		{
+			msg:      "date range legacy",
+			legacyDB: true,
+			dates:    DateRange{Since: dates.Since},
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"message_id"}).
+					AddRow(192)
+				sMock.ExpectQuery(regexp.QuoteMeta("SELECT message_id FROM chat_message_join WHERE chat_id=42 AND message_id IN (SELECT ROWID FROM message WHERE date >= 567993600)")).WillReturnRows(rows)
+				rows = sqlmock.NewRows([]string{"date"}).
+					AddRow(593720716)
+				sMock.ExpectQuery("SELECT date FROM message WHERE ROWID=192").WillReturnRows(rows)
+			},
+			wantIDs: []DatedMessageID{
+				{ID: 192, Date: 593720716},
 			},
 		},
 		{
@@ -139,10 +174,11 @@ func TestGetMessageIDs(t *testing.T) {
 			tt.setupMock(sMock)
 			cdb := &chatDB{
 				DB:             db,
+				dateDivisor:    1,
 				cmJoinHasDates: !tt.legacyDB,
 			}
 
-			ids, err := cdb.GetMessageIDs(42)
+			ids, err := cdb.GetMessageIDs(42, tt.dates)
 			if tt.wantErr != "" {
 				assert.Error(t, err, tt.wantErr)
 				return
@@ -156,53 +192,75 @@ func TestGetMessageIDs(t *testing.T) {
 func TestGetMessage(t *testing.T) {
 	handleMap := map[int]string{
 		10: "testhandle1",
+		11: "testhandle2",
 	}
 	// 591906391000000000 nanoseconds since Apple epoch (2001-01-01 00:00:00 UTC)
 	// = 2019-10-04 18:26:31 UTC
 	const appleNanos int64 = 591906391000000000
 
+	digitalTouchSketch := append(protoField(_digitalTouchSketchPointsField, le16(1, 2)), protoField(_digitalTouchSketchCountsField, []byte{1})...)
+	digitalTouchPayload := append(protoField(_digitalTouchKindField, _digitalTouchKindSketch), protoField(_digitalTouchSketchField, digitalTouchSketch)...)
+
 	tests := []struct {
-		msg         string
-		loc         *time.Location
-		setupQuery  func(*sqlmock.ExpectedQuery)
-		ptsOutput   string
-		ptsErr      string
+		msg        string
+		loc        *time.Location
+		setupQuery func(*sqlmock.ExpectedQuery)
+		ptsOutput  string
+		ptsErr     string
+		// oldTable leaves out the columns which older message tables lack.
+		oldTable    bool
 		wantMessage string
-		wantValid   bool
+		wantStatus  TextStatus
+		wantSketch  *Sketch
+		wantAvatar  string
+		wantHandle  string
 		wantErr     string
 	}{
 		{
 			msg: "message to me - UTC",
 			loc: time.UTC,
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
-				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date"}).
-					AddRow(0, 10, "message text", "", appleNanos)
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date", "is_audio_message", "balloon_bundle_id", "payload_data"}).
+					AddRow(0, 10, "message text", "", appleNanos, 0, nil, nil)
 				query.WillReturnRows(rows)
 			},
 			wantMessage: "[2019-10-04 18:26:31] testhandle1: message text\n",
-			wantValid:   true,
+			wantStatus:  TextValid,
+		},
+		{
+			msg: "message from a contact with an avatar",
+			loc: time.UTC,
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date", "is_audio_message", "balloon_bundle_id", "payload_data"}).
+					AddRow(0, 11, "message text", "", appleNanos, 0, nil, nil)
+				query.WillReturnRows(rows)
+			},
+			wantMessage: "[2019-10-04 18:26:31] testhandle2: message text\n",
+			wantStatus:  TextValid,
+			wantAvatar:  "avatar-1.jpg",
+			wantHandle:  "friend@gmail.com",
 		},
 		{
 			msg: "message from me - UTC",
 			loc: time.UTC,
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
-				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date"}).
-					AddRow(1, 10, "message text", "", appleNanos)
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date", "is_audio_message", "balloon_bundle_id", "payload_data"}).
+					AddRow(1, 10, "message text", "", appleNanos, 0, nil, nil)
 				query.WillReturnRows(rows)
 			},
 			wantMessage: "[2019-10-04 18:26:31] Me: message text\n",
-			wantValid:   true,
+			wantStatus:  TextValid,
 		},
 		{
 			msg: "message to me - UTC-8",
 			loc: time.FixedZone("UTC-8", -8*60*60),
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
-				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date"}).
-					AddRow(0, 10, "message text", "", appleNanos)
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date", "is_audio_message", "balloon_bundle_id", "payload_data"}).
+					AddRow(0, 10, "message text", "", appleNanos, 0, nil, nil)
 				query.WillReturnRows(rows)
 			},
 			wantMessage: "[2019-10-04 10:26:31] testhandle1: message text\n",
-			wantValid:   true,
+			wantStatus:  TextValid,
 		},
 		{
 			msg: "DB error",
@@ -216,8 +274,8 @@ func TestGetMessage(t *testing.T) {
 			msg: "row scan error",
 			loc: time.UTC,
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
-				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date"}).
-					AddRow(0, nil, "message text", "", appleNanos)
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date", "is_audio_message", "balloon_bundle_id", "payload_data"}).
+					AddRow(0, nil, "message text", "", appleNanos, 0, nil, nil)
 				query.WillReturnRows(rows)
 			},
 			wantErr: `read data for message ID 42: sql: Scan error on column index 1, name "handle_id": converting NULL to int is unsupported`,
@@ -226,9 +284,9 @@ func TestGetMessage(t *testing.T) {
 			msg: "duplicate message ID",
 			loc: time.UTC,
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
-				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date"}).
-					AddRow(0, 10, "message text", "", appleNanos).
-					AddRow(1, 10, "response message text", "", appleNanos)
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date", "is_audio_message", "balloon_bundle_id", "payload_data"}).
+					AddRow(0, 10, "message text", "", appleNanos, 0, nil, nil).
+					AddRow(1, 10, "response message text", "", appleNanos, 0, nil, nil)
 				query.WillReturnRows(rows)
 			},
 			wantErr: "multiple messages with the same ID: 42 - message ID uniqueness assumption violated - open an issue at https://github.com/tagatac/bagoup/issues",
@@ -237,12 +295,62 @@ func TestGetMessage(t *testing.T) {
 			msg: "no valid text or attributedBody",
 			loc: time.UTC,
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
-				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date"}).
-					AddRow(0, 10, nil, nil, appleNanos)
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date", "is_audio_message", "balloon_bundle_id", "payload_data"}).
+					AddRow(0, 10, nil, nil, appleNanos, 0, nil, nil)
 				query.WillReturnRows(rows)
 			},
 			wantMessage: "[2019-10-04 18:26:31] testhandle1: \n",
 		},
+		{
+			msg: "Digital Touch sketch",
+			loc: time.UTC,
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date", "is_audio_message", "balloon_bundle_id", "payload_data"}).
+					AddRow(0, 10, "\ufffc", "", appleNanos, 0, "com.apple.DigitalTouchBalloonProvider", digitalTouchPayload)
+				query.WillReturnRows(rows)
+			},
+			wantMessage: "[2019-10-04 18:26:31] testhandle1: \ufffc\n",
+			wantStatus:  TextValid,
+			wantSketch: &Sketch{
+				Width:   300,
+				Height:  300,
+				Strokes: []Stroke{{Points: []Point{{X: 1, Y: 2, Width: 4}}}},
+			},
+		},
+		{
+			msg: "undecodable sketch",
+			loc: time.UTC,
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date", "is_audio_message", "balloon_bundle_id", "payload_data"}).
+					AddRow(0, 10, "\ufffc", "", appleNanos, 0, "com.apple.Handwriting.HandwritingProvider", []byte{0xff})
+				query.WillReturnRows(rows)
+			},
+			wantMessage: "[2019-10-04 18:26:31] testhandle1: \ufffc\n",
+			wantStatus:  TextValid,
+		},
+		{
+			msg: "sketch without payload",
+			loc: time.UTC,
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date", "is_audio_message", "balloon_bundle_id", "payload_data"}).
+					AddRow(0, 10, "\ufffc", "", appleNanos, 0, "com.apple.Handwriting.HandwritingProvider", nil)
+				query.WillReturnRows(rows)
+			},
+			wantMessage: "[2019-10-04 18:26:31] testhandle1: \ufffc\n",
+			wantStatus:  TextValid,
+		},
+		{
+			msg:      "message table without audio and sketch columns",
+			loc:      time.UTC,
+			oldTable: true,
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "attributedBody", "date", "0", "NULL", "NULL"}).
+					AddRow(0, 10, "message text", "", appleNanos, 0, nil, nil)
+				query.WillReturnRows(rows)
+			},
+			wantMessage: "[2019-10-04 18:26:31] testhandle1: message text\n",
+			wantStatus:  TextValid,
+		},
 	}
 
 	for _, tt := range tests {
@@ -250,8 +358,11 @@ func TestGetMessage(t *testing.T) {
 			db, sMock, err := sqlmock.New()
 			assert.NilError(t, err)
 			defer db.Close()
-			query := sMock.ExpectQuery(`SELECT is_from_me, handle_id, text, attributedBody, date FROM message WHERE ROWID\=42`)
-			tt.setupQuery(query)
+			query := `SELECT is_from_me, handle_id, text, attributedBody, date, is_audio_message, balloon_bundle_id, payload_data FROM message WHERE ROWID\=42`
+			if tt.oldTable {
+				query = `SELECT is_from_me, handle_id, text, attributedBody, date, 0, NULL, NULL FROM message WHERE ROWID\=42`
+			}
+			tt.setupQuery(sMock.ExpectQuery(query))
 			exitCode := 0
 			if tt.ptsErr != "" {
 				exitCode = 1
@@ -262,17 +373,93 @@ func TestGetMessage(t *testing.T) {
 				dateDivisor:    _modernVersionDateDivisor,
 				loc:            tt.loc,
 				cmJoinHasDates: true,
+				msgHasAudio:    !tt.oldTable,
+				msgHasSketches: !tt.oldTable,
+				handleAvatars:  map[int]string{11: "avatar-1.jpg"},
+				handleAddrs:    map[int]string{11: "friend@gmail.com"},
 				execCommand:    exectest.GenFakeExecCommand("TestRunExecCmd", tt.ptsOutput, tt.ptsErr, exitCode),
 			}
 
-			message, ok, err := cdb.GetMessage(42, handleMap)
+			message, err := cdb.GetMessage(42, handleMap)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, message.Status, tt.wantStatus)
+			assert.Equal(t, message.String(), tt.wantMessage)
+			assert.DeepEqual(t, message.Sketch, tt.wantSketch)
+			assert.Equal(t, message.SenderAvatar, tt.wantAvatar)
+			assert.Equal(t, message.SenderHandle, tt.wantHandle)
+		})
+	}
+}
+
+func TestGetRecentMessageTexts(t *testing.T) {
+	const query = "SELECT message.ROWID, message.is_from_me, message.text, message.attributedBody FROM chat_message_join JOIN message ON message.ROWID = chat_message_join.message_id WHERE chat_message_join.chat_id=42"
+	columns := []string{"ROWID", "is_from_me", "text", "attributedBody"}
+	tests := []struct {
+		msg       string
+		dates     DateRange
+		setupMock func(sqlmock.Sqlmock)
+		wantMsgs  []Message
+		wantErr   string
+	}{
+		{
+			msg: "texts of other senders",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows(columns).
+					AddRow(3, 0, "Your code is 123456", nil).
+					AddRow(2, 1, "thanks", nil).
+					AddRow(1, 0, nil, "not a typedstream")
+				sMock.ExpectQuery(regexp.QuoteMeta(query + " ORDER BY message.date DESC LIMIT 10")).WillReturnRows(rows)
+			},
+			wantMsgs: []Message{
+				{ID: 3, Text: "Your code is 123456", Status: TextValid},
+				{ID: 2, FromMe: true, Status: TextInvalid},
+				{ID: 1, Status: TextInvalid},
+			},
+		},
+		{
+			msg:   "date range",
+			dates: DateRange{Since: time.Unix(appleEpochUnixSec+10, 0)},
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(query + " AND message.date >= 10 ORDER BY message.date DESC LIMIT 10")).WillReturnRows(sqlmock.NewRows(columns))
+			},
+			wantMsgs: []Message{},
+		},
+		{
+			msg: "query error",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "query recent messages of chat ID 42: this is a DB error",
+		},
+		{
+			msg: "row scan error",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows(columns).AddRow("one", 0, "hi", nil)
+				sMock.ExpectQuery(regexp.QuoteMeta(query)).WillReturnRows(rows)
+			},
+			wantErr: `read recent message of chat ID 42: sql: Scan error on column index 0, name "ROWID": converting driver.Value type string ("one") to a int: invalid syntax`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			tt.setupMock(sMock)
+			cdb := &chatDB{DB: db, dateDivisor: 1}
+
+			msgs, err := cdb.GetRecentMessageTexts(42, tt.dates, 10)
 			if tt.wantErr != "" {
 				assert.Error(t, err, tt.wantErr)
 				return
 			}
 			assert.NilError(t, err)
-			assert.Equal(t, ok, tt.wantValid)
-			assert.Equal(t, message, tt.wantMessage)
+			assert.DeepEqual(t, tt.wantMsgs, msgs)
 		})
 	}
 }
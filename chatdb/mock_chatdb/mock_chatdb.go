@@ -59,44 +59,58 @@ func (mr *MockChatDBMockRecorder) GetAttachmentPaths(ptools any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAttachmentPaths", reflect.TypeOf((*MockChatDB)(nil).GetAttachmentPaths), ptools)
 }
 
+// GetChatActivity mocks base method.
+func (m *MockChatDB) GetChatActivity(dates chatdb.DateRange) (map[int]chatdb.ChatActivity, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetChatActivity", dates)
+	ret0, _ := ret[0].(map[int]chatdb.ChatActivity)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetChatActivity indicates an expected call of GetChatActivity.
+func (mr *MockChatDBMockRecorder) GetChatActivity(dates any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetChatActivity", reflect.TypeOf((*MockChatDB)(nil).GetChatActivity), dates)
+}
+
 // GetChats mocks base method.
-func (m *MockChatDB) GetChats(contactMap map[string]*vcard.Card) ([]chatdb.EntityChats, error) {
+func (m *MockChatDB) GetChats(contactMap map[string]*vcard.Card, aliases chatdb.Aliases) ([]chatdb.EntityChats, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetChats", contactMap)
+	ret := m.ctrl.Call(m, "GetChats", contactMap, aliases)
 	ret0, _ := ret[0].([]chatdb.EntityChats)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetChats indicates an expected call of GetChats.
-func (mr *MockChatDBMockRecorder) GetChats(contactMap any) *gomock.Call {
+func (mr *MockChatDBMockRecorder) GetChats(contactMap, aliases any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetChats", reflect.TypeOf((*MockChatDB)(nil).GetChats), contactMap)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetChats", reflect.TypeOf((*MockChatDB)(nil).GetChats), contactMap, aliases)
 }
 
 // GetHandleMap mocks base method.
-func (m *MockChatDB) GetHandleMap(contactMap map[string]*vcard.Card) (map[int]string, error) {
+func (m *MockChatDB) GetHandleMap(contactMap map[string]*vcard.Card, aliases chatdb.Aliases) (map[int]string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetHandleMap", contactMap)
+	ret := m.ctrl.Call(m, "GetHandleMap", contactMap, aliases)
 	ret0, _ := ret[0].(map[int]string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetHandleMap indicates an expected call of GetHandleMap.
-func (mr *MockChatDBMockRecorder) GetHandleMap(contactMap any) *gomock.Call {
+func (mr *MockChatDBMockRecorder) GetHandleMap(contactMap, aliases any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHandleMap", reflect.TypeOf((*MockChatDB)(nil).GetHandleMap), contactMap)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHandleMap", reflect.TypeOf((*MockChatDB)(nil).GetHandleMap), contactMap, aliases)
 }
 
 // GetMessage mocks base method.
-func (m *MockChatDB) GetMessage(messageID int, handleMap map[int]string) (string, bool, error) {
+func (m *MockChatDB) GetMessage(messageID int, handleMap map[int]string) (chatdb.Message, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GetMessage", messageID, handleMap)
-	ret0, _ := ret[0].(string)
-	ret1, _ := ret[1].(bool)
-	ret2, _ := ret[2].(error)
-	return ret0, ret1, ret2
+	ret0, _ := ret[0].(chatdb.Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // GetMessage indicates an expected call of GetMessage.
@@ -106,18 +120,33 @@ func (mr *MockChatDBMockRecorder) GetMessage(messageID, handleMap any) *gomock.C
 }
 
 // GetMessageIDs mocks base method.
-func (m *MockChatDB) GetMessageIDs(chatID int) ([]chatdb.DatedMessageID, error) {
+func (m *MockChatDB) GetMessageIDs(chatID int, dates chatdb.DateRange) ([]chatdb.DatedMessageID, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetMessageIDs", chatID)
+	ret := m.ctrl.Call(m, "GetMessageIDs", chatID, dates)
 	ret0, _ := ret[0].([]chatdb.DatedMessageID)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetMessageIDs indicates an expected call of GetMessageIDs.
-func (mr *MockChatDBMockRecorder) GetMessageIDs(chatID any) *gomock.Call {
+func (mr *MockChatDBMockRecorder) GetMessageIDs(chatID, dates any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMessageIDs", reflect.TypeOf((*MockChatDB)(nil).GetMessageIDs), chatID, dates)
+}
+
+// GetRecentMessageTexts mocks base method.
+func (m *MockChatDB) GetRecentMessageTexts(chatID int, dates chatdb.DateRange, limit int) ([]chatdb.Message, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRecentMessageTexts", chatID, dates, limit)
+	ret0, _ := ret[0].([]chatdb.Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRecentMessageTexts indicates an expected call of GetRecentMessageTexts.
+func (mr *MockChatDBMockRecorder) GetRecentMessageTexts(chatID, dates, limit any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMessageIDs", reflect.TypeOf((*MockChatDB)(nil).GetMessageIDs), chatID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRecentMessageTexts", reflect.TypeOf((*MockChatDB)(nil).GetRecentMessageTexts), chatID, dates, limit)
 }
 
 // Init mocks base method.
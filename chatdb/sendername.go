@@ -0,0 +1,142 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package chatdb
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/emersion/go-vcard"
+)
+
+// Styles for naming the senders of messages after their contacts.
+const (
+	// SenderNameGiven is the contact's given (first) name. This is the default.
+	SenderNameGiven = "given"
+	// SenderNameFull is the contact's full (formatted) name.
+	SenderNameFull = "full"
+	// SenderNameNickname is the contact's nickname.
+	SenderNameNickname = "nickname"
+	// SenderNameGivenInitial is the contact's given name and the initial of
+	// their family name, e.g. "Alex K.".
+	SenderNameGivenInitial = "given-initial"
+	// SenderNameOrganization is the contact's organization.
+	SenderNameOrganization = "organization"
+	// SenderNameHandle is the phone number or email address of the sender,
+	// ignoring contacts.
+	SenderNameHandle = "handle"
+)
+
+// SenderNameStyles lists the valid sender name styles.
+var SenderNameStyles = []string{SenderNameGiven, SenderNameFull, SenderNameNickname, SenderNameGivenInitial, SenderNameOrganization, SenderNameHandle}
+
+// The names to try, in order, when a contact lacks the name in the chosen
+// style.
+var _senderNameFallbacks = []string{SenderNameGiven, SenderNameNickname, SenderNameFull, SenderNameOrganization}
+
+// senderName names a contact in the given style, falling back to the given
+// name, nickname, full name, and organization. An empty string is returned if
+// the card has none of these names, or if the style is SenderNameHandle.
+func senderName(card *vcard.Card, style string) string {
+	if style == SenderNameHandle {
+		return ""
+	}
+	for _, s := range append([]string{style}, _senderNameFallbacks...) {
+		if name := contactName(card, s); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// entityName names a contact for its export folder: by its full name, or by
+// its organization or nickname for cards without a name.
+func entityName(card *vcard.Card) string {
+	for _, style := range []string{SenderNameFull, SenderNameOrganization, SenderNameNickname} {
+		if name := contactName(card, style); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// contactName names a contact in the given style, or returns an empty string
+// if the card lacks that name.
+func contactName(card *vcard.Card, style string) string {
+	var name string
+	switch style {
+	case SenderNameGiven:
+		if n := card.Name(); n != nil {
+			name = n.GivenName
+		}
+	case SenderNameFull:
+		name = card.PreferredValue(vcard.FieldFormattedName)
+		if n := card.Name(); strings.TrimSpace(name) == "" && n != nil {
+			name = n.GivenName + " " + n.FamilyName
+		}
+	case SenderNameNickname:
+		name, _, _ = strings.Cut(card.PreferredValue(vcard.FieldNickname), ",")
+	case SenderNameGivenInitial:
+		n := card.Name()
+		if n == nil || n.GivenName == "" {
+			return ""
+		}
+		name = n.GivenName
+		if initial, _ := utf8.DecodeRuneInString(strings.TrimSpace(n.FamilyName)); initial != utf8.RuneError {
+			name = fmt.Sprintf("%s %c.", n.GivenName, initial)
+		}
+	case SenderNameOrganization:
+		name, _, _ = strings.Cut(card.PreferredValue(vcard.FieldOrganization), ";")
+	}
+	return strings.TrimSpace(name)
+}
+
+// disambiguateSenders renames the participants of a group chat who share a
+// name, with their given names and last initials, or failing that, their full
+// names. It returns the new names by handle ID, or nil if no participants
+// share a name.
+func (d chatDB) disambiguateSenders(handleIDs []int) map[int]string {
+	names := make(map[int]string, len(handleIDs))
+	for _, handleID := range handleIDs {
+		names[handleID] = d.handleNames[handleID]
+	}
+	ambiguous := ambiguousSenders(names, handleIDs)
+	for _, style := range []string{SenderNameGivenInitial, SenderNameFull} {
+		for _, handleID := range ambiguous {
+			if card, ok := d.handleCards[handleID]; ok {
+				if name := contactName(card, style); name != "" {
+					names[handleID] = name
+				}
+			}
+		}
+		ambiguous = ambiguousSenders(names, ambiguous)
+	}
+	var renamed map[int]string
+	for handleID, name := range names {
+		if name != d.handleNames[handleID] {
+			if renamed == nil {
+				renamed = map[int]string{}
+			}
+			renamed[handleID] = name
+		}
+	}
+	return renamed
+}
+
+// ambiguousSenders returns the handle IDs among the candidates whose names
+// are shared by other senders.
+func ambiguousSenders(names map[int]string, candidates []int) []int {
+	counts := map[string]int{}
+	for _, name := range names {
+		counts[name]++
+	}
+	var ambiguous []int
+	for _, handleID := range candidates {
+		if counts[names[handleID]] > 1 {
+			ambiguous = append(ambiguous, handleID)
+		}
+	}
+	return ambiguous
+}
@@ -0,0 +1,156 @@
+package chatdb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/emersion/go-vcard"
+	"gotest.tools/v3/assert"
+)
+
+func TestSenderName(t *testing.T) {
+	fullCard := &vcard.Card{
+		"FN":       []*vcard.Field{{Value: "Alexandra Kowalski"}},
+		"N":        []*vcard.Field{{Value: "Kowalski;Alexandra;;;"}},
+		"NICKNAME": []*vcard.Field{{Value: "Alex,Ola"}},
+		"ORG":      []*vcard.Field{{Value: "Acme;Engineering"}},
+	}
+	orgCard := &vcard.Card{
+		"ORG": []*vcard.Field{{Value: "Acme Bank"}},
+	}
+	nicknameCard := &vcard.Card{
+		"NICKNAME": []*vcard.Field{{Value: "Bubbles"}},
+	}
+	givenOnlyCard := &vcard.Card{
+		"N": []*vcard.Field{{Value: ";Cher;;;"}},
+	}
+
+	tests := []struct {
+		msg        string
+		card       *vcard.Card
+		style      string
+		wantName   string
+		wantEntity string
+	}{
+		{msg: "default", card: fullCard, wantName: "Alexandra", wantEntity: "Alexandra Kowalski"},
+		{msg: "given", card: fullCard, style: SenderNameGiven, wantName: "Alexandra", wantEntity: "Alexandra Kowalski"},
+		{msg: "full", card: fullCard, style: SenderNameFull, wantName: "Alexandra Kowalski", wantEntity: "Alexandra Kowalski"},
+		{msg: "nickname", card: fullCard, style: SenderNameNickname, wantName: "Alex", wantEntity: "Alexandra Kowalski"},
+		{msg: "given plus last initial", card: fullCard, style: SenderNameGivenInitial, wantName: "Alexandra K.", wantEntity: "Alexandra Kowalski"},
+		{msg: "organization", card: fullCard, style: SenderNameOrganization, wantName: "Acme", wantEntity: "Alexandra Kowalski"},
+		{msg: "handle", card: fullCard, style: SenderNameHandle, wantName: "", wantEntity: "Alexandra Kowalski"},
+		{msg: "organization only", card: orgCard, style: SenderNameGiven, wantName: "Acme Bank", wantEntity: "Acme Bank"},
+		{msg: "nickname only", card: nicknameCard, style: SenderNameFull, wantName: "Bubbles", wantEntity: "Bubbles"},
+		{msg: "given name only", card: givenOnlyCard, style: SenderNameGivenInitial, wantName: "Cher", wantEntity: "Cher"},
+		{msg: "empty card", card: &vcard.Card{}, style: SenderNameGiven, wantName: "", wantEntity: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			assert.Equal(t, senderName(tt.card, tt.style), tt.wantName)
+			assert.Equal(t, entityName(tt.card), tt.wantEntity)
+		})
+	}
+}
+
+func TestGetChatsSenderNames(t *testing.T) {
+	alexK := &vcard.Card{
+		"FN": []*vcard.Field{{Value: "Alex Kowalski"}},
+		"N":  []*vcard.Field{{Value: "Kowalski;Alex;;;"}},
+	}
+	alexM := &vcard.Card{
+		"FN": []*vcard.Field{{Value: "Alex Martin"}},
+		"N":  []*vcard.Field{{Value: "Martin;Alex;;;"}},
+	}
+	alexMa := &vcard.Card{
+		"FN": []*vcard.Field{{Value: "Alex Mata"}},
+		"N":  []*vcard.Field{{Value: "Mata;Alex;;;"}},
+	}
+	sam := &vcard.Card{
+		"FN": []*vcard.Field{{Value: "Sam Lee"}},
+		"N":  []*vcard.Field{{Value: "Lee;Sam;;;"}},
+	}
+	handleNames := map[int]string{1: "Alex", 2: "Alex", 3: "Alex", 4: "Sam"}
+	handleCards := map[int]*vcard.Card{1: alexK, 2: alexM, 3: alexMa, 4: sam}
+
+	tests := []struct {
+		msg              string
+		setupQueries     func(sqlmock.Sqlmock)
+		wantSenderNames  map[int]map[int]string
+		wantParticipants map[int][]string
+		wantErr          string
+	}{
+		{
+			msg: "shared first names",
+			setupQueries: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery("SELECT chat_id, handle_id FROM chat_handle_join ORDER BY chat_id, handle_id").
+					WillReturnRows(sqlmock.NewRows([]string{"chat_id", "handle_id"}).
+						AddRow(1, 1).AddRow(1, 2).AddRow(1, 4).
+						AddRow(2, 2).AddRow(2, 3).
+						AddRow(3, 1).AddRow(3, 4).
+						AddRow(4, 4).AddRow(4, 5))
+				sMock.ExpectQuery(`SELECT ROWID, guid, chat_identifier, COALESCE\(display_name, ''\), style FROM chat`).
+					WillReturnRows(sqlmock.NewRows([]string{"ROWID", "guid", "chat_identifier", "display_name", "style"}).
+						AddRow(1, "iMessage;+;chat1", "chat1", "Hikers", 43).
+						AddRow(2, "iMessage;+;chat2", "chat2", "Climbers", 43).
+						AddRow(3, "iMessage;+;chat3", "chat3", "Runners", 43).
+						AddRow(4, "iMessage;-;sam@example.com", "sam@example.com", "", 45))
+			},
+			wantSenderNames: map[int]map[int]string{
+				1: {1: "Alex K.", 2: "Alex M."},
+				2: {2: "Alex Martin", 3: "Alex Mata"},
+				3: nil,
+				4: nil,
+			},
+			wantParticipants: map[int][]string{
+				1: {"Alex K.", "Alex M.", "Sam"},
+				2: {"Alex Martin", "Alex Mata"},
+				3: {"Alex", "Sam"},
+				4: {"Sam"},
+			},
+		},
+		{
+			msg: "DB error",
+			setupQueries: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery("SELECT chat_id, handle_id FROM chat_handle_join").WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "query chat participants: this is a DB error",
+		},
+		{
+			msg: "row scan error",
+			setupQueries: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery("SELECT chat_id, handle_id FROM chat_handle_join").
+					WillReturnRows(sqlmock.NewRows([]string{"chat_id", "handle_id"}).AddRow(1, nil))
+			},
+			wantErr: "read chat participant: sql: Scan error on column index 1, name \"handle_id\": converting NULL to int is unsupported",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			tt.setupQueries(sMock)
+			cdb := &chatDB{DB: db, handleNames: handleNames, handleCards: handleCards}
+
+			chats, err := cdb.GetChats(nil, nil)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			senderNames := map[int]map[int]string{}
+			participants := map[int][]string{}
+			for _, entityChats := range chats {
+				for _, chat := range entityChats.Chats {
+					senderNames[chat.ID] = chat.SenderNames
+					participants[chat.ID] = chat.Participants
+				}
+			}
+			assert.DeepEqual(t, tt.wantSenderNames, senderNames)
+			assert.DeepEqual(t, tt.wantParticipants, participants)
+		})
+	}
+}
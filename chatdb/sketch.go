@@ -0,0 +1,286 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package chatdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ulikunitz/xz"
+)
+
+// Balloon bundle IDs of messages which store stroke data in payload_data
+// instead of an image attachment.
+const (
+	_handwritingBundleIDPrefix  = "com.apple.Handwriting"
+	_digitalTouchBundleIDPrefix = "com.apple.DigitalTouchBalloonProvider"
+)
+
+type (
+	// Sketch is a drawing decoded from the stroke data of a handwritten message
+	// or a Digital Touch sketch.
+	Sketch struct {
+		Width   int
+		Height  int
+		Strokes []Stroke
+	}
+
+	// Stroke is a single continuous line in a Sketch. Color is a CSS hex color,
+	// or empty for the default ink color.
+	Stroke struct {
+		Color  string
+		Points []Point
+	}
+
+	// Point is a point on a Stroke, relative to the top-left corner of the
+	// Sketch. Width is the width of the line at that point.
+	Point struct {
+		X     int
+		Y     int
+		Width int
+	}
+)
+
+// isSketchBundleID reports whether messages with the given balloon bundle ID
+// carry stroke data.
+func isSketchBundleID(bundleID string) bool {
+	return strings.HasPrefix(bundleID, _handwritingBundleIDPrefix) ||
+		strings.HasPrefix(bundleID, _digitalTouchBundleIDPrefix)
+}
+
+// decodeSketch decodes the payload_data of a handwritten message or Digital
+// Touch message with the given balloon bundle ID.
+func decodeSketch(bundleID string, payload []byte) (*Sketch, error) {
+	if strings.HasPrefix(bundleID, _handwritingBundleIDPrefix) {
+		return decodeHandwriting(payload)
+	}
+	return decodeDigitalTouch(payload)
+}
+
+// Protobuf field numbers of the handwriting payload. The top-level message
+// wraps a Handwriting message, which holds the frame of the drawing and its
+// (usually XZ-compressed) stroke data.
+const (
+	_handwritingField            = 4
+	_handwritingFrameField       = 2
+	_handwritingStrokesField     = 3
+	_handwritingCompressionField = 6
+
+	_handwritingCompressionNone = 1
+	_handwritingCompressionXZ   = 2
+
+	// Stroke data larger than this after decompression is rejected, so that a
+	// small payload cannot expand without bound.
+	_maxHandwritingStrokeBytes = 16 << 20
+)
+
+// decodeHandwriting decodes a handwritten message payload. The frame is four
+// little-endian int16s (x, y, width, height), and the points are made relative
+// to its origin. The stroke data is a uint16
+// stroke count, then for each stroke a uint16 point count followed by 8-byte
+// points: int16 x, int16 y, uint16 width, and 2 unused bytes.
+func decodeHandwriting(payload []byte) (*Sketch, error) {
+	top, err := parseProtoFields(payload)
+	if err != nil {
+		return nil, fmt.Errorf("parse payload: %w", err)
+	}
+	hwBytes, ok := top.bytes(_handwritingField)
+	if !ok {
+		return nil, errors.New("no handwriting in payload")
+	}
+	hw, err := parseProtoFields(hwBytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse handwriting: %w", err)
+	}
+	frame, _ := hw.bytes(_handwritingFrameField)
+	if len(frame) != 8 {
+		return nil, fmt.Errorf("invalid frame size %d", len(frame))
+	}
+	strokeData, _ := hw.bytes(_handwritingStrokesField)
+	compression, _ := hw.varint(_handwritingCompressionField)
+	switch compression {
+	case _handwritingCompressionNone:
+	case _handwritingCompressionXZ:
+		r, err := xz.NewReader(bytes.NewReader(strokeData))
+		if err != nil {
+			return nil, fmt.Errorf("decompress strokes: %w", err)
+		}
+		if strokeData, err = io.ReadAll(io.LimitReader(r, _maxHandwritingStrokeBytes+1)); err != nil {
+			return nil, fmt.Errorf("decompress strokes: %w", err)
+		}
+		if len(strokeData) > _maxHandwritingStrokeBytes {
+			return nil, fmt.Errorf("decompressed strokes exceed %d bytes", _maxHandwritingStrokeBytes)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported stroke compression %d", compression)
+	}
+	originX := int(int16(binary.LittleEndian.Uint16(frame[0:2])))
+	originY := int(int16(binary.LittleEndian.Uint16(frame[2:4])))
+	sketch := &Sketch{
+		Width:  int(int16(binary.LittleEndian.Uint16(frame[4:6]))),
+		Height: int(int16(binary.LittleEndian.Uint16(frame[6:8]))),
+	}
+	if sketch.Width <= 0 || sketch.Height <= 0 {
+		return nil, fmt.Errorf("invalid frame dimensions %dx%d", sketch.Width, sketch.Height)
+	}
+	r := bytes.NewReader(strokeData)
+	var numStrokes uint16
+	if err := binary.Read(r, binary.LittleEndian, &numStrokes); err != nil {
+		return nil, fmt.Errorf("read stroke count: %w", err)
+	}
+	for i := 0; i < int(numStrokes); i++ {
+		var numPoints uint16
+		if err := binary.Read(r, binary.LittleEndian, &numPoints); err != nil {
+			return nil, fmt.Errorf("read point count of stroke %d: %w", i, err)
+		}
+		points := make([]struct {
+			X, Y  int16
+			Width uint16
+			_     uint16
+		}, numPoints)
+		if err := binary.Read(r, binary.LittleEndian, points); err != nil {
+			return nil, fmt.Errorf("read points of stroke %d: %w", i, err)
+		}
+		stroke := Stroke{Points: make([]Point, len(points))}
+		for j, p := range points {
+			stroke.Points[j] = Point{X: int(p.X) - originX, Y: int(p.Y) - originY, Width: int(p.Width)}
+		}
+		sketch.Strokes = append(sketch.Strokes, stroke)
+	}
+	return sketch, nil
+}
+
+// Protobuf field numbers of the Digital Touch payload. Only sketches are
+// decoded; the other kinds (taps, kisses, heartbeats, etc.) are animations
+// with no meaningful still image.
+const (
+	_digitalTouchKindField         = 1
+	_digitalTouchSketchField       = 3
+	_digitalTouchSketchPointsField = 2
+	_digitalTouchSketchCountsField = 3
+	_digitalTouchSketchColorsField = 4
+
+	_digitalTouchKindSketch = 1
+
+	// Digital Touch sketches are drawn on a square canvas.
+	_digitalTouchCanvasSize = 300
+	_digitalTouchLineWidth  = 4
+)
+
+// decodeDigitalTouch decodes a Digital Touch sketch payload. The sketch holds
+// all points as consecutive little-endian int16 (x, y) pairs, the number of
+// points in each stroke as packed varints, and one RGBA color per stroke.
+func decodeDigitalTouch(payload []byte) (*Sketch, error) {
+	top, err := parseProtoFields(payload)
+	if err != nil {
+		return nil, fmt.Errorf("parse payload: %w", err)
+	}
+	if kind, _ := top.varint(_digitalTouchKindField); kind != _digitalTouchKindSketch {
+		return nil, fmt.Errorf("unsupported Digital Touch kind %d", kind)
+	}
+	sketchBytes, ok := top.bytes(_digitalTouchSketchField)
+	if !ok {
+		return nil, errors.New("no sketch in payload")
+	}
+	fields, err := parseProtoFields(sketchBytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse sketch: %w", err)
+	}
+	pointData, _ := fields.bytes(_digitalTouchSketchPointsField)
+	countData, _ := fields.bytes(_digitalTouchSketchCountsField)
+	colors, _ := fields.bytes(_digitalTouchSketchColorsField)
+	if len(pointData)%4 != 0 {
+		return nil, fmt.Errorf("invalid point data length %d", len(pointData))
+	}
+	sketch := &Sketch{Width: _digitalTouchCanvasSize, Height: _digitalTouchCanvasSize}
+	for i := 0; len(countData) > 0; i++ {
+		count, n := binary.Uvarint(countData)
+		if n <= 0 {
+			return nil, fmt.Errorf("read point count of stroke %d", i)
+		}
+		countData = countData[n:]
+		if count > uint64(len(pointData)/4) {
+			return nil, fmt.Errorf("stroke %d has %d points but only %d remain", i, count, len(pointData)/4)
+		}
+		stroke := Stroke{Points: make([]Point, count)}
+		for j := range stroke.Points {
+			stroke.Points[j] = Point{
+				X:     int(int16(binary.LittleEndian.Uint16(pointData[0:2]))),
+				Y:     int(int16(binary.LittleEndian.Uint16(pointData[2:4]))),
+				Width: _digitalTouchLineWidth,
+			}
+			pointData = pointData[4:]
+		}
+		if len(colors) >= 4*(i+1) {
+			c := colors[4*i : 4*(i+1)]
+			stroke.Color = fmt.Sprintf("#%02x%02x%02x", c[0], c[1], c[2])
+		}
+		sketch.Strokes = append(sketch.Strokes, stroke)
+	}
+	return sketch, nil
+}
+
+// protoFields holds the top-level fields of a protobuf message, indexed by
+// field number. Only the first occurrence of each field is kept.
+type protoFields map[int]any
+
+// parseProtoFields parses the top-level fields of a protobuf message without
+// a schema. Varints are stored as uint64 and length-delimited fields as
+// []byte; fixed-width fields are skipped.
+func parseProtoFields(b []byte) (protoFields, error) {
+	fields := protoFields{}
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errors.New("invalid field key")
+		}
+		b = b[n:]
+		num, wireType := int(key>>3), key&7
+		var val any
+		switch wireType {
+		case 0:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return nil, fmt.Errorf("invalid varint in field %d", num)
+			}
+			val, b = v, b[n:]
+		case 1, 5:
+			size := 8
+			if wireType == 5 {
+				size = 4
+			}
+			if len(b) < size {
+				return nil, fmt.Errorf("truncated field %d", num)
+			}
+			b = b[size:]
+			continue
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return nil, fmt.Errorf("truncated field %d", num)
+			}
+			val, b = b[n:n+int(l)], b[n+int(l):]
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d in field %d", wireType, num)
+		}
+		if _, ok := fields[num]; !ok {
+			fields[num] = val
+		}
+	}
+	return fields, nil
+}
+
+func (f protoFields) bytes(num int) ([]byte, bool) {
+	b, ok := f[num].([]byte)
+	return b, ok
+}
+
+func (f protoFields) varint(num int) (uint64, bool) {
+	v, ok := f[num].(uint64)
+	return v, ok
+}
@@ -0,0 +1,227 @@
+package chatdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/ulikunitz/xz"
+	"gotest.tools/v3/assert"
+)
+
+// protoField encodes a single protobuf field. Values of type int are encoded as
+// varints and values of type []byte as length-delimited fields.
+func protoField(num int, val any) []byte {
+	switch v := val.(type) {
+	case int:
+		b := binary.AppendUvarint(nil, uint64(num<<3))
+		return binary.AppendUvarint(b, uint64(v))
+	case []byte:
+		b := binary.AppendUvarint(nil, uint64(num<<3|2))
+		b = binary.AppendUvarint(b, uint64(len(v)))
+		return append(b, v...)
+	}
+	panic("unsupported protobuf field value")
+}
+
+func le16(vals ...int) []byte {
+	b := []byte{}
+	for _, v := range vals {
+		b = binary.LittleEndian.AppendUint16(b, uint16(v))
+	}
+	return b
+}
+
+func handwritingPayload(t *testing.T, compression int, strokes []byte) []byte {
+	if compression == _handwritingCompressionXZ {
+		var buf bytes.Buffer
+		w, err := xz.NewWriter(&buf)
+		assert.NilError(t, err)
+		_, err = w.Write(strokes)
+		assert.NilError(t, err)
+		assert.NilError(t, w.Close())
+		strokes = buf.Bytes()
+	}
+	hw := bytes.Join([][]byte{
+		protoField(1, []byte("handwriting-id")),
+		protoField(_handwritingFrameField, le16(0, 0, 200, 100)),
+		protoField(_handwritingStrokesField, strokes),
+		protoField(_handwritingCompressionField, compression),
+	}, nil)
+	return append(protoField(1, 1), protoField(_handwritingField, hw)...)
+}
+
+func TestDecodeSketch(t *testing.T) {
+	// Two strokes: (10,20)-(30,40) and a single point at (50,60).
+	strokes := bytes.Join([][]byte{
+		le16(2),
+		le16(2), le16(10, 20, 3, 0), le16(30, 40, 5, 0),
+		le16(1), le16(50, 60, 4, 0),
+	}, nil)
+	wantHandwriting := &Sketch{
+		Width:  200,
+		Height: 100,
+		Strokes: []Stroke{
+			{Points: []Point{{X: 10, Y: 20, Width: 3}, {X: 30, Y: 40, Width: 5}}},
+			{Points: []Point{{X: 50, Y: 60, Width: 4}}},
+		},
+	}
+	digitalTouchSketch := bytes.Join([][]byte{
+		protoField(_digitalTouchSketchPointsField, le16(1, 2, 3, 4, 5, 6)),
+		protoField(_digitalTouchSketchCountsField, []byte{2, 1}),
+		protoField(_digitalTouchSketchColorsField, []byte{0xff, 0x00, 0x80, 0xff, 0x00, 0x00, 0x00, 0xff}),
+	}, nil)
+
+	tests := []struct {
+		msg        string
+		bundleID   string
+		payload    []byte
+		wantSketch *Sketch
+		wantErr    string
+	}{
+		{
+			msg:        "handwriting - XZ",
+			bundleID:   "com.apple.Handwriting.HandwritingProvider",
+			payload:    handwritingPayload(t, _handwritingCompressionXZ, strokes),
+			wantSketch: wantHandwriting,
+		},
+		{
+			msg:        "handwriting - uncompressed",
+			bundleID:   "com.apple.Handwriting.HandwritingProvider",
+			payload:    handwritingPayload(t, _handwritingCompressionNone, strokes),
+			wantSketch: wantHandwriting,
+		},
+		{
+			msg:      "handwriting - offset frame",
+			bundleID: "com.apple.Handwriting.HandwritingProvider",
+			payload: append(protoField(1, 1), protoField(_handwritingField, bytes.Join([][]byte{
+				protoField(_handwritingFrameField, le16(10, -20, 200, 100)),
+				protoField(_handwritingStrokesField, strokes),
+				protoField(_handwritingCompressionField, _handwritingCompressionNone),
+			}, nil))...),
+			wantSketch: &Sketch{
+				Width:  200,
+				Height: 100,
+				Strokes: []Stroke{
+					{Points: []Point{{X: 0, Y: 40, Width: 3}, {X: 20, Y: 60, Width: 5}}},
+					{Points: []Point{{X: 40, Y: 80, Width: 4}}},
+				},
+			},
+		},
+		{
+			msg:      "handwriting - negative frame size",
+			bundleID: "com.apple.Handwriting.HandwritingProvider",
+			payload: append(protoField(1, 1), protoField(_handwritingField, bytes.Join([][]byte{
+				protoField(_handwritingFrameField, le16(0, 0, -200, 0)),
+				protoField(_handwritingStrokesField, strokes),
+				protoField(_handwritingCompressionField, _handwritingCompressionNone),
+			}, nil))...),
+			wantErr: "invalid frame dimensions -200x0",
+		},
+		{
+			msg:      "handwriting - unknown compression",
+			bundleID: "com.apple.Handwriting.HandwritingProvider",
+			payload:  handwritingPayload(t, 7, strokes),
+			wantErr:  "unsupported stroke compression 7",
+		},
+		{
+			msg:      "handwriting - bad XZ",
+			bundleID: "com.apple.Handwriting.HandwritingProvider",
+			payload: append(protoField(1, 1), protoField(_handwritingField, bytes.Join([][]byte{
+				protoField(_handwritingFrameField, le16(0, 0, 200, 100)),
+				protoField(_handwritingStrokesField, []byte("not xz")),
+				protoField(_handwritingCompressionField, _handwritingCompressionXZ),
+			}, nil))...),
+			wantErr: "decompress strokes: unexpected EOF",
+		},
+		{
+			msg:      "handwriting - XZ bomb",
+			bundleID: "com.apple.Handwriting.HandwritingProvider",
+			payload:  handwritingPayload(t, _handwritingCompressionXZ, make([]byte, _maxHandwritingStrokeBytes+1)),
+			wantErr:  "decompressed strokes exceed 16777216 bytes",
+		},
+		{
+			msg:      "handwriting - truncated points",
+			bundleID: "com.apple.Handwriting.HandwritingProvider",
+			payload:  handwritingPayload(t, _handwritingCompressionNone, strokes[:14]),
+			wantErr:  "read points of stroke 0: unexpected EOF",
+		},
+		{
+			msg:      "handwriting - missing",
+			bundleID: "com.apple.Handwriting.HandwritingProvider",
+			payload:  protoField(1, 1),
+			wantErr:  "no handwriting in payload",
+		},
+		{
+			msg:      "handwriting - bad frame",
+			bundleID: "com.apple.Handwriting.HandwritingProvider",
+			payload:  protoField(_handwritingField, protoField(_handwritingFrameField, le16(0, 0))),
+			wantErr:  "invalid frame size 4",
+		},
+		{
+			msg:      "invalid protobuf",
+			bundleID: "com.apple.Handwriting.HandwritingProvider",
+			payload:  []byte{0x22, 0x10, 0x01},
+			wantErr:  "parse payload: truncated field 4",
+		},
+		{
+			msg:      "Digital Touch sketch",
+			bundleID: "com.apple.DigitalTouchBalloonProvider",
+			payload: append(
+				protoField(_digitalTouchKindField, _digitalTouchKindSketch),
+				protoField(_digitalTouchSketchField, digitalTouchSketch)...,
+			),
+			wantSketch: &Sketch{
+				Width:  300,
+				Height: 300,
+				Strokes: []Stroke{
+					{Color: "#ff0080", Points: []Point{{X: 1, Y: 2, Width: 4}, {X: 3, Y: 4, Width: 4}}},
+					{Color: "#000000", Points: []Point{{X: 5, Y: 6, Width: 4}}},
+				},
+			},
+		},
+		{
+			msg:      "Digital Touch heartbeat",
+			bundleID: "com.apple.DigitalTouchBalloonProvider",
+			payload:  protoField(_digitalTouchKindField, 5),
+			wantErr:  "unsupported Digital Touch kind 5",
+		},
+		{
+			msg:      "Digital Touch - too few points",
+			bundleID: "com.apple.DigitalTouchBalloonProvider",
+			payload: append(
+				protoField(_digitalTouchKindField, _digitalTouchKindSketch),
+				protoField(_digitalTouchSketchField, bytes.Join([][]byte{
+					protoField(_digitalTouchSketchPointsField, le16(1, 2)),
+					protoField(_digitalTouchSketchCountsField, []byte{2}),
+				}, nil))...,
+			),
+			wantErr: "stroke 0 has 2 points but only 1 remain",
+		},
+		{
+			msg:      "Digital Touch - overflowing point count",
+			bundleID: "com.apple.DigitalTouchBalloonProvider",
+			payload: append(
+				protoField(_digitalTouchKindField, _digitalTouchKindSketch),
+				protoField(_digitalTouchSketchField, bytes.Join([][]byte{
+					protoField(_digitalTouchSketchPointsField, le16(1, 2)),
+					protoField(_digitalTouchSketchCountsField, binary.AppendUvarint(nil, 1<<62)),
+				}, nil))...,
+			),
+			wantErr: "stroke 0 has 4611686018427387904 points but only 1 remain",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			assert.Assert(t, isSketchBundleID(tt.bundleID))
+			sketch, err := decodeSketch(tt.bundleID, tt.payload)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, sketch, tt.wantSketch)
+		})
+	}
+}
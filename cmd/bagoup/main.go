@@ -28,6 +28,7 @@ import (
 	"github.com/jessevdk/go-flags"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/archive"
 	"github.com/tagatac/bagoup/v2/chatdb"
 	"github.com/tagatac/bagoup/v2/internal/bagoup"
 	"github.com/tagatac/bagoup/v2/opsys"
@@ -49,7 +50,9 @@ func main() {
 
 	startTime := time.Now()
 	var opts bagoup.Options
-	_, err := flags.Parse(&opts)
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.SubcommandsOptional = true
+	_, err := parser.Parse()
 	if err != nil && err.(*flags.Error).Type == flags.ErrHelp {
 		return
 	}
@@ -62,19 +65,36 @@ func main() {
 
 	ptools, err := pathtools.NewPathTools()
 	panicOnErr(err, "create pathtools")
+	// The render command reads an archive instead of a chat DB.
+	rendering := parser.Active != nil
+	if rendering {
+		opts.DBPath = opts.Render.Args.ArchivePath
+	}
 	opts.DBPath = ptools.ReplaceTilde(opts.DBPath)
+	if opts.AddressBookPath != nil {
+		addressBookPath := ptools.ReplaceTilde(*opts.AddressBookPath)
+		opts.AddressBookPath = &addressBookPath
+	}
 
 	s := opsys.NewOS(afero.NewOsFs(), os.Stat, _version)
 	db, err := sql.Open("sqlite3", opts.DBPath)
 	panicOnErr(err, "open DB file %q", opts.DBPath)
 	defer db.Close()
-	cdb := chatdb.NewChatDB(db, opts.SelfHandle)
+	cdb := chatdb.NewChatDB(db, opts.SelfHandle, opts.DefaultRegion, opts.SenderName)
+	if rendering {
+		cdb = archive.NewChatDB(db, opts.SelfHandle)
+	}
 
 	logDir := filepath.Join(opts.ExportPath, ".bagoup")
 	cfg, err := bagoup.NewConfiguration(opts, s, cdb, ptools, logDir, startTime, _version)
 	panicOnErr(err, "create bagoup configuration")
 	panicOnErr(cfg.Run(), "run bagoup")
 	panicOnErr(db.Close(), "close DB file %q", opts.DBPath)
+	if opts.ExportPath == bagoup.StdoutExportPath || rendering {
+		// There is no export folder in which to keep a copy of the DB, or the
+		// DB is an archive which is kept already.
+		return
+	}
 	dbf, err := os.Open(opts.DBPath)
 	panicOnErr(err, "open DB file %q for copying", opts.DBPath)
 	defer dbf.Close()
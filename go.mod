@@ -3,6 +3,7 @@ module github.com/tagatac/bagoup/v2
 go 1.25.0
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/DATA-DOG/go-sqlmock v1.5.2
 	github.com/Masterminds/semver/v3 v3.4.0
 	github.com/SebastiaanKlippert/go-wkhtmltopdf v1.9.3
@@ -13,7 +14,9 @@ require (
 	github.com/spf13/afero v1.15.0
 	github.com/tagatac/go-typedstream v1.0.0
 	github.com/tagatac/gorecurcopy v1.1.0
+	github.com/ulikunitz/xz v0.5.15
 	go.uber.org/mock v0.6.0
+	gopkg.in/yaml.v3 v3.0.1
 	gotest.tools/v3 v3.5.2
 )
 
@@ -0,0 +1,66 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package bagoup
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tagatac/bagoup/v2/chatdb"
+)
+
+// parseActiveSince parses the --active-since option like --since, returning a
+// zero time if it is not given.
+func parseActiveSince(activeSince *string, now time.Time, loc *time.Location) (time.Time, error) {
+	if activeSince == nil {
+		return time.Time{}, nil
+	}
+	t, err := parseDate(*activeSince, now, loc, false)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parse --active-since %q - %s: %w", *activeSince, _dateFormatsFix, err)
+	}
+	return t, nil
+}
+
+func (cfg *configuration) filteringActivity() bool {
+	return cfg.Options.MinMessages > 0 || cfg.Options.MaxMessages > 0 || !cfg.activeSince.IsZero() || cfg.Options.HasAttachments
+}
+
+// filterActivity selects the entities whose chats, taken together, meet the
+// activity thresholds. The activity of all chats is summarized with a single
+// aggregate query, so that no messages are read for the entities left out.
+func (cfg *configuration) filterActivity(chats []chatdb.EntityChats) ([]chatdb.EntityChats, error) {
+	if !cfg.filteringActivity() {
+		return chats, nil
+	}
+	chatActivity, err := cfg.ChatDB.GetChatActivity(cfg.dates)
+	if err != nil {
+		return nil, fmt.Errorf("get chat activity: %w", err)
+	}
+	result := []chatdb.EntityChats{}
+	for _, entityChats := range chats {
+		var activity chatdb.ChatActivity
+		for _, chat := range entityChats.Chats {
+			activity = activity.Add(chatActivity[chat.ID])
+		}
+		if cfg.meetsActivityThresholds(activity) {
+			result = append(result, entityChats)
+		}
+	}
+	return result, nil
+}
+
+func (cfg *configuration) meetsActivityThresholds(activity chatdb.ChatActivity) bool {
+	switch {
+	case activity.Messages < cfg.Options.MinMessages:
+		return false
+	case cfg.Options.MaxMessages > 0 && activity.Messages > cfg.Options.MaxMessages:
+		return false
+	case !cfg.activeSince.IsZero() && activity.LastMessage.Before(cfg.activeSince):
+		return false
+	case cfg.Options.HasAttachments && activity.Attachments == 0:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,137 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package bagoup
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tagatac/bagoup/v2/chatdb"
+	"github.com/tagatac/bagoup/v2/chatdb/mock_chatdb"
+	"go.uber.org/mock/gomock"
+	"gotest.tools/v3/assert"
+)
+
+func TestFilterActivity(t *testing.T) {
+	chats := []chatdb.EntityChats{
+		{Name: "2FA", Chats: []chatdb.Chat{{ID: 1}}},
+		{Name: "Friend", Chats: []chatdb.Chat{{ID: 2}, {ID: 3}}},
+		{Name: "Old Friend", Chats: []chatdb.Chat{{ID: 4}}},
+		{Name: "No Messages", Chats: []chatdb.Chat{{ID: 5}}},
+	}
+	chatActivity := map[int]chatdb.ChatActivity{
+		1: {Messages: 1, LastMessage: time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)},
+		2: {Messages: 40, LastMessage: time.Date(2023, time.May, 1, 0, 0, 0, 0, time.UTC)},
+		3: {Messages: 60, LastMessage: time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC), Attachments: 3},
+		4: {Messages: 500, LastMessage: time.Date(2015, time.July, 1, 0, 0, 0, 0, time.UTC), Attachments: 20},
+	}
+
+	tests := []struct {
+		msg         string
+		opts        Options
+		activeSince time.Time
+		dates       chatdb.DateRange
+		dbErr       bool
+		wantNames   []string
+		wantErr     string
+	}{
+		{
+			msg:       "no thresholds",
+			wantNames: []string{"2FA", "Friend", "Old Friend", "No Messages"},
+		},
+		{
+			msg:       "minimum messages across an entity's chats",
+			opts:      Options{MinMessages: 100},
+			wantNames: []string{"Friend", "Old Friend"},
+		},
+		{
+			msg:       "maximum messages",
+			opts:      Options{MaxMessages: 100},
+			wantNames: []string{"2FA", "Friend", "No Messages"},
+		},
+		{
+			msg:       "minimum and maximum messages",
+			opts:      Options{MinMessages: 2, MaxMessages: 100},
+			wantNames: []string{"Friend"},
+		},
+		{
+			msg:         "active since",
+			activeSince: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+			wantNames:   []string{"2FA", "Friend"},
+		},
+		{
+			msg:       "has attachments",
+			opts:      Options{HasAttachments: true},
+			wantNames: []string{"Friend", "Old Friend"},
+		},
+		{
+			msg:       "in a date range",
+			opts:      Options{MinMessages: 1},
+			dates:     chatdb.DateRange{Since: time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)},
+			wantNames: []string{"2FA", "Friend", "Old Friend"},
+		},
+		{
+			msg:     "DB error",
+			opts:    Options{MinMessages: 2},
+			dbErr:   true,
+			wantErr: "get chat activity: this is a DB error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			dbMock := mock_chatdb.NewMockChatDB(ctrl)
+			cfg := configuration{
+				Options:     tt.opts,
+				ChatDB:      dbMock,
+				dates:       tt.dates,
+				activeSince: tt.activeSince,
+			}
+			if cfg.filteringActivity() {
+				call := dbMock.EXPECT().GetChatActivity(tt.dates)
+				if tt.dbErr {
+					call.Return(nil, errors.New("this is a DB error"))
+				} else {
+					call.Return(chatActivity, nil)
+				}
+			}
+
+			result, err := cfg.filterActivity(chats)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			names := []string{}
+			for _, entityChats := range result {
+				names = append(names, entityChats.Name)
+			}
+			assert.DeepEqual(t, tt.wantNames, names)
+		})
+	}
+}
+
+func TestParseActiveSince(t *testing.T) {
+	now := time.Date(2024, time.March, 15, 12, 0, 0, 0, time.UTC)
+	activeSince, err := parseActiveSince(nil, now, time.UTC)
+	assert.NilError(t, err)
+	assert.Assert(t, activeSince.IsZero())
+
+	s := "6m"
+	activeSince, err = parseActiveSince(&s, now, time.UTC)
+	assert.NilError(t, err)
+	assert.Equal(t, activeSince, time.Date(2023, time.September, 15, 12, 0, 0, 0, time.UTC))
+
+	s = "2023"
+	activeSince, err = parseActiveSince(&s, now, time.UTC)
+	assert.NilError(t, err)
+	assert.Equal(t, activeSince, time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC))
+
+	s = "recently"
+	_, err = parseActiveSince(&s, now, time.UTC)
+	assert.Error(t, err, `parse --active-since "recently" - `+_dateFormatsFix+`: parsing time "recently" as "2006-01-02T15:04:05Z07:00": cannot parse "recently" as "2006"`)
+}
@@ -0,0 +1,131 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package bagoup
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/tagatac/bagoup/v2/chatdb"
+)
+
+const (
+	// The entity collecting the chats with automated senders, with the
+	// --automated-folder flag.
+	_automatedEntityName = "Automated"
+	// The number of a chat's most recent messages to read when classifying it.
+	_automatedSampleSize = 10
+)
+
+var (
+	// Short codes, e.g. 262966, are the sending numbers of businesses.
+	_shortCodeRE = regexp.MustCompile(`^\d{5,6}$`)
+	_noReplyRE   = regexp.MustCompile(`(?i)^(no-?reply|do-?not-?reply|notifications?|alerts?)@`)
+	// Verification codes, delivery notices, and opt-out instructions.
+	_automatedTextREs = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)\b(code|passcode|pin|otp)\b.*\b\d{4,8}\b|\b\d{4,8}\b.*\b(code|passcode|pin|otp)\b`),
+		regexp.MustCompile(`(?i)\b(verification|verify|one-time|2fa|two-factor|sign-in|log-?in attempt)\b`),
+		regexp.MustCompile(`(?i)\b(out for delivery|was delivered|has been delivered|has shipped|tracking (number|#|link)|your (order|package|parcel))\b`),
+		regexp.MustCompile(`(?i)\b(reply|text|txt) stop\b|\bmsg ?(&|and) ?data rates\b`),
+	}
+)
+
+// filterAutomated leaves out or keeps only the chats with automated senders,
+// or moves them into a single Automated entity, as the options require.
+func (cfg *configuration) filterAutomated(chats []chatdb.EntityChats) ([]chatdb.EntityChats, error) {
+	opts := cfg.Options
+	if !opts.ExcludeAuto && !opts.OnlyAuto && !opts.AutoFolder {
+		return chats, nil
+	}
+	automated := chatdb.EntityChats{Name: automatedEntityName(chats), Automated: true}
+	result := []chatdb.EntityChats{}
+	for _, entityChats := range chats {
+		var personChats, automatedChats []chatdb.Chat
+		for _, chat := range entityChats.Chats {
+			isAutomated, err := cfg.isAutomated(chat)
+			if err != nil {
+				return nil, err
+			}
+			if isAutomated {
+				automatedChats = append(automatedChats, chat)
+			} else {
+				personChats = append(personChats, chat)
+			}
+		}
+		switch {
+		case opts.AutoFolder:
+			automated.Chats = append(automated.Chats, automatedChats...)
+			entityChats.Chats = personChats
+			if opts.OnlyAuto {
+				entityChats.Chats = nil
+			}
+		case opts.OnlyAuto:
+			entityChats.Chats = automatedChats
+		default:
+			entityChats.Chats = personChats
+		}
+		if len(entityChats.Chats) > 0 {
+			result = append(result, entityChats)
+		}
+	}
+	if len(automated.Chats) > 0 {
+		result = append(result, automated)
+		sort.SliceStable(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	}
+	return result, nil
+}
+
+// automatedEntityName returns the name of the entity collecting the chats with
+// automated senders, which is numbered if a contact or alias already has that
+// name, so that the two are not written to the same folder. Names are compared
+// without regard to case, as on the default macOS filesystem.
+func automatedEntityName(chats []chatdb.EntityChats) string {
+	names := map[string]bool{}
+	for _, entityChats := range chats {
+		names[strings.ToLower(entityChats.Name)] = true
+	}
+	name := _automatedEntityName
+	for i := 2; names[strings.ToLower(name)]; i++ {
+		name = fmt.Sprintf("%s-%d", _automatedEntityName, i)
+	}
+	if name != _automatedEntityName {
+		slog.Warn("automated entity collision; using a unique name instead",
+			"existing name", _automatedEntityName,
+			"unique name", name,
+		)
+	}
+	return name
+}
+
+// isAutomated classifies a one-on-one chat as having an automated sender if
+// its handle is a short code or a no-reply email address, or if at least half
+// of its most recent messages look like verification codes, delivery notices,
+// or marketing. Group chats are never automated.
+func (cfg *configuration) isAutomated(chat chatdb.Chat) (bool, error) {
+	if chat.Group {
+		return false, nil
+	}
+	handle := chatHandle(chat.GUID)
+	if _shortCodeRE.MatchString(handle) || _noReplyRE.MatchString(handle) {
+		return true, nil
+	}
+	sample, err := cfg.ChatDB.GetRecentMessageTexts(chat.ID, cfg.dates, _automatedSampleSize)
+	if err != nil {
+		return false, fmt.Errorf("get recent messages of chat ID %d: %w", chat.ID, err)
+	}
+	if len(sample) == 0 {
+		return false, nil
+	}
+	automatedCount := 0
+	for _, msg := range sample {
+		if !msg.FromMe && slices.ContainsFunc(_automatedTextREs, func(re *regexp.Regexp) bool { return re.MatchString(msg.Text) }) {
+			automatedCount++
+		}
+	}
+	return automatedCount*2 >= len(sample), nil
+}
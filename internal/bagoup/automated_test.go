@@ -0,0 +1,256 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package bagoup
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/chatdb"
+	"github.com/tagatac/bagoup/v2/chatdb/mock_chatdb"
+	"github.com/tagatac/bagoup/v2/opsys/mock_opsys"
+	"go.uber.org/mock/gomock"
+	"gotest.tools/v3/assert"
+)
+
+func TestIsAutomated(t *testing.T) {
+	tests := []struct {
+		msg        string
+		chat       chatdb.Chat
+		texts      []string
+		fromMe     []bool
+		dbErr      bool
+		wantResult bool
+		wantErr    string
+	}{
+		{
+			msg:        "short code",
+			chat:       chatdb.Chat{ID: 1, GUID: "SMS;-;262966"},
+			wantResult: true,
+		},
+		{
+			msg:        "no-reply email address",
+			chat:       chatdb.Chat{ID: 1, GUID: "iMessage;-;No-Reply@example.com"},
+			wantResult: true,
+		},
+		{
+			msg:  "group chat",
+			chat: chatdb.Chat{ID: 1, GUID: "iMessage;+;chat123456", Group: true},
+		},
+		{
+			msg:  "verification codes",
+			chat: chatdb.Chat{ID: 1, GUID: "SMS;-;+15551234567"},
+			texts: []string{
+				"Your Acme verification code is 482913. Don't share it with anyone.",
+				"G-482913 is your Google code",
+				"hello?",
+			},
+			wantResult: true,
+		},
+		{
+			msg:  "delivery notices",
+			chat: chatdb.Chat{ID: 1, GUID: "SMS;-;+15551234567"},
+			texts: []string{
+				"Your package is out for delivery today.",
+				"Your order has shipped! Reply STOP to opt out.",
+			},
+			wantResult: true,
+		},
+		{
+			msg:  "a person",
+			chat: chatdb.Chat{ID: 1, GUID: "iMessage;-;+15551234567"},
+			texts: []string{
+				"are we still on for dinner?",
+				"yes! 7pm",
+				"my door code is 4821 btw",
+			},
+			fromMe: []bool{false, true, false},
+		},
+		{
+			msg:    "codes sent by me",
+			chat:   chatdb.Chat{ID: 1, GUID: "iMessage;-;+15551234567"},
+			texts:  []string{"the verification code is 123456", "thanks"},
+			fromMe: []bool{true, false},
+		},
+		{
+			msg:  "no messages",
+			chat: chatdb.Chat{ID: 1, GUID: "iMessage;-;+15551234567"},
+		},
+		{
+			msg:     "GetRecentMessageTexts error",
+			chat:    chatdb.Chat{ID: 1, GUID: "iMessage;-;+15551234567"},
+			dbErr:   true,
+			wantErr: "get recent messages of chat ID 1: this is a DB error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			dbMock := mock_chatdb.NewMockChatDB(ctrl)
+			handle := chatHandle(tt.chat.GUID)
+			if !tt.chat.Group && !_shortCodeRE.MatchString(handle) && !_noReplyRE.MatchString(handle) {
+				msgs := []chatdb.Message{}
+				for i, text := range tt.texts {
+					msg := chatdb.Message{ID: 100 + i, Text: text}
+					if i < len(tt.fromMe) {
+						msg.FromMe = tt.fromMe[i]
+					}
+					msgs = append(msgs, msg)
+				}
+				if tt.dbErr {
+					dbMock.EXPECT().GetRecentMessageTexts(tt.chat.ID, chatdb.DateRange{}, _automatedSampleSize).Return(nil, errors.New("this is a DB error"))
+				} else {
+					dbMock.EXPECT().GetRecentMessageTexts(tt.chat.ID, chatdb.DateRange{}, _automatedSampleSize).Return(msgs, nil)
+				}
+			}
+			cfg := configuration{ChatDB: dbMock}
+
+			isAutomated, err := cfg.isAutomated(tt.chat)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, isAutomated, tt.wantResult)
+		})
+	}
+}
+
+func TestFilterAutomated(t *testing.T) {
+	chats := []chatdb.EntityChats{
+		{Name: "262966", Chats: []chatdb.Chat{{ID: 1, GUID: "SMS;-;262966"}}},
+		{Name: "Acme Bank", Chats: []chatdb.Chat{{ID: 2, GUID: "SMS;-;72245"}, {ID: 3, GUID: "iMessage;-;help@acme.example"}}},
+		{Name: "Book Club", Chats: []chatdb.Chat{{ID: 4, GUID: "iMessage;+;chat123456", Group: true}}},
+	}
+
+	tests := []struct {
+		msg       string
+		opts      Options
+		chats     []chatdb.EntityChats
+		wantChats map[string][]int
+		wantErr   string
+		msgsErr   bool
+	}{
+		{
+			msg:       "no classification",
+			wantChats: map[string][]int{"262966": {1}, "Acme Bank": {2, 3}, "Book Club": {4}},
+		},
+		{
+			msg:       "exclude automated",
+			opts:      Options{ExcludeAuto: true},
+			wantChats: map[string][]int{"Acme Bank": {3}, "Book Club": {4}},
+		},
+		{
+			msg:       "only automated",
+			opts:      Options{OnlyAuto: true},
+			wantChats: map[string][]int{"262966": {1}, "Acme Bank": {2}},
+		},
+		{
+			msg:       "automated folder",
+			opts:      Options{AutoFolder: true},
+			wantChats: map[string][]int{"Acme Bank": {3}, "Automated": {1, 2}, "Book Club": {4}},
+		},
+		{
+			msg:       "only automated, in a folder",
+			opts:      Options{OnlyAuto: true, AutoFolder: true},
+			wantChats: map[string][]int{"Automated": {1, 2}},
+		},
+		{
+			msg:  "automated folder, contact named Automated",
+			opts: Options{AutoFolder: true},
+			chats: append(chats[:len(chats):len(chats)],
+				chatdb.EntityChats{Name: "automated", Chats: []chatdb.Chat{{ID: 5, GUID: "iMessage;+;chat987654", Group: true}}},
+			),
+			wantChats: map[string][]int{"Acme Bank": {3}, "Automated-2": {1, 2}, "Book Club": {4}, "automated": {5}},
+		},
+		{
+			msg:     "classification error",
+			opts:    Options{ExcludeAuto: true},
+			msgsErr: true,
+			wantErr: "get recent messages of chat ID 3: this is a DB error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			dbMock := mock_chatdb.NewMockChatDB(ctrl)
+			if tt.opts.ExcludeAuto || tt.opts.OnlyAuto || tt.opts.AutoFolder {
+				if tt.msgsErr {
+					dbMock.EXPECT().GetRecentMessageTexts(3, chatdb.DateRange{}, _automatedSampleSize).Return(nil, errors.New("this is a DB error"))
+				} else {
+					dbMock.EXPECT().GetRecentMessageTexts(3, chatdb.DateRange{}, _automatedSampleSize).Return([]chatdb.Message{{ID: 300, Text: "Thanks for contacting Acme support!"}}, nil)
+				}
+			}
+			cfg := configuration{Options: tt.opts, ChatDB: dbMock}
+			if tt.chats == nil {
+				tt.chats = chats
+			}
+
+			result, err := cfg.filterAutomated(tt.chats)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			gotChats := map[string][]int{}
+			var names []string
+			for _, entityChats := range result {
+				assert.Equal(t, entityChats.Automated, strings.HasPrefix(entityChats.Name, _automatedEntityName), entityChats.Name)
+				names = append(names, entityChats.Name)
+				for _, chat := range entityChats.Chats {
+					gotChats[entityChats.Name] = append(gotChats[entityChats.Name], chat.ID)
+				}
+			}
+			assert.DeepEqual(t, tt.wantChats, gotChats)
+			for i := 1; i < len(names); i++ {
+				assert.Assert(t, names[i-1] < names[i], "entities out of order: %v", names)
+			}
+		})
+	}
+}
+
+func TestExportAutomatedEntity(t *testing.T) {
+	chatFile, err := afero.NewMemMapFs().Create("testfile")
+	assert.NilError(t, err)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dbMock := mock_chatdb.NewMockChatDB(ctrl)
+	osMock := mock_opsys.NewMockOS(ctrl)
+	ofMocks := []*mock_opsys.MockOutFile{mock_opsys.NewMockOutFile(ctrl), mock_opsys.NewMockOutFile(ctrl)}
+	gomock.InOrder(
+		dbMock.EXPECT().GetMessageIDs(1, chatdb.DateRange{}),
+		osMock.EXPECT().MkdirAll("messages-export/Automated", os.ModePerm),
+		osMock.EXPECT().Create("messages-export/Automated/SMS;-;262966.txt").Return(chatFile, nil),
+		osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMocks[0]),
+		ofMocks[0].EXPECT().Stage(),
+		osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
+		ofMocks[0].EXPECT().Flush(),
+		dbMock.EXPECT().GetMessageIDs(2, chatdb.DateRange{}),
+		osMock.EXPECT().MkdirAll("messages-export/Automated", os.ModePerm),
+		osMock.EXPECT().Create("messages-export/Automated/SMS;-;72245.txt").Return(chatFile, nil),
+		osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMocks[1]),
+		ofMocks[1].EXPECT().Stage(),
+		osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
+		ofMocks[1].EXPECT().Flush(),
+	)
+
+	cfg := configuration{
+		Options: Options{ExportPath: "messages-export", AutoFolder: true},
+		OS:      osMock,
+		ChatDB:  dbMock,
+	}
+	assert.NilError(t, cfg.exportEntityChats(chatdb.EntityChats{
+		Name:      _automatedEntityName,
+		Chats:     []chatdb.Chat{{ID: 1, GUID: "SMS;-;262966"}, {ID: 2, GUID: "SMS;-;72245"}},
+		Automated: true,
+	}))
+	assert.Equal(t, cfg.counts.chats, 2)
+}
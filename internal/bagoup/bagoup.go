@@ -2,20 +2,24 @@
 // See cmd/bagoup/main.go for usage terms.
 
 // Package bagoup reads data from a macOS messsages chat database and exports
-// it to text or PDF.
+// it to text, PDF, or other formats.
 package bagoup
 
 import (
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
 	"github.com/Masterminds/semver/v3"
 	"github.com/emersion/go-vcard"
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/archive"
 	"github.com/tagatac/bagoup/v2/chatdb"
 	"github.com/tagatac/bagoup/v2/imgconv"
 	"github.com/tagatac/bagoup/v2/opsys"
@@ -25,8 +29,13 @@ import (
 const (
 	PreservedPathDir                = "bagoup-attachments"
 	PreservedPathTildeExpansionFile = ".tildeexpansion"
+	// StdoutExportPath is the export path for streaming messages to stdout
+	// in the JSON Lines format.
+	StdoutExportPath = "-"
 )
 
+const _contactCollisionsFilename = "contact-collisions.txt"
+
 const _readmeURL = "https://github.com/tagatac/bagoup/blob/master/README.md#protected-file-access"
 
 type (
@@ -39,8 +48,30 @@ type (
 		logDir          string
 		macOSVersion    *semver.Version
 		loc             *time.Location
+		dates           chatdb.DateRange
+		activeSince     time.Time
+		search          *regexp.Regexp
 		handleMap       map[int]string
 		attachmentPaths map[int][]chatdb.Attachment
+		// searchBreaks holds the IDs of messages to be preceded by a separator
+		// in a search export.
+		searchBreaks map[int]bool
+		// searchedMessages holds the messages of the chat being written which
+		// were read by the search, by ID, so that they are not read and
+		// decoded again.
+		searchedMessages map[int]chatdb.Message
+		// stdout receives the messages when streaming to stdout.
+		stdout afero.File
+		// combinedCSV holds the messages of all chats, with the --combined-csv
+		// flag. combinedCSVStarted is set once its header row is written.
+		combinedCSV        afero.File
+		combinedCSVStarted bool
+		// htmlIndex summarizes the chat files of each entity, with --format
+		// html.
+		htmlIndex []opsys.HTMLIndexEntry
+		// archive holds the messages of all chats, with --format sqlite.
+		archive       archive.Archive
+		createArchive func(path, bagoupVersion string) (archive.Archive, error)
 		counts
 		startTime time.Time
 		version   string
@@ -49,7 +80,9 @@ type (
 		files               int
 		chats               int
 		messages            int
+		messagesRecovered   int
 		messagesInvalid     int
+		searchMatches       int
 		attachments         map[string]int
 		attachmentsCopied   map[string]int
 		attachmentsEmbedded map[string]int
@@ -80,6 +113,18 @@ func NewConfiguration(
 	if err != nil {
 		return nil, fmt.Errorf("load timezone %q: %w", opts.Timezone, err)
 	}
+	dates, err := parseDateRange(opts.Since, opts.Until, startTime, loc)
+	if err != nil {
+		return nil, err
+	}
+	activeSince, err := parseActiveSince(opts.ActiveSince, startTime, loc)
+	if err != nil {
+		return nil, err
+	}
+	search, err := newSearch(opts.Grep, opts.Regex)
+	if err != nil {
+		return nil, err
+	}
 	if opts.AttachmentsPath != "/" {
 		tef := filepath.Join(opts.AttachmentsPath, PreservedPathTildeExpansionFile)
 		homeDir, err := s.ReadFile(tef)
@@ -89,12 +134,19 @@ func NewConfiguration(
 		ptools = pathtools.NewPathToolsWithHomeDir(strings.TrimRight(string(homeDir), "\n"))
 	}
 	return &configuration{
-		Options:   opts,
-		OS:        s,
-		ChatDB:    cdb,
-		PathTools: ptools,
-		logDir:    logDir,
-		loc:       loc,
+		Options:     opts,
+		OS:          s,
+		ChatDB:      cdb,
+		PathTools:   ptools,
+		logDir:      logDir,
+		loc:         loc,
+		dates:       dates,
+		activeSince: activeSince,
+		search:      search,
+		stdout:      os.Stdout,
+		// The archive is a SQLite database, which cannot be created on the
+		// afero filesystem of the OS.
+		createArchive: archive.Create,
 		counts: counts{
 			attachments:         map[string]int{},
 			attachmentsCopied:   map[string]int{},
@@ -110,45 +162,83 @@ func (cfg *configuration) Run() error {
 		return err
 	}
 
-	if err := cfg.OS.MkdirAll(cfg.logDir, os.ModePerm); err != nil {
-		return fmt.Errorf("make log directory: %w", err)
-	}
-	logFile, err := cfg.OS.Create(filepath.Join(cfg.logDir, "out.log"))
-	if err != nil {
-		return fmt.Errorf("create log file: %w", err)
+	var err error
+	if !cfg.streaming() {
+		// When streaming, there is no export folder for the logs, which go
+		// only to stderr.
+		if err := cfg.OS.MkdirAll(cfg.logDir, os.ModePerm); err != nil {
+			return fmt.Errorf("make log directory: %w", err)
+		}
+		logFile, err := cfg.OS.Create(filepath.Join(cfg.logDir, "out.log"))
+		if err != nil {
+			return fmt.Errorf("create log file: %w", err)
+		}
+		defer logFile.Close()
+		w := log.Writer()
+		log.SetOutput(io.MultiWriter(logFile, w))
+		defer log.SetOutput(w)
 	}
-	defer logFile.Close()
-	w := log.Writer()
-	log.SetOutput(io.MultiWriter(logFile, w))
-	defer log.SetOutput(w)
 
 	if cfg.Options.MacOSVersion != nil {
 		cfg.macOSVersion, err = semver.NewVersion(*cfg.Options.MacOSVersion)
 		if err != nil {
 			return fmt.Errorf("parse macOS version %q: %w", *cfg.Options.MacOSVersion, err)
 		}
-	} else if cfg.macOSVersion, err = cfg.OS.GetMacOSVersion(); err != nil {
-		return fmt.Errorf("get macOS version - FIX: specify the macOS version from which chat.db was copied with the --mac-os-version option: %w", err)
+	} else if !cfg.Options.rendering() {
+		// An archive does not depend on the version of macOS.
+		if cfg.macOSVersion, err = cfg.OS.GetMacOSVersion(); err != nil {
+			return fmt.Errorf("get macOS version - FIX: specify the macOS version from which chat.db was copied with the --mac-os-version option: %w", err)
+		}
 	}
 
 	var contactMap map[string]*vcard.Card
-	if cfg.Options.ContactsPath != nil {
-		contactMap, err = cfg.OS.GetContactMap(*cfg.Options.ContactsPath)
+	var collisions []opsys.ContactCollision
+	contactOpts := opsys.ContactOptions{
+		DefaultRegion:   cfg.Options.DefaultRegion,
+		CSVColumns:      cfg.Options.CSVColumns,
+		CollisionPolicy: cfg.Options.CollisionPolicy,
+		Avatars:         cfg.Options.showingAvatars(),
+		DownloadPhotos:  cfg.Options.DownloadPhotos,
+	}
+	if len(cfg.Options.ContactsPaths) > 0 {
+		contactMap, collisions, err = cfg.OS.GetContactMap(cfg.Options.ContactsPaths, contactOpts)
+		if err := cfg.writeContactCollisions(collisions); err != nil {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("get contacts: %w", err)
+		}
+	} else if cfg.Options.AddressBookPath != nil {
+		contactMap, collisions, err = cfg.OS.GetAddressBookContactMap(*cfg.Options.AddressBookPath, contactOpts)
+		if err := cfg.writeContactCollisions(collisions); err != nil {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("get contacts from AddressBook %q: %w", *cfg.Options.AddressBookPath, err)
+		}
+	}
+
+	var aliases chatdb.Aliases
+	if cfg.Options.AliasesPath != nil {
+		aliases, err = cfg.OS.GetAliases(*cfg.Options.AliasesPath, cfg.Options.DefaultRegion)
 		if err != nil {
-			return fmt.Errorf("get contacts from vcard file %q: %w", *cfg.Options.ContactsPath, err)
+			return fmt.Errorf("get aliases from file %q: %w", *cfg.Options.AliasesPath, err)
 		}
 	}
 
 	if err := cfg.ChatDB.Init(cfg.macOSVersion, cfg.loc); err != nil {
+		if cfg.Options.rendering() {
+			return fmt.Errorf("initialize the archive %q for reading: %w", cfg.Options.DBPath, err)
+		}
 		return fmt.Errorf("initialize the database for reading on macOS version %s: %w", cfg.macOSVersion.String(), err)
 	}
 
-	cfg.handleMap, err = cfg.ChatDB.GetHandleMap(contactMap)
+	cfg.handleMap, err = cfg.ChatDB.GetHandleMap(contactMap, aliases)
 	if err != nil {
 		return fmt.Errorf("get handle map: %w", err)
 	}
 
-	if cfg.Options.OutputPDF {
+	if cfg.Options.embeddingAttachments() {
 		tempDir, err := cfg.OS.GetTempDir()
 		if err != nil {
 			return fmt.Errorf("get temporary directory: %w", err)
@@ -157,8 +247,12 @@ func (cfg *configuration) Run() error {
 		cfg.ImgConverter = imgconv.NewImgConverter(tempDir)
 	}
 
-	err = cfg.exportChats(contactMap)
-	printResults(cfg.version, cfg.Options.ExportPath, cfg.counts, time.Since(cfg.startTime))
+	err = cfg.exportChats(contactMap, aliases)
+	exportPath := cfg.Options.ExportPath
+	if cfg.streaming() {
+		exportPath = "stdout"
+	}
+	printResults(cfg.version, exportPath, cfg.counts, cfg.search != nil, time.Since(cfg.startTime))
 	if err != nil {
 		return fmt.Errorf("export chats: %w", err)
 	}
@@ -172,9 +266,22 @@ func (cfg *configuration) validatePaths() error {
 	if err := cfg.OS.FileAccess(cfg.Options.DBPath); err != nil {
 		return fmt.Errorf("test DB file %q - FIX: %s: %w", cfg.Options.DBPath, _readmeURL, err)
 	}
-	var err error
-	var exportPathAbs string
-	if exportPathAbs, err = filepath.Abs(cfg.Options.ExportPath); err != nil {
+	if !cfg.streaming() {
+		if err := cfg.validateExportPath(); err != nil {
+			return err
+		}
+	}
+	attPathAbs, err := filepath.Abs(cfg.Options.AttachmentsPath)
+	if err != nil {
+		return fmt.Errorf("convert attachments path %q to an absolute path: %w", cfg.Options.AttachmentsPath, err)
+	}
+	cfg.Options.AttachmentsPath = attPathAbs
+	return nil
+}
+
+func (cfg *configuration) validateExportPath() error {
+	exportPathAbs, err := filepath.Abs(cfg.Options.ExportPath)
+	if err != nil {
 		return fmt.Errorf("convert export path %q to an absolute path: %w", cfg.Options.ExportPath, err)
 	}
 	cfg.Options.ExportPath = exportPathAbs
@@ -183,15 +290,61 @@ func (cfg *configuration) validatePaths() error {
 	} else if ok {
 		return fmt.Errorf("export folder %q already exists - FIX: move it or specify a different export path with the --export-path option", exportPathAbs)
 	}
-	var attPathAbs string
-	if attPathAbs, err = filepath.Abs(cfg.Options.AttachmentsPath); err != nil {
-		return fmt.Errorf("convert attachments path %q to an absolute path: %w", cfg.Options.AttachmentsPath, err)
+	return nil
+}
+
+// streaming reports whether the messages are streamed to stdout rather than
+// written to an export folder.
+func (cfg configuration) streaming() bool {
+	return cfg.Options.ExportPath == StdoutExportPath
+}
+
+// writeContactCollisions lists the phone numbers and email addresses shared by
+// multiple contacts, along with the contacts involved, so that they can be
+// cleaned up.
+func (cfg configuration) writeContactCollisions(collisions []opsys.ContactCollision) error {
+	if len(collisions) == 0 {
+		return nil
+	}
+	if cfg.streaming() {
+		slog.Warn("multiple contacts share phone numbers or email addresses - export to a folder for a report",
+			"collisions", len(collisions),
+		)
+		return nil
+	}
+	reportPath := filepath.Join(cfg.logDir, _contactCollisionsFilename)
+	slog.Warn("multiple contacts share phone numbers or email addresses",
+		"collisions", len(collisions),
+		"report", reportPath,
+	)
+	f, err := cfg.OS.Create(reportPath)
+	if err != nil {
+		return fmt.Errorf("create contact collisions report %q: %w", reportPath, err)
+	}
+	defer f.Close()
+	var report strings.Builder
+	for _, collision := range collisions {
+		resolution := "unresolved"
+		if collision.Resolved != nil {
+			resolution = collision.Resolved.PreferredValue(vcard.FieldFormattedName)
+		}
+		fmt.Fprintf(&report, "%s -> %s\n", collision.Handle, resolution)
+		for _, card := range collision.Cards {
+			phonesAndEmails := append(card.Values(vcard.FieldTelephone), card.Values(vcard.FieldEmail)...)
+			fmt.Fprintf(&report, "\t%s (%s)\n", card.PreferredValue(vcard.FieldFormattedName), strings.Join(phonesAndEmails, ", "))
+		}
+	}
+	if _, err := f.WriteString(report.String()); err != nil {
+		return fmt.Errorf("write contact collisions report %q: %w", reportPath, err)
 	}
-	cfg.Options.AttachmentsPath = attPathAbs
 	return nil
 }
 
-func printResults(version, exportPath string, c counts, duration time.Duration) {
+func printResults(version, exportPath string, c counts, searched bool, duration time.Duration) {
+	var searchString string
+	if searched {
+		searchString = fmt.Sprintf("\nMessages matching the search: %d", c.searchMatches)
+	}
 	log.Printf(`%sBAGOUP RESULTS:
 bagoup version: %s
 Invocation: %s
@@ -199,7 +352,8 @@ Export folder: %q
 Export files written: %d
 Chats exported: %d
 Valid messages exported: %d
-Invalid messages exported (see warnings above): %d
+Recovered messages exported (see warnings above): %d
+Invalid messages exported (see warnings above): %d%s
 Attachments copied: %s
 Attachments referenced or embedded: %s
 Attachments embedded: %s
@@ -214,7 +368,9 @@ Time elapsed: %s%s`,
 		c.files,
 		c.chats,
 		c.messages,
+		c.messagesRecovered,
 		c.messagesInvalid,
+		searchString,
 		makeAttachmentsString(c.attachmentsCopied),
 		makeAttachmentsString(c.attachments),
 		makeAttachmentsString(c.attachmentsEmbedded),
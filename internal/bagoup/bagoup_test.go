@@ -13,8 +13,11 @@ import (
 	"time"
 
 	"github.com/Masterminds/semver/v3"
+	"github.com/emersion/go-vcard"
 	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/chatdb"
 	"github.com/tagatac/bagoup/v2/chatdb/mock_chatdb"
+	"github.com/tagatac/bagoup/v2/opsys"
 	"github.com/tagatac/bagoup/v2/opsys/mock_opsys"
 	"github.com/tagatac/bagoup/v2/pathtools/mock_pathtools"
 	"go.uber.org/mock/gomock"
@@ -34,12 +37,18 @@ func TestBagoup(t *testing.T) {
 	exportPathAbs := filepath.Join(wd, "messages-export")
 	logDirAbs := filepath.Join(exportPathAbs, ".bagoup")
 	logFileAbs := filepath.Join(logDirAbs, "out.log")
+	collisionsFileAbs := filepath.Join(logDirAbs, "contact-collisions.txt")
 	tildeexpansionAbs := filepath.Join(exportPathAbs, PreservedPathDir, PreservedPathTildeExpansionFile)
 	tenDotTwelve := "10.12"
 	tenDotTenDotTenDotTen := "10.10.10.10"
-	contactsPath := "contacts.vcf"
+	addressBookPath := "AddressBook"
+	aliasesPath := "aliases.yaml"
 	devnull, err := os.Open(os.DevNull)
 	assert.NilError(t, err)
+	collisions := []opsys.ContactCollision{{Handle: "+15551234567"}}
+	renderOpts := defaultOpts
+	renderOpts.DBPath = "messages.sqlite"
+	renderOpts.Render.Args.ArchivePath = "messages.sqlite"
 
 	tests := []struct {
 		msg        string
@@ -59,9 +68,9 @@ func TestBagoup(t *testing.T) {
 					osMock.EXPECT().Create(logFileAbs).Return(devnull, nil),
 					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("12.4"), nil),
 					dbMock.EXPECT().Init(semver.MustParse("12.4"), time.Local),
-					dbMock.EXPECT().GetHandleMap(nil),
+					dbMock.EXPECT().GetHandleMap(nil, nil),
 					dbMock.EXPECT().GetAttachmentPaths(ptMock),
-					dbMock.EXPECT().GetChats(nil),
+					dbMock.EXPECT().GetChats(nil, nil),
 					osMock.EXPECT().RmTempDir(),
 				)
 			},
@@ -84,9 +93,9 @@ func TestBagoup(t *testing.T) {
 					osMock.EXPECT().Create(logFileAbs).Return(devnull, nil),
 					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("12.4"), nil),
 					dbMock.EXPECT().Init(semver.MustParse("12.4"), time.Local),
-					dbMock.EXPECT().GetHandleMap(nil),
+					dbMock.EXPECT().GetHandleMap(nil, nil),
 					dbMock.EXPECT().GetAttachmentPaths(ptMock),
-					dbMock.EXPECT().GetChats(nil),
+					dbMock.EXPECT().GetChats(nil, nil),
 					osMock.EXPECT().RmTempDir(),
 				)
 			},
@@ -102,6 +111,17 @@ func TestBagoup(t *testing.T) {
 			},
 			wantCfgErr: `load timezone "NotATimezone": unknown time zone NotATimezone`,
 		},
+		{
+			msg: "invalid regex",
+			opts: Options{
+				DBPath:          "~/Library/Messages/chat.db",
+				ExportPath:      "messages-export",
+				SelfHandle:      "Me",
+				AttachmentsPath: "/",
+				Regex:           []string{"(lunch"},
+			},
+			wantCfgErr: "compile --regex \"(lunch\": error parsing regexp: missing closing ): `(lunch`",
+		},
 		{
 			msg: "error reading tilde expansion file",
 			opts: Options{
@@ -201,9 +221,9 @@ func TestBagoup(t *testing.T) {
 					osMock.EXPECT().MkdirAll(logDirAbs, os.ModePerm),
 					osMock.EXPECT().Create(logFileAbs).Return(devnull, nil),
 					dbMock.EXPECT().Init(semver.MustParse("10.12"), time.Local),
-					dbMock.EXPECT().GetHandleMap(nil),
+					dbMock.EXPECT().GetHandleMap(nil, nil),
 					dbMock.EXPECT().GetAttachmentPaths(ptMock),
-					dbMock.EXPECT().GetChats(nil),
+					dbMock.EXPECT().GetChats(nil, nil),
 					osMock.EXPECT().RmTempDir(),
 				)
 			},
@@ -232,7 +252,7 @@ func TestBagoup(t *testing.T) {
 			opts: Options{
 				DBPath:          "~/Library/Messages/chat.db",
 				ExportPath:      "messages-export",
-				ContactsPath:    &contactsPath,
+				ContactsPaths:   []string{"contacts.vcf"},
 				SelfHandle:      "Me",
 				AttachmentsPath: "/",
 				Timezone:        "Local",
@@ -244,21 +264,171 @@ func TestBagoup(t *testing.T) {
 					osMock.EXPECT().MkdirAll(logDirAbs, os.ModePerm),
 					osMock.EXPECT().Create(logFileAbs).Return(devnull, nil),
 					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("12.4"), nil),
-					osMock.EXPECT().GetContactMap("contacts.vcf"),
+					osMock.EXPECT().GetContactMap([]string{"contacts.vcf"}, opsys.ContactOptions{}),
 					dbMock.EXPECT().Init(semver.MustParse("12.4"), time.Local),
-					dbMock.EXPECT().GetHandleMap(nil),
+					dbMock.EXPECT().GetHandleMap(nil, nil),
 					dbMock.EXPECT().GetAttachmentPaths(ptMock),
-					dbMock.EXPECT().GetChats(nil),
+					dbMock.EXPECT().GetChats(nil, nil),
 					osMock.EXPECT().RmTempDir(),
 				)
 			},
 		},
+		{
+			msg: "contact photos for epub covers",
+			opts: Options{
+				DBPath:          "~/Library/Messages/chat.db",
+				ExportPath:      "messages-export",
+				Format:          "epub",
+				ContactsPaths:   []string{"contacts.vcf"},
+				SelfHandle:      "Me",
+				AttachmentsPath: "/",
+				Timezone:        "Local",
+			},
+			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB, ptMock *mock_pathtools.MockPathTools) {
+				gomock.InOrder(
+					osMock.EXPECT().FileAccess("~/Library/Messages/chat.db"),
+					osMock.EXPECT().FileExist(exportPathAbs),
+					osMock.EXPECT().MkdirAll(logDirAbs, os.ModePerm),
+					osMock.EXPECT().Create(logFileAbs).Return(devnull, nil),
+					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("12.4"), nil),
+					osMock.EXPECT().GetContactMap([]string{"contacts.vcf"}, opsys.ContactOptions{Avatars: true}),
+					dbMock.EXPECT().Init(semver.MustParse("12.4"), time.Local),
+					dbMock.EXPECT().GetHandleMap(nil, nil),
+					osMock.EXPECT().GetTempDir(),
+					dbMock.EXPECT().GetAttachmentPaths(ptMock),
+					dbMock.EXPECT().GetChats(nil, nil),
+					osMock.EXPECT().RmTempDir().Times(2),
+				)
+			},
+		},
 		{
 			msg: "error getting contact map",
 			opts: Options{
 				DBPath:          "~/Library/Messages/chat.db",
 				ExportPath:      "messages-export",
-				ContactsPath:    &contactsPath,
+				ContactsPaths:   []string{"contacts.vcf"},
+				SelfHandle:      "Me",
+				AttachmentsPath: "/",
+				Timezone:        "Local",
+			},
+			setupMocks: func(osMock *mock_opsys.MockOS, _ *mock_chatdb.MockChatDB, _ *mock_pathtools.MockPathTools) {
+				gomock.InOrder(
+					osMock.EXPECT().FileAccess("~/Library/Messages/chat.db"),
+					osMock.EXPECT().FileExist(exportPathAbs),
+					osMock.EXPECT().MkdirAll(logDirAbs, os.ModePerm),
+					osMock.EXPECT().Create(logFileAbs).Return(devnull, nil),
+					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("12.4"), nil),
+					osMock.EXPECT().GetContactMap([]string{"contacts.vcf"}, opsys.ContactOptions{}).Return(nil, nil, errors.New("this is an os error")),
+				)
+			},
+			wantErr: "get contacts: this is an os error",
+		},
+		{
+			msg: "contact collisions",
+			opts: Options{
+				DBPath:          "~/Library/Messages/chat.db",
+				ExportPath:      "messages-export",
+				ContactsPaths:   []string{"contacts.vcf"},
+				CollisionPolicy: "fail",
+				SelfHandle:      "Me",
+				AttachmentsPath: "/",
+				Timezone:        "Local",
+			},
+			setupMocks: func(osMock *mock_opsys.MockOS, _ *mock_chatdb.MockChatDB, _ *mock_pathtools.MockPathTools) {
+				gomock.InOrder(
+					osMock.EXPECT().FileAccess("~/Library/Messages/chat.db"),
+					osMock.EXPECT().FileExist(exportPathAbs),
+					osMock.EXPECT().MkdirAll(logDirAbs, os.ModePerm),
+					osMock.EXPECT().Create(logFileAbs).Return(devnull, nil),
+					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("12.4"), nil),
+					osMock.EXPECT().GetContactMap([]string{"contacts.vcf"}, opsys.ContactOptions{CollisionPolicy: "fail"}).Return(nil, collisions, errors.New("this is an os error")),
+					osMock.EXPECT().Create(collisionsFileAbs).DoAndReturn(func(string) (afero.File, error) {
+						return os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+					}),
+				)
+			},
+			wantErr: "get contacts: this is an os error",
+		},
+		{
+			msg: "stream to stdout",
+			opts: Options{
+				DBPath:          "~/Library/Messages/chat.db",
+				ExportPath:      "-",
+				Format:          "jsonl",
+				ContactsPaths:   []string{"contacts.vcf"},
+				SelfHandle:      "Me",
+				AttachmentsPath: "/",
+				Timezone:        "Local",
+			},
+			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB, ptMock *mock_pathtools.MockPathTools) {
+				gomock.InOrder(
+					osMock.EXPECT().FileAccess("~/Library/Messages/chat.db"),
+					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("12.4"), nil),
+					osMock.EXPECT().GetContactMap([]string{"contacts.vcf"}, opsys.ContactOptions{}).Return(nil, collisions, nil),
+					dbMock.EXPECT().Init(semver.MustParse("12.4"), time.Local),
+					dbMock.EXPECT().GetHandleMap(nil, nil),
+					dbMock.EXPECT().GetAttachmentPaths(ptMock),
+					dbMock.EXPECT().GetChats(nil, nil),
+					osMock.EXPECT().RmTempDir(),
+				)
+			},
+		},
+		{
+			msg: "error creating contact collisions report",
+			opts: Options{
+				DBPath:          "~/Library/Messages/chat.db",
+				ExportPath:      "messages-export",
+				ContactsPaths:   []string{"contacts.vcf"},
+				SelfHandle:      "Me",
+				AttachmentsPath: "/",
+				Timezone:        "Local",
+			},
+			setupMocks: func(osMock *mock_opsys.MockOS, _ *mock_chatdb.MockChatDB, _ *mock_pathtools.MockPathTools) {
+				gomock.InOrder(
+					osMock.EXPECT().FileAccess("~/Library/Messages/chat.db"),
+					osMock.EXPECT().FileExist(exportPathAbs),
+					osMock.EXPECT().MkdirAll(logDirAbs, os.ModePerm),
+					osMock.EXPECT().Create(logFileAbs).Return(devnull, nil),
+					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("12.4"), nil),
+					osMock.EXPECT().GetContactMap([]string{"contacts.vcf"}, opsys.ContactOptions{}).Return(nil, collisions, nil),
+					osMock.EXPECT().Create(collisionsFileAbs).Return(nil, errors.New("this is an os error")),
+				)
+			},
+			wantErr: fmt.Sprintf("create contact collisions report %q: this is an os error", collisionsFileAbs),
+		},
+		{
+			msg: "AddressBook specified",
+			opts: Options{
+				DBPath:          "~/Library/Messages/chat.db",
+				ExportPath:      "messages-export",
+				AddressBookPath: &addressBookPath,
+				DefaultRegion:   "US",
+				SelfHandle:      "Me",
+				AttachmentsPath: "/",
+				Timezone:        "Local",
+			},
+			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB, ptMock *mock_pathtools.MockPathTools) {
+				gomock.InOrder(
+					osMock.EXPECT().FileAccess("~/Library/Messages/chat.db"),
+					osMock.EXPECT().FileExist(exportPathAbs),
+					osMock.EXPECT().MkdirAll(logDirAbs, os.ModePerm),
+					osMock.EXPECT().Create(logFileAbs).Return(devnull, nil),
+					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("12.4"), nil),
+					osMock.EXPECT().GetAddressBookContactMap("AddressBook", opsys.ContactOptions{DefaultRegion: "US"}),
+					dbMock.EXPECT().Init(semver.MustParse("12.4"), time.Local),
+					dbMock.EXPECT().GetHandleMap(nil, nil),
+					dbMock.EXPECT().GetAttachmentPaths(ptMock),
+					dbMock.EXPECT().GetChats(nil, nil),
+					osMock.EXPECT().RmTempDir(),
+				)
+			},
+		},
+		{
+			msg: "error reading AddressBook",
+			opts: Options{
+				DBPath:          "~/Library/Messages/chat.db",
+				ExportPath:      "messages-export",
+				AddressBookPath: &addressBookPath,
 				SelfHandle:      "Me",
 				AttachmentsPath: "/",
 				Timezone:        "Local",
@@ -270,10 +440,60 @@ func TestBagoup(t *testing.T) {
 					osMock.EXPECT().MkdirAll(logDirAbs, os.ModePerm),
 					osMock.EXPECT().Create(logFileAbs).Return(devnull, nil),
 					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("12.4"), nil),
-					osMock.EXPECT().GetContactMap("contacts.vcf").Return(nil, errors.New("this is an os error")),
+					osMock.EXPECT().GetAddressBookContactMap("AddressBook", opsys.ContactOptions{}).Return(nil, nil, errors.New("this is an os error")),
 				)
 			},
-			wantErr: `get contacts from vcard file "contacts.vcf": this is an os error`,
+			wantErr: `get contacts from AddressBook "AddressBook": this is an os error`,
+		},
+		{
+			msg: "aliases file specified",
+			opts: Options{
+				DBPath:          "~/Library/Messages/chat.db",
+				ExportPath:      "messages-export",
+				AliasesPath:     &aliasesPath,
+				DefaultRegion:   "US",
+				SelfHandle:      "Me",
+				AttachmentsPath: "/",
+				Timezone:        "Local",
+			},
+			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB, ptMock *mock_pathtools.MockPathTools) {
+				aliases := chatdb.Aliases{"+15551234567": {Entity: "Book Club"}}
+				gomock.InOrder(
+					osMock.EXPECT().FileAccess("~/Library/Messages/chat.db"),
+					osMock.EXPECT().FileExist(exportPathAbs),
+					osMock.EXPECT().MkdirAll(logDirAbs, os.ModePerm),
+					osMock.EXPECT().Create(logFileAbs).Return(devnull, nil),
+					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("12.4"), nil),
+					osMock.EXPECT().GetAliases("aliases.yaml", "US").Return(aliases, nil),
+					dbMock.EXPECT().Init(semver.MustParse("12.4"), time.Local),
+					dbMock.EXPECT().GetHandleMap(nil, aliases),
+					dbMock.EXPECT().GetAttachmentPaths(ptMock),
+					dbMock.EXPECT().GetChats(nil, aliases),
+					osMock.EXPECT().RmTempDir(),
+				)
+			},
+		},
+		{
+			msg: "error reading aliases file",
+			opts: Options{
+				DBPath:          "~/Library/Messages/chat.db",
+				ExportPath:      "messages-export",
+				AliasesPath:     &aliasesPath,
+				SelfHandle:      "Me",
+				AttachmentsPath: "/",
+				Timezone:        "Local",
+			},
+			setupMocks: func(osMock *mock_opsys.MockOS, _ *mock_chatdb.MockChatDB, _ *mock_pathtools.MockPathTools) {
+				gomock.InOrder(
+					osMock.EXPECT().FileAccess("~/Library/Messages/chat.db"),
+					osMock.EXPECT().FileExist(exportPathAbs),
+					osMock.EXPECT().MkdirAll(logDirAbs, os.ModePerm),
+					osMock.EXPECT().Create(logFileAbs).Return(devnull, nil),
+					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("12.4"), nil),
+					osMock.EXPECT().GetAliases("aliases.yaml", "").Return(nil, errors.New("this is an os error")),
+				)
+			},
+			wantErr: `get aliases from file "aliases.yaml": this is an os error`,
 		},
 		{
 			msg:  "error initializing chat DB",
@@ -301,11 +521,42 @@ func TestBagoup(t *testing.T) {
 					osMock.EXPECT().Create(logFileAbs).Return(devnull, nil),
 					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("12.4"), nil),
 					dbMock.EXPECT().Init(semver.MustParse("12.4"), time.Local),
-					dbMock.EXPECT().GetHandleMap(nil).Return(nil, errors.New("this is a DB error")),
+					dbMock.EXPECT().GetHandleMap(nil, nil).Return(nil, errors.New("this is a DB error")),
 				)
 			},
 			wantErr: "get handle map: this is a DB error",
 		},
+		{
+			msg:  "render an archive",
+			opts: renderOpts,
+			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB, ptMock *mock_pathtools.MockPathTools) {
+				gomock.InOrder(
+					osMock.EXPECT().FileAccess("messages.sqlite"),
+					osMock.EXPECT().FileExist(exportPathAbs),
+					osMock.EXPECT().MkdirAll(logDirAbs, os.ModePerm),
+					osMock.EXPECT().Create(logFileAbs).Return(devnull, nil),
+					dbMock.EXPECT().Init(nil, time.Local),
+					dbMock.EXPECT().GetHandleMap(nil, nil),
+					dbMock.EXPECT().GetAttachmentPaths(ptMock),
+					dbMock.EXPECT().GetChats(nil, nil),
+					osMock.EXPECT().RmTempDir(),
+				)
+			},
+		},
+		{
+			msg:  "error initializing archive",
+			opts: renderOpts,
+			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB, _ *mock_pathtools.MockPathTools) {
+				gomock.InOrder(
+					osMock.EXPECT().FileAccess("messages.sqlite"),
+					osMock.EXPECT().FileExist(exportPathAbs),
+					osMock.EXPECT().MkdirAll(logDirAbs, os.ModePerm),
+					osMock.EXPECT().Create(logFileAbs).Return(devnull, nil),
+					dbMock.EXPECT().Init(nil, time.Local).Return(errors.New("this is a DB error")),
+				)
+			},
+			wantErr: `initialize the archive "messages.sqlite" for reading: this is a DB error`,
+		},
 		{
 			msg: "pdf output",
 			opts: Options{
@@ -324,10 +575,10 @@ func TestBagoup(t *testing.T) {
 					osMock.EXPECT().Create(logFileAbs).Return(devnull, nil),
 					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("12.4"), nil),
 					dbMock.EXPECT().Init(semver.MustParse("12.4"), time.Local),
-					dbMock.EXPECT().GetHandleMap(nil),
+					dbMock.EXPECT().GetHandleMap(nil, nil),
 					osMock.EXPECT().GetTempDir(),
 					dbMock.EXPECT().GetAttachmentPaths(ptMock),
-					dbMock.EXPECT().GetChats(nil),
+					dbMock.EXPECT().GetChats(nil, nil),
 					osMock.EXPECT().RmTempDir().Times(2),
 				)
 			},
@@ -350,7 +601,7 @@ func TestBagoup(t *testing.T) {
 					osMock.EXPECT().Create(logFileAbs).Return(devnull, nil),
 					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("12.4"), nil),
 					dbMock.EXPECT().Init(semver.MustParse("12.4"), time.Local),
-					dbMock.EXPECT().GetHandleMap(nil),
+					dbMock.EXPECT().GetHandleMap(nil, nil),
 					osMock.EXPECT().GetTempDir().Return("", errors.New("this is a tempdir error")),
 				)
 			},
@@ -367,9 +618,9 @@ func TestBagoup(t *testing.T) {
 					osMock.EXPECT().Create(logFileAbs).Return(devnull, nil),
 					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("12.4"), nil),
 					dbMock.EXPECT().Init(semver.MustParse("12.4"), time.Local),
-					dbMock.EXPECT().GetHandleMap(nil),
+					dbMock.EXPECT().GetHandleMap(nil, nil),
 					dbMock.EXPECT().GetAttachmentPaths(ptMock),
-					dbMock.EXPECT().GetChats(nil).Return(nil, errors.New("this is a DB error")),
+					dbMock.EXPECT().GetChats(nil, nil).Return(nil, errors.New("this is a DB error")),
 				)
 			},
 			wantErr: "export chats: get chats: this is a DB error",
@@ -393,9 +644,9 @@ func TestBagoup(t *testing.T) {
 					osMock.EXPECT().Create(logFileAbs).Return(devnull, nil),
 					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("12.4"), nil),
 					dbMock.EXPECT().Init(semver.MustParse("12.4"), time.Local),
-					dbMock.EXPECT().GetHandleMap(nil),
+					dbMock.EXPECT().GetHandleMap(nil, nil),
 					dbMock.EXPECT().GetAttachmentPaths(ptMock),
-					dbMock.EXPECT().GetChats(nil),
+					dbMock.EXPECT().GetChats(nil, nil),
 					ptMock.EXPECT().GetHomeDir(),
 					osMock.EXPECT().Create(tildeexpansionAbs).Return(afero.NewMemMapFs().Create("dummy")),
 					osMock.EXPECT().RmTempDir(),
@@ -421,9 +672,9 @@ func TestBagoup(t *testing.T) {
 					osMock.EXPECT().Create(logFileAbs).Return(devnull, nil),
 					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("12.4"), nil),
 					dbMock.EXPECT().Init(semver.MustParse("12.4"), time.Local),
-					dbMock.EXPECT().GetHandleMap(nil),
+					dbMock.EXPECT().GetHandleMap(nil, nil),
 					dbMock.EXPECT().GetAttachmentPaths(ptMock),
-					dbMock.EXPECT().GetChats(nil),
+					dbMock.EXPECT().GetChats(nil, nil),
 					ptMock.EXPECT().GetHomeDir(),
 					osMock.EXPECT().Create(tildeexpansionAbs).Return(nil, errors.New("this is a permissions error")),
 				)
@@ -453,9 +704,9 @@ func TestBagoup(t *testing.T) {
 					osMock.EXPECT().Create(logFileAbs).Return(devnull, nil),
 					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("12.4"), nil),
 					dbMock.EXPECT().Init(semver.MustParse("12.4"), time.Local),
-					dbMock.EXPECT().GetHandleMap(nil),
+					dbMock.EXPECT().GetHandleMap(nil, nil),
 					dbMock.EXPECT().GetAttachmentPaths(ptMock),
-					dbMock.EXPECT().GetChats(nil),
+					dbMock.EXPECT().GetChats(nil, nil),
 					ptMock.EXPECT().GetHomeDir(),
 					osMock.EXPECT().Create(tildeexpansionAbs).Return(rofs.Open("dummy")),
 				)
@@ -504,3 +755,54 @@ func TestBagoup(t *testing.T) {
 		})
 	}
 }
+
+func TestWriteContactCollisions(t *testing.T) {
+	noleCard := &vcard.Card{
+		"FN":    []*vcard.Field{{Value: "Novak Djokovic"}},
+		"TEL":   []*vcard.Field{{Value: "+381 11 555 5555"}},
+		"EMAIL": []*vcard.Field{{Value: "info@novakdjokovic.com"}},
+	}
+	jelenaCard := &vcard.Card{
+		"FN":    []*vcard.Field{{Value: "Jelena Djokovic"}},
+		"EMAIL": []*vcard.Field{{Value: "info@novakdjokovic.com"}},
+	}
+
+	tests := []struct {
+		msg        string
+		collisions []opsys.ContactCollision
+		wantReport string
+	}{
+		{
+			msg: "no collisions",
+		},
+		{
+			msg: "resolved and unresolved collisions",
+			collisions: []opsys.ContactCollision{
+				{Handle: "+381115555555", Cards: []*vcard.Card{noleCard, noleCard}, Resolved: noleCard},
+				{Handle: "info@novakdjokovic.com", Cards: []*vcard.Card{noleCard, jelenaCard}},
+			},
+			wantReport: `+381115555555 -> Novak Djokovic
+	Novak Djokovic (+381 11 555 5555, info@novakdjokovic.com)
+	Novak Djokovic (+381 11 555 5555, info@novakdjokovic.com)
+info@novakdjokovic.com -> unresolved
+	Novak Djokovic (+381 11 555 5555, info@novakdjokovic.com)
+	Jelena Djokovic (info@novakdjokovic.com)
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			cfg := configuration{OS: opsys.NewOS(fs, nil, ""), logDir: ".bagoup"}
+			assert.NilError(t, cfg.writeContactCollisions(tt.collisions))
+			report, err := afero.ReadFile(fs, ".bagoup/contact-collisions.txt")
+			if tt.wantReport == "" {
+				assert.ErrorIs(t, err, os.ErrNotExist)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantReport, string(report))
+		})
+	}
+}
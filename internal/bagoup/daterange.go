@@ -0,0 +1,87 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package bagoup
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/tagatac/bagoup/v2/chatdb"
+)
+
+const _dateFormatsFix = "FIX: use a date (e.g. 2023, 2023-06, 2023-06-15, or 2023-06-15T09:30) or a duration before now (e.g. 36h, 90d, 2w, 6m, or 1y)"
+
+// Absolute date layouts, with the length of the period each one denotes, so
+// that e.g. "--until 2023" includes all of 2023.
+var _dateLayouts = []struct {
+	layout              string
+	years, months, days int
+}{
+	{layout: "2006", years: 1},
+	{layout: "2006-01", months: 1},
+	{layout: time.DateOnly, days: 1},
+	{layout: "2006-01-02T15:04"},
+	{layout: "2006-01-02T15:04:05"},
+	{layout: time.RFC3339},
+}
+
+var _relativeDateRE = regexp.MustCompile(`^(\d+)([hdwmy])$`)
+
+// parseDateRange parses the --since and --until options into a date range,
+// relative to now and in the given location.
+func parseDateRange(since, until *string, now time.Time, loc *time.Location) (chatdb.DateRange, error) {
+	var dates chatdb.DateRange
+	var err error
+	if since != nil {
+		if dates.Since, err = parseDate(*since, now, loc, false); err != nil {
+			return dates, fmt.Errorf("parse --since %q - %s: %w", *since, _dateFormatsFix, err)
+		}
+	}
+	if until != nil {
+		if dates.Until, err = parseDate(*until, now, loc, true); err != nil {
+			return dates, fmt.Errorf("parse --until %q - %s: %w", *until, _dateFormatsFix, err)
+		}
+	}
+	if !dates.Since.IsZero() && !dates.Until.IsZero() && !dates.Since.Before(dates.Until) {
+		return dates, fmt.Errorf("the --since date %s is not before the --until date %s", dates.Since.Format(time.RFC3339), dates.Until.Format(time.RFC3339))
+	}
+	return dates, nil
+}
+
+// parseDate parses an absolute date, or a duration before now. If end is set,
+// an absolute date denotes the end of its period, e.g. the end of the day for
+// "2023-06-15".
+func parseDate(s string, now time.Time, loc *time.Location, end bool) (time.Time, error) {
+	if m := _relativeDateRE.FindStringSubmatch(s); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return time.Time{}, err
+		}
+		switch m[2] {
+		case "h":
+			return now.Add(-time.Duration(n) * time.Hour), nil
+		case "d":
+			return now.AddDate(0, 0, -n), nil
+		case "w":
+			return now.AddDate(0, 0, -7*n), nil
+		case "m":
+			return now.AddDate(0, -n, 0), nil
+		default:
+			return now.AddDate(-n, 0, 0), nil
+		}
+	}
+	var err error
+	for _, l := range _dateLayouts {
+		var t time.Time
+		if t, err = time.ParseInLocation(l.layout, s, loc); err == nil {
+			if end {
+				t = t.AddDate(l.years, l.months, l.days)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
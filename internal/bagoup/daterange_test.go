@@ -0,0 +1,132 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package bagoup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tagatac/bagoup/v2/chatdb"
+	"gotest.tools/v3/assert"
+)
+
+func TestParseDateRange(t *testing.T) {
+	loc := time.FixedZone("EDT", -4*60*60)
+	now := time.Date(2024, time.March, 15, 12, 0, 0, 0, loc)
+	tests := []struct {
+		msg       string
+		since     string
+		until     string
+		wantDates chatdb.DateRange
+		wantErr   string
+	}{
+		{
+			msg: "no dates",
+		},
+		{
+			msg:   "years",
+			since: "2022",
+			until: "2023",
+			wantDates: chatdb.DateRange{
+				Since: time.Date(2022, time.January, 1, 0, 0, 0, 0, loc),
+				Until: time.Date(2024, time.January, 1, 0, 0, 0, 0, loc),
+			},
+		},
+		{
+			msg:   "months",
+			since: "2023-06",
+			until: "2023-12",
+			wantDates: chatdb.DateRange{
+				Since: time.Date(2023, time.June, 1, 0, 0, 0, 0, loc),
+				Until: time.Date(2024, time.January, 1, 0, 0, 0, 0, loc),
+			},
+		},
+		{
+			msg:   "days",
+			since: "2023-06-15",
+			until: "2023-06-15",
+			wantDates: chatdb.DateRange{
+				Since: time.Date(2023, time.June, 15, 0, 0, 0, 0, loc),
+				Until: time.Date(2023, time.June, 16, 0, 0, 0, 0, loc),
+			},
+		},
+		{
+			msg:   "times",
+			since: "2023-06-15T09:30",
+			until: "2023-06-15T17:45:30",
+			wantDates: chatdb.DateRange{
+				Since: time.Date(2023, time.June, 15, 9, 30, 0, 0, loc),
+				Until: time.Date(2023, time.June, 15, 17, 45, 30, 0, loc),
+			},
+		},
+		{
+			msg:   "RFC 3339",
+			since: "2023-06-15T09:30:00Z",
+			wantDates: chatdb.DateRange{
+				Since: time.Date(2023, time.June, 15, 9, 30, 0, 0, time.UTC),
+			},
+		},
+		{
+			msg:   "relative dates",
+			since: "1y",
+			until: "36h",
+			wantDates: chatdb.DateRange{
+				Since: time.Date(2023, time.March, 15, 12, 0, 0, 0, loc),
+				Until: time.Date(2024, time.March, 14, 0, 0, 0, 0, loc),
+			},
+		},
+		{
+			msg:   "relative days, weeks, and months",
+			since: "6m",
+			until: "2w",
+			wantDates: chatdb.DateRange{
+				Since: time.Date(2023, time.September, 15, 12, 0, 0, 0, loc),
+				Until: time.Date(2024, time.March, 1, 12, 0, 0, 0, loc),
+			},
+		},
+		{
+			msg:   "relative days",
+			since: "90d",
+			wantDates: chatdb.DateRange{
+				Since: time.Date(2023, time.December, 16, 12, 0, 0, 0, loc),
+			},
+		},
+		{
+			msg:     "invalid since",
+			since:   "last week",
+			wantErr: `parse --since "last week" - ` + _dateFormatsFix + `: parsing time "last week" as "2006-01-02T15:04:05Z07:00": cannot parse "last week" as "2006"`,
+		},
+		{
+			msg:     "invalid until",
+			until:   "2023-13",
+			wantErr: `parse --until "2023-13" - ` + _dateFormatsFix + `: parsing time "2023-13": month out of range`,
+		},
+		{
+			msg:     "since not before until",
+			since:   "2023-06-15",
+			until:   "2023-06-14",
+			wantErr: "the --since date 2023-06-15T00:00:00-04:00 is not before the --until date 2023-06-15T00:00:00-04:00",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			var since, until *string
+			if tt.since != "" {
+				since = &tt.since
+			}
+			if tt.until != "" {
+				until = &tt.until
+			}
+			dates, err := parseDateRange(since, until, now, loc)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Assert(t, dates.Since.Equal(tt.wantDates.Since), "since: got %s, want %s", dates.Since, tt.wantDates.Since)
+			assert.Assert(t, dates.Until.Equal(tt.wantDates.Until), "until: got %s, want %s", dates.Until, tt.wantDates.Until)
+		})
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package bagoup
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/tagatac/bagoup/v2/chatdb"
+	"github.com/tagatac/bagoup/v2/phonenum"
+)
+
+const (
+	_entityFix      = "FIX: use a folder name from an export, a phone number or email address, or a chat GUID"
+	_maxSuggestions = 3
+)
+
+// An entityPattern selects entities by their folder names, or by the handles
+// (phone numbers or email addresses) or GUIDs of their chats. Patterns match
+// case-insensitively, as globs if they contain * or ?, or as regular
+// expressions if they are wrapped in slashes, e.g. "/^acme/".
+type entityPattern struct {
+	pattern string
+	re      *regexp.Regexp
+	// handle is the pattern as a normalized phone number or email address, for
+	// plain patterns.
+	handle string
+}
+
+func newEntityPatterns(flag string, patterns []string, defaultRegion string) ([]entityPattern, error) {
+	entityPatterns := make([]entityPattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		p := entityPattern{pattern: pattern}
+		switch {
+		case len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/"):
+			re, err := regexp.Compile("(?i)" + pattern[1:len(pattern)-1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q for the %s flag: %w", pattern, flag, err)
+			}
+			p.re = re
+		case p.isGlob():
+			p.re = globRegexp(pattern)
+		default:
+			p.re = regexp.MustCompile("(?i)^" + regexp.QuoteMeta(pattern) + "$")
+			p.handle = phonenum.Normalize(pattern, defaultRegion)
+		}
+		entityPatterns = append(entityPatterns, p)
+	}
+	return entityPatterns, nil
+}
+
+func (p entityPattern) isGlob() bool {
+	return strings.ContainsAny(p.pattern, "*?")
+}
+
+func (p entityPattern) isPlain() bool {
+	return p.handle != ""
+}
+
+func (p entityPattern) matches(entityChats chatdb.EntityChats, defaultRegion string) bool {
+	return p.re.MatchString(entityChats.Name) ||
+		slices.ContainsFunc(entityChats.Chats, func(chat chatdb.Chat) bool { return p.matchesChat(chat, defaultRegion) })
+}
+
+func (p entityPattern) matchesChat(chat chatdb.Chat, defaultRegion string) bool {
+	handle := chatHandle(chat.GUID)
+	if p.re.MatchString(chat.GUID) || p.re.MatchString(handle) {
+		return true
+	}
+	return p.isPlain() && phonenum.Normalize(handle, defaultRegion) == p.handle
+}
+
+// globRegexp compiles a case-insensitive glob, in which * matches any text and
+// ? matches any single character.
+func globRegexp(glob string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("(?i)^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
+
+// chatHandle returns the handle (or group chat identifier) at the end of a chat
+// GUID, e.g. "+15551234567" for "iMessage;-;+15551234567".
+func chatHandle(guid string) string {
+	return guid[strings.LastIndex(guid, ";")+1:]
+}
+
+// filterEntities selects the entities matching the --entity patterns, if any,
+// and not matching the --exclude-entity patterns. An --entity pattern matching
+// no entities is an error, with suggestions for similarly named entities. The
+// Automated entity is selected by its name as a whole, but a pattern matching
+// the handles or GUIDs of its chats selects or leaves out just those chats,
+// since they belong to unrelated senders.
+func filterEntities(opts Options, chats []chatdb.EntityChats) ([]chatdb.EntityChats, error) {
+	include, err := newEntityPatterns("--entity", opts.Entities, opts.DefaultRegion)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := newEntityPatterns("--exclude-entity", opts.ExcludeEntities, opts.DefaultRegion)
+	if err != nil {
+		return nil, err
+	}
+	includeMatched := make([]bool, len(include))
+	excludeMatched := make([]bool, len(exclude))
+	result := []chatdb.EntityChats{}
+	for _, entityChats := range chats {
+		if entityChats.Automated {
+			entityChats = selectAutomatedChats(entityChats, include, exclude, includeMatched, excludeMatched, opts.DefaultRegion)
+			if len(entityChats.Chats) > 0 {
+				result = append(result, entityChats)
+			}
+			continue
+		}
+		included := len(include) == 0
+		for i, p := range include {
+			if p.matches(entityChats, opts.DefaultRegion) {
+				includeMatched[i] = true
+				included = true
+			}
+		}
+		excluded := false
+		for i, p := range exclude {
+			if p.matches(entityChats, opts.DefaultRegion) {
+				excludeMatched[i] = true
+				excluded = true
+			}
+		}
+		if included && !excluded {
+			result = append(result, entityChats)
+		}
+	}
+	for i, matched := range includeMatched {
+		if !matched {
+			return nil, unmatchedEntityError(include[i], chats)
+		}
+	}
+	for i, matched := range excludeMatched {
+		if !matched {
+			slog.Warn("no entities match the --exclude-entity pattern", "pattern", exclude[i].pattern)
+		}
+	}
+	return result, nil
+}
+
+// selectAutomatedChats returns the Automated entity with the chats selected by
+// the patterns, marking the patterns which match.
+func selectAutomatedChats(entityChats chatdb.EntityChats, include, exclude []entityPattern, includeMatched, excludeMatched []bool, defaultRegion string) chatdb.EntityChats {
+	includedByName := len(include) == 0
+	for i, p := range include {
+		if p.re.MatchString(entityChats.Name) {
+			includeMatched[i] = true
+			includedByName = true
+		}
+	}
+	excludedByName := false
+	for i, p := range exclude {
+		if p.re.MatchString(entityChats.Name) {
+			excludeMatched[i] = true
+			excludedByName = true
+		}
+	}
+	var selected []chatdb.Chat
+	for _, chat := range entityChats.Chats {
+		included := includedByName
+		for i, p := range include {
+			if p.matchesChat(chat, defaultRegion) {
+				includeMatched[i] = true
+				included = true
+			}
+		}
+		excluded := excludedByName
+		for i, p := range exclude {
+			if p.matchesChat(chat, defaultRegion) {
+				excludeMatched[i] = true
+				excluded = true
+			}
+		}
+		if included && !excluded {
+			selected = append(selected, chat)
+		}
+	}
+	entityChats.Chats = selected
+	return entityChats
+}
+
+func unmatchedEntityError(p entityPattern, chats []chatdb.EntityChats) error {
+	var suggestions []string
+	if p.isPlain() {
+		suggestions = suggestEntities(p.pattern, chats)
+	}
+	if len(suggestions) == 0 {
+		return fmt.Errorf("no entities match --entity %q - %s", p.pattern, _entityFix)
+	}
+	for i, s := range suggestions {
+		suggestions[i] = fmt.Sprintf("%q", s)
+	}
+	if len(suggestions) > 1 {
+		suggestions[len(suggestions)-1] = "or " + suggestions[len(suggestions)-1]
+	}
+	sep := ", "
+	if len(suggestions) == 2 {
+		sep = " "
+	}
+	return fmt.Errorf("no entities match --entity %q - did you mean %s?", p.pattern, strings.Join(suggestions, sep))
+}
+
+// suggestEntities returns the names of up to _maxSuggestions entities which are
+// a few edits away from the given name, or which contain it, closest first.
+func suggestEntities(name string, chats []chatdb.EntityChats) []string {
+	type suggestion struct {
+		name     string
+		distance int
+	}
+	name = strings.ToLower(name)
+	maxDistance := max(2, len([]rune(name))/3)
+	var suggestions []suggestion
+	for _, entityChats := range chats {
+		candidate := strings.ToLower(entityChats.Name)
+		distance := editDistance(name, candidate)
+		if distance > maxDistance && !strings.Contains(candidate, name) {
+			continue
+		}
+		suggestions = append(suggestions, suggestion{name: entityChats.Name, distance: distance})
+	}
+	slices.SortStableFunc(suggestions, func(a, b suggestion) int { return cmp.Compare(a.distance, b.distance) })
+	var names []string
+	for _, s := range suggestions {
+		if !slices.Contains(names, s.name) {
+			names = append(names, s.name)
+		}
+		if len(names) == _maxSuggestions {
+			break
+		}
+	}
+	return names
+}
+
+// editDistance returns the Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,236 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package bagoup
+
+import (
+	"testing"
+
+	"github.com/tagatac/bagoup/v2/chatdb"
+	"gotest.tools/v3/assert"
+)
+
+func TestFilterEntities(t *testing.T) {
+	chats := []chatdb.EntityChats{
+		{
+			Name: "Acme Bank",
+			Chats: []chatdb.Chat{
+				{ID: 1, GUID: "SMS;-;262966"},
+			},
+		},
+		{
+			Name: "John Smith",
+			Chats: []chatdb.Chat{
+				{ID: 2, GUID: "iMessage;-;+15551234567"},
+				{ID: 3, GUID: "iMessage;-;john@example.com"},
+			},
+		},
+		{
+			Name: "Jane Smith",
+			Chats: []chatdb.Chat{
+				{ID: 4, GUID: "iMessage;-;+15557654321"},
+			},
+		},
+		{
+			Name: "Book Club",
+			Chats: []chatdb.Chat{
+				{ID: 5, GUID: "iMessage;+;chat123456789"},
+			},
+		},
+	}
+
+	tests := []struct {
+		msg       string
+		opts      Options
+		wantNames []string
+		wantErr   string
+	}{
+		{
+			msg:       "no filters",
+			wantNames: []string{"Acme Bank", "John Smith", "Jane Smith", "Book Club"},
+		},
+		{
+			msg:       "exact name, ignoring case",
+			opts:      Options{Entities: []string{"john smith"}},
+			wantNames: []string{"John Smith"},
+		},
+		{
+			msg:       "raw handle of a contact",
+			opts:      Options{Entities: []string{"JOHN@example.com"}},
+			wantNames: []string{"John Smith"},
+		},
+		{
+			msg:       "phone number in another format",
+			opts:      Options{Entities: []string{"(555) 765-4321"}, DefaultRegion: "US"},
+			wantNames: []string{"Jane Smith"},
+		},
+		{
+			msg:       "chat GUID",
+			opts:      Options{Entities: []string{"iMessage;+;chat123456789"}},
+			wantNames: []string{"Book Club"},
+		},
+		{
+			msg:       "glob",
+			opts:      Options{Entities: []string{"* smith"}},
+			wantNames: []string{"John Smith", "Jane Smith"},
+		},
+		{
+			msg:       "glob with a single character wildcard",
+			opts:      Options{Entities: []string{"J?ne*"}},
+			wantNames: []string{"Jane Smith"},
+		},
+		{
+			msg:       "regular expression",
+			opts:      Options{Entities: []string{"/^(acme|book)/"}},
+			wantNames: []string{"Acme Bank", "Book Club"},
+		},
+		{
+			msg:       "exclude",
+			opts:      Options{ExcludeEntities: []string{"acme*", "+15557654321"}},
+			wantNames: []string{"John Smith", "Book Club"},
+		},
+		{
+			msg:       "include and exclude",
+			opts:      Options{Entities: []string{"*smith"}, ExcludeEntities: []string{"Jane Smith"}},
+			wantNames: []string{"John Smith"},
+		},
+		{
+			msg:       "exclude pattern matching nothing",
+			opts:      Options{ExcludeEntities: []string{"nobody"}},
+			wantNames: []string{"Acme Bank", "John Smith", "Jane Smith", "Book Club"},
+		},
+		{
+			msg:     "invalid regular expression",
+			opts:    Options{Entities: []string{"/(acme/"}},
+			wantErr: "invalid pattern \"/(acme/\" for the --entity flag: error parsing regexp: missing closing ): `(?i)(acme`",
+		},
+		{
+			msg:     "invalid exclude regular expression",
+			opts:    Options{ExcludeEntities: []string{"/[a/"}},
+			wantErr: "invalid pattern \"/[a/\" for the --exclude-entity flag: error parsing regexp: missing closing ]: `[a`",
+		},
+		{
+			msg:     "typo",
+			opts:    Options{Entities: []string{"Jon Smith"}},
+			wantErr: `no entities match --entity "Jon Smith" - did you mean "John Smith" or "Jane Smith"?`,
+		},
+		{
+			msg:     "partial name",
+			opts:    Options{Entities: []string{"smith"}},
+			wantErr: `no entities match --entity "smith" - did you mean "John Smith" or "Jane Smith"?`,
+		},
+		{
+			msg:     "one suggestion",
+			opts:    Options{Entities: []string{"Acme Bnk"}},
+			wantErr: `no entities match --entity "Acme Bnk" - did you mean "Acme Bank"?`,
+		},
+		{
+			msg:     "no suggestions",
+			opts:    Options{Entities: []string{"Alice"}},
+			wantErr: `no entities match --entity "Alice" - ` + _entityFix,
+		},
+		{
+			msg:     "unmatched glob",
+			opts:    Options{Entities: []string{"*jones"}},
+			wantErr: `no entities match --entity "*jones" - ` + _entityFix,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			result, err := filterEntities(tt.opts, chats)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			names := []string{}
+			for _, entityChats := range result {
+				names = append(names, entityChats.Name)
+			}
+			assert.DeepEqual(t, tt.wantNames, names)
+		})
+	}
+}
+
+func TestFilterEntitiesAutomated(t *testing.T) {
+	chats := []chatdb.EntityChats{
+		{Name: "Acme Bank", Chats: []chatdb.Chat{{ID: 1, GUID: "iMessage;-;help@acme.example"}}},
+		{Name: "Automated", Chats: []chatdb.Chat{{ID: 2, GUID: "SMS;-;262966"}, {ID: 3, GUID: "SMS;-;72245"}}, Automated: true},
+		{Name: "John Smith", Chats: []chatdb.Chat{{ID: 4, GUID: "iMessage;-;+15551234567"}}},
+	}
+
+	tests := []struct {
+		msg       string
+		opts      Options
+		wantChats map[string][]int
+		wantErr   string
+	}{
+		{
+			msg:       "no filters",
+			wantChats: map[string][]int{"Acme Bank": {1}, "Automated": {2, 3}, "John Smith": {4}},
+		},
+		{
+			msg:       "automated entity by name",
+			opts:      Options{Entities: []string{"Automated"}},
+			wantChats: map[string][]int{"Automated": {2, 3}},
+		},
+		{
+			msg:       "automated entity by glob",
+			opts:      Options{Entities: []string{"auto*"}},
+			wantChats: map[string][]int{"Automated": {2, 3}},
+		},
+		{
+			msg:       "one automated chat by handle",
+			opts:      Options{Entities: []string{"72245", "John Smith"}},
+			wantChats: map[string][]int{"Automated": {3}, "John Smith": {4}},
+		},
+		{
+			msg:       "exclude automated entity",
+			opts:      Options{ExcludeEntities: []string{"automated"}},
+			wantChats: map[string][]int{"Acme Bank": {1}, "John Smith": {4}},
+		},
+		{
+			msg:       "exclude one automated chat",
+			opts:      Options{Entities: []string{"Automated"}, ExcludeEntities: []string{"262966"}},
+			wantChats: map[string][]int{"Automated": {3}},
+		},
+		{
+			msg:     "typo",
+			opts:    Options{Entities: []string{"Automted"}},
+			wantErr: `no entities match --entity "Automted" - did you mean "Automated"?`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			result, err := filterEntities(tt.opts, chats)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			gotChats := map[string][]int{}
+			for _, entityChats := range result {
+				for _, chat := range entityChats.Chats {
+					gotChats[entityChats.Name] = append(gotChats[entityChats.Name], chat.ID)
+				}
+			}
+			assert.DeepEqual(t, tt.wantChats, gotChats)
+		})
+	}
+}
+
+func TestSuggestEntities(t *testing.T) {
+	chats := []chatdb.EntityChats{
+		{Name: "Alexandra"},
+		{Name: "Alexander"},
+		{Name: "Alexis"},
+		{Name: "Alex Kowalski"},
+		{Name: "Bob"},
+	}
+	assert.DeepEqual(t, suggestEntities("alexandr", chats), []string{"Alexandra", "Alexander"})
+	assert.DeepEqual(t, suggestEntities("alex", chats), []string{"Alexis", "Alexandra", "Alexander"})
+	assert.DeepEqual(t, suggestEntities("bobby", chats), []string{"Bob"})
+	assert.Assert(t, suggestEntities("Charlie", chats) == nil)
+}
@@ -5,22 +5,68 @@ package bagoup
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 
 	progressbar "github.com/elulcao/progress-bar/cmd"
 	"github.com/emersion/go-vcard"
 	"github.com/tagatac/bagoup/v2/chatdb"
+	"github.com/tagatac/bagoup/v2/opsys"
 )
 
-func (cfg *configuration) exportChats(contactMap map[string]*vcard.Card) error {
+// The file holding the messages of all chats, with the --combined-csv flag.
+const _combinedCSVFilename = "messages.csv"
+
+// The archive of all chats, with --format sqlite.
+const _archiveFilename = "messages.sqlite"
+
+func (cfg *configuration) exportChats(contactMap map[string]*vcard.Card, aliases chatdb.Aliases) error {
 	if err := getAttachmentPaths(cfg); err != nil {
 		return err
 	}
-	chats, err := cfg.ChatDB.GetChats(contactMap)
+	chats, err := cfg.ChatDB.GetChats(contactMap, aliases)
 	if err != nil {
 		return fmt.Errorf("get chats: %w", err)
 	}
-	chats = filterEntities(cfg.Options.Entities, chats)
+	// Automated chats are collected first, so that the Automated entity can be
+	// selected with the --entity flag.
+	chats, err = cfg.filterAutomated(chats)
+	if err != nil {
+		return err
+	}
+	chats, err = filterEntities(cfg.Options, chats)
+	if err != nil {
+		return err
+	}
+	chats, err = cfg.filterActivity(chats)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Options.CombinedCSV {
+		if err := cfg.OS.MkdirAll(cfg.Options.ExportPath, os.ModePerm); err != nil {
+			return fmt.Errorf("create directory %q: %w", cfg.Options.ExportPath, err)
+		}
+		csvPath := filepath.Join(cfg.Options.ExportPath, _combinedCSVFilename)
+		csvFile, err := cfg.OS.Create(csvPath)
+		if err != nil {
+			return fmt.Errorf("create file %q: %w", csvPath, err)
+		}
+		defer csvFile.Close()
+		cfg.combinedCSV = csvFile
+	}
+	if cfg.Options.Format == opsys.FormatSQLite {
+		if err := cfg.OS.MkdirAll(cfg.Options.ExportPath, os.ModePerm); err != nil {
+			return fmt.Errorf("create directory %q: %w", cfg.Options.ExportPath, err)
+		}
+		archivePath := filepath.Join(cfg.Options.ExportPath, _archiveFilename)
+		a, err := cfg.createArchive(archivePath, cfg.version)
+		if err != nil {
+			return fmt.Errorf("create archive %q: %w", archivePath, err)
+		}
+		defer a.Close()
+		cfg.archive = a
+	}
 
 	bar := progressbar.NewPBar()
 	bar.SignalHandler()
@@ -31,6 +77,9 @@ func (cfg *configuration) exportChats(contactMap map[string]*vcard.Card) error {
 			return err
 		}
 	}
+	if cfg.Options.Format == opsys.FormatHTML {
+		return cfg.writeHTMLIndex()
+	}
 	return nil
 }
 
@@ -40,7 +89,7 @@ func getAttachmentPaths(cfg *configuration) error {
 		return fmt.Errorf("get attachment paths: %w", err)
 	}
 	cfg.attachmentPaths = attPaths
-	if cfg.Options.OutputPDF || cfg.Options.CopyAttachments {
+	if cfg.Options.embeddingAttachments() || cfg.Options.CopyAttachments {
 		for _, msgPaths := range attPaths {
 			if len(msgPaths) == 0 {
 				continue
@@ -58,42 +107,36 @@ func getAttachmentPaths(cfg *configuration) error {
 	return nil
 }
 
-func filterEntities(entities []string, chats []chatdb.EntityChats) []chatdb.EntityChats {
-	if len(entities) == 0 {
-		return chats
-	}
-	result := []chatdb.EntityChats{}
-	for _, entityChats := range chats {
-		for _, entity := range entities {
-			if entityChats.Name == entity {
-				result = append(result, entityChats)
-			}
-		}
-	}
-	return result
-}
-
 func (cfg *configuration) exportEntityChats(entityChats chatdb.EntityChats) error {
-	mergeChats := !cfg.Options.SeparateChats
+	// The chats in the Automated folder are from unrelated senders, so they
+	// are never merged.
+	mergeChats := !cfg.Options.SeparateChats && !entityChats.Automated
 	var guids []string
 	var entityMessageIDs []chatdb.DatedMessageID
 	for _, chat := range entityChats.Chats {
-		messageIDs, err := cfg.ChatDB.GetMessageIDs(chat.ID)
+		messageIDs, err := cfg.ChatDB.GetMessageIDs(chat.ID, cfg.dates)
 		if err != nil {
 			return fmt.Errorf("get message IDs for chat ID %d: %w", chat.ID, err)
 		}
+		for i := range messageIDs {
+			messageIDs[i].ChatGUID = chat.GUID
+		}
+		if len(messageIDs) == 0 && cfg.dates != (chatdb.DateRange{}) {
+			// Skip chats without messages in the date range.
+			continue
+		}
 		if mergeChats {
 			guids = append(guids, chat.GUID)
 			entityMessageIDs = append(entityMessageIDs, messageIDs...)
 		} else {
-			if err := cfg.writeFile(entityChats.Name, []string{chat.GUID}, messageIDs); err != nil {
+			if err := cfg.writeFile(entityChats, []string{chat.GUID}, messageIDs); err != nil {
 				return err
 			}
 		}
 		cfg.counts.chats++
 	}
-	if mergeChats {
-		if err := cfg.writeFile(entityChats.Name, guids, entityMessageIDs); err != nil {
+	if mergeChats && len(guids) > 0 {
+		if err := cfg.writeFile(entityChats, guids, entityMessageIDs); err != nil {
 			return err
 		}
 	}
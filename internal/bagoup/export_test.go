@@ -7,8 +7,11 @@ import (
 	"errors"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/archive"
+	"github.com/tagatac/bagoup/v2/archive/mock_archive"
 	"github.com/tagatac/bagoup/v2/chatdb"
 	"github.com/tagatac/bagoup/v2/chatdb/mock_chatdb"
 	"github.com/tagatac/bagoup/v2/opsys/mock_opsys"
@@ -25,7 +28,9 @@ func TestExportChats(t *testing.T) {
 		separateChats   bool
 		pdf             bool
 		copyAttachments bool
+		combinedCSV     bool
 		entities        []string
+		dates           chatdb.DateRange
 		setupMocks      func(*mock_chatdb.MockChatDB, *mock_opsys.MockOS, []*mock_opsys.MockOutFile)
 		wantErr         string
 	}{
@@ -36,7 +41,7 @@ func TestExportChats(t *testing.T) {
 					dbMock.EXPECT().GetAttachmentPaths(nil).Return(map[int][]chatdb.Attachment{
 						100: {{Filename: "attachmentpath"}},
 					}, nil),
-					dbMock.EXPECT().GetChats(nil).Return([]chatdb.EntityChats{
+					dbMock.EXPECT().GetChats(nil, nil).Return([]chatdb.EntityChats{
 						{
 							Name: "testdisplayname",
 							Chats: []chatdb.Chat{
@@ -60,15 +65,15 @@ func TestExportChats(t *testing.T) {
 							},
 						},
 					}, nil),
-					dbMock.EXPECT().GetMessageIDs(1),
-					dbMock.EXPECT().GetMessageIDs(2),
+					dbMock.EXPECT().GetMessageIDs(1, chatdb.DateRange{}),
+					dbMock.EXPECT().GetMessageIDs(2, chatdb.DateRange{}),
 					osMock.EXPECT().MkdirAll("messages-export/testdisplayname", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/testdisplayname/testguid;;;testguid2.txt").Return(chatFile, nil),
 					osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMocks[0]),
 					ofMocks[0].EXPECT().Stage(),
 					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
 					ofMocks[0].EXPECT().Flush(),
-					dbMock.EXPECT().GetMessageIDs(3),
+					dbMock.EXPECT().GetMessageIDs(3, chatdb.DateRange{}),
 					osMock.EXPECT().MkdirAll("messages-export/testdisplayname2", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/testdisplayname2/testguid3.txt").Return(chatFile, nil),
 					osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMocks[1]),
@@ -86,7 +91,7 @@ func TestExportChats(t *testing.T) {
 					dbMock.EXPECT().GetAttachmentPaths(nil).Return(map[int][]chatdb.Attachment{
 						100: {{Filename: "attachmentpath"}},
 					}, nil),
-					dbMock.EXPECT().GetChats(nil).Return([]chatdb.EntityChats{
+					dbMock.EXPECT().GetChats(nil, nil).Return([]chatdb.EntityChats{
 						{
 							Name: "testdisplayname",
 							Chats: []chatdb.Chat{
@@ -110,8 +115,8 @@ func TestExportChats(t *testing.T) {
 							},
 						},
 					}, nil),
-					dbMock.EXPECT().GetMessageIDs(1),
-					dbMock.EXPECT().GetMessageIDs(2),
+					dbMock.EXPECT().GetMessageIDs(1, chatdb.DateRange{}),
+					dbMock.EXPECT().GetMessageIDs(2, chatdb.DateRange{}),
 					osMock.EXPECT().MkdirAll("messages-export/testdisplayname", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/testdisplayname/testguid;;;testguid2.txt").Return(chatFile, nil),
 					osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMocks[0]),
@@ -129,7 +134,7 @@ func TestExportChats(t *testing.T) {
 					dbMock.EXPECT().GetAttachmentPaths(nil).Return(map[int][]chatdb.Attachment{
 						100: {{Filename: "attachmentpath"}},
 					}, nil),
-					dbMock.EXPECT().GetChats(nil).Return([]chatdb.EntityChats{
+					dbMock.EXPECT().GetChats(nil, nil).Return([]chatdb.EntityChats{
 						{
 							Name: "testdisplayname",
 							Chats: []chatdb.Chat{
@@ -153,15 +158,15 @@ func TestExportChats(t *testing.T) {
 							},
 						},
 					}, nil),
-					dbMock.EXPECT().GetMessageIDs(1),
-					dbMock.EXPECT().GetMessageIDs(2),
+					dbMock.EXPECT().GetMessageIDs(1, chatdb.DateRange{}),
+					dbMock.EXPECT().GetMessageIDs(2, chatdb.DateRange{}),
 					osMock.EXPECT().MkdirAll("messages-export/testdisplayname", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/testdisplayname/testguid;;;testguid2.txt").Return(chatFile, nil),
 					osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMocks[0]),
 					ofMocks[0].EXPECT().Stage(),
 					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
 					ofMocks[0].EXPECT().Flush(),
-					dbMock.EXPECT().GetMessageIDs(3),
+					dbMock.EXPECT().GetMessageIDs(3, chatdb.DateRange{}),
 					osMock.EXPECT().MkdirAll("messages-export/testdisplayname2", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/testdisplayname2/testguid3.txt").Return(chatFile, nil),
 					osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMocks[1]),
@@ -179,7 +184,7 @@ func TestExportChats(t *testing.T) {
 					dbMock.EXPECT().GetAttachmentPaths(nil).Return(map[int][]chatdb.Attachment{
 						100: {{Filename: "attachmentpath"}},
 					}, nil),
-					dbMock.EXPECT().GetChats(nil).Return([]chatdb.EntityChats{
+					dbMock.EXPECT().GetChats(nil, nil).Return([]chatdb.EntityChats{
 						{
 							Name: "testdisplayname",
 							Chats: []chatdb.Chat{
@@ -194,14 +199,14 @@ func TestExportChats(t *testing.T) {
 							},
 						},
 					}, nil),
-					dbMock.EXPECT().GetMessageIDs(1),
+					dbMock.EXPECT().GetMessageIDs(1, chatdb.DateRange{}),
 					osMock.EXPECT().MkdirAll("messages-export/testdisplayname", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/testdisplayname/testguid.txt").Return(chatFile, nil),
 					osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMocks[0]),
 					ofMocks[0].EXPECT().Stage(),
 					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
 					ofMocks[0].EXPECT().Flush(),
-					dbMock.EXPECT().GetMessageIDs(2),
+					dbMock.EXPECT().GetMessageIDs(2, chatdb.DateRange{}),
 					osMock.EXPECT().MkdirAll("messages-export/testdisplayname", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/testdisplayname/testguid2.txt").Return(chatFile, nil),
 					osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMocks[1]),
@@ -220,7 +225,7 @@ func TestExportChats(t *testing.T) {
 						100: {{Filename: "attachmentpath"}},
 					}, nil),
 					osMock.EXPECT().FileAccess("attachmentpath"),
-					dbMock.EXPECT().GetChats(nil).Return([]chatdb.EntityChats{
+					dbMock.EXPECT().GetChats(nil, nil).Return([]chatdb.EntityChats{
 						{
 							Name: "testdisplayname",
 							Chats: []chatdb.Chat{
@@ -231,7 +236,7 @@ func TestExportChats(t *testing.T) {
 							},
 						},
 					}, nil),
-					dbMock.EXPECT().GetMessageIDs(1),
+					dbMock.EXPECT().GetMessageIDs(1, chatdb.DateRange{}),
 					osMock.EXPECT().MkdirAll("messages-export/testdisplayname", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/testdisplayname/testguid.pdf").Return(chatFile, nil),
 					osMock.EXPECT().NewWeasyPrintFile("testdisplayname", chatFile, false).Return(ofMocks[0]),
@@ -250,7 +255,7 @@ func TestExportChats(t *testing.T) {
 						100: {},
 						200: {{}},
 					}, nil),
-					dbMock.EXPECT().GetChats(nil).Return([]chatdb.EntityChats{
+					dbMock.EXPECT().GetChats(nil, nil).Return([]chatdb.EntityChats{
 						{
 							Name: "testdisplayname",
 							Chats: []chatdb.Chat{
@@ -261,7 +266,7 @@ func TestExportChats(t *testing.T) {
 							},
 						},
 					}, nil),
-					dbMock.EXPECT().GetMessageIDs(1),
+					dbMock.EXPECT().GetMessageIDs(1, chatdb.DateRange{}),
 					osMock.EXPECT().MkdirAll("messages-export/testdisplayname", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/testdisplayname/testguid.pdf").Return(chatFile, nil),
 					osMock.EXPECT().NewWeasyPrintFile("testdisplayname", chatFile, false).Return(ofMocks[0]),
@@ -280,7 +285,7 @@ func TestExportChats(t *testing.T) {
 						100: {{Filename: "attachmentpath"}},
 					}, nil),
 					osMock.EXPECT().FileAccess("attachmentpath"),
-					dbMock.EXPECT().GetChats(nil).Return([]chatdb.EntityChats{
+					dbMock.EXPECT().GetChats(nil, nil).Return([]chatdb.EntityChats{
 						{
 							Name: "testdisplayname",
 							Chats: []chatdb.Chat{
@@ -291,7 +296,7 @@ func TestExportChats(t *testing.T) {
 							},
 						},
 					}, nil),
-					dbMock.EXPECT().GetMessageIDs(1),
+					dbMock.EXPECT().GetMessageIDs(1, chatdb.DateRange{}),
 					osMock.EXPECT().MkdirAll("messages-export/testdisplayname", os.ModePerm),
 					osMock.EXPECT().MkdirAll("messages-export/testdisplayname/attachments", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/testdisplayname/testguid.txt").Return(chatFile, nil),
@@ -302,6 +307,89 @@ func TestExportChats(t *testing.T) {
 				)
 			},
 		},
+		{
+			msg:   "date range - skip chats without messages in range",
+			dates: chatdb.DateRange{Since: time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)},
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB, osMock *mock_opsys.MockOS, ofMocks []*mock_opsys.MockOutFile) {
+				dates := chatdb.DateRange{Since: time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)}
+				gomock.InOrder(
+					dbMock.EXPECT().GetAttachmentPaths(nil),
+					dbMock.EXPECT().GetChats(nil, nil).Return([]chatdb.EntityChats{
+						{
+							Name: "testdisplayname",
+							Chats: []chatdb.Chat{
+								{
+									ID:   1,
+									GUID: "testguid",
+								},
+								{
+									ID:   2,
+									GUID: "testguid2",
+								},
+							},
+						},
+						{
+							Name: "testdisplayname2",
+							Chats: []chatdb.Chat{
+								{
+									ID:   3,
+									GUID: "testguid3",
+								},
+							},
+						},
+					}, nil),
+					dbMock.EXPECT().GetMessageIDs(1, dates),
+					dbMock.EXPECT().GetMessageIDs(2, dates).Return([]chatdb.DatedMessageID{{ID: 200}}, nil),
+					osMock.EXPECT().MkdirAll("messages-export/testdisplayname", os.ModePerm),
+					osMock.EXPECT().Create("messages-export/testdisplayname/testguid2.txt").Return(chatFile, nil),
+					osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMocks[0]),
+					dbMock.EXPECT().GetMessage(200, gomock.Any()),
+					ofMocks[0].EXPECT().WriteMessage(gomock.Any()),
+					ofMocks[0].EXPECT().Stage(),
+					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
+					ofMocks[0].EXPECT().Flush(),
+					dbMock.EXPECT().GetMessageIDs(3, dates),
+				)
+			},
+		},
+		{
+			msg:         "combined CSV",
+			combinedCSV: true,
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB, osMock *mock_opsys.MockOS, ofMocks []*mock_opsys.MockOutFile) {
+				gomock.InOrder(
+					dbMock.EXPECT().GetAttachmentPaths(nil),
+					dbMock.EXPECT().GetChats(nil, nil).Return([]chatdb.EntityChats{
+						{Name: "testdisplayname", Chats: []chatdb.Chat{{ID: 1, GUID: "testguid"}}},
+						{Name: "testdisplayname2", Chats: []chatdb.Chat{{ID: 2, GUID: "testguid2"}}},
+					}, nil),
+					osMock.EXPECT().MkdirAll("messages-export", os.ModePerm),
+					osMock.EXPECT().Create("messages-export/messages.csv").Return(chatFile, nil),
+					dbMock.EXPECT().GetMessageIDs(1, chatdb.DateRange{}),
+					osMock.EXPECT().NewCSVFile("testdisplayname", chatFile, true).Return(ofMocks[0]),
+					ofMocks[0].EXPECT().Stage(),
+					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
+					ofMocks[0].EXPECT().Flush(),
+					dbMock.EXPECT().GetMessageIDs(2, chatdb.DateRange{}),
+					osMock.EXPECT().NewCSVFile("testdisplayname2", chatFile, false).Return(ofMocks[1]),
+					ofMocks[1].EXPECT().Stage(),
+					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
+					ofMocks[1].EXPECT().Flush(),
+				)
+			},
+		},
+		{
+			msg:         "combined CSV - create file error",
+			combinedCSV: true,
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB, osMock *mock_opsys.MockOS, _ []*mock_opsys.MockOutFile) {
+				gomock.InOrder(
+					dbMock.EXPECT().GetAttachmentPaths(nil),
+					dbMock.EXPECT().GetChats(nil, nil),
+					osMock.EXPECT().MkdirAll("messages-export", os.ModePerm),
+					osMock.EXPECT().Create("messages-export/messages.csv").Return(nil, errors.New("this is a permissions error")),
+				)
+			},
+			wantErr: `create file "messages-export/messages.csv": this is a permissions error`,
+		},
 		{
 			msg: "error getting attachment paths",
 			setupMocks: func(dbMock *mock_chatdb.MockChatDB, _ *mock_opsys.MockOS, _ []*mock_opsys.MockOutFile) {
@@ -322,12 +410,33 @@ func TestExportChats(t *testing.T) {
 			},
 			wantErr: "access to attachments - FIX: https://github.com/tagatac/bagoup/blob/master/README.md#protected-file-access: this is a permissions error",
 		},
+		{
+			msg:      "no entities match",
+			entities: []string{"testdisplayname3"},
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB, _ *mock_opsys.MockOS, _ []*mock_opsys.MockOutFile) {
+				gomock.InOrder(
+					dbMock.EXPECT().GetAttachmentPaths(nil),
+					dbMock.EXPECT().GetChats(nil, nil).Return([]chatdb.EntityChats{
+						{
+							Name: "testdisplayname",
+							Chats: []chatdb.Chat{
+								{
+									ID:   1,
+									GUID: "testguid",
+								},
+							},
+						},
+					}, nil),
+				)
+			},
+			wantErr: `no entities match --entity "testdisplayname3" - did you mean "testdisplayname"?`,
+		},
 		{
 			msg: "GetMessageIDs error",
 			setupMocks: func(dbMock *mock_chatdb.MockChatDB, _ *mock_opsys.MockOS, _ []*mock_opsys.MockOutFile) {
 				gomock.InOrder(
 					dbMock.EXPECT().GetAttachmentPaths(nil),
-					dbMock.EXPECT().GetChats(nil).Return([]chatdb.EntityChats{
+					dbMock.EXPECT().GetChats(nil, nil).Return([]chatdb.EntityChats{
 						{
 							Name: "testdisplayname",
 							Chats: []chatdb.Chat{
@@ -338,7 +447,7 @@ func TestExportChats(t *testing.T) {
 							},
 						},
 					}, nil),
-					dbMock.EXPECT().GetMessageIDs(1).Return(nil, errors.New("this is a DB error")),
+					dbMock.EXPECT().GetMessageIDs(1, chatdb.DateRange{}).Return(nil, errors.New("this is a DB error")),
 				)
 			},
 			wantErr: "get message IDs for chat ID 1: this is a DB error",
@@ -348,7 +457,7 @@ func TestExportChats(t *testing.T) {
 			setupMocks: func(dbMock *mock_chatdb.MockChatDB, osMock *mock_opsys.MockOS, _ []*mock_opsys.MockOutFile) {
 				gomock.InOrder(
 					dbMock.EXPECT().GetAttachmentPaths(nil),
-					dbMock.EXPECT().GetChats(nil).Return([]chatdb.EntityChats{
+					dbMock.EXPECT().GetChats(nil, nil).Return([]chatdb.EntityChats{
 						{
 							Name: "testdisplayname",
 							Chats: []chatdb.Chat{
@@ -359,7 +468,7 @@ func TestExportChats(t *testing.T) {
 							},
 						},
 					}, nil),
-					dbMock.EXPECT().GetMessageIDs(1),
+					dbMock.EXPECT().GetMessageIDs(1, chatdb.DateRange{}),
 					osMock.EXPECT().MkdirAll("messages-export/testdisplayname", os.ModePerm).Return(errors.New("this is a permissions error")),
 				)
 			},
@@ -371,7 +480,7 @@ func TestExportChats(t *testing.T) {
 			setupMocks: func(dbMock *mock_chatdb.MockChatDB, osMock *mock_opsys.MockOS, _ []*mock_opsys.MockOutFile) {
 				gomock.InOrder(
 					dbMock.EXPECT().GetAttachmentPaths(nil),
-					dbMock.EXPECT().GetChats(nil).Return([]chatdb.EntityChats{
+					dbMock.EXPECT().GetChats(nil, nil).Return([]chatdb.EntityChats{
 						{
 							Name: "testdisplayname",
 							Chats: []chatdb.Chat{
@@ -386,7 +495,7 @@ func TestExportChats(t *testing.T) {
 							},
 						},
 					}, nil),
-					dbMock.EXPECT().GetMessageIDs(1),
+					dbMock.EXPECT().GetMessageIDs(1, chatdb.DateRange{}),
 					osMock.EXPECT().MkdirAll("messages-export/testdisplayname", os.ModePerm).Return(errors.New("this is a permissions error")),
 				)
 			},
@@ -417,18 +526,140 @@ func TestExportChats(t *testing.T) {
 					SeparateChats:   tt.separateChats,
 					OutputPDF:       tt.pdf,
 					CopyAttachments: tt.copyAttachments,
+					CombinedCSV:     tt.combinedCSV,
 					Entities:        tt.entities,
 				},
 				OS:     osMock,
 				ChatDB: dbMock,
+				dates:  tt.dates,
 				counts: cnts,
 			}
-			err = cfg.exportChats(nil)
+			err = cfg.exportChats(nil, nil)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+		})
+	}
+}
+
+func TestExportArchive(t *testing.T) {
+	entity := chatdb.EntityChats{
+		Name: "testdisplayname",
+		Chats: []chatdb.Chat{
+			{ID: 1, GUID: "testguid"},
+			{ID: 2, GUID: "testguid2"},
+		},
+	}
+
+	tests := []struct {
+		msg        string
+		setupMocks func(*mock_chatdb.MockChatDB, *mock_opsys.MockOS, *mock_archive.MockArchive, *mock_opsys.MockOutFile)
+		createErr  error
+		wantFiles  int
+		wantErr    string
+	}{
+		{
+			msg: "one entity",
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB, osMock *mock_opsys.MockOS, archiveMock *mock_archive.MockArchive, ofMock *mock_opsys.MockOutFile) {
+				gomock.InOrder(
+					dbMock.EXPECT().GetAttachmentPaths(nil),
+					dbMock.EXPECT().GetChats(nil, nil).Return([]chatdb.EntityChats{entity}, nil),
+					osMock.EXPECT().MkdirAll("messages-export", os.ModePerm),
+					dbMock.EXPECT().GetMessageIDs(1, chatdb.DateRange{}),
+					dbMock.EXPECT().GetMessageIDs(2, chatdb.DateRange{}),
+					archiveMock.EXPECT().NewOutFile(entity, []string{"testguid", "testguid2"}).Return(ofMock),
+					ofMock.EXPECT().Stage(),
+					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
+					ofMock.EXPECT().Flush(),
+					archiveMock.EXPECT().Close(),
+				)
+			},
+			wantFiles: 1,
+		},
+		{
+			msg: "create directory error",
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB, osMock *mock_opsys.MockOS, _ *mock_archive.MockArchive, _ *mock_opsys.MockOutFile) {
+				gomock.InOrder(
+					dbMock.EXPECT().GetAttachmentPaths(nil),
+					dbMock.EXPECT().GetChats(nil, nil).Return([]chatdb.EntityChats{entity}, nil),
+					osMock.EXPECT().MkdirAll("messages-export", os.ModePerm).Return(errors.New("this is a permissions error")),
+				)
+			},
+			wantErr: `create directory "messages-export": this is a permissions error`,
+		},
+		{
+			msg: "create archive error",
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB, osMock *mock_opsys.MockOS, _ *mock_archive.MockArchive, _ *mock_opsys.MockOutFile) {
+				gomock.InOrder(
+					dbMock.EXPECT().GetAttachmentPaths(nil),
+					dbMock.EXPECT().GetChats(nil, nil).Return([]chatdb.EntityChats{entity}, nil),
+					osMock.EXPECT().MkdirAll("messages-export", os.ModePerm),
+				)
+			},
+			createErr: errors.New("this is a DB error"),
+			wantErr:   `create archive "messages-export/messages.sqlite": this is a DB error`,
+		},
+		{
+			msg: "flush error",
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB, osMock *mock_opsys.MockOS, archiveMock *mock_archive.MockArchive, ofMock *mock_opsys.MockOutFile) {
+				gomock.InOrder(
+					dbMock.EXPECT().GetAttachmentPaths(nil),
+					dbMock.EXPECT().GetChats(nil, nil).Return([]chatdb.EntityChats{entity}, nil),
+					osMock.EXPECT().MkdirAll("messages-export", os.ModePerm),
+					dbMock.EXPECT().GetMessageIDs(1, chatdb.DateRange{}),
+					dbMock.EXPECT().GetMessageIDs(2, chatdb.DateRange{}),
+					archiveMock.EXPECT().NewOutFile(entity, []string{"testguid", "testguid2"}).Return(ofMock),
+					ofMock.EXPECT().Stage(),
+					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
+					ofMock.EXPECT().Flush().Return(errors.New("this is a DB error")),
+					ofMock.EXPECT().Name().Return("messages-export/messages.sqlite"),
+					archiveMock.EXPECT().Close(),
+				)
+			},
+			wantErr: `flush chat file "messages-export/messages.sqlite" to disk: this is a DB error`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			dbMock := mock_chatdb.NewMockChatDB(ctrl)
+			osMock := mock_opsys.NewMockOS(ctrl)
+			archiveMock := mock_archive.NewMockArchive(ctrl)
+			ofMock := mock_opsys.NewMockOutFile(ctrl)
+			tt.setupMocks(dbMock, osMock, archiveMock, ofMock)
+
+			cfg := configuration{
+				Options: Options{
+					ExportPath: "messages-export",
+					Format:     "sqlite",
+				},
+				OS:     osMock,
+				ChatDB: dbMock,
+				createArchive: func(path, bagoupVersion string) (archive.Archive, error) {
+					assert.Equal(t, path, "messages-export/messages.sqlite")
+					assert.Equal(t, bagoupVersion, "v1.2.3")
+					if tt.createErr != nil {
+						return nil, tt.createErr
+					}
+					return archiveMock, nil
+				},
+				counts: counts{
+					attachments:         map[string]int{},
+					attachmentsEmbedded: map[string]int{},
+				},
+				version: "v1.2.3",
+			}
+			err := cfg.exportChats(nil, nil)
 			if tt.wantErr != "" {
 				assert.Error(t, err, tt.wantErr)
 				return
 			}
 			assert.NilError(t, err)
+			assert.Equal(t, cfg.counts.files, tt.wantFiles)
 		})
 	}
 }
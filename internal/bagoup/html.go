@@ -0,0 +1,78 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package bagoup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/tagatac/bagoup/v2/chatdb"
+	"github.com/tagatac/bagoup/v2/opsys"
+)
+
+const _htmlIndexFilename = "index.html"
+
+// indexedOutFile counts the messages written to an OutFile, and the dates of
+// the first and last, in an entry of the HTML index.
+type indexedOutFile struct {
+	opsys.OutFile
+	entry *opsys.HTMLIndexEntry
+}
+
+func (f indexedOutFile) WriteMessage(msg chatdb.Message) error {
+	if err := f.OutFile.WriteMessage(msg); err != nil {
+		return err
+	}
+	f.entry.Messages++
+	if f.entry.First.IsZero() || msg.Date.Before(f.entry.First) {
+		f.entry.First = msg.Date
+	}
+	if msg.Date.After(f.entry.Last) {
+		f.entry.Last = msg.Date
+	}
+	return nil
+}
+
+func (cfg *configuration) writeHTML(entityName string, handleMap map[int]string, messageIDs []chatdb.DatedMessageID, chatPathNoExt, attDir string) error {
+	chatPath := chatPathNoExt + ".html"
+	relPath, err := filepath.Rel(cfg.Options.ExportPath, chatPath)
+	if err != nil {
+		return fmt.Errorf("get path of %q relative to the export folder: %w", chatPath, err)
+	}
+	chatFile, err := cfg.OS.Create(chatPath)
+	if err != nil {
+		return fmt.Errorf("create file %q: %w", chatPath, err)
+	}
+	defer chatFile.Close()
+	entry := cfg.htmlIndexEntry(entityName)
+	entry.Files = append(entry.Files, filepath.ToSlash(relPath))
+	htmlOpts := opsys.HTMLOptions{
+		SelfContained:  cfg.Options.SelfContained,
+		MaxInlineBytes: int64(cfg.Options.MaxInlineMB) << 20,
+	}
+	outFile := indexedOutFile{OutFile: cfg.OS.NewHTMLFile(entityName, chatFile, htmlOpts), entry: entry}
+	return cfg.handleFileContents(outFile, handleMap, messageIDs, attDir, false)
+}
+
+// htmlIndexEntry returns the entry of the HTML index for the entity, which is
+// the last one if the entity has more than one chat file.
+func (cfg *configuration) htmlIndexEntry(entityName string) *opsys.HTMLIndexEntry {
+	if n := len(cfg.htmlIndex); n > 0 && cfg.htmlIndex[n-1].Entity == entityName {
+		return &cfg.htmlIndex[n-1]
+	}
+	cfg.htmlIndex = append(cfg.htmlIndex, opsys.HTMLIndexEntry{Entity: entityName})
+	return &cfg.htmlIndex[len(cfg.htmlIndex)-1]
+}
+
+func (cfg *configuration) writeHTMLIndex() error {
+	if err := cfg.OS.MkdirAll(cfg.Options.ExportPath, os.ModePerm); err != nil {
+		return fmt.Errorf("create directory %q: %w", cfg.Options.ExportPath, err)
+	}
+	indexPath := filepath.Join(cfg.Options.ExportPath, _htmlIndexFilename)
+	if err := cfg.OS.WriteHTMLIndex(indexPath, cfg.htmlIndex); err != nil {
+		return fmt.Errorf("write HTML index %q: %w", indexPath, err)
+	}
+	return nil
+}
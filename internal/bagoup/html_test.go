@@ -0,0 +1,175 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package bagoup
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/chatdb"
+	"github.com/tagatac/bagoup/v2/chatdb/mock_chatdb"
+	"github.com/tagatac/bagoup/v2/imgconv/mock_imgconv"
+	"github.com/tagatac/bagoup/v2/opsys"
+	"github.com/tagatac/bagoup/v2/opsys/mock_opsys"
+	"go.uber.org/mock/gomock"
+	"gotest.tools/v3/assert"
+)
+
+func TestWriteHTML(t *testing.T) {
+	chatFile, err := afero.NewMemMapFs().Create("testfile")
+	assert.NilError(t, err)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dbMock := mock_chatdb.NewMockChatDB(ctrl)
+	osMock := mock_opsys.NewMockOS(ctrl)
+	ofMocks := []*mock_opsys.MockOutFile{mock_opsys.NewMockOutFile(ctrl), mock_opsys.NewMockOutFile(ctrl)}
+	msgs := []chatdb.Message{
+		{ID: 1, Date: time.Date(2021, 5, 1, 9, 0, 0, 0, time.UTC), Text: "hi"},
+		{ID: 2, Date: time.Date(2020, 3, 1, 15, 34, 5, 0, time.UTC), Text: "older, from another chat"},
+		{ID: 3, Date: time.Date(2023, 6, 15, 21, 30, 0, 0, time.UTC), Text: "bye"},
+	}
+	gomock.InOrder(
+		osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
+		osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com.html").Return(chatFile, nil),
+		osMock.EXPECT().NewHTMLFile("friend", chatFile, opsys.HTMLOptions{}).Return(ofMocks[0]),
+		dbMock.EXPECT().GetMessage(1, nil).Return(msgs[0], nil),
+		ofMocks[0].EXPECT().WriteMessage(msgs[0]),
+		dbMock.EXPECT().GetMessage(3, nil).Return(msgs[2], nil),
+		ofMocks[0].EXPECT().WriteMessage(msgs[2]),
+		ofMocks[0].EXPECT().Stage(),
+		osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
+		ofMocks[0].EXPECT().Flush(),
+		osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
+		osMock.EXPECT().Create("messages-export/friend/SMS;-;+15551234567.html").Return(chatFile, nil),
+		osMock.EXPECT().NewHTMLFile("friend", chatFile, opsys.HTMLOptions{}).Return(ofMocks[1]),
+		dbMock.EXPECT().GetMessage(2, nil).Return(msgs[1], nil),
+		ofMocks[1].EXPECT().WriteMessage(msgs[1]).Return(errors.New("this is an outfile error")),
+		ofMocks[1].EXPECT().Name().Return("messages-export/friend/SMS;-;+15551234567.html"),
+	)
+
+	cfg := configuration{
+		Options: Options{ExportPath: "messages-export", Format: "html"},
+		OS:      osMock,
+		ChatDB:  dbMock,
+	}
+	assert.NilError(t, cfg.writeFile(chatdb.EntityChats{Name: "friend"}, []string{"iMessage;-;friend@gmail.com"}, []chatdb.DatedMessageID{{ID: 1, Date: 2}, {ID: 3, Date: 3}}))
+	err = cfg.writeFile(chatdb.EntityChats{Name: "friend"}, []string{"SMS;-;+15551234567"}, []chatdb.DatedMessageID{{ID: 2, Date: 1}})
+	assert.ErrorContains(t, err, "this is an outfile error")
+
+	// A message which could not be written is not counted.
+	assert.DeepEqual(t, cfg.htmlIndex, []opsys.HTMLIndexEntry{{
+		Entity:   "friend",
+		Files:    []string{"friend/iMessage;-;friend@gmail.com.html", "friend/SMS;-;+15551234567.html"},
+		Messages: 2,
+		First:    msgs[0].Date,
+		Last:     msgs[2].Date,
+	}})
+}
+
+func TestWriteSelfContainedHTML(t *testing.T) {
+	chatFile, err := afero.NewMemMapFs().Create("testfile")
+	assert.NilError(t, err)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dbMock := mock_chatdb.NewMockChatDB(ctrl)
+	osMock := mock_opsys.NewMockOS(ctrl)
+	icMock := mock_imgconv.NewMockImgConverter(ctrl)
+	ofMock := mock_opsys.NewMockOutFile(ctrl)
+	msg := chatdb.Message{ID: 1, Text: "\uFFFC"}
+	gomock.InOrder(
+		osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
+		osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com.html").Return(chatFile, nil),
+		osMock.EXPECT().NewHTMLFile("friend", chatFile, opsys.HTMLOptions{SelfContained: true, MaxInlineBytes: 25 << 20}).Return(ofMock),
+		dbMock.EXPECT().GetMessage(1, nil).Return(msg, nil),
+		ofMock.EXPECT().WriteMessage(msg),
+		osMock.EXPECT().FileExist("/attachments/IMG_0001.heic").Return(true, nil),
+		icMock.EXPECT().ConvertHEIC("/attachments/IMG_0001.heic").Return("tmp/IMG_0001.jpeg", nil),
+		ofMock.EXPECT().WriteAttachment(chatdb.Attachment{Filename: "attachments/IMG_0001.heic", Filepath: "tmp/IMG_0001.jpeg", MIMEType: "image/jpeg"}).Return(true, nil),
+		ofMock.EXPECT().Stage(),
+		osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
+		ofMock.EXPECT().Flush(),
+	)
+
+	cfg := configuration{
+		Options: Options{
+			ExportPath:      "messages-export",
+			Format:          "html",
+			SelfContained:   true,
+			MaxInlineMB:     25,
+			AttachmentsPath: "/",
+		},
+		OS:           osMock,
+		ChatDB:       dbMock,
+		ImgConverter: icMock,
+		attachmentPaths: map[int][]chatdb.Attachment{
+			1: {{Filename: "attachments/IMG_0001.heic", MIMEType: "image/heic"}},
+		},
+		counts: counts{
+			attachments:         map[string]int{},
+			attachmentsEmbedded: map[string]int{},
+		},
+	}
+	assert.NilError(t, cfg.writeFile(chatdb.EntityChats{Name: "friend"}, []string{"iMessage;-;friend@gmail.com"}, []chatdb.DatedMessageID{{ID: 1}}))
+	assert.Equal(t, cfg.counts.conversions, 1)
+	assert.Equal(t, cfg.counts.attachmentsEmbedded["image/jpeg"], 1)
+}
+
+func TestWriteHTMLIndex(t *testing.T) {
+	entries := []opsys.HTMLIndexEntry{{Entity: "friend", Files: []string{"friend/iMessage;-;friend@gmail.com.html"}, Messages: 1}}
+	tests := []struct {
+		msg        string
+		setupMocks func(*mock_opsys.MockOS)
+		wantErr    string
+	}{
+		{
+			msg: "success",
+			setupMocks: func(osMock *mock_opsys.MockOS) {
+				gomock.InOrder(
+					osMock.EXPECT().MkdirAll("messages-export", os.ModePerm),
+					osMock.EXPECT().WriteHTMLIndex("messages-export/index.html", entries),
+				)
+			},
+		},
+		{
+			msg: "MkdirAll error",
+			setupMocks: func(osMock *mock_opsys.MockOS) {
+				osMock.EXPECT().MkdirAll("messages-export", os.ModePerm).Return(errors.New("this is a permissions error"))
+			},
+			wantErr: `create directory "messages-export": this is a permissions error`,
+		},
+		{
+			msg: "WriteHTMLIndex error",
+			setupMocks: func(osMock *mock_opsys.MockOS) {
+				gomock.InOrder(
+					osMock.EXPECT().MkdirAll("messages-export", os.ModePerm),
+					osMock.EXPECT().WriteHTMLIndex("messages-export/index.html", entries).Return(errors.New("this is a template error")),
+				)
+			},
+			wantErr: `write HTML index "messages-export/index.html": this is a template error`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			osMock := mock_opsys.NewMockOS(ctrl)
+			tt.setupMocks(osMock)
+			cfg := configuration{
+				Options:   Options{ExportPath: "messages-export", Format: "html"},
+				OS:        osMock,
+				htmlIndex: entries,
+			}
+			err := cfg.writeHTMLIndex()
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+		})
+	}
+}
@@ -1,25 +1,67 @@
 package bagoup
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/tagatac/bagoup/v2/chatdb"
+	"github.com/tagatac/bagoup/v2/opsys"
+	"github.com/tagatac/bagoup/v2/phonenum"
+)
 
 type // Options are the commandline options that can be passed to the bagoup
 // command.
 Options struct {
-	DBPath          string   `short:"i" long:"db-path" description:"Path to the Messages chat database file" default:"~/Library/Messages/chat.db"`
-	ExportPath      string   `short:"o" long:"export-path" description:"Path to which the Messages will be exported" default:"messages-export"`
-	MacOSVersion    *string  `short:"m" long:"mac-os-version" description:"Version of macOS, e.g. '10.15', from which the Messages chat database file was copied (not needed if bagoup is running on the same Mac)"`
-	ContactsPath    *string  `short:"c" long:"contacts-path" description:"Path to the contacts vCard file"`
-	SelfHandle      string   `short:"s" long:"self-handle" description:"Prefix to use for for messages sent by you" default:"Me"`
-	Timezone        string   `long:"timezone" description:"Timezone for message timestamps, e.g. \"America/New_York\" or \"UTC\"" default:"Local"`
-	SeparateChats   bool     `long:"separate-chats" description:"Do not merge chats with the same contact (e.g. iMessage and SMS) into a single file"`
-	OutputPDF       bool     `short:"p" long:"pdf" description:"Export text and images to PDF files (requires full disk access)"`
-	UseWkhtmltopdf  bool     `short:"w" long:"wkhtml" description:"Use wkhtmltopdf instead of weasyprint to generate PDFs (requires wkhtmltopdf executable to be on the system path - https://wkhtmltopdf.org/)"`
-	IncludePPA      bool     `long:"include-ppa" description:"Include plugin payload attachments (e.g. link previews) in generated PDFs"`
-	CopyAttachments bool     `short:"a" long:"copy-attachments" description:"Copy attachments to the same folder as the chat which included them (requires full disk access)"`
-	PreservePaths   bool     `short:"r" long:"preserve-paths" description:"When copying attachments, preserve the full path instead of co-locating them with the chats which included them"`
-	AttachmentsPath string   `short:"t" long:"attachments-path" description:"Root path to the attachments (useful for re-running bagoup on an export created with the --copy-attachments and --preserve-paths flags)" default:"/"`
-	Entities        []string `short:"e" long:"entity" description:"An entity name to include in the export (matches the folder name in the export, e.g. \"John Smith\" or \"+15551234567\"). If given, other entities' chats will not be exported. If this flag is used multiple times, all entities specified will be exported."`
-	PrintVersion    bool     `short:"v" long:"version" description:"Show the version of bagoup"`
+	DBPath          string            `short:"i" long:"db-path" description:"Path to the Messages chat database file" default:"~/Library/Messages/chat.db"`
+	ExportPath      string            `short:"o" long:"export-path" description:"Path to which the Messages will be exported, or - to stream them to stdout (requires --format jsonl)" default:"messages-export"`
+	MacOSVersion    *string           `short:"m" long:"mac-os-version" description:"Version of macOS, e.g. '10.15', from which the Messages chat database file was copied (not needed if bagoup is running on the same Mac)"`
+	ContactsPaths   []string          `short:"c" long:"contacts-path" description:"Path to a contacts vCard file, or a CSV file exported from Google Contacts or Outlook. Can be used multiple times to merge several files, in order of precedence."`
+	CSVColumns      map[string]string `long:"csv-column" description:"Map a contact field to the columns of a CSV contacts file matching a name pattern, in which * matches any text, e.g. \"phone=Mobile*\" (fields: formatted-name, given-name, middle-name, family-name, prefix, suffix, nickname, organization, phone, email). Can be used multiple times for a custom CSV layout." key-value-delimiter:"="`
+	AddressBookPath *string           `long:"address-book" description:"Read contacts from the macOS AddressBook instead of a vCard file, optionally from a copied AddressBook directory (requires full disk access)" optional:"yes" optional-value:"~/Library/Application Support/AddressBook"`
+	CollisionPolicy string            `long:"collision-policy" description:"How to resolve a phone number or email address shared by multiple contacts: combine (e.g. \"Alice and Bob\"), first, last, most-fields (the contact with the most fields), or fail. Collisions are listed in .bagoup/contact-collisions.txt in the export folder." default:"combine"`
+	DownloadPhotos  bool              `long:"download-photos" description:"Download contact photos linked by http(s) URL in the contacts files, to show them with the --pdf flag or --format epub"`
+	AliasesPath     *string           `long:"aliases" description:"Path to a YAML or TOML file mapping handles (phone numbers or email addresses) and chat GUIDs to display names and entity folders, overriding contacts"`
+	DefaultRegion   string            `long:"default-region" description:"Two-letter country code, e.g. \"US\", used to interpret phone numbers without a country code when matching contacts to handles"`
+	SenderName      string            `long:"sender-name" description:"How to name the senders of messages after their contacts: given (first name), full, nickname, given-initial (first name and last initial), organization, or handle (phone number or email address). Contacts without the chosen name fall back to their first name, nickname, full name, or organization. Group chat participants who share a name are told apart by last initial or full name." default:"given"`
+	SelfHandle      string            `short:"s" long:"self-handle" description:"Prefix to use for for messages sent by you" default:"Me"`
+	Timezone        string            `long:"timezone" description:"Timezone for message timestamps, e.g. \"America/New_York\" or \"UTC\"" default:"Local"`
+	Since           *string           `long:"since" description:"Only export messages sent since this date, e.g. \"2023\", \"2023-06-15\", or \"2023-06-15T09:30\", or this long ago, e.g. \"90d\" (units: h, d, w, m, y)"`
+	Until           *string           `long:"until" description:"Only export messages sent until the end of this date, e.g. \"2023\" or \"2023-06-15\", or until this long ago, e.g. \"1y\". Chats without messages in the date range are skipped."`
+	MinMessages     int               `long:"min-messages" description:"Only export entities with at least this many messages (in the date range, if given)"`
+	MaxMessages     int               `long:"max-messages" description:"Only export entities with at most this many messages (in the date range, if given)"`
+	ActiveSince     *string           `long:"active-since" description:"Only export entities with a message since this date or this long ago, in the same formats as --since, e.g. \"2023\" or \"6m\""`
+	HasAttachments  bool              `long:"has-attachments" description:"Only export entities with at least one attachment"`
+	ExcludeAuto     bool              `long:"exclude-automated" description:"Leave out chats with automated senders: short codes (5 or 6 digit numbers), no-reply email addresses, and senders of mostly verification codes, delivery notices, or marketing"`
+	OnlyAuto        bool              `long:"only-automated" description:"Only export chats with automated senders (see --exclude-automated)"`
+	AutoFolder      bool              `long:"automated-folder" description:"Export the chats with automated senders into a single Automated folder, one file per chat, instead of a folder per sender"`
+	Grep            []string          `long:"grep" description:"Only export messages containing this text, ignoring case, and the messages around them (see --context). Can be used multiple times to match any of several terms."`
+	Regex           []string          `long:"regex" description:"Only export messages matching this regular expression, e.g. \"(?i)\\bproject (x|y)\\b\", like --grep"`
+	Context         int               `long:"context" description:"Number of messages to include before and after each message matching --grep or --regex"`
+	SeparateChats   bool              `long:"separate-chats" description:"Do not merge chats with the same contact (e.g. iMessage and SMS) into a single file"`
+	Format          string            `long:"format" description:"Format of the exported chat files: txt, json (one document per chat, with the details of each message and attachment), jsonl (JSON Lines, one message per line), csv, html (web pages of chat bubbles, with an index.html listing the entities), md (Markdown notes with YAML front matter, for an Obsidian vault), epub (an e-book per chat, with a chapter per month and images embedded), mbox (an email per message, with attachments, for importing into an email client), or sqlite (a messages.sqlite database of all chats with a full-text search index, which can be exported again with the render command). The epub and mbox formats require full disk access. With jsonl, use \"--export-path -\" to stream the messages of all chats to stdout." default:"txt"`
+	CombinedCSV     bool              `long:"combined-csv" description:"With --format csv, write the messages of all chats to a single messages.csv file in the export folder"`
+	SelfContained   bool              `long:"self-contained" description:"With --format html, write each chat to a single file which can be opened anywhere, with images, video, and audio inlined and HEIC images converted to JPEG (requires full disk access)"`
+	MaxInlineMB     int               `long:"max-inline-mb" description:"With --self-contained, leave out attachments larger than this many megabytes"`
+	DailyNotes      bool              `long:"daily-notes" description:"With --format md, write the messages to a note per day in a Daily folder of the export, linking to the chats' notes"`
+	OutputPDF       bool              `short:"p" long:"pdf" description:"Export text and images to PDF files (requires full disk access)"`
+	UseWkhtmltopdf  bool              `short:"w" long:"wkhtml" description:"Use wkhtmltopdf instead of weasyprint to generate PDFs (requires wkhtmltopdf executable to be on the system path - https://wkhtmltopdf.org/)"`
+	IncludePPA      bool              `long:"include-ppa" description:"Include plugin payload attachments (e.g. link previews) in generated PDFs"`
+	CopyAttachments bool              `short:"a" long:"copy-attachments" description:"Copy attachments to the same folder as the chat which included them (requires full disk access)"`
+	PreservePaths   bool              `short:"r" long:"preserve-paths" description:"When copying attachments, preserve the full path instead of co-locating them with the chats which included them"`
+	AttachmentsPath string            `short:"t" long:"attachments-path" description:"Root path to the attachments (useful for re-running bagoup on an export created with the --copy-attachments and --preserve-paths flags)" default:"/"`
+	Entities        []string          `short:"e" long:"entity" description:"An entity to include in the export, by folder name (e.g. \"John Smith\"), phone number or email address, or chat GUID, ignoring case. Use * and ? as wildcards, or wrap a regular expression in slashes, e.g. \"/^acme/\". If given, other entities' chats will not be exported. If this flag is used multiple times, all entities specified will be exported."`
+	ExcludeEntities []string          `long:"exclude-entity" description:"An entity to leave out of the export, matched like --entity. Can be used multiple times."`
+	PrintVersion    bool              `short:"v" long:"version" description:"Show the version of bagoup"`
+	Render          RenderOptions     `command:"render" description:"Export the chats of a --format sqlite archive instead of chat.db"`
+}
+
+// RenderOptions are the arguments of the render command.
+type RenderOptions struct {
+	Args struct {
+		ArchivePath string `positional-arg-name:"archive" description:"Path to the messages.sqlite archive"`
+	} `positional-args:"yes" required:"yes"`
 }
 
 func ValidateOptions(opts Options) error {
@@ -29,12 +71,103 @@ func ValidateOptions(opts Options) error {
 	if opts.IncludePPA && !opts.OutputPDF {
 		return errors.New("the --include-ppa flag requires the --pdf flag")
 	}
+	if opts.Format != "" && !slices.Contains(opsys.OutputFormats, opts.Format) {
+		return fmt.Errorf("unsupported format %q for the --format flag - valid formats: %s", opts.Format, strings.Join(opsys.OutputFormats, ", "))
+	}
+	if opts.OutputPDF && opts.Format != "" && opts.Format != opsys.FormatTxt {
+		return fmt.Errorf("the --pdf flag is incompatible with the --format flag value %q", opts.Format)
+	}
+	if opts.CombinedCSV && opts.Format != opsys.FormatCSV {
+		return errors.New("the --combined-csv flag requires the --format csv flag")
+	}
+	if opts.DailyNotes && opts.Format != opsys.FormatMD {
+		return errors.New("the --daily-notes flag requires the --format md flag")
+	}
+	if opts.SelfContained && opts.Format != opsys.FormatHTML {
+		return errors.New("the --self-contained flag requires the --format html flag")
+	}
+	if opts.SelfContained && opts.CopyAttachments {
+		return errors.New("the --self-contained and --copy-attachments flags are mutually exclusive")
+	}
+	if opts.MaxInlineMB < 0 {
+		return fmt.Errorf("invalid value %d for the --max-inline-mb flag - FIX: use a number of megabytes, or 0 for no limit", opts.MaxInlineMB)
+	}
+	if opts.MaxInlineMB > 0 && !opts.SelfContained {
+		return errors.New("the --max-inline-mb flag requires the --self-contained flag")
+	}
+	if opts.ExportPath == StdoutExportPath && opts.Format != opsys.FormatJSONL {
+		return errors.New("streaming to stdout (--export-path -) requires the --format jsonl flag")
+	}
+	if opts.ExportPath == StdoutExportPath && opts.CopyAttachments {
+		return errors.New("the --copy-attachments flag requires an export folder - FIX: specify a folder with the --export-path option")
+	}
 	if opts.PreservePaths && !opts.CopyAttachments {
 		return errors.New("the --preserve-paths flag requires the --copy-attachments flag")
 	}
-	usingAttachments := opts.CopyAttachments || opts.OutputPDF
+	if len(opts.ContactsPaths) > 0 && opts.AddressBookPath != nil {
+		return errors.New("the --contacts-path and --address-book flags are mutually exclusive")
+	}
+	if opts.DownloadPhotos && !opts.showingAvatars() {
+		return errors.New("the --download-photos flag requires the --pdf flag or the --format epub flag")
+	}
+	if len(opts.CSVColumns) > 0 && len(opts.ContactsPaths) == 0 {
+		return errors.New("the --csv-column flag requires the --contacts-path flag")
+	}
+	if opts.CollisionPolicy != "" && !slices.Contains(opsys.ContactCollisionPolicies, opts.CollisionPolicy) {
+		return fmt.Errorf("unsupported policy %q for the --collision-policy flag - valid policies: %s", opts.CollisionPolicy, strings.Join(opsys.ContactCollisionPolicies, ", "))
+	}
+	if opts.SenderName != "" && !slices.Contains(chatdb.SenderNameStyles, opts.SenderName) {
+		return fmt.Errorf("unsupported style %q for the --sender-name flag - valid styles: %s", opts.SenderName, strings.Join(chatdb.SenderNameStyles, ", "))
+	}
+	if opts.MinMessages < 0 || opts.MaxMessages < 0 {
+		return errors.New("the --min-messages and --max-messages flags must not be negative")
+	}
+	if opts.MaxMessages > 0 && opts.MinMessages > opts.MaxMessages {
+		return fmt.Errorf("the --min-messages value %d is greater than the --max-messages value %d", opts.MinMessages, opts.MaxMessages)
+	}
+	if opts.ExcludeAuto && (opts.OnlyAuto || opts.AutoFolder) {
+		return errors.New("the --exclude-automated flag is incompatible with the --only-automated and --automated-folder flags")
+	}
+	if opts.Context < 0 {
+		return fmt.Errorf("invalid value %d for the --context flag - FIX: use a number of messages, 0 or more", opts.Context)
+	}
+	if opts.Context > 0 && len(opts.Grep) == 0 && len(opts.Regex) == 0 {
+		return errors.New("the --context flag requires the --grep or --regex flag")
+	}
+	if !phonenum.ValidRegion(opts.DefaultRegion) {
+		return fmt.Errorf("unsupported region %q for the --default-region flag", opts.DefaultRegion)
+	}
+	if opts.rendering() && (len(opts.ContactsPaths) > 0 || opts.AddressBookPath != nil || opts.AliasesPath != nil) {
+		return errors.New("the render command uses the names saved in the archive, so it is incompatible with the --contacts-path, --address-book, and --aliases flags")
+	}
+	if opts.rendering() && opts.MacOSVersion != nil {
+		return errors.New("the render command is incompatible with the --mac-os-version flag")
+	}
+	if opts.rendering() && opts.Format == opsys.FormatSQLite {
+		return errors.New("the render command cannot write an archive - FIX: copy the archive instead")
+	}
+	usingAttachments := opts.CopyAttachments || opts.embeddingAttachments()
 	if opts.AttachmentsPath != "/" && !usingAttachments {
-		return errors.New("the --attachments-path flag requires a flag that uses those attachments: --copy-attachments or --pdf")
+		return errors.New("the --attachments-path flag requires a flag that uses those attachments: --copy-attachments, --pdf, --self-contained, or --format epub or mbox")
 	}
 	return nil
 }
+
+// embeddingAttachments reports whether attachments are embedded in the
+// exported files, so that HEIC images must be converted and sketches
+// rendered.
+func (opts Options) embeddingAttachments() bool {
+	return opts.OutputPDF || opts.SelfContained || opts.Format == opsys.FormatEPUB || opts.Format == opsys.FormatMBox
+}
+
+// showingAvatars reports whether contact photos are shown in the exported
+// files, so that they must be read from the contacts.
+func (opts Options) showingAvatars() bool {
+	return opts.OutputPDF || opts.Format == opsys.FormatEPUB
+}
+
+// rendering reports whether the chats are read from a bagoup archive, with
+// the render command.
+func (opts Options) rendering() bool {
+	return opts.Render.Args.ArchivePath != ""
+}
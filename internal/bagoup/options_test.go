@@ -8,6 +8,8 @@ import (
 )
 
 func TestValidateOptions(t *testing.T) {
+	addressBookPath := "AddressBook"
+	macOSVersion := "10.15"
 	tests := []struct {
 		msg     string
 		opts    bagoup.Options
@@ -54,7 +56,216 @@ func TestValidateOptions(t *testing.T) {
 				OutputPDF:       false,
 				AttachmentsPath: "testpath",
 			},
-			wantErr: "the --attachments-path flag requires a flag that uses those attachments: --copy-attachments or --pdf",
+			wantErr: "the --attachments-path flag requires a flag that uses those attachments: --copy-attachments, --pdf, --self-contained, or --format epub or mbox",
+		},
+		{
+			msg: "vCard file and AddressBook",
+			opts: bagoup.Options{
+				ContactsPaths:   []string{"contacts.vcf"},
+				AddressBookPath: &addressBookPath,
+				AttachmentsPath: "/",
+			},
+			wantErr: "the --contacts-path and --address-book flags are mutually exclusive",
+		},
+		{
+			msg: "CSV columns without a contacts file",
+			opts: bagoup.Options{
+				CSVColumns:      map[string]string{"phone": "Mobile*"},
+				AttachmentsPath: "/",
+			},
+			wantErr: "the --csv-column flag requires the --contacts-path flag",
+		},
+		{
+			msg: "unsupported format",
+			opts: bagoup.Options{
+				Format:          "xml",
+				AttachmentsPath: "/",
+			},
+			wantErr: `unsupported format "xml" for the --format flag - valid formats: txt, json, jsonl, csv, html, md, epub, mbox, sqlite`,
+		},
+		{
+			msg: "daily notes without the Markdown format",
+			opts: bagoup.Options{
+				DailyNotes:      true,
+				AttachmentsPath: "/",
+			},
+			wantErr: "the --daily-notes flag requires the --format md flag",
+		},
+		{
+			msg: "self-contained without the HTML format",
+			opts: bagoup.Options{
+				SelfContained:   true,
+				AttachmentsPath: "/",
+			},
+			wantErr: "the --self-contained flag requires the --format html flag",
+		},
+		{
+			msg: "self-contained with copied attachments",
+			opts: bagoup.Options{
+				Format:          "html",
+				SelfContained:   true,
+				CopyAttachments: true,
+				AttachmentsPath: "/",
+			},
+			wantErr: "the --self-contained and --copy-attachments flags are mutually exclusive",
+		},
+		{
+			msg: "negative inline size",
+			opts: bagoup.Options{
+				Format:          "html",
+				SelfContained:   true,
+				MaxInlineMB:     -1,
+				AttachmentsPath: "/",
+			},
+			wantErr: "invalid value -1 for the --max-inline-mb flag - FIX: use a number of megabytes, or 0 for no limit",
+		},
+		{
+			msg: "inline size without self-contained",
+			opts: bagoup.Options{
+				Format:          "html",
+				MaxInlineMB:     25,
+				AttachmentsPath: "/",
+			},
+			wantErr: "the --max-inline-mb flag requires the --self-contained flag",
+		},
+		{
+			msg: "pdf with another format",
+			opts: bagoup.Options{
+				Format:          "json",
+				OutputPDF:       true,
+				AttachmentsPath: "/",
+			},
+			wantErr: `the --pdf flag is incompatible with the --format flag value "json"`,
+		},
+		{
+			msg: "combined CSV without the CSV format",
+			opts: bagoup.Options{
+				CombinedCSV:     true,
+				AttachmentsPath: "/",
+			},
+			wantErr: "the --combined-csv flag requires the --format csv flag",
+		},
+		{
+			msg: "stdout without jsonl",
+			opts: bagoup.Options{
+				ExportPath:      "-",
+				Format:          "json",
+				AttachmentsPath: "/",
+			},
+			wantErr: "streaming to stdout (--export-path -) requires the --format jsonl flag",
+		},
+		{
+			msg: "stdout with copied attachments",
+			opts: bagoup.Options{
+				ExportPath:      "-",
+				Format:          "jsonl",
+				CopyAttachments: true,
+				AttachmentsPath: "/",
+			},
+			wantErr: "the --copy-attachments flag requires an export folder - FIX: specify a folder with the --export-path option",
+		},
+		{
+			msg: "fetch contact photos without showing them",
+			opts: bagoup.Options{
+				ContactsPaths:   []string{"contacts.vcf"},
+				DownloadPhotos:  true,
+				AttachmentsPath: "/",
+			},
+			wantErr: "the --download-photos flag requires the --pdf flag or the --format epub flag",
+		},
+		{
+			msg: "unsupported contact collisions policy",
+			opts: bagoup.Options{
+				CollisionPolicy: "merge",
+				AttachmentsPath: "/",
+			},
+			wantErr: `unsupported policy "merge" for the --collision-policy flag - valid policies: combine, first, last, most-fields, fail`,
+		},
+		{
+			msg: "unsupported sender name style",
+			opts: bagoup.Options{
+				SenderName:      "first",
+				AttachmentsPath: "/",
+			},
+			wantErr: `unsupported style "first" for the --sender-name flag - valid styles: given, full, nickname, given-initial, organization, handle`,
+		},
+		{
+			msg: "negative message threshold",
+			opts: bagoup.Options{
+				MinMessages:     -1,
+				AttachmentsPath: "/",
+			},
+			wantErr: "the --min-messages and --max-messages flags must not be negative",
+		},
+		{
+			msg: "minimum messages above maximum",
+			opts: bagoup.Options{
+				MinMessages:     10,
+				MaxMessages:     5,
+				AttachmentsPath: "/",
+			},
+			wantErr: "the --min-messages value 10 is greater than the --max-messages value 5",
+		},
+		{
+			msg: "exclude and only automated",
+			opts: bagoup.Options{
+				ExcludeAuto:     true,
+				OnlyAuto:        true,
+				AttachmentsPath: "/",
+			},
+			wantErr: "the --exclude-automated flag is incompatible with the --only-automated and --automated-folder flags",
+		},
+		{
+			msg: "context without a search",
+			opts: bagoup.Options{
+				Context:         2,
+				AttachmentsPath: "/",
+			},
+			wantErr: "the --context flag requires the --grep or --regex flag",
+		},
+		{
+			msg: "negative context",
+			opts: bagoup.Options{
+				Grep:            []string{"lunch"},
+				Context:         -1,
+				AttachmentsPath: "/",
+			},
+			wantErr: "invalid value -1 for the --context flag - FIX: use a number of messages, 0 or more",
+		},
+		{
+			msg: "render with contacts",
+			opts: bagoup.Options{
+				ContactsPaths:   []string{"contacts.vcf"},
+				Render:          renderOptions("messages.sqlite"),
+				AttachmentsPath: "/",
+			},
+			wantErr: "the render command uses the names saved in the archive, so it is incompatible with the --contacts-path, --address-book, and --aliases flags",
+		},
+		{
+			msg: "render with a macOS version",
+			opts: bagoup.Options{
+				MacOSVersion:    &macOSVersion,
+				Render:          renderOptions("messages.sqlite"),
+				AttachmentsPath: "/",
+			},
+			wantErr: "the render command is incompatible with the --mac-os-version flag",
+		},
+		{
+			msg: "render to an archive",
+			opts: bagoup.Options{
+				Format:          "sqlite",
+				Render:          renderOptions("messages.sqlite"),
+				AttachmentsPath: "/",
+			},
+			wantErr: "the render command cannot write an archive - FIX: copy the archive instead",
+		},
+		{
+			msg: "unsupported default region",
+			opts: bagoup.Options{
+				DefaultRegion:   "XX",
+				AttachmentsPath: "/",
+			},
+			wantErr: `unsupported region "XX" for the --default-region flag`,
 		},
 	}
 
@@ -69,3 +280,9 @@ func TestValidateOptions(t *testing.T) {
 		})
 	}
 }
+
+func renderOptions(archivePath string) bagoup.RenderOptions {
+	var ro bagoup.RenderOptions
+	ro.Args.ArchivePath = archivePath
+	return ro
+}
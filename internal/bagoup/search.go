@@ -0,0 +1,76 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package bagoup
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/tagatac/bagoup/v2/chatdb"
+)
+
+// newSearch compiles the --grep terms, which match case-insensitively, and the
+// --regex patterns into a single regular expression matching any of them. It
+// returns nil if there are no terms or patterns.
+func newSearch(terms, patterns []string) (*regexp.Regexp, error) {
+	alternatives := make([]string, 0, len(terms)+len(patterns))
+	for _, term := range terms {
+		alternatives = append(alternatives, "(?i:"+regexp.QuoteMeta(term)+")")
+	}
+	for _, pattern := range patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("compile --regex %q: %w", pattern, err)
+		}
+		alternatives = append(alternatives, "(?:"+pattern+")")
+	}
+	if len(alternatives) == 0 {
+		return nil, nil
+	}
+	return regexp.Compile(strings.Join(alternatives, "|"))
+}
+
+// searchMessages returns the messages matching the search, each with up to
+// --context messages before and after it. The first message of each run which
+// does not follow on from the previous run is marked to be preceded by a
+// separator. The returned messages are kept in cfg.searchedMessages for
+// writing.
+func (cfg *configuration) searchMessages(handleMap map[int]string, messageIDs []chatdb.DatedMessageID) ([]chatdb.DatedMessageID, error) {
+	var matches []int
+	msgs := make([]chatdb.Message, len(messageIDs))
+	for i, messageID := range messageIDs {
+		msg, err := cfg.ChatDB.GetMessage(messageID.ID, handleMap)
+		if err != nil {
+			return nil, fmt.Errorf("get message with ID %d: %w", messageID.ID, err)
+		}
+		msgs[i] = msg
+		if cfg.search.MatchString(msg.Text) || cfg.search.MatchString(msg.AudioTranscription) {
+			matches = append(matches, i)
+		}
+	}
+	cfg.counts.searchMatches += len(matches)
+
+	var result []chatdb.DatedMessageID
+	cfg.searchedMessages = map[int]chatdb.Message{}
+	next := 0
+	for _, i := range matches {
+		from := max(i-cfg.Options.Context, next)
+		to := min(i+cfg.Options.Context+1, len(messageIDs))
+		if from >= to {
+			continue
+		}
+		if len(result) > 0 && from > next {
+			if cfg.searchBreaks == nil {
+				cfg.searchBreaks = map[int]bool{}
+			}
+			cfg.searchBreaks[messageIDs[from].ID] = true
+		}
+		result = append(result, messageIDs[from:to]...)
+		for _, msg := range msgs[from:to] {
+			cfg.searchedMessages[msg.ID] = msg
+		}
+		next = to
+	}
+	return result, nil
+}
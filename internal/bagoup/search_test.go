@@ -0,0 +1,170 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package bagoup
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/tagatac/bagoup/v2/chatdb"
+	"github.com/tagatac/bagoup/v2/chatdb/mock_chatdb"
+	"go.uber.org/mock/gomock"
+	"gotest.tools/v3/assert"
+)
+
+func TestNewSearch(t *testing.T) {
+	tests := []struct {
+		msg         string
+		terms       []string
+		patterns    []string
+		wantMatches []string
+		wantMisses  []string
+		wantErr     string
+	}{
+		{
+			msg: "no search",
+		},
+		{
+			msg:         "terms ignore case and regexp syntax",
+			terms:       []string{"Project X", "a+b"},
+			wantMatches: []string{"how is project x going?", "A+B=C"},
+			wantMisses:  []string{"project y", "aab"},
+		},
+		{
+			msg:         "patterns are case-sensitive",
+			patterns:    []string{`\bX\d+\b`},
+			wantMatches: []string{"flight X42 is late"},
+			wantMisses:  []string{"flight x42 is late", "X42B"},
+		},
+		{
+			msg:         "terms and patterns",
+			terms:       []string{"dinner"},
+			patterns:    []string{"^Lunch"},
+			wantMatches: []string{"DINNER?", "Lunch at noon"},
+			wantMisses:  []string{"lunch at noon"},
+		},
+		{
+			msg:      "invalid pattern",
+			patterns: []string{"(lunch"},
+			wantErr:  "compile --regex \"(lunch\": error parsing regexp: missing closing ): `(lunch`",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			search, err := newSearch(tt.terms, tt.patterns)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			if len(tt.terms) == 0 && len(tt.patterns) == 0 {
+				assert.Assert(t, search == nil)
+				return
+			}
+			for _, s := range tt.wantMatches {
+				assert.Assert(t, search.MatchString(s), s)
+			}
+			for _, s := range tt.wantMisses {
+				assert.Assert(t, !search.MatchString(s), s)
+			}
+		})
+	}
+}
+
+func TestSearchMessages(t *testing.T) {
+	messageIDs := []chatdb.DatedMessageID{}
+	for id := 1; id <= 10; id++ {
+		messageIDs = append(messageIDs, chatdb.DatedMessageID{ID: id, Date: id})
+	}
+	texts := map[int]string{2: "meet at the Cafe", 3: "which cafe?", 8: "cafe was great"}
+
+	tests := []struct {
+		msg           string
+		context       int
+		transcription bool
+		dbErr         bool
+		wantIDs       []int
+		wantBreaks    map[int]bool
+		wantMatches   int
+		wantErr       string
+	}{
+		{
+			msg:         "no context",
+			wantIDs:     []int{2, 3, 8},
+			wantBreaks:  map[int]bool{8: true},
+			wantMatches: 3,
+		},
+		{
+			msg:         "overlapping context",
+			context:     1,
+			wantIDs:     []int{1, 2, 3, 4, 7, 8, 9},
+			wantBreaks:  map[int]bool{7: true},
+			wantMatches: 3,
+		},
+		{
+			msg:         "context joining runs",
+			context:     2,
+			wantIDs:     []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+			wantMatches: 3,
+		},
+		{
+			msg:           "audio transcription",
+			transcription: true,
+			wantIDs:       []int{2, 3, 8, 10},
+			wantBreaks:    map[int]bool{8: true, 10: true},
+			wantMatches:   4,
+		},
+		{
+			msg:     "DB error",
+			dbErr:   true,
+			wantErr: "get message with ID 1: this is a DB error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			dbMock := mock_chatdb.NewMockChatDB(ctrl)
+			if tt.dbErr {
+				dbMock.EXPECT().GetMessage(1, nil).Return(chatdb.Message{}, errors.New("this is a DB error"))
+			} else {
+				for _, messageID := range messageIDs {
+					msg := chatdb.Message{ID: messageID.ID, Text: texts[messageID.ID]}
+					if tt.transcription && messageID.ID == 10 {
+						msg.AudioTranscription = "see you at the cafe"
+					}
+					dbMock.EXPECT().GetMessage(messageID.ID, nil).Return(msg, nil)
+				}
+			}
+			search, err := newSearch([]string{"cafe"}, nil)
+			assert.NilError(t, err)
+			cfg := configuration{
+				Options: Options{Context: tt.context},
+				ChatDB:  dbMock,
+				search:  search,
+			}
+
+			result, err := cfg.searchMessages(nil, messageIDs)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			ids := []int{}
+			for _, messageID := range result {
+				ids = append(ids, messageID.ID)
+			}
+			assert.DeepEqual(t, tt.wantIDs, ids)
+			assert.DeepEqual(t, tt.wantBreaks, cfg.searchBreaks)
+			// The returned messages are kept, so that they are not read again.
+			assert.Equal(t, len(cfg.searchedMessages), len(tt.wantIDs))
+			for _, id := range tt.wantIDs {
+				assert.Equal(t, cfg.searchedMessages[id].ID, id)
+			}
+			assert.Equal(t, cfg.counts.searchMatches, tt.wantMatches)
+		})
+	}
+}
@@ -7,8 +7,10 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 
@@ -21,12 +23,31 @@ const (
 	_filenamePrefixMaxLength = 251
 	_pdfPreferredMessages    = 2048
 	_pdfMaxMessages          = 3072
+	_sketchMIMEType          = "image/svg+xml"
 )
 
-func (cfg *configuration) writeFile(entityName string, guids []string, messageIDs []chatdb.DatedMessageID) error {
-	chatDirPath := filepath.Join(cfg.Options.ExportPath, entityName)
-	if err := cfg.OS.MkdirAll(chatDirPath, os.ModePerm); err != nil {
-		return fmt.Errorf("create directory %q: %w", chatDirPath, err)
+func (cfg *configuration) writeFile(entity chatdb.EntityChats, guids []string, messageIDs []chatdb.DatedMessageID) error {
+	sort.SliceStable(messageIDs, func(i, j int) bool { return messageIDs[i].Date < messageIDs[j].Date })
+	handleMap := cfg.entityHandleMap(entity)
+	if cfg.search != nil {
+		var err error
+		if messageIDs, err = cfg.searchMessages(handleMap, messageIDs); err != nil {
+			return err
+		}
+		if len(messageIDs) == 0 {
+			// Skip chats without search matches.
+			return nil
+		}
+	}
+	if cfg.streaming() {
+		outFile := cfg.OS.NewJSONLFile(entity.Name, guids, cfg.stdout)
+		return cfg.handleFileContents(outFile, handleMap, messageIDs, "", false)
+	}
+	chatDirPath := filepath.Join(cfg.Options.ExportPath, entity.Name)
+	if cfg.combinedCSV == nil && cfg.archive == nil {
+		if err := cfg.OS.MkdirAll(chatDirPath, os.ModePerm); err != nil {
+			return fmt.Errorf("create directory %q: %w", chatDirPath, err)
+		}
 	}
 	filename := strings.Join(guids, ";;;")
 	if len(filename) > _filenamePrefixMaxLength {
@@ -39,14 +60,76 @@ func (cfg *configuration) writeFile(entityName string, guids []string, messageID
 			return fmt.Errorf("create directory %q: %w", attDir, err)
 		}
 	}
-	sort.SliceStable(messageIDs, func(i, j int) bool { return messageIDs[i].Date < messageIDs[j].Date })
+	if cfg.combinedCSV != nil {
+		outFile := cfg.OS.NewCSVFile(entity.Name, cfg.combinedCSV, !cfg.combinedCSVStarted)
+		cfg.combinedCSVStarted = true
+		return cfg.handleFileContents(outFile, handleMap, messageIDs, attDir, false)
+	}
+	if cfg.archive != nil {
+		outFile := cfg.archive.NewOutFile(entity, guids)
+		return cfg.handleFileContents(outFile, handleMap, messageIDs, attDir, false)
+	}
 	if cfg.Options.OutputPDF {
-		return cfg.writePDFs(entityName, messageIDs, chatPathNoExt, attDir)
+		return cfg.writePDFs(entity, handleMap, messageIDs, chatPathNoExt, attDir)
+	}
+	if cfg.Options.Format == opsys.FormatCSV {
+		return cfg.writeCSV(entity.Name, handleMap, messageIDs, chatPathNoExt, attDir)
+	}
+	if cfg.Options.Format == opsys.FormatHTML {
+		return cfg.writeHTML(entity.Name, handleMap, messageIDs, chatPathNoExt, attDir)
+	}
+	if cfg.Options.Format == opsys.FormatJSON || cfg.Options.Format == opsys.FormatJSONL {
+		return cfg.writeJSON(entity, guids, handleMap, messageIDs, chatPathNoExt, attDir)
 	}
-	return cfg.writeTxt(messageIDs, chatPathNoExt, attDir)
+	if cfg.Options.Format == opsys.FormatEPUB {
+		return cfg.writeEPUB(entity, handleMap, messageIDs, chatPathNoExt, attDir)
+	}
+	if cfg.Options.Format == opsys.FormatMBox {
+		return cfg.writeMBox(entity.Name, handleMap, messageIDs, chatPathNoExt, attDir)
+	}
+	if cfg.Options.Format == opsys.FormatMD {
+		return cfg.writeMarkdown(entity.Name, guids, handleMap, messageIDs, chatPathNoExt, attDir)
+	}
+	return cfg.writeTxt(handleMap, messageIDs, chatPathNoExt, attDir)
 }
 
-func (cfg *configuration) writeTxt(messageIDs []chatdb.DatedMessageID, chatPathNoExt, attDir string) error {
+// chatParticipants returns the participants of the entity's chats with the
+// given GUIDs, without duplicates.
+func chatParticipants(entity chatdb.EntityChats, guids []string) []string {
+	var participants []string
+	for _, chat := range entity.Chats {
+		if !slices.Contains(guids, chat.GUID) {
+			continue
+		}
+		for _, name := range chat.Participants {
+			if !slices.Contains(participants, name) {
+				participants = append(participants, name)
+			}
+		}
+	}
+	return participants
+}
+
+// entityHandleMap returns the handle map for the entity's chats, in which
+// participants of group chats who share a name are told apart.
+func (cfg *configuration) entityHandleMap(entity chatdb.EntityChats) map[int]string {
+	handleMap := cfg.handleMap
+	cloned := false
+	for _, chat := range entity.Chats {
+		if len(chat.SenderNames) == 0 {
+			continue
+		}
+		if !cloned {
+			handleMap = make(map[int]string, len(cfg.handleMap))
+			maps.Copy(handleMap, cfg.handleMap)
+			cloned = true
+		}
+		maps.Copy(handleMap, chat.SenderNames)
+	}
+	return handleMap
+}
+
+func (cfg *configuration) writeTxt(handleMap map[int]string, messageIDs []chatdb.DatedMessageID, chatPathNoExt, attDir string) error {
 	chatPath := chatPathNoExt + ".txt"
 	chatFile, err := cfg.OS.Create(chatPath)
 	if err != nil {
@@ -54,10 +137,82 @@ func (cfg *configuration) writeTxt(messageIDs []chatdb.DatedMessageID, chatPathN
 	}
 	defer chatFile.Close()
 	outFile := cfg.OS.NewTxtOutFile(chatFile)
-	return cfg.handleFileContents(outFile, messageIDs, attDir)
+	return cfg.handleFileContents(outFile, handleMap, messageIDs, attDir, false)
+}
+
+func (cfg *configuration) writeJSON(entity chatdb.EntityChats, guids []string, handleMap map[int]string, messageIDs []chatdb.DatedMessageID, chatPathNoExt, attDir string) error {
+	chatPath := chatPathNoExt + "." + cfg.Options.Format
+	chatFile, err := cfg.OS.Create(chatPath)
+	if err != nil {
+		return fmt.Errorf("create file %q: %w", chatPath, err)
+	}
+	defer chatFile.Close()
+	var outFile opsys.OutFile
+	if cfg.Options.Format == opsys.FormatJSONL {
+		outFile = cfg.OS.NewJSONLFile(entity.Name, guids, chatFile)
+	} else {
+		outFile = cfg.OS.NewJSONFile(entity.Name, guids, chatParticipants(entity, guids), chatFile)
+	}
+	return cfg.handleFileContents(outFile, handleMap, messageIDs, attDir, false)
+}
+
+func (cfg *configuration) writeCSV(entityName string, handleMap map[int]string, messageIDs []chatdb.DatedMessageID, chatPathNoExt, attDir string) error {
+	chatPath := chatPathNoExt + ".csv"
+	chatFile, err := cfg.OS.Create(chatPath)
+	if err != nil {
+		return fmt.Errorf("create file %q: %w", chatPath, err)
+	}
+	defer chatFile.Close()
+	outFile := cfg.OS.NewCSVFile(entityName, chatFile, true)
+	return cfg.handleFileContents(outFile, handleMap, messageIDs, attDir, false)
+}
+
+func (cfg *configuration) writeMarkdown(entityName string, guids []string, handleMap map[int]string, messageIDs []chatdb.DatedMessageID, chatPathNoExt, attDir string) error {
+	chatPath := chatPathNoExt + ".md"
+	chatFile, err := cfg.OS.Create(chatPath)
+	if err != nil {
+		return fmt.Errorf("create file %q: %w", chatPath, err)
+	}
+	defer chatFile.Close()
+	outFile := cfg.OS.NewMarkdownFile(entityName, guids, chatFile, opsys.MarkdownOptions{
+		VaultPath:  cfg.Options.ExportPath,
+		DailyNotes: cfg.Options.DailyNotes,
+	})
+	return cfg.handleFileContents(outFile, handleMap, messageIDs, attDir, false)
+}
+
+func (cfg *configuration) writeMBox(entityName string, handleMap map[int]string, messageIDs []chatdb.DatedMessageID, chatPathNoExt, attDir string) error {
+	chatPath := chatPathNoExt + ".mbox"
+	chatFile, err := cfg.OS.Create(chatPath)
+	if err != nil {
+		return fmt.Errorf("create file %q: %w", chatPath, err)
+	}
+	defer chatFile.Close()
+	outFile := cfg.OS.NewMBoxFile(entityName, cfg.Options.SelfHandle, chatFile)
+	return cfg.handleFileContents(outFile, handleMap, messageIDs, attDir, false)
+}
+
+// writeEPUB writes the messages to an e-book, with the entity's avatar as its
+// cover, and senders' avatars beside messages in group chats.
+func (cfg *configuration) writeEPUB(entity chatdb.EntityChats, handleMap map[int]string, messageIDs []chatdb.DatedMessageID, chatPathNoExt, attDir string) error {
+	chatPath := chatPathNoExt + ".epub"
+	chatFile, err := cfg.OS.Create(chatPath)
+	if err != nil {
+		return fmt.Errorf("create file %q: %w", chatPath, err)
+	}
+	defer chatFile.Close()
+	outFile := cfg.OS.NewEPUBFile(entity.Name, chatFile)
+	if entity.Avatar != "" {
+		outFile.SetAvatar(entity.Avatar)
+	}
+	senderAvatars := slices.ContainsFunc(entity.Chats, func(chat chatdb.Chat) bool { return chat.Group })
+	return cfg.handleFileContents(outFile, handleMap, messageIDs, attDir, senderAvatars)
 }
 
-func (cfg *configuration) writePDFs(entityName string, messageIDs []chatdb.DatedMessageID, chatPathNoExt, attDir string) error {
+// writePDFs writes the messages to one or more PDF files, with the entity's
+// avatar in the header of each, and senders' avatars beside messages in group
+// chats.
+func (cfg *configuration) writePDFs(entity chatdb.EntityChats, handleMap map[int]string, messageIDs []chatdb.DatedMessageID, chatPathNoExt, attDir string) error {
 	type messageIDsAndChatPath struct {
 		messageIDs []chatdb.DatedMessageID
 		chatPath   string
@@ -81,6 +236,7 @@ func (cfg *configuration) writePDFs(entityName string, messageIDs []chatdb.Dated
 		chatPath:   lastChatPath,
 	})
 
+	senderAvatars := slices.ContainsFunc(entity.Chats, func(chat chatdb.Chat) bool { return chat.Group })
 	for _, idsAndPath := range idsAndPaths {
 		chatPath := idsAndPath.chatPath
 		chatFile, err := cfg.OS.Create(chatPath)
@@ -94,33 +250,63 @@ func (cfg *configuration) writePDFs(entityName string, messageIDs []chatdb.Dated
 			if err != nil {
 				return fmt.Errorf("create PDF generator: %w", err)
 			}
-			outFile = cfg.OS.NewWkhtmltopdfFile(entityName, chatFile, pdfg, cfg.Options.IncludePPA)
+			outFile = cfg.OS.NewWkhtmltopdfFile(entity.Name, chatFile, pdfg, cfg.Options.IncludePPA)
 		} else {
-			outFile = cfg.OS.NewWeasyPrintFile(entityName, chatFile, cfg.Options.IncludePPA)
+			outFile = cfg.OS.NewWeasyPrintFile(entity.Name, chatFile, cfg.Options.IncludePPA)
+		}
+		if entity.Avatar != "" {
+			outFile.SetAvatar(entity.Avatar)
 		}
-		if err := cfg.handleFileContents(outFile, idsAndPath.messageIDs, attDir); err != nil {
+		if err := cfg.handleFileContents(outFile, handleMap, idsAndPath.messageIDs, attDir, senderAvatars); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (cfg *configuration) handleFileContents(outFile opsys.OutFile, messageIDs []chatdb.DatedMessageID, attDir string) error {
-	msgCount, invalidCount := 0, 0
+func (cfg *configuration) handleFileContents(outFile opsys.OutFile, handleMap map[int]string, messageIDs []chatdb.DatedMessageID, attDir string, senderAvatars bool) error {
+	msgCount, recoveredCount, invalidCount := 0, 0, 0
 	for _, messageID := range messageIDs {
-		msg, ok, err := cfg.ChatDB.GetMessage(messageID.ID, cfg.handleMap)
-		if err != nil {
-			return fmt.Errorf("get message with ID %d: %w", messageID.ID, err)
+		msg, ok := cfg.searchedMessages[messageID.ID]
+		if !ok {
+			var err error
+			if msg, err = cfg.ChatDB.GetMessage(messageID.ID, handleMap); err != nil {
+				return fmt.Errorf("get message with ID %d: %w", messageID.ID, err)
+			}
+		}
+		msg.ChatGUID = messageID.ChatGUID
+		if cfg.searchBreaks[messageID.ID] {
+			if err := outFile.WriteSeparator(); err != nil {
+				return fmt.Errorf("write separator before message %d to file %q: %w", messageID.ID, outFile.Name(), err)
+			}
+		}
+		if senderAvatars && msg.SenderAvatar != "" {
+			if err := outFile.WriteAvatar(msg.SenderAvatar); err != nil {
+				return fmt.Errorf("write avatar of message %d to file %q: %w", messageID.ID, outFile.Name(), err)
+			}
 		}
 		if err := outFile.WriteMessage(msg); err != nil {
-			return fmt.Errorf("write message %q to file %q: %w", msg, outFile.Name(), err)
+			return fmt.Errorf("write message %q to file %q: %w", msg.String(), outFile.Name(), err)
 		}
 		if err := cfg.handleAttachments(outFile, messageID.ID, attDir); err != nil {
 			return fmt.Errorf("chat file %q - message %d: %w", outFile.Name(), messageID.ID, err)
 		}
-		if ok {
+		if msg.Sketch != nil {
+			if err := cfg.handleSketch(outFile, msg, attDir); err != nil {
+				return fmt.Errorf("chat file %q - message %d: %w", outFile.Name(), messageID.ID, err)
+			}
+		}
+		if msg.AudioTranscription != "" {
+			if err := outFile.WriteTranscription(msg.AudioTranscription); err != nil {
+				return fmt.Errorf("write transcription of message %d to file %q: %w", messageID.ID, outFile.Name(), err)
+			}
+		}
+		switch msg.Status {
+		case chatdb.TextValid:
 			msgCount++
-		} else {
+		case chatdb.TextRecovered:
+			recoveredCount++
+		default:
 			invalidCount++
 		}
 	}
@@ -142,6 +328,7 @@ func (cfg *configuration) handleFileContents(outFile opsys.OutFile, messageIDs [
 	}
 	cfg.counts.files++
 	cfg.counts.messages += msgCount
+	cfg.counts.messagesRecovered += recoveredCount
 	cfg.counts.messagesInvalid += invalidCount
 	return nil
 }
@@ -152,7 +339,9 @@ func (cfg *configuration) handleAttachments(outFile opsys.OutFile, msgID int, at
 		return nil
 	}
 	for _, att := range msgPaths {
-		att.Filepath = filepath.Join(cfg.Options.AttachmentsPath, att.Filename)
+		if err := cfg.locateAttachment(&att); err != nil {
+			return err
+		}
 		err := cfg.validateAttachmentPath(att)
 		if _, ok := err.(errorMissingAttachment); ok {
 			// Attachment is missing. Just reference it, and skip copying/embedding.
@@ -165,7 +354,7 @@ func (cfg *configuration) handleAttachments(outFile opsys.OutFile, msgID int, at
 					"name", att.TransferName,
 					"ID", att.ID,
 				))
-			if err := outFile.ReferenceAttachment(att.TransferName); err != nil {
+			if err := outFile.ReferenceAttachment(att); err != nil {
 				return fmt.Errorf("reference attachment %q: %w", att.TransferName, err)
 			}
 			cfg.counts.attachments[att.MIMEType]++
@@ -183,6 +372,60 @@ func (cfg *configuration) handleAttachments(outFile opsys.OutFile, msgID int, at
 	return nil
 }
 
+// handleSketch renders the drawing in a handwritten or Digital Touch message to
+// an SVG image, which is then copied and embedded like an image attachment. If
+// attachments are neither copied nor embedded, the image is still saved with
+// the chat, since it would not outlive the temporary directory. Sketches are
+// left out of archives, which only record the attachments in chat.db.
+func (cfg *configuration) handleSketch(outFile opsys.OutFile, msg chatdb.Message, attDir string) error {
+	if cfg.archive != nil {
+		return nil
+	}
+	filename := fmt.Sprintf("sketch-%d.svg", msg.ID)
+	att := chatdb.Attachment{
+		Filename:     filename,
+		MIMEType:     _sketchMIMEType,
+		TransferName: filename,
+	}
+	svgPath, err := cfg.OS.RenderSketch(*msg.Sketch, filename)
+	if err != nil {
+		return fmt.Errorf("render sketch: %w", err)
+	}
+	att.Filepath = svgPath
+	if !cfg.Options.embeddingAttachments() && !cfg.Options.CopyAttachments {
+		if err := cfg.OS.MkdirAll(attDir, os.ModePerm); err != nil {
+			return fmt.Errorf("create directory %q: %w", attDir, err)
+		}
+		dstPath, err := cfg.OS.CopyFile(svgPath, attDir, true)
+		if err != nil {
+			return fmt.Errorf("copy sketch %q to %q: %w", svgPath, attDir, err)
+		}
+		att.Filepath, att.CopiedPath = dstPath, dstPath
+	} else if err := cfg.copyAttachment(&att, attDir); err != nil {
+		return err
+	}
+	return cfg.writeAttachment(outFile, att)
+}
+
+// locateAttachment sets the path from which the attachment is read, under the
+// attachments path. An attachment rendered from an archive is read from its
+// copy in the export which wrote the archive instead, if the copy still exists.
+func (cfg configuration) locateAttachment(att *chatdb.Attachment) error {
+	att.Filepath = filepath.Join(cfg.Options.AttachmentsPath, att.Filename)
+	if att.CopiedPath == "" {
+		return nil
+	}
+	copiedPath := att.CopiedPath
+	// The copy belongs to the earlier export, not this one.
+	att.CopiedPath = ""
+	if ok, err := cfg.OS.FileExist(copiedPath); err != nil {
+		return fmt.Errorf("check existence of file %q: %w", copiedPath, err)
+	} else if ok {
+		att.Filepath = copiedPath
+	}
+	return nil
+}
+
 type errorMissingAttachment struct{ err error }
 
 func (e errorMissingAttachment) Error() string { return e.err.Error() }
@@ -215,14 +458,14 @@ func (cfg *configuration) copyAttachment(att *chatdb.Attachment, attDir string)
 	if err != nil {
 		return fmt.Errorf("copy attachment %q to %q: %w", att.Filepath, attDir, err)
 	}
-	att.Filepath = dstPath
+	att.Filepath, att.CopiedPath = dstPath, dstPath
 	cfg.counts.attachmentsCopied[att.MIMEType]++
 	return nil
 }
 
 func (cfg *configuration) writeAttachment(outFile opsys.OutFile, att chatdb.Attachment) error {
 	attPath, mimeType := att.Filepath, att.MIMEType
-	if cfg.Options.OutputPDF {
+	if cfg.Options.embeddingAttachments() {
 		if jpgPath, err := cfg.ImgConverter.ConvertHEIC(attPath); err != nil {
 			cfg.counts.conversionsFailed++
 			slog.Warn("failed to convert HEIC file to JPEG",
@@ -235,7 +478,8 @@ func (cfg *configuration) writeAttachment(outFile opsys.OutFile, att chatdb.Atta
 			attPath, mimeType = jpgPath, "image/jpeg"
 		}
 	}
-	embedded, err := outFile.WriteAttachment(attPath)
+	att.Filepath, att.MIMEType = attPath, mimeType
+	embedded, err := outFile.WriteAttachment(att)
 	if err != nil {
 		return fmt.Errorf("include attachment %q: %w", attPath, err)
 	}
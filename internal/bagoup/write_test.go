@@ -8,12 +8,15 @@ import (
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/Masterminds/semver/v3"
 	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/archive/mock_archive"
 	"github.com/tagatac/bagoup/v2/chatdb"
 	"github.com/tagatac/bagoup/v2/chatdb/mock_chatdb"
 	"github.com/tagatac/bagoup/v2/imgconv/mock_imgconv"
+	"github.com/tagatac/bagoup/v2/opsys"
 	"github.com/tagatac/bagoup/v2/opsys/mock_opsys"
 	"go.uber.org/mock/gomock"
 	"gotest.tools/v3/assert"
@@ -23,15 +26,46 @@ func TestWriteFile(t *testing.T) {
 	fileSys := afero.NewMemMapFs()
 	chatFile, err := fileSys.Create("testfile")
 	assert.NilError(t, err)
+	msg1 := chatdb.Message{
+		ID:     1,
+		Date:   time.Date(2020, 3, 1, 15, 34, 5, 0, time.UTC),
+		Sender: "Me",
+		FromMe: true,
+		Text:   "message1",
+		Status: chatdb.TextValid,
+	}
+	msg2 := chatdb.Message{
+		ID:     2,
+		Date:   time.Date(2020, 3, 1, 15, 34, 41, 0, time.UTC),
+		Sender: "friend",
+		Text:   "message2",
+		Status: chatdb.TextValid,
+	}
+	msg2Recovered := msg2
+	msg2Recovered.Status = chatdb.TextRecovered
+	msg2Audio := msg2
+	msg2Audio.Text, msg2Audio.IsAudio, msg2Audio.AudioTranscription = "\uFFFC", true, "see you soon"
+	msg2Sketch := msg2
+	msg2Sketch.Text, msg2Sketch.Sketch = "\uFFFC", &chatdb.Sketch{Width: 300, Height: 300}
+	msg2Invalid := msg2
+	msg2Avatar := msg2
+	msg2Avatar.SenderAvatar = "avatar-2.jpg"
+	msg2Invalid.Text, msg2Invalid.Status = "", chatdb.TextInvalid
 
 	tests := []struct {
 		msg             string
+		format          string
 		pdf             bool
 		wkhtml          bool
 		copyAttachments bool
 		preservePaths   bool
+		avatar          string
+		group           bool
+		senderNames     map[int]string
 		setupMocks      func(*mock_chatdb.MockChatDB, *mock_opsys.MockOS, *mock_imgconv.MockImgConverter, *mock_opsys.MockOutFile)
+		wantRecovered   int
 		wantInvalid     int
+		wantSketches    int
 		wantJPGs        int
 		wantEmbedded    int
 		wantConv        int
@@ -45,16 +79,147 @@ func TestWriteFile(t *testing.T) {
 					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt").Return(chatFile, nil),
 					osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMock),
-					dbMock.EXPECT().GetMessage(1, nil).Return("message1", true, nil),
-					ofMock.EXPECT().WriteMessage("message1"),
-					dbMock.EXPECT().GetMessage(2, nil).Return("message2", true, nil),
-					ofMock.EXPECT().WriteMessage("message2"),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2, nil),
+					ofMock.EXPECT().WriteMessage(msg2),
 					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
-					ofMock.EXPECT().WriteAttachment("attachment1.heic"),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment1.heic")),
 					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
-					ofMock.EXPECT().WriteAttachment("attachment2.jpeg"),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment2.jpeg")),
 					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt"),
-					ofMock.EXPECT().ReferenceAttachment("att3transfer.png"),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att3transfer.png")),
+					ofMock.EXPECT().Stage(),
+					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
+					ofMock.EXPECT().Flush(),
+				)
+			},
+			wantJPGs: 1,
+		},
+		{
+			msg:    "JSON export",
+			format: "json",
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB, osMock *mock_opsys.MockOS, _ *mock_imgconv.MockImgConverter, ofMock *mock_opsys.MockOutFile) {
+				gomock.InOrder(
+					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
+					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.json").Return(chatFile, nil),
+					osMock.EXPECT().NewJSONFile("friend", []string{"iMessage;-;friend@gmail.com", "iMessage;-;friend@hotmail.com"}, []string{"friend"}, chatFile).Return(ofMock),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2, nil),
+					ofMock.EXPECT().WriteMessage(msg2),
+					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment1.heic")),
+					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment2.jpeg")),
+					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.json"),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att3transfer.png")),
+					ofMock.EXPECT().Stage(),
+					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
+					ofMock.EXPECT().Flush(),
+				)
+			},
+			wantJPGs: 1,
+		},
+		{
+			msg:    "CSV export",
+			format: "csv",
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB, osMock *mock_opsys.MockOS, _ *mock_imgconv.MockImgConverter, ofMock *mock_opsys.MockOutFile) {
+				gomock.InOrder(
+					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
+					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.csv").Return(chatFile, nil),
+					osMock.EXPECT().NewCSVFile("friend", chatFile, true).Return(ofMock),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2, nil),
+					ofMock.EXPECT().WriteMessage(msg2),
+					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment1.heic")),
+					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment2.jpeg")),
+					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.csv"),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att3transfer.png")),
+					ofMock.EXPECT().Stage(),
+					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
+					ofMock.EXPECT().Flush(),
+				)
+			},
+			wantJPGs: 1,
+		},
+		{
+			msg:    "Markdown export",
+			format: "md",
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB, osMock *mock_opsys.MockOS, _ *mock_imgconv.MockImgConverter, ofMock *mock_opsys.MockOutFile) {
+				gomock.InOrder(
+					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
+					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.md").Return(chatFile, nil),
+					osMock.EXPECT().NewMarkdownFile("friend", []string{"iMessage;-;friend@gmail.com", "iMessage;-;friend@hotmail.com"}, chatFile, opsys.MarkdownOptions{VaultPath: "messages-export"}).Return(ofMock),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2, nil),
+					ofMock.EXPECT().WriteMessage(msg2),
+					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment1.heic")),
+					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment2.jpeg")),
+					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.md"),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att3transfer.png")),
+					ofMock.EXPECT().Stage(),
+					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
+					ofMock.EXPECT().Flush(),
+				)
+			},
+			wantJPGs: 1,
+		},
+		{
+			msg:    "mbox export",
+			format: "mbox",
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB, osMock *mock_opsys.MockOS, icMock *mock_imgconv.MockImgConverter, ofMock *mock_opsys.MockOutFile) {
+				gomock.InOrder(
+					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
+					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.mbox").Return(chatFile, nil),
+					osMock.EXPECT().NewMBoxFile("friend", "", chatFile).Return(ofMock),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2, nil),
+					ofMock.EXPECT().WriteMessage(msg2),
+					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
+					icMock.EXPECT().ConvertHEIC("attachment1.heic").Return("attachment1.jpeg", nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment1.jpeg")).Return(true, nil),
+					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
+					icMock.EXPECT().ConvertHEIC("attachment2.jpeg").Return("attachment2.jpeg", nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment2.jpeg")).Return(true, nil),
+					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.mbox"),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att3transfer.png")),
+					ofMock.EXPECT().Stage(),
+					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
+					ofMock.EXPECT().Flush(),
+				)
+			},
+			wantJPGs:     2,
+			wantEmbedded: 2,
+			wantConv:     1,
+		},
+		{
+			msg:         "group chat with disambiguated sender names",
+			group:       true,
+			senderNames: map[int]string{10: "Alex K."},
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB, osMock *mock_opsys.MockOS, _ *mock_imgconv.MockImgConverter, ofMock *mock_opsys.MockOutFile) {
+				handleMap := map[int]string{10: "Alex K."}
+				gomock.InOrder(
+					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
+					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt").Return(chatFile, nil),
+					osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMock),
+					dbMock.EXPECT().GetMessage(1, handleMap).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, handleMap).Return(msg2, nil),
+					ofMock.EXPECT().WriteMessage(msg2),
+					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment1.heic")),
+					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment2.jpeg")),
+					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt"),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att3transfer.png")),
 					ofMock.EXPECT().Stage(),
 					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
 					ofMock.EXPECT().Flush(),
@@ -70,18 +235,18 @@ func TestWriteFile(t *testing.T) {
 					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf").Return(chatFile, nil),
 					osMock.EXPECT().NewWeasyPrintFile("friend", chatFile, false).Return(ofMock),
-					dbMock.EXPECT().GetMessage(1, nil).Return("message1", true, nil),
-					ofMock.EXPECT().WriteMessage("message1"),
-					dbMock.EXPECT().GetMessage(2, nil).Return("message2", true, nil),
-					ofMock.EXPECT().WriteMessage("message2"),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2, nil),
+					ofMock.EXPECT().WriteMessage(msg2),
 					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
 					icMock.EXPECT().ConvertHEIC("attachment1.heic").Return("attachment1.jpeg", nil),
-					ofMock.EXPECT().WriteAttachment("attachment1.jpeg").Return(true, nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment1.jpeg")).Return(true, nil),
 					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
 					icMock.EXPECT().ConvertHEIC("attachment2.jpeg").Return("attachment2.jpeg", nil),
-					ofMock.EXPECT().WriteAttachment("attachment2.jpeg").Return(true, nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment2.jpeg")).Return(true, nil),
 					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf"),
-					ofMock.EXPECT().ReferenceAttachment("att3transfer.png"),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att3transfer.png")),
 					ofMock.EXPECT().Stage(),
 					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
 					ofMock.EXPECT().Flush(),
@@ -100,18 +265,18 @@ func TestWriteFile(t *testing.T) {
 					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf").Return(chatFile, nil),
 					osMock.EXPECT().NewWkhtmltopdfFile("friend", chatFile, gomock.Any(), false).Return(ofMock),
-					dbMock.EXPECT().GetMessage(1, nil).Return("message1", true, nil),
-					ofMock.EXPECT().WriteMessage("message1"),
-					dbMock.EXPECT().GetMessage(2, nil).Return("message2", true, nil),
-					ofMock.EXPECT().WriteMessage("message2"),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2, nil),
+					ofMock.EXPECT().WriteMessage(msg2),
 					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
 					icMock.EXPECT().ConvertHEIC("attachment1.heic").Return("attachment1.jpeg", nil),
-					ofMock.EXPECT().WriteAttachment("attachment1.jpeg").Return(true, nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment1.jpeg")).Return(true, nil),
 					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
 					icMock.EXPECT().ConvertHEIC("attachment2.jpeg").Return("attachment2.jpeg", nil),
-					ofMock.EXPECT().WriteAttachment("attachment2.jpeg").Return(true, nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment2.jpeg")).Return(true, nil),
 					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf"),
-					ofMock.EXPECT().ReferenceAttachment("att3transfer.png"),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att3transfer.png")),
 					ofMock.EXPECT().Stage(),
 					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
 					ofMock.EXPECT().Flush(),
@@ -121,6 +286,88 @@ func TestWriteFile(t *testing.T) {
 			wantEmbedded: 2,
 			wantConv:     1,
 		},
+		{
+			msg:    "pdf export with avatars in a group chat",
+			pdf:    true,
+			avatar: "avatar-1.jpg",
+			group:  true,
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB, osMock *mock_opsys.MockOS, icMock *mock_imgconv.MockImgConverter, ofMock *mock_opsys.MockOutFile) {
+				gomock.InOrder(
+					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
+					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf").Return(chatFile, nil),
+					osMock.EXPECT().NewWeasyPrintFile("friend", chatFile, false).Return(ofMock),
+					ofMock.EXPECT().SetAvatar("avatar-1.jpg"),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2Avatar, nil),
+					ofMock.EXPECT().WriteAvatar("avatar-2.jpg"),
+					ofMock.EXPECT().WriteMessage(msg2Avatar),
+					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
+					icMock.EXPECT().ConvertHEIC("attachment1.heic").Return("attachment1.jpeg", nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment1.jpeg")).Return(true, nil),
+					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
+					icMock.EXPECT().ConvertHEIC("attachment2.jpeg").Return("attachment2.jpeg", nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment2.jpeg")).Return(true, nil),
+					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf"),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att3transfer.png")),
+					ofMock.EXPECT().Stage(),
+					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
+					ofMock.EXPECT().Flush(),
+				)
+			},
+			wantJPGs:     2,
+			wantEmbedded: 2,
+			wantConv:     1,
+		},
+		{
+			msg:    "pdf export without sender avatars in a one-on-one chat",
+			pdf:    true,
+			avatar: "avatar-1.jpg",
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB, osMock *mock_opsys.MockOS, icMock *mock_imgconv.MockImgConverter, ofMock *mock_opsys.MockOutFile) {
+				gomock.InOrder(
+					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
+					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf").Return(chatFile, nil),
+					osMock.EXPECT().NewWeasyPrintFile("friend", chatFile, false).Return(ofMock),
+					ofMock.EXPECT().SetAvatar("avatar-1.jpg"),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2Avatar, nil),
+					ofMock.EXPECT().WriteMessage(msg2Avatar),
+					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
+					icMock.EXPECT().ConvertHEIC("attachment1.heic").Return("attachment1.jpeg", nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment1.jpeg")).Return(true, nil),
+					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
+					icMock.EXPECT().ConvertHEIC("attachment2.jpeg").Return("attachment2.jpeg", nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment2.jpeg")).Return(true, nil),
+					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf"),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att3transfer.png")),
+					ofMock.EXPECT().Stage(),
+					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
+					ofMock.EXPECT().Flush(),
+				)
+			},
+			wantJPGs:     2,
+			wantEmbedded: 2,
+			wantConv:     1,
+		},
+		{
+			msg:   "writing avatar fails",
+			pdf:   true,
+			group: true,
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB, osMock *mock_opsys.MockOS, _ *mock_imgconv.MockImgConverter, ofMock *mock_opsys.MockOutFile) {
+				gomock.InOrder(
+					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
+					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf").Return(chatFile, nil),
+					osMock.EXPECT().NewWeasyPrintFile("friend", chatFile, false).Return(ofMock),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2Avatar, nil),
+					ofMock.EXPECT().WriteAvatar("avatar-2.jpg").Return(errors.New("this is an outfile error")),
+					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf"),
+				)
+			},
+			wantErr: `write avatar of message 2 to file "messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf": this is an outfile error`,
+		},
 		{
 			msg: "pdf export needs open files limit increase",
 			pdf: true,
@@ -129,18 +376,18 @@ func TestWriteFile(t *testing.T) {
 					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf").Return(chatFile, nil),
 					osMock.EXPECT().NewWeasyPrintFile("friend", chatFile, false).Return(ofMock),
-					dbMock.EXPECT().GetMessage(1, nil).Return("message1", true, nil),
-					ofMock.EXPECT().WriteMessage("message1"),
-					dbMock.EXPECT().GetMessage(2, nil).Return("message2", true, nil),
-					ofMock.EXPECT().WriteMessage("message2"),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2, nil),
+					ofMock.EXPECT().WriteMessage(msg2),
 					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
 					icMock.EXPECT().ConvertHEIC("attachment1.heic").Return("attachment1.jpeg", nil),
-					ofMock.EXPECT().WriteAttachment("attachment1.jpeg").Return(true, nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment1.jpeg")).Return(true, nil),
 					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
 					icMock.EXPECT().ConvertHEIC("attachment2.jpeg").Return("attachment2.jpeg", nil),
-					ofMock.EXPECT().WriteAttachment("attachment2.jpeg").Return(true, nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment2.jpeg")).Return(true, nil),
 					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf"),
-					ofMock.EXPECT().ReferenceAttachment("att3transfer.png"),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att3transfer.png")),
 					ofMock.EXPECT().Stage().Return(500, nil),
 					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
 					osMock.EXPECT().SetOpenFilesLimit(1000),
@@ -160,18 +407,18 @@ func TestWriteFile(t *testing.T) {
 					osMock.EXPECT().MkdirAll("messages-export/friend/attachments", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt").Return(chatFile, nil),
 					osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMock),
-					dbMock.EXPECT().GetMessage(1, nil).Return("message1", true, nil),
-					ofMock.EXPECT().WriteMessage("message1"),
-					dbMock.EXPECT().GetMessage(2, nil).Return("message2", true, nil),
-					ofMock.EXPECT().WriteMessage("message2"),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2, nil),
+					ofMock.EXPECT().WriteMessage(msg2),
 					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
 					osMock.EXPECT().CopyFile("attachment1.heic", "messages-export/friend/attachments", true).Return("messages-export/friend/attachments/attachment1.heic", nil),
-					ofMock.EXPECT().WriteAttachment("messages-export/friend/attachments/attachment1.heic"),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("messages-export/friend/attachments/attachment1.heic")),
 					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
 					osMock.EXPECT().CopyFile("attachment2.jpeg", "messages-export/friend/attachments", true).Return("messages-export/friend/attachments/attachment2.jpeg", nil),
-					ofMock.EXPECT().WriteAttachment("messages-export/friend/attachments/attachment2.jpeg"),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("messages-export/friend/attachments/attachment2.jpeg")),
 					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt"),
-					ofMock.EXPECT().ReferenceAttachment("att3transfer.png"),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att3transfer.png")),
 					ofMock.EXPECT().Stage(),
 					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
 					ofMock.EXPECT().Flush(),
@@ -188,20 +435,20 @@ func TestWriteFile(t *testing.T) {
 					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt").Return(chatFile, nil),
 					osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMock),
-					dbMock.EXPECT().GetMessage(1, nil).Return("message1", true, nil),
-					ofMock.EXPECT().WriteMessage("message1"),
-					dbMock.EXPECT().GetMessage(2, nil).Return("message2", true, nil),
-					ofMock.EXPECT().WriteMessage("message2"),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2, nil),
+					ofMock.EXPECT().WriteMessage(msg2),
 					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
 					osMock.EXPECT().MkdirAll("messages-export/bagoup-attachments", os.ModePerm),
 					osMock.EXPECT().CopyFile("attachment1.heic", "messages-export/bagoup-attachments", false).Return("messages-export/bagoup-attachments/attachment1.heic", nil),
-					ofMock.EXPECT().WriteAttachment("messages-export/bagoup-attachments/attachment1.heic"),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("messages-export/bagoup-attachments/attachment1.heic")),
 					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
 					osMock.EXPECT().MkdirAll("messages-export/bagoup-attachments", os.ModePerm),
 					osMock.EXPECT().CopyFile("attachment2.jpeg", "messages-export/bagoup-attachments", false).Return("messages-export/bagoup-attachments/attachment2-1.jpeg", nil),
-					ofMock.EXPECT().WriteAttachment("messages-export/bagoup-attachments/attachment2-1.jpeg"),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("messages-export/bagoup-attachments/attachment2-1.jpeg")),
 					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf"),
-					ofMock.EXPECT().ReferenceAttachment("att3transfer.png"),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att3transfer.png")),
 					ofMock.EXPECT().Stage(),
 					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
 					ofMock.EXPECT().Flush(),
@@ -219,20 +466,20 @@ func TestWriteFile(t *testing.T) {
 					osMock.EXPECT().MkdirAll("messages-export/friend/attachments", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf").Return(chatFile, nil),
 					osMock.EXPECT().NewWeasyPrintFile("friend", chatFile, false).Return(ofMock),
-					dbMock.EXPECT().GetMessage(1, nil).Return("message1", true, nil),
-					ofMock.EXPECT().WriteMessage("message1"),
-					dbMock.EXPECT().GetMessage(2, nil).Return("message2", true, nil),
-					ofMock.EXPECT().WriteMessage("message2"),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2, nil),
+					ofMock.EXPECT().WriteMessage(msg2),
 					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
 					osMock.EXPECT().CopyFile("attachment1.heic", "messages-export/friend/attachments", true).Return("messages-export/friend/attachments/attachment1.heic", nil),
 					icMock.EXPECT().ConvertHEIC("messages-export/friend/attachments/attachment1.heic").Return("attachment1.jpeg", nil),
-					ofMock.EXPECT().WriteAttachment("attachment1.jpeg").Return(true, nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment1.jpeg")).Return(true, nil),
 					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
 					osMock.EXPECT().CopyFile("attachment2.jpeg", "messages-export/friend/attachments", true).Return("messages-export/friend/attachments/attachment2.jpeg", nil),
 					icMock.EXPECT().ConvertHEIC("messages-export/friend/attachments/attachment2.jpeg").Return("messages-export/friend/attachments/attachment2.jpeg", nil),
-					ofMock.EXPECT().WriteAttachment("messages-export/friend/attachments/attachment2.jpeg").Return(true, nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("messages-export/friend/attachments/attachment2.jpeg")).Return(true, nil),
 					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf"),
-					ofMock.EXPECT().ReferenceAttachment("att3transfer.png"),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att3transfer.png")),
 					ofMock.EXPECT().Stage(),
 					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
 					ofMock.EXPECT().Flush(),
@@ -291,9 +538,9 @@ func TestWriteFile(t *testing.T) {
 					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt").Return(chatFile, nil),
 					osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMock),
-					dbMock.EXPECT().GetMessage(1, nil).Return("message1", true, nil),
-					ofMock.EXPECT().WriteMessage("message1"),
-					dbMock.EXPECT().GetMessage(2, nil).Return("", false, errors.New("this is a DB error")),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(chatdb.Message{}, errors.New("this is a DB error")),
 				)
 			},
 			wantErr: "get message with ID 2: this is a DB error",
@@ -305,14 +552,14 @@ func TestWriteFile(t *testing.T) {
 					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt").Return(chatFile, nil),
 					osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMock),
-					dbMock.EXPECT().GetMessage(1, nil).Return("message1", true, nil),
-					ofMock.EXPECT().WriteMessage("message1"),
-					dbMock.EXPECT().GetMessage(2, nil).Return("message2", true, nil),
-					ofMock.EXPECT().WriteMessage("message2").Return(errors.New("this is an outfile error")),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2, nil),
+					ofMock.EXPECT().WriteMessage(msg2).Return(errors.New("this is an outfile error")),
 					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt"),
 				)
 			},
-			wantErr: `write message "message2" to file "messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt": this is an outfile error`,
+			wantErr: `write message "[2020-03-01 15:34:41] friend: message2\n" to file "messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt": this is an outfile error`,
 		},
 		{
 			msg: "Staging error",
@@ -322,18 +569,18 @@ func TestWriteFile(t *testing.T) {
 					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf").Return(chatFile, nil),
 					osMock.EXPECT().NewWeasyPrintFile("friend", chatFile, false).Return(ofMock),
-					dbMock.EXPECT().GetMessage(1, nil).Return("message1", true, nil),
-					ofMock.EXPECT().WriteMessage("message1"),
-					dbMock.EXPECT().GetMessage(2, nil).Return("message2", true, nil),
-					ofMock.EXPECT().WriteMessage("message2"),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2, nil),
+					ofMock.EXPECT().WriteMessage(msg2),
 					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
 					icMock.EXPECT().ConvertHEIC("attachment1.heic").Return("attachment1.jpeg", nil),
-					ofMock.EXPECT().WriteAttachment("attachment1.jpeg"),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment1.jpeg")),
 					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
 					icMock.EXPECT().ConvertHEIC("attachment2.jpeg").Return("attachment2.jpeg", nil),
-					ofMock.EXPECT().WriteAttachment("attachment2.jpeg"),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment2.jpeg")),
 					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf"),
-					ofMock.EXPECT().ReferenceAttachment("att3transfer.png"),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att3transfer.png")),
 					ofMock.EXPECT().Stage().Return(0, errors.New("this is a staging error")),
 					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf"),
 				)
@@ -348,18 +595,18 @@ func TestWriteFile(t *testing.T) {
 					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf").Return(chatFile, nil),
 					osMock.EXPECT().NewWeasyPrintFile("friend", chatFile, false).Return(ofMock),
-					dbMock.EXPECT().GetMessage(1, nil).Return("message1", true, nil),
-					ofMock.EXPECT().WriteMessage("message1"),
-					dbMock.EXPECT().GetMessage(2, nil).Return("message2", true, nil),
-					ofMock.EXPECT().WriteMessage("message2"),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2, nil),
+					ofMock.EXPECT().WriteMessage(msg2),
 					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
 					icMock.EXPECT().ConvertHEIC("attachment1.heic").Return("attachment1.jpeg", nil),
-					ofMock.EXPECT().WriteAttachment("attachment1.jpeg"),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment1.jpeg")),
 					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
 					icMock.EXPECT().ConvertHEIC("attachment2.jpeg").Return("attachment2.jpeg", nil),
-					ofMock.EXPECT().WriteAttachment("attachment2.jpeg"),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment2.jpeg")),
 					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf"),
-					ofMock.EXPECT().ReferenceAttachment("att3transfer.png"),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att3transfer.png")),
 					ofMock.EXPECT().Stage().Return(500, nil),
 					osMock.EXPECT().GetOpenFilesLimit().Return(0, errors.New("this is a ulimit error")),
 				)
@@ -374,18 +621,18 @@ func TestWriteFile(t *testing.T) {
 					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf").Return(chatFile, nil),
 					osMock.EXPECT().NewWeasyPrintFile("friend", chatFile, false).Return(ofMock),
-					dbMock.EXPECT().GetMessage(1, nil).Return("message1", true, nil),
-					ofMock.EXPECT().WriteMessage("message1"),
-					dbMock.EXPECT().GetMessage(2, nil).Return("message2", true, nil),
-					ofMock.EXPECT().WriteMessage("message2"),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2, nil),
+					ofMock.EXPECT().WriteMessage(msg2),
 					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
 					icMock.EXPECT().ConvertHEIC("attachment1.heic").Return("attachment1.jpeg", nil),
-					ofMock.EXPECT().WriteAttachment("attachment1.jpeg"),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment1.jpeg")),
 					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
 					icMock.EXPECT().ConvertHEIC("attachment2.jpeg").Return("attachment2.jpeg", nil),
-					ofMock.EXPECT().WriteAttachment("attachment2.jpeg"),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment2.jpeg")),
 					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf"),
-					ofMock.EXPECT().ReferenceAttachment("att3transfer.png"),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att3transfer.png")),
 					ofMock.EXPECT().Stage().Return(500, nil),
 					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
 					osMock.EXPECT().SetOpenFilesLimit(1000).Return(errors.New("this is a syscall error")),
@@ -402,18 +649,18 @@ func TestWriteFile(t *testing.T) {
 					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf").Return(chatFile, nil),
 					osMock.EXPECT().NewWeasyPrintFile("friend", chatFile, false).Return(ofMock),
-					dbMock.EXPECT().GetMessage(1, nil).Return("message1", true, nil),
-					ofMock.EXPECT().WriteMessage("message1"),
-					dbMock.EXPECT().GetMessage(2, nil).Return("message2", true, nil),
-					ofMock.EXPECT().WriteMessage("message2"),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2, nil),
+					ofMock.EXPECT().WriteMessage(msg2),
 					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
 					icMock.EXPECT().ConvertHEIC("attachment1.heic").Return("attachment1.jpeg", nil),
-					ofMock.EXPECT().WriteAttachment("attachment1.jpeg"),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment1.jpeg")),
 					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
 					icMock.EXPECT().ConvertHEIC("attachment2.jpeg").Return("attachment2.jpeg", nil),
-					ofMock.EXPECT().WriteAttachment("attachment2.jpeg"),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment2.jpeg")),
 					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf"),
-					ofMock.EXPECT().ReferenceAttachment("att3transfer.png"),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att3transfer.png")),
 					ofMock.EXPECT().Stage(),
 					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
 					ofMock.EXPECT().Flush().Return(errors.New("this is a flush error")),
@@ -429,17 +676,17 @@ func TestWriteFile(t *testing.T) {
 					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt").Return(chatFile, nil),
 					osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMock),
-					dbMock.EXPECT().GetMessage(1, nil).Return("message1", true, nil),
-					ofMock.EXPECT().WriteMessage("message1"),
-					dbMock.EXPECT().GetMessage(2, nil).Return("message2", true, nil),
-					ofMock.EXPECT().WriteMessage("message2"),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2, nil),
+					ofMock.EXPECT().WriteMessage(msg2),
 					osMock.EXPECT().FileExist("attachment1.heic").Return(false, nil),
 					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt"),
-					ofMock.EXPECT().ReferenceAttachment("att1transfer.heic"),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att1transfer.heic")),
 					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
-					ofMock.EXPECT().WriteAttachment("attachment2.jpeg"),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment2.jpeg")),
 					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt"),
-					ofMock.EXPECT().ReferenceAttachment("att3transfer.png"),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att3transfer.png")),
 					ofMock.EXPECT().Stage(),
 					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
 					ofMock.EXPECT().Flush(),
@@ -454,13 +701,13 @@ func TestWriteFile(t *testing.T) {
 					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt").Return(chatFile, nil),
 					osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMock),
-					dbMock.EXPECT().GetMessage(1, nil).Return("message1", true, nil),
-					ofMock.EXPECT().WriteMessage("message1"),
-					dbMock.EXPECT().GetMessage(2, nil).Return("message2", true, nil),
-					ofMock.EXPECT().WriteMessage("message2"),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2, nil),
+					ofMock.EXPECT().WriteMessage(msg2),
 					osMock.EXPECT().FileExist("attachment1.heic").Return(false, nil),
 					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt"),
-					ofMock.EXPECT().ReferenceAttachment("att1transfer.heic").Return(errors.New("this is a permissions error")),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att1transfer.heic")).Return(errors.New("this is a permissions error")),
 					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf"),
 				)
 			},
@@ -473,10 +720,10 @@ func TestWriteFile(t *testing.T) {
 					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt").Return(chatFile, nil),
 					osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMock),
-					dbMock.EXPECT().GetMessage(1, nil).Return("message1", true, nil),
-					ofMock.EXPECT().WriteMessage("message1"),
-					dbMock.EXPECT().GetMessage(2, nil).Return("message2", true, nil),
-					ofMock.EXPECT().WriteMessage("message2"),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2, nil),
+					ofMock.EXPECT().WriteMessage(msg2),
 					osMock.EXPECT().FileExist("attachment1.heic").Return(false, errors.New("this is a permissions error")),
 					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt"),
 				)
@@ -492,10 +739,10 @@ func TestWriteFile(t *testing.T) {
 					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt").Return(chatFile, nil),
 					osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMock),
-					dbMock.EXPECT().GetMessage(1, nil).Return("message1", true, nil),
-					ofMock.EXPECT().WriteMessage("message1"),
-					dbMock.EXPECT().GetMessage(2, nil).Return("message2", true, nil),
-					ofMock.EXPECT().WriteMessage("message2"),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2, nil),
+					ofMock.EXPECT().WriteMessage(msg2),
 					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
 					osMock.EXPECT().MkdirAll("messages-export/bagoup-attachments", os.ModePerm).Return(errors.New("this is a permissions error")),
 					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt"),
@@ -512,13 +759,13 @@ func TestWriteFile(t *testing.T) {
 					osMock.EXPECT().MkdirAll("messages-export/friend/attachments", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt").Return(chatFile, nil),
 					osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMock),
-					dbMock.EXPECT().GetMessage(1, nil).Return("message1", true, nil),
-					ofMock.EXPECT().WriteMessage("message1"),
-					dbMock.EXPECT().GetMessage(2, nil).Return("message2", true, nil),
-					ofMock.EXPECT().WriteMessage("message2"),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2, nil),
+					ofMock.EXPECT().WriteMessage(msg2),
 					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
 					osMock.EXPECT().CopyFile("attachment1.heic", "messages-export/friend/attachments", true).Return("messages-export/friend/attachments/attachment1.heic", nil),
-					ofMock.EXPECT().WriteAttachment("messages-export/friend/attachments/attachment1.heic"),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("messages-export/friend/attachments/attachment1.heic")),
 					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
 					osMock.EXPECT().CopyFile("attachment2.jpeg", "messages-export/friend/attachments", true).Return("", errors.New("this is a permissions error")),
 					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt"),
@@ -534,19 +781,19 @@ func TestWriteFile(t *testing.T) {
 					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf").Return(chatFile, nil),
 					osMock.EXPECT().NewWeasyPrintFile("friend", chatFile, false).Return(ofMock),
-					dbMock.EXPECT().GetMessage(1, nil).Return("message1", true, nil),
-					ofMock.EXPECT().WriteMessage("message1"),
-					dbMock.EXPECT().GetMessage(2, nil).Return("message2", true, nil),
-					ofMock.EXPECT().WriteMessage("message2"),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2, nil),
+					ofMock.EXPECT().WriteMessage(msg2),
 					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
 					icMock.EXPECT().ConvertHEIC("attachment1.heic").Return("attachment1.heic", errors.New("this is a goheif error")),
 					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf"),
-					ofMock.EXPECT().WriteAttachment("attachment1.heic"),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment1.heic")),
 					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
 					icMock.EXPECT().ConvertHEIC("attachment2.jpeg").Return("attachment2.jpeg", nil),
-					ofMock.EXPECT().WriteAttachment("attachment2.jpeg").Return(true, nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment2.jpeg")).Return(true, nil),
 					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf"),
-					ofMock.EXPECT().ReferenceAttachment("att3transfer.png"),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att3transfer.png")),
 					ofMock.EXPECT().Stage(),
 					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
 					ofMock.EXPECT().Flush(),
@@ -563,19 +810,160 @@ func TestWriteFile(t *testing.T) {
 					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt").Return(chatFile, nil),
 					osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMock),
-					dbMock.EXPECT().GetMessage(1, nil).Return("message1", true, nil),
-					ofMock.EXPECT().WriteMessage("message1"),
-					dbMock.EXPECT().GetMessage(2, nil).Return("message2", true, nil),
-					ofMock.EXPECT().WriteMessage("message2"),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2, nil),
+					ofMock.EXPECT().WriteMessage(msg2),
 					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
-					ofMock.EXPECT().WriteAttachment("attachment1.heic"),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment1.heic")),
 					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
-					ofMock.EXPECT().WriteAttachment("attachment2.jpeg").Return(false, errors.New("this is an outfile error")),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment2.jpeg")).Return(false, errors.New("this is an outfile error")),
 					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt"),
 				)
 			},
 			wantErr: `chat file "messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt" - message 2: include attachment "attachment2.jpeg": this is an outfile error`,
 		},
+		{
+			msg: "audio message with transcription",
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB, osMock *mock_opsys.MockOS, _ *mock_imgconv.MockImgConverter, ofMock *mock_opsys.MockOutFile) {
+				gomock.InOrder(
+					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
+					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt").Return(chatFile, nil),
+					osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMock),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2Audio, nil),
+					ofMock.EXPECT().WriteMessage(msg2Audio),
+					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment1.heic")),
+					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment2.jpeg")),
+					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt"),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att3transfer.png")),
+					ofMock.EXPECT().WriteTranscription("see you soon"),
+					ofMock.EXPECT().Stage(),
+					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
+					ofMock.EXPECT().Flush(),
+				)
+			},
+			wantJPGs: 1,
+		},
+		{
+			msg: "WriteTranscription error",
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB, osMock *mock_opsys.MockOS, _ *mock_imgconv.MockImgConverter, ofMock *mock_opsys.MockOutFile) {
+				gomock.InOrder(
+					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
+					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt").Return(chatFile, nil),
+					osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMock),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2Audio, nil),
+					ofMock.EXPECT().WriteMessage(msg2Audio),
+					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment1.heic")),
+					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment2.jpeg")),
+					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt"),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att3transfer.png")),
+					ofMock.EXPECT().WriteTranscription("see you soon").Return(errors.New("this is an outfile error")),
+					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt"),
+				)
+			},
+			wantErr: `write transcription of message 2 to file "messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt": this is an outfile error`,
+		},
+		{
+			msg: "sketch - text export",
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB, osMock *mock_opsys.MockOS, _ *mock_imgconv.MockImgConverter, ofMock *mock_opsys.MockOutFile) {
+				gomock.InOrder(
+					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
+					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt").Return(chatFile, nil),
+					osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMock),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2Sketch, nil),
+					ofMock.EXPECT().WriteMessage(msg2Sketch),
+					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment1.heic")),
+					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment2.jpeg")),
+					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt"),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att3transfer.png")),
+					osMock.EXPECT().RenderSketch(*msg2Sketch.Sketch, "sketch-2.svg").Return("tmp/sketch-2.svg", nil),
+					osMock.EXPECT().MkdirAll("messages-export/friend/attachments", os.ModePerm),
+					osMock.EXPECT().CopyFile("tmp/sketch-2.svg", "messages-export/friend/attachments", true).Return("messages-export/friend/attachments/sketch-2.svg", nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("messages-export/friend/attachments/sketch-2.svg")),
+					ofMock.EXPECT().Stage(),
+					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
+					ofMock.EXPECT().Flush(),
+				)
+			},
+			wantSketches: 1,
+			wantJPGs:     1,
+		},
+		{
+			msg:             "sketch - pdf export with copied attachments",
+			pdf:             true,
+			copyAttachments: true,
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB, osMock *mock_opsys.MockOS, icMock *mock_imgconv.MockImgConverter, ofMock *mock_opsys.MockOutFile) {
+				gomock.InOrder(
+					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
+					osMock.EXPECT().MkdirAll("messages-export/friend/attachments", os.ModePerm),
+					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf").Return(chatFile, nil),
+					osMock.EXPECT().NewWeasyPrintFile("friend", chatFile, false).Return(ofMock),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2Sketch, nil),
+					ofMock.EXPECT().WriteMessage(msg2Sketch),
+					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
+					osMock.EXPECT().CopyFile("attachment1.heic", "messages-export/friend/attachments", true).Return("messages-export/friend/attachments/attachment1.heic", nil),
+					icMock.EXPECT().ConvertHEIC("messages-export/friend/attachments/attachment1.heic").Return("tmp/attachment1.jpeg", nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("tmp/attachment1.jpeg")).Return(true, nil),
+					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
+					osMock.EXPECT().CopyFile("attachment2.jpeg", "messages-export/friend/attachments", true).Return("messages-export/friend/attachments/attachment2.jpeg", nil),
+					icMock.EXPECT().ConvertHEIC("messages-export/friend/attachments/attachment2.jpeg").Return("messages-export/friend/attachments/attachment2.jpeg", nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("messages-export/friend/attachments/attachment2.jpeg")).Return(true, nil),
+					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf"),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att3transfer.png")),
+					osMock.EXPECT().RenderSketch(*msg2Sketch.Sketch, "sketch-2.svg").Return("tmp/sketch-2.svg", nil),
+					osMock.EXPECT().CopyFile("tmp/sketch-2.svg", "messages-export/friend/attachments", true).Return("messages-export/friend/attachments/sketch-2.svg", nil),
+					icMock.EXPECT().ConvertHEIC("messages-export/friend/attachments/sketch-2.svg").Return("messages-export/friend/attachments/sketch-2.svg", nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("messages-export/friend/attachments/sketch-2.svg")).Return(true, nil),
+					ofMock.EXPECT().Stage(),
+					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
+					ofMock.EXPECT().Flush(),
+				)
+			},
+			wantSketches: 1,
+			wantJPGs:     2,
+			wantEmbedded: 2,
+			wantConv:     1,
+		},
+		{
+			msg: "RenderSketch error",
+			pdf: true,
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB, osMock *mock_opsys.MockOS, icMock *mock_imgconv.MockImgConverter, ofMock *mock_opsys.MockOutFile) {
+				gomock.InOrder(
+					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
+					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf").Return(chatFile, nil),
+					osMock.EXPECT().NewWeasyPrintFile("friend", chatFile, false).Return(ofMock),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2Sketch, nil),
+					ofMock.EXPECT().WriteMessage(msg2Sketch),
+					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
+					icMock.EXPECT().ConvertHEIC("attachment1.heic").Return("attachment1.jpeg", nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment1.jpeg")).Return(true, nil),
+					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
+					icMock.EXPECT().ConvertHEIC("attachment2.jpeg").Return("attachment2.jpeg", nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment2.jpeg")).Return(true, nil),
+					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf"),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att3transfer.png")),
+					osMock.EXPECT().RenderSketch(*msg2Sketch.Sketch, "sketch-2.svg").Return("", errors.New("this is a render error")),
+					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf"),
+				)
+			},
+			wantErr: `chat file "messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.pdf" - message 2: render sketch: this is a render error`,
+		},
 		{
 			msg: "1 message invalid",
 			setupMocks: func(dbMock *mock_chatdb.MockChatDB, osMock *mock_opsys.MockOS, _ *mock_imgconv.MockImgConverter, ofMock *mock_opsys.MockOutFile) {
@@ -583,16 +971,16 @@ func TestWriteFile(t *testing.T) {
 					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
 					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt").Return(chatFile, nil),
 					osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMock),
-					dbMock.EXPECT().GetMessage(1, nil).Return("message1", true, nil),
-					ofMock.EXPECT().WriteMessage("message1"),
-					dbMock.EXPECT().GetMessage(2, nil).Return("", false, nil),
-					ofMock.EXPECT().WriteMessage(""),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2Invalid, nil),
+					ofMock.EXPECT().WriteMessage(msg2Invalid),
 					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
-					ofMock.EXPECT().WriteAttachment("attachment1.heic"),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment1.heic")),
 					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
-					ofMock.EXPECT().WriteAttachment("attachment2.jpeg"),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment2.jpeg")),
 					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt"),
-					ofMock.EXPECT().ReferenceAttachment("att3transfer.png"),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att3transfer.png")),
 					ofMock.EXPECT().Stage(),
 					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
 					ofMock.EXPECT().Flush(),
@@ -601,6 +989,31 @@ func TestWriteFile(t *testing.T) {
 			wantInvalid: 1,
 			wantJPGs:    1,
 		},
+		{
+			msg: "1 message recovered",
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB, osMock *mock_opsys.MockOS, _ *mock_imgconv.MockImgConverter, ofMock *mock_opsys.MockOutFile) {
+				gomock.InOrder(
+					osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
+					osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt").Return(chatFile, nil),
+					osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMock),
+					dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+					ofMock.EXPECT().WriteMessage(msg1),
+					dbMock.EXPECT().GetMessage(2, nil).Return(msg2Recovered, nil),
+					ofMock.EXPECT().WriteMessage(msg2Recovered),
+					osMock.EXPECT().FileExist("attachment1.heic").Return(true, nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment1.heic")),
+					osMock.EXPECT().FileExist("attachment2.jpeg").Return(true, nil),
+					ofMock.EXPECT().WriteAttachment(attachmentAt("attachment2.jpeg")),
+					ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com;;;iMessage;-;friend@hotmail.com.txt"),
+					ofMock.EXPECT().ReferenceAttachment(attachmentNamed("att3transfer.png")),
+					ofMock.EXPECT().Stage(),
+					osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
+					ofMock.EXPECT().Flush(),
+				)
+			},
+			wantRecovered: 1,
+			wantJPGs:      1,
+		},
 	}
 
 	for _, tt := range tests {
@@ -621,6 +1034,7 @@ func TestWriteFile(t *testing.T) {
 			cfg := configuration{
 				Options: Options{
 					ExportPath:      "messages-export",
+					Format:          tt.format,
 					OutputPDF:       tt.pdf,
 					UseWkhtmltopdf:  tt.wkhtml,
 					CopyAttachments: tt.copyAttachments,
@@ -640,7 +1054,10 @@ func TestWriteFile(t *testing.T) {
 				counts: cnts,
 			}
 			err := cfg.writeFile(
-				"friend",
+				chatdb.EntityChats{Name: "friend", Avatar: tt.avatar, Chats: []chatdb.Chat{
+					{GUID: "iMessage;-;friend@gmail.com", Group: tt.group, SenderNames: tt.senderNames, Participants: []string{"friend"}},
+					{GUID: "iMessage;-;friend@yahoo.com", Participants: []string{"not exported"}},
+				}},
 				[]string{"iMessage;-;friend@gmail.com", "iMessage;-;friend@hotmail.com"},
 				[]chatdb.DatedMessageID{
 					{ID: 2, Date: 2},
@@ -652,8 +1069,10 @@ func TestWriteFile(t *testing.T) {
 				return
 			}
 			assert.NilError(t, err)
-			assert.Equal(t, cfg.counts.messages, 2-tt.wantInvalid)
+			assert.Equal(t, cfg.counts.messages, 2-tt.wantRecovered-tt.wantInvalid)
+			assert.Equal(t, cfg.counts.messagesRecovered, tt.wantRecovered)
 			assert.Equal(t, cfg.counts.messagesInvalid, tt.wantInvalid)
+			assert.Equal(t, cfg.counts.attachments["image/svg+xml"], tt.wantSketches)
 			assert.Equal(t, cfg.counts.attachments["image/jpeg"], tt.wantJPGs)
 			assert.Equal(t, cfg.counts.attachmentsEmbedded["image/jpeg"], tt.wantEmbedded)
 			assert.Equal(t, cfg.counts.conversions, tt.wantConv)
@@ -661,6 +1080,201 @@ func TestWriteFile(t *testing.T) {
 		})
 	}
 
+	t.Run("search", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		dbMock := mock_chatdb.NewMockChatDB(ctrl)
+		osMock := mock_opsys.NewMockOS(ctrl)
+		ofMock := mock_opsys.NewMockOutFile(ctrl)
+		msg3 := chatdb.Message{ID: 3, Date: msg2.Date.Add(time.Minute), Sender: "friend", Text: "Message3", Status: chatdb.TextValid}
+		gomock.InOrder(
+			dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+			dbMock.EXPECT().GetMessage(2, nil).Return(msg2, nil),
+			dbMock.EXPECT().GetMessage(3, nil).Return(msg3, nil),
+			osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
+			osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com.txt").Return(chatFile, nil),
+			osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMock),
+			// The messages read by the search are not read again.
+			ofMock.EXPECT().WriteMessage(msg1),
+			ofMock.EXPECT().WriteSeparator(),
+			ofMock.EXPECT().WriteMessage(msg3),
+			ofMock.EXPECT().Stage(),
+			osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
+			ofMock.EXPECT().Flush(),
+		)
+
+		search, err := newSearch(nil, []string{"[13]$"})
+		assert.NilError(t, err)
+		cfg := configuration{
+			Options: Options{ExportPath: "messages-export"},
+			OS:      osMock,
+			ChatDB:  dbMock,
+			search:  search,
+		}
+		err = cfg.writeFile(
+			chatdb.EntityChats{Name: "friend"},
+			[]string{"iMessage;-;friend@gmail.com"},
+			[]chatdb.DatedMessageID{{ID: 3, Date: 3}, {ID: 1, Date: 1}, {ID: 2, Date: 2}},
+		)
+		assert.NilError(t, err)
+		assert.Equal(t, cfg.counts.searchMatches, 2)
+		assert.Equal(t, cfg.counts.messages, 2)
+		assert.Equal(t, cfg.counts.files, 1)
+	})
+
+	t.Run("search without matches", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		dbMock := mock_chatdb.NewMockChatDB(ctrl)
+		dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil)
+
+		search, err := newSearch([]string{"lunch"}, nil)
+		assert.NilError(t, err)
+		cfg := configuration{ChatDB: dbMock, search: search}
+		err = cfg.writeFile(
+			chatdb.EntityChats{Name: "friend"},
+			[]string{"iMessage;-;friend@gmail.com"},
+			[]chatdb.DatedMessageID{{ID: 1, Date: 1}},
+		)
+		assert.NilError(t, err)
+		assert.Equal(t, cfg.counts.files, 0)
+	})
+
+	t.Run("stream to stdout", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		dbMock := mock_chatdb.NewMockChatDB(ctrl)
+		osMock := mock_opsys.NewMockOS(ctrl)
+		ofMock := mock_opsys.NewMockOutFile(ctrl)
+		gomock.InOrder(
+			osMock.EXPECT().NewJSONLFile("friend", []string{"iMessage;-;friend@gmail.com"}, chatFile).Return(ofMock),
+			dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+			ofMock.EXPECT().WriteMessage(msg1),
+			ofMock.EXPECT().Stage(),
+			osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
+			ofMock.EXPECT().Flush(),
+		)
+
+		cfg := configuration{
+			Options: Options{ExportPath: "-", Format: "jsonl"},
+			OS:      osMock,
+			ChatDB:  dbMock,
+			stdout:  chatFile,
+		}
+		err := cfg.writeFile(
+			chatdb.EntityChats{Name: "friend"},
+			[]string{"iMessage;-;friend@gmail.com"},
+			[]chatdb.DatedMessageID{{ID: 1, Date: 1}},
+		)
+		assert.NilError(t, err)
+		assert.Equal(t, cfg.counts.messages, 1)
+	})
+
+	t.Run("combined CSV", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		dbMock := mock_chatdb.NewMockChatDB(ctrl)
+		osMock := mock_opsys.NewMockOS(ctrl)
+		ofMocks := []*mock_opsys.MockOutFile{mock_opsys.NewMockOutFile(ctrl), mock_opsys.NewMockOutFile(ctrl)}
+		msg1Chat := msg1
+		msg1Chat.ChatGUID = "iMessage;-;friend@gmail.com"
+		gomock.InOrder(
+			osMock.EXPECT().NewCSVFile("friend", chatFile, true).Return(ofMocks[0]),
+			dbMock.EXPECT().GetMessage(1, nil).Return(msg1, nil),
+			ofMocks[0].EXPECT().WriteMessage(msg1Chat),
+			ofMocks[0].EXPECT().Stage(),
+			osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
+			ofMocks[0].EXPECT().Flush(),
+			osMock.EXPECT().NewCSVFile("other friend", chatFile, false).Return(ofMocks[1]),
+			ofMocks[1].EXPECT().Stage(),
+			osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
+			ofMocks[1].EXPECT().Flush(),
+		)
+
+		cfg := configuration{
+			Options:     Options{ExportPath: "messages-export", Format: "csv", CombinedCSV: true},
+			OS:          osMock,
+			ChatDB:      dbMock,
+			combinedCSV: chatFile,
+		}
+		assert.NilError(t, cfg.writeFile(
+			chatdb.EntityChats{Name: "friend"},
+			[]string{"iMessage;-;friend@gmail.com"},
+			[]chatdb.DatedMessageID{{ID: 1, Date: 1, ChatGUID: "iMessage;-;friend@gmail.com"}},
+		))
+		assert.NilError(t, cfg.writeFile(
+			chatdb.EntityChats{Name: "other friend"},
+			[]string{"iMessage;-;otherfriend@gmail.com"},
+			nil,
+		))
+	})
+
+	t.Run("rendered attachments", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		dbMock := mock_chatdb.NewMockChatDB(ctrl)
+		osMock := mock_opsys.NewMockOS(ctrl)
+		ofMock := mock_opsys.NewMockOutFile(ctrl)
+		notCopied := gomock.Cond(func(att chatdb.Attachment) bool { return att.CopiedPath == "" })
+		gomock.InOrder(
+			osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm),
+			osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com.txt").Return(chatFile, nil),
+			osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMock),
+			dbMock.EXPECT().GetMessage(2, nil).Return(msg2, nil),
+			ofMock.EXPECT().WriteMessage(msg2),
+			osMock.EXPECT().FileExist("/old-export/friend/attachments/copied.jpeg").Return(true, nil),
+			osMock.EXPECT().FileExist("/old-export/friend/attachments/copied.jpeg").Return(true, nil),
+			ofMock.EXPECT().WriteAttachment(gomock.All(attachmentAt("/old-export/friend/attachments/copied.jpeg"), notCopied)),
+			osMock.EXPECT().FileExist("/old-export/friend/attachments/deleted.jpeg").Return(false, nil),
+			osMock.EXPECT().FileExist("/mnt/mac/Users/me/Library/Messages/Attachments/deleted.jpeg").Return(true, nil),
+			ofMock.EXPECT().WriteAttachment(gomock.All(attachmentAt("/mnt/mac/Users/me/Library/Messages/Attachments/deleted.jpeg"), notCopied)),
+			osMock.EXPECT().FileExist("/mnt/mac/Users/me/Library/Messages/Attachments/missing.png").Return(false, nil),
+			ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com.txt"),
+			ofMock.EXPECT().ReferenceAttachment(attachmentNamed("missing.png")),
+			ofMock.EXPECT().Stage(),
+			osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
+			ofMock.EXPECT().Flush(),
+		)
+
+		cfg := configuration{
+			Options: Options{ExportPath: "messages-export", AttachmentsPath: "/mnt/mac"},
+			OS:      osMock,
+			ChatDB:  dbMock,
+			attachmentPaths: map[int][]chatdb.Attachment{
+				2: {
+					{Filename: "/Users/me/Library/Messages/Attachments/copied.jpeg", CopiedPath: "/old-export/friend/attachments/copied.jpeg", MIMEType: "image/jpeg", TransferName: "copied.jpeg"},
+					{Filename: "/Users/me/Library/Messages/Attachments/deleted.jpeg", CopiedPath: "/old-export/friend/attachments/deleted.jpeg", MIMEType: "image/jpeg", TransferName: "deleted.jpeg"},
+					{Filename: "/Users/me/Library/Messages/Attachments/missing.png", MIMEType: "image/png", TransferName: "missing.png"},
+				},
+			},
+			counts: counts{
+				attachments:         map[string]int{},
+				attachmentsCopied:   map[string]int{},
+				attachmentsEmbedded: map[string]int{},
+			},
+		}
+		assert.NilError(t, cfg.writeFile(
+			chatdb.EntityChats{Name: "friend"},
+			[]string{"iMessage;-;friend@gmail.com"},
+			[]chatdb.DatedMessageID{{ID: 2, Date: 2}},
+		))
+		assert.Equal(t, cfg.counts.attachments["image/jpeg"], 2)
+		assert.Equal(t, cfg.counts.attachmentsMissing, 1)
+
+		osMock.EXPECT().MkdirAll("messages-export/friend", os.ModePerm)
+		osMock.EXPECT().Create("messages-export/friend/iMessage;-;friend@gmail.com.txt").Return(chatFile, nil)
+		osMock.EXPECT().NewTxtOutFile(chatFile).Return(ofMock)
+		dbMock.EXPECT().GetMessage(2, nil).Return(msg2, nil)
+		ofMock.EXPECT().WriteMessage(msg2)
+		osMock.EXPECT().FileExist("/old-export/friend/attachments/copied.jpeg").Return(false, errors.New("this is a stat error"))
+		ofMock.EXPECT().Name().Return("messages-export/friend/iMessage;-;friend@gmail.com.txt")
+		assert.ErrorContains(t, cfg.writeFile(
+			chatdb.EntityChats{Name: "friend"},
+			[]string{"iMessage;-;friend@gmail.com"},
+			[]chatdb.DatedMessageID{{ID: 2, Date: 2}},
+		), `check existence of file "/old-export/friend/attachments/copied.jpeg": this is a stat error`)
+	})
+
 	t.Run("long email address", func(t *testing.T) {
 		ctrl := gomock.NewController(t)
 		defer ctrl.Finish()
@@ -677,7 +1291,7 @@ func TestWriteFile(t *testing.T) {
 
 		cfg := configuration{OS: osMock}
 		cfg.writeFile(
-			"friend",
+			chatdb.EntityChats{Name: "friend"},
 			[]string{"iMessage;-;heresareallylongemailaddress.heresareallylongemailaddress.heresareallylongemailaddress.heresareallylongemailaddress.heresareallylongemailaddress.heresareallylongemailaddress.heresareallylongemailaddress.heresareallylongemailaddress@gmail.com"},
 			nil,
 		)
@@ -703,10 +1317,10 @@ func TestWriteFile(t *testing.T) {
 		}
 		for i := 0; i < 2048; i++ {
 			msgs = append(msgs, chatdb.DatedMessageID{ID: i, Date: i})
-			msg := fmt.Sprintf("message%d", i)
+			msg := chatdb.Message{ID: i, Text: fmt.Sprintf("message%d", i), Status: chatdb.TextValid}
 			mockCalls = append(
 				mockCalls,
-				dbMock.EXPECT().GetMessage(i, nil).Return(msg, true, nil),
+				dbMock.EXPECT().GetMessage(i, nil).Return(msg, nil),
 				ofMock1.EXPECT().WriteMessage(msg),
 			)
 		}
@@ -720,10 +1334,10 @@ func TestWriteFile(t *testing.T) {
 		)
 		for i := 2048; i < 4000; i++ {
 			msgs = append(msgs, chatdb.DatedMessageID{ID: i, Date: i})
-			msg := fmt.Sprintf("message%d", i)
+			msg := chatdb.Message{ID: i, Text: fmt.Sprintf("message%d", i), Status: chatdb.TextValid}
 			mockCalls = append(
 				mockCalls,
-				dbMock.EXPECT().GetMessage(i, nil).Return(msg, true, nil),
+				dbMock.EXPECT().GetMessage(i, nil).Return(msg, nil),
 				ofMock2.EXPECT().WriteMessage(msg),
 			)
 		}
@@ -751,12 +1365,13 @@ func TestWriteFile(t *testing.T) {
 			counts:          cnts,
 		}
 		err = cfg.writeFile(
-			"friend",
+			chatdb.EntityChats{Name: "friend"},
 			[]string{"iMessage;-;friend@gmail.com"},
 			msgs,
 		)
 		assert.NilError(t, err)
 		assert.Equal(t, cfg.counts.messages, 4000)
+		assert.Equal(t, cfg.counts.messagesRecovered, 0)
 		assert.Equal(t, cfg.counts.messagesInvalid, 0)
 		assert.Equal(t, len(cfg.counts.attachments), 0)
 		assert.Equal(t, len(cfg.counts.attachmentsEmbedded), 0)
@@ -764,3 +1379,77 @@ func TestWriteFile(t *testing.T) {
 		assert.Equal(t, cfg.counts.conversionsFailed, 0)
 	})
 }
+
+// attachmentAt matches an attachment to be written from the given path.
+func attachmentAt(path string) gomock.Matcher {
+	return gomock.Cond(func(att chatdb.Attachment) bool { return att.Filepath == path })
+}
+
+func TestHandleSketchArchive(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cfg := configuration{
+		OS:      mock_opsys.NewMockOS(ctrl),
+		archive: mock_archive.NewMockArchive(ctrl),
+	}
+	msg := chatdb.Message{ID: 2, Sketch: &chatdb.Sketch{Width: 300, Height: 300}}
+	assert.NilError(t, cfg.handleSketch(mock_opsys.NewMockOutFile(ctrl), msg, "messages-export/friend/attachments"))
+}
+
+// attachmentNamed matches an attachment to be referenced by the given name.
+func attachmentNamed(name string) gomock.Matcher {
+	return gomock.Cond(func(att chatdb.Attachment) bool { return att.TransferName == name })
+}
+
+func TestWriteEPUB(t *testing.T) {
+	chatFile, err := afero.NewMemMapFs().Create("testfile")
+	assert.NilError(t, err)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dbMock := mock_chatdb.NewMockChatDB(ctrl)
+	osMock := mock_opsys.NewMockOS(ctrl)
+	icMock := mock_imgconv.NewMockImgConverter(ctrl)
+	ofMock := mock_opsys.NewMockOutFile(ctrl)
+	msg := chatdb.Message{ID: 1, Text: "￼", SenderAvatar: "avatar-alex.jpg"}
+	gomock.InOrder(
+		osMock.EXPECT().MkdirAll("messages-export/Book Club", os.ModePerm),
+		osMock.EXPECT().Create("messages-export/Book Club/iMessage;+;chat123456.epub").Return(chatFile, nil),
+		osMock.EXPECT().NewEPUBFile("Book Club", chatFile).Return(ofMock),
+		ofMock.EXPECT().SetAvatar("avatar-club.jpg"),
+		dbMock.EXPECT().GetMessage(1, nil).Return(msg, nil),
+		ofMock.EXPECT().WriteAvatar("avatar-alex.jpg"),
+		ofMock.EXPECT().WriteMessage(msg),
+		osMock.EXPECT().FileExist("/attachments/IMG_0001.heic").Return(true, nil),
+		icMock.EXPECT().ConvertHEIC("/attachments/IMG_0001.heic").Return("tmp/IMG_0001.jpeg", nil),
+		ofMock.EXPECT().WriteAttachment(chatdb.Attachment{Filename: "attachments/IMG_0001.heic", Filepath: "tmp/IMG_0001.jpeg", MIMEType: "image/jpeg"}).Return(true, nil),
+		ofMock.EXPECT().Stage(),
+		osMock.EXPECT().GetOpenFilesLimit().Return(256, nil),
+		ofMock.EXPECT().Flush(),
+	)
+
+	cfg := configuration{
+		Options: Options{
+			ExportPath:      "messages-export",
+			Format:          "epub",
+			AttachmentsPath: "/",
+		},
+		OS:           osMock,
+		ChatDB:       dbMock,
+		ImgConverter: icMock,
+		attachmentPaths: map[int][]chatdb.Attachment{
+			1: {{Filename: "attachments/IMG_0001.heic", MIMEType: "image/heic"}},
+		},
+		counts: counts{
+			attachments:         map[string]int{},
+			attachmentsEmbedded: map[string]int{},
+		},
+	}
+	entity := chatdb.EntityChats{
+		Name:   "Book Club",
+		Avatar: "avatar-club.jpg",
+		Chats:  []chatdb.Chat{{GUID: "iMessage;+;chat123456", Group: true}},
+	}
+	assert.NilError(t, cfg.writeFile(entity, []string{"iMessage;+;chat123456"}, []chatdb.DatedMessageID{{ID: 1}}))
+	assert.Equal(t, cfg.counts.conversions, 1)
+	assert.Equal(t, cfg.counts.attachmentsEmbedded["image/jpeg"], 1)
+}
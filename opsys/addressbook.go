@@ -0,0 +1,160 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package opsys
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"path/filepath"
+
+	"github.com/emersion/go-vcard"
+	"github.com/spf13/afero"
+
+	// Register the sqlite3 driver for reading AddressBook databases.
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const _addressBookDBFilename = "AddressBook-v22.abcddb"
+
+func (s *opSys) GetAddressBookContactMap(addressBookDir string, opts ContactOptions) (map[string]*vcard.Card, []ContactCollision, error) {
+	// Contacts.app keeps a database for each account (iCloud, Google, etc.)
+	// under Sources, plus one for contacts stored only on this Mac.
+	dbPaths, err := afero.Glob(s.Fs, filepath.Join(addressBookDir, "Sources", "*", _addressBookDBFilename))
+	if err != nil {
+		return nil, nil, fmt.Errorf("find AddressBook sources in %q: %w", addressBookDir, err)
+	}
+	localDBPath := filepath.Join(addressBookDir, _addressBookDBFilename)
+	if ok, err := afero.Exists(s.Fs, localDBPath); err != nil {
+		return nil, nil, fmt.Errorf("check existence of file %q: %w", localDBPath, err)
+	} else if ok {
+		dbPaths = append(dbPaths, localDBPath)
+	}
+	if len(dbPaths) == 0 {
+		return nil, nil, fmt.Errorf("no AddressBook databases found in %q", addressBookDir)
+	}
+	sources := make([][]*vcard.Card, 0, len(dbPaths))
+	for _, dbPath := range dbPaths {
+		cards, err := readAddressBook(dbPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read AddressBook database %q: %w", dbPath, err)
+		}
+		sources = append(sources, cards)
+	}
+	return s.indexContactSources(sources, opts)
+}
+
+// readAddressBook converts each contact in the given AddressBook database to a
+// vCard.
+func readAddressBook(dbPath string) ([]*vcard.Card, error) {
+	// The path is escaped so that any '?', '#', or '%' in it is not taken as
+	// part of the URI syntax. Relative paths cannot be written as file URIs.
+	absPath, err := filepath.Abs(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("get absolute path: %w", err)
+	}
+	dsn := (&url.URL{Scheme: "file", Path: filepath.ToSlash(absPath), RawQuery: "mode=ro"}).String()
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	defer db.Close()
+
+	// Older databases have no thumbnails of the contact photos.
+	thumbnailColumn := "NULL"
+	if ok, err := hasThumbnails(db); err != nil {
+		return nil, err
+	} else if ok {
+		thumbnailColumn = "ZTHUMBNAILIMAGEDATA"
+	}
+	// The records table also holds groups and other records which are not
+	// people, so only the records of the contact entity are read.
+	records, err := db.Query(`SELECT Z_PK,
+		COALESCE(ZFIRSTNAME, ''), COALESCE(ZMIDDLENAME, ''), COALESCE(ZLASTNAME, ''),
+		COALESCE(ZTITLE, ''), COALESCE(ZSUFFIX, ''), COALESCE(ZNICKNAME, ''), COALESCE(ZORGANIZATION, ''),
+		` + thumbnailColumn + `
+		FROM ZABCDRECORD
+		WHERE Z_ENT = (SELECT Z_ENT FROM Z_PRIMARYKEY WHERE Z_NAME = 'ABCDContact')`)
+	if err != nil {
+		return nil, fmt.Errorf("query records: %w", err)
+	}
+	defer records.Close()
+	cardsByID := map[int]*vcard.Card{}
+	cards := []*vcard.Card{}
+	for records.Next() {
+		var id int
+		var name vcard.Name
+		var nickname, org string
+		var thumbnail []byte
+		if err := records.Scan(&id, &name.GivenName, &name.AdditionalName, &name.FamilyName, &name.HonorificPrefix, &name.HonorificSuffix, &nickname, &org, &thumbnail); err != nil {
+			return nil, fmt.Errorf("read record: %w", err)
+		}
+		card := newContactCard(name, "", nickname, org)
+		// The image data of a thumbnail follows a version byte.
+		if len(thumbnail) > 1 && thumbnail[0] == 0x01 {
+			card.Add(vcard.FieldPhoto, &vcard.Field{
+				Value:  base64.StdEncoding.EncodeToString(thumbnail[1:]),
+				Params: vcard.Params{"ENCODING": {"b"}},
+			})
+		}
+		cardsByID[id] = card
+		cards = append(cards, card)
+	}
+	if err := records.Err(); err != nil {
+		return nil, fmt.Errorf("read records: %w", err)
+	}
+
+	for _, q := range []struct {
+		field string
+		query string
+	}{
+		{vcard.FieldTelephone, "SELECT ZOWNER, ZFULLNUMBER FROM ZABCDPHONENUMBER WHERE ZOWNER IS NOT NULL AND ZFULLNUMBER IS NOT NULL ORDER BY ZORDERINGINDEX"},
+		{vcard.FieldEmail, "SELECT ZOWNER, ZADDRESS FROM ZABCDEMAILADDRESS WHERE ZOWNER IS NOT NULL AND ZADDRESS IS NOT NULL ORDER BY ZORDERINGINDEX"},
+	} {
+		if err := addAddressBookValues(db, q.query, q.field, cardsByID); err != nil {
+			return nil, err
+		}
+	}
+	return cards, nil
+}
+
+// hasThumbnails reports whether the records of the AddressBook database have
+// thumbnails of the contact photos.
+func hasThumbnails(db *sql.DB) (bool, error) {
+	rows, err := db.Query("SELECT name FROM pragma_table_info('ZABCDRECORD')")
+	if err != nil {
+		return false, fmt.Errorf("get record table info: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return false, fmt.Errorf("read record column info: %w", err)
+		}
+		if column == "ZTHUMBNAILIMAGEDATA" {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+func addAddressBookValues(db *sql.DB, query, field string, cardsByID map[int]*vcard.Card) error {
+	rows, err := db.Query(query)
+	if err != nil {
+		return fmt.Errorf("query %s values: %w", field, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var owner int
+		var value string
+		if err := rows.Scan(&owner, &value); err != nil {
+			return fmt.Errorf("read %s value: %w", field, err)
+		}
+		if card, ok := cardsByID[owner]; ok {
+			card.AddValue(field, value)
+		}
+	}
+	return rows.Err()
+}
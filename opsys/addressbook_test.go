@@ -0,0 +1,145 @@
+package opsys
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-vcard"
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/chatdb"
+	"gotest.tools/v3/assert"
+)
+
+func TestGetAddressBookContactMap(t *testing.T) {
+	tagCard := &vcard.Card{
+		"VERSION":  []*vcard.Field{{Value: "3.0"}},
+		"FN":       []*vcard.Field{{Value: "David Tagatac"}},
+		"N":        []*vcard.Field{{Value: "Tagatac;David;;;"}},
+		"NICKNAME": []*vcard.Field{{Value: "Tag"}},
+		"TEL": []*vcard.Field{
+			{Value: "(415) 555-5555"},
+			{Value: "+1 415 555 1234"},
+		},
+		"EMAIL": []*vcard.Field{{Value: "David@Tagatac.net"}},
+	}
+	noleCard := &vcard.Card{
+		"VERSION": []*vcard.Field{{Value: "3.0"}},
+		"FN":      []*vcard.Field{{Value: "Mr. Novak Djokovic"}},
+		"N":       []*vcard.Field{{Value: "Djokovic;Novak;;Mr.;"}},
+		"TEL":     []*vcard.Field{{Value: "+381 11 555 5555"}},
+		"EMAIL":   []*vcard.Field{{Value: "info@novakdjokovic.com"}},
+	}
+	acmeCard := &vcard.Card{
+		"VERSION": []*vcard.Field{{Value: "3.0"}},
+		"FN":      []*vcard.Field{{Value: "Acme Pizza"}},
+		"N":       []*vcard.Field{{Value: ";;;;"}},
+		"ORG":     []*vcard.Field{{Value: "Acme Pizza"}},
+		"TEL":     []*vcard.Field{{Value: "415-555-0000"}},
+	}
+	jelenaCard := &vcard.Card{
+		"VERSION": []*vcard.Field{{Value: "3.0"}},
+		"FN":      []*vcard.Field{{Value: "Jelena Djokovic"}},
+		"N":       []*vcard.Field{{Value: "Djokovic;Jelena;;;"}},
+		"EMAIL":   []*vcard.Field{{Value: "info@novakdjokovic.com"}},
+	}
+	combinedCard := &vcard.Card{
+		"FN": []*vcard.Field{{Value: "Mr. Novak Djokovic and Jelena Djokovic"}},
+		"N":  []*vcard.Field{{Value: ";Novak or Jelena;;;"}},
+	}
+
+	tests := []struct {
+		msg            string
+		dir            string
+		wantMap        map[string]*vcard.Card
+		wantCollisions []ContactCollision
+		wantErr        string
+	}{
+		{
+			// The card of Novak in the second source is merged into the card
+			// of Novak in the first, with which it shares a phone number. The
+			// card of Jelena shares an email address with Novak under another
+			// name, so it collides with his.
+			msg: "merged sources",
+			dir: "testdata/AddressBook",
+			wantMap: map[string]*vcard.Card{
+				"+14155555555":           tagCard,
+				"+14155551234":           tagCard,
+				"david@tagatac.net":      tagCard,
+				"+381115555555":          noleCard,
+				"info@novakdjokovic.com": combinedCard,
+				"+14155550000":           acmeCard,
+			},
+			wantCollisions: []ContactCollision{
+				{Handle: "info@novakdjokovic.com", Cards: []*vcard.Card{noleCard, jelenaCard}, Resolved: combinedCard},
+			},
+		},
+		{
+			msg:     "no databases",
+			dir:     "testdata",
+			wantErr: `no AddressBook databases found in "testdata"`,
+		},
+		{
+			msg:     "corrupt database",
+			dir:     "testdata/AddressBookCorrupt",
+			wantErr: `read AddressBook database "testdata/AddressBookCorrupt/AddressBook-v22.abcddb": get record table info: file is not a database`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			s := NewOS(afero.NewOsFs(), nil, "")
+			contactMap, collisions, err := s.GetAddressBookContactMap(tt.dir, ContactOptions{DefaultRegion: "US"})
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, tt.wantMap, contactMap)
+			assert.DeepEqual(t, tt.wantCollisions, collisions)
+		})
+	}
+}
+
+func TestReadAddressBook(t *testing.T) {
+	// The source holds a group, Tennis Friends, as well as three contacts.
+	cards, err := readAddressBook("testdata/AddressBook/Sources/5A5F3E1C-0D6B-4E0C-9C1A-2B0F1E7D8C01/AddressBook-v22.abcddb")
+	assert.NilError(t, err)
+	var names []string
+	for _, card := range cards {
+		names = append(names, card.PreferredValue(vcard.FieldFormattedName))
+	}
+	assert.DeepEqual(t, names, []string{"David Tagatac", "Mr. Novak Djokovic", "Acme Pizza"})
+}
+
+func TestGetAddressBookContactMapAvatars(t *testing.T) {
+	s := NewOS(afero.NewOsFs(), nil, "")
+	defer s.RmTempDir()
+	contactMap, collisions, err := s.GetAddressBookContactMap("testdata/AddressBookPhotos", ContactOptions{Avatars: true})
+	assert.NilError(t, err)
+	assert.Equal(t, len(collisions), 0)
+	avatarPath := contactMap["+14155551234"].Value(chatdb.FieldAvatar)
+	assert.Assert(t, strings.HasSuffix(avatarPath, "/avatars/avatar-0.jpg"), avatarPath)
+	avatar, err := os.ReadFile(avatarPath)
+	assert.NilError(t, err)
+	assert.Equal(t, string(avatar), "\xff\xd8\xff\xe0\x00\x10JFIF\x00")
+	assert.Equal(t, contactMap["+381115555555"].Value(chatdb.FieldAvatar), "")
+}
+
+func TestGetAddressBookContactMapURIPath(t *testing.T) {
+	// A copy of an AddressBook directory whose path would be misread as part
+	// of a database URI if it were not escaped.
+	dir := filepath.Join(t.TempDir(), "AddressBook #2?mode=rwc%20")
+	assert.NilError(t, os.Mkdir(dir, 0755))
+	db, err := os.ReadFile("testdata/AddressBookPhotos/AddressBook-v22.abcddb")
+	assert.NilError(t, err)
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "AddressBook-v22.abcddb"), db, 0644))
+
+	s := NewOS(afero.NewOsFs(), nil, "")
+	contactMap, collisions, err := s.GetAddressBookContactMap(dir, ContactOptions{DefaultRegion: "US"})
+	assert.NilError(t, err)
+	assert.Equal(t, len(collisions), 0)
+	assert.Equal(t, contactMap["+14155551234"].PreferredValue(vcard.FieldFormattedName), "David Tagatac")
+	assert.Equal(t, contactMap["+381115555555"].PreferredValue(vcard.FieldFormattedName), "Novak Djokovic")
+}
@@ -0,0 +1,58 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package opsys
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/chatdb"
+	"github.com/tagatac/bagoup/v2/phonenum"
+	"gopkg.in/yaml.v3"
+)
+
+func (s opSys) GetAliases(aliasesFilePath, defaultRegion string) (chatdb.Aliases, error) {
+	contents, err := afero.ReadFile(s.Fs, aliasesFilePath)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]chatdb.Alias
+	switch ext := strings.ToLower(filepath.Ext(aliasesFilePath)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(contents))
+		dec.KnownFields(true)
+		if err := dec.Decode(&raw); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("decode YAML: %w", err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(contents), &raw)
+		if err != nil {
+			return nil, fmt.Errorf("decode TOML: %w", err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("decode TOML: unknown key %s", undecoded[0])
+		}
+	default:
+		return nil, fmt.Errorf("unsupported aliases file extension %q - FIX: use .yaml, .yml, or .toml", ext)
+	}
+
+	aliases := chatdb.Aliases{}
+	for key, alias := range raw {
+		if alias.Name == "" && alias.Entity == "" {
+			return nil, fmt.Errorf("alias for %q has neither a name nor an entity", key)
+		}
+		key = phonenum.Normalize(key, defaultRegion)
+		if _, ok := aliases[key]; ok {
+			return nil, fmt.Errorf("multiple aliases for %q", key)
+		}
+		aliases[key] = alias
+	}
+	return aliases, nil
+}
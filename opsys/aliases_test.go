@@ -0,0 +1,116 @@
+package opsys
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/chatdb"
+	"gotest.tools/v3/assert"
+)
+
+func TestGetAliases(t *testing.T) {
+	wantAliases := chatdb.Aliases{
+		"+15551234567":             {Name: "Johnny", Entity: "John Smith"},
+		"john@example.com":         {Entity: "John Smith"},
+		"262966":                   {Name: "Acme Bank"},
+		"iMessage;+;chat123456789": {Entity: "Book Club"},
+	}
+
+	tests := []struct {
+		msg         string
+		filename    string
+		contents    string
+		wantAliases chatdb.Aliases
+		wantErr     string
+	}{
+		{
+			msg:      "YAML",
+			filename: "aliases.yaml",
+			contents: `"(555) 123-4567":
+  name: Johnny
+  entity: John Smith
+John@Example.com:
+  entity: John Smith
+"262966":
+  name: Acme Bank
+"iMessage;+;chat123456789":
+  entity: Book Club
+`,
+			wantAliases: wantAliases,
+		},
+		{
+			msg:      "TOML",
+			filename: "aliases.TOML",
+			contents: `["(555) 123-4567"]
+name = "Johnny"
+entity = "John Smith"
+
+["John@Example.com"]
+entity = "John Smith"
+
+["262966"]
+name = "Acme Bank"
+
+["iMessage;+;chat123456789"]
+entity = "Book Club"
+`,
+			wantAliases: wantAliases,
+		},
+		{
+			msg:         "empty YAML file",
+			filename:    "aliases.yml",
+			wantAliases: chatdb.Aliases{},
+		},
+		{
+			msg:      "unknown YAML field",
+			filename: "aliases.yaml",
+			contents: "\"+15551234567\":\n  folder: John Smith\n",
+			wantErr:  "decode YAML: yaml: unmarshal errors:\n  line 2: field folder not found in type chatdb.Alias",
+		},
+		{
+			msg:      "unknown TOML key",
+			filename: "aliases.toml",
+			contents: "[\"+15551234567\"]\nfolder = \"John Smith\"\n",
+			wantErr:  `decode TOML: unknown key "+15551234567".folder`,
+		},
+		{
+			msg:      "bad TOML",
+			filename: "aliases.toml",
+			contents: "[\"+15551234567\"\n",
+			wantErr:  "decode TOML: toml: line 2: expected '.' or ']' to end table name, but got '\\n' instead",
+		},
+		{
+			msg:      "empty alias",
+			filename: "aliases.yaml",
+			contents: "\"+15551234567\": {}\n",
+			wantErr:  `alias for "+15551234567" has neither a name nor an entity`,
+		},
+		{
+			msg:      "duplicate handles",
+			filename: "aliases.yaml",
+			contents: "\"+15551234567\":\n  name: Johnny\n\"(555) 123-4567\":\n  name: John\n",
+			wantErr:  `multiple aliases for "+15551234567"`,
+		},
+		{
+			msg:      "unsupported extension",
+			filename: "aliases.json",
+			contents: "{}",
+			wantErr:  `unsupported aliases file extension ".json" - FIX: use .yaml, .yml, or .toml`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			assert.NilError(t, afero.WriteFile(fs, tt.filename, []byte(tt.contents), 0644))
+			s := NewOS(fs, nil, "")
+			aliases, err := s.GetAliases(tt.filename, "US")
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, tt.wantAliases, aliases)
+		})
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package opsys
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/emersion/go-vcard"
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/chatdb"
+)
+
+const (
+	_avatarDir = "avatars"
+	// The largest contact photo which is downloaded from a URL.
+	_maxPhotoBytes = 10 << 20
+)
+
+// errPhotoNotDownloaded is returned for photos linked by an http(s) URL when
+// downloading them is not enabled.
+var errPhotoNotDownloaded = errors.New("photo not downloaded")
+
+// Extensions for the image types which are written as avatars, by the MIME
+// type detected from their contents.
+var _avatarExtensions = map[string]string{
+	"image/bmp":  ".bmp",
+	"image/gif":  ".gif",
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// writeAvatars decodes the preferred photo of each card, writes it to the
+// avatars directory in the temporary directory, and records its path in the
+// card's chatdb.FieldAvatar. Photos which cannot be read are logged and
+// skipped, as are photos linked by an http(s) URL unless downloadPhotos is set.
+func (s *opSys) writeAvatars(cards []*vcard.Card, downloadPhotos bool) error {
+	avatarDir := ""
+	notDownloaded := 0
+	for i, card := range cards {
+		photo := card.Preferred(vcard.FieldPhoto)
+		if photo == nil || photo.Value == "" {
+			continue
+		}
+		contact := card.PreferredValue(vcard.FieldFormattedName)
+		data, err := s.readPhoto(photo, downloadPhotos)
+		if errors.Is(err, errPhotoNotDownloaded) {
+			notDownloaded++
+			continue
+		}
+		if err != nil {
+			slog.Warn("failed to read contact photo", "contact", contact, "err", err)
+			continue
+		}
+		contentType := http.DetectContentType(data)
+		ext, ok := _avatarExtensions[contentType]
+		if !ok {
+			slog.Warn("unsupported contact photo type", "contact", contact, "type", contentType)
+			continue
+		}
+		if avatarDir == "" {
+			tempDir, err := s.GetTempDir()
+			if err != nil {
+				return fmt.Errorf("get temporary directory: %w", err)
+			}
+			avatarDir = filepath.Join(tempDir, _avatarDir)
+			if err := s.Fs.MkdirAll(avatarDir, 0700); err != nil {
+				return fmt.Errorf("create directory %q: %w", avatarDir, err)
+			}
+		}
+		avatarPath := filepath.Join(avatarDir, fmt.Sprintf("avatar-%d%s", i, ext))
+		if err := afero.WriteFile(s.Fs, avatarPath, data, 0600); err != nil {
+			return fmt.Errorf("write avatar %q: %w", avatarPath, err)
+		}
+		card.SetValue(chatdb.FieldAvatar, avatarPath)
+	}
+	if notDownloaded > 0 {
+		slog.Warn("contact photos linked by URL were not downloaded - FIX: pass the --download-photos flag to download them", "count", notDownloaded)
+	}
+	return nil
+}
+
+// readPhoto reads the image data of a PHOTO field, which is either embedded in
+// the card in base64 (vCard 2.1 and 3.0), embedded as a data URI (vCard 4.0),
+// or referenced by an http(s) or file URI. Photos at http(s) URIs are only
+// downloaded if download is set.
+func (s *opSys) readPhoto(photo *vcard.Field, download bool) ([]byte, error) {
+	value := strings.TrimSpace(photo.Value)
+	switch strings.ToLower(photo.Params.Get("ENCODING")) {
+	case "b", "base64":
+		return decodeBase64(value)
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("parse photo URI: %w", err)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "data":
+		return decodeDataURI(u.Opaque)
+	case "http", "https":
+		if !download {
+			return nil, errPhotoNotDownloaded
+		}
+		resp, err := s.httpClient.Get(value)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("get %q: %s", value, resp.Status)
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, _maxPhotoBytes+1))
+		if err != nil {
+			return nil, fmt.Errorf("read %q: %w", value, err)
+		}
+		if len(data) > _maxPhotoBytes {
+			return nil, fmt.Errorf("photo at %q is larger than %d bytes", value, _maxPhotoBytes)
+		}
+		return data, nil
+	case "file":
+		return afero.ReadFile(s.Fs, u.Path)
+	}
+	return nil, fmt.Errorf("unsupported photo URI scheme %q", u.Scheme)
+}
+
+// decodeDataURI decodes the opaque part of a data URI, e.g.
+// "image/jpeg;base64,/9j/4AAQ...".
+func decodeDataURI(opaque string) ([]byte, error) {
+	header, data, ok := strings.Cut(opaque, ",")
+	if !ok {
+		return nil, fmt.Errorf("invalid data URI")
+	}
+	if strings.HasSuffix(strings.ToLower(header), ";base64") {
+		return decodeBase64(data)
+	}
+	unescaped, err := url.PathUnescape(data)
+	if err != nil {
+		return nil, fmt.Errorf("unescape data URI: %w", err)
+	}
+	return []byte(unescaped), nil
+}
+
+// decodeBase64 decodes base64 which may be folded across lines.
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.Join(strings.Fields(s), "")
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("decode base64: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,125 @@
+package opsys
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/chatdb"
+	"gotest.tools/v3/assert"
+)
+
+func TestGetContactMapAvatars(t *testing.T) {
+	png := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+	jpeg := "\xff\xd8\xff\xe0\x00\x10JFIF\x00"
+	gif := "GIF89a\x01\x00\x01\x00"
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/photo.gif":
+			fmt.Fprint(w, gif)
+		case "/huge.gif":
+			fmt.Fprint(w, gif+strings.Repeat("\x00", _maxPhotoBytes))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	card := func(tel, photo string) string {
+		return fmt.Sprintf("BEGIN:VCARD\r\nVERSION:3.0\r\nFN:%s\r\nTEL:%s\r\n%sEND:VCARD\r\n", tel, tel, photo)
+	}
+	contents := strings.Join([]string{
+		card("+15550000001", "PHOTO;ENCODING=b;TYPE=PNG:"+base64.StdEncoding.EncodeToString([]byte(png))+"\r\n"),
+		card("+15550000002", "PHOTO:data:image/jpeg;base64,"+base64.StdEncoding.EncodeToString([]byte(jpeg))+"\r\n"),
+		card("+15550000003", "PHOTO;VALUE=uri:"+server.URL+"/photo.gif\r\n"),
+		card("+15550000004", "PHOTO;VALUE=uri:file:///photos/photo.png\r\n"),
+		card("+15550000005", "PHOTO;VALUE=uri:"+server.URL+"/missing.gif\r\n"),
+		card("+15550000006", "PHOTO:data:text/plain,not%20an%20image\r\n"),
+		card("+15550000007", "PHOTO;ENCODING=b:not base64!\r\n"),
+		card("+15550000008", "PHOTO;VALUE=uri:ftp://example.com/photo.png\r\n"),
+		card("+15550000009", ""),
+		card("+15550000010", "PHOTO;VALUE=uri:"+server.URL+"/huge.gif\r\n"),
+	}, "")
+
+	tests := []struct {
+		msg            string
+		avatars        bool
+		downloadPhotos bool
+		roFS           bool
+		wantAvatars    map[string]string
+		wantErr        string
+	}{
+		{
+			msg:            "avatars",
+			avatars:        true,
+			downloadPhotos: true,
+			wantAvatars: map[string]string{
+				"+15550000001": png,
+				"+15550000002": jpeg,
+				"+15550000003": gif,
+				"+15550000004": png,
+			},
+		},
+		{
+			msg:     "avatars without downloading photos",
+			avatars: true,
+			wantAvatars: map[string]string{
+				"+15550000001": png,
+				"+15550000002": jpeg,
+				"+15550000004": png,
+			},
+		},
+		{
+			msg:         "avatars disabled",
+			wantAvatars: map[string]string{},
+		},
+		{
+			msg:     "temp dir creation fails",
+			avatars: true,
+			roFS:    true,
+			wantErr: "write contact photos: get temporary directory: create temporary directory",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			assert.NilError(t, afero.WriteFile(fs, "contacts.vcf", []byte(contents), 0644))
+			assert.NilError(t, afero.WriteFile(fs, "/photos/photo.png", []byte(png), 0644))
+			if tt.roFS {
+				fs = afero.NewReadOnlyFs(fs)
+			}
+			requests = 0
+			s := &opSys{Fs: fs, httpClient: server.Client()}
+			contactMap, _, err := s.GetContactMap([]string{"contacts.vcf"}, ContactOptions{Avatars: tt.avatars, DownloadPhotos: tt.downloadPhotos})
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, len(contactMap), 10)
+			if !tt.downloadPhotos {
+				assert.Equal(t, requests, 0)
+			}
+			for handle, card := range contactMap {
+				avatarPath := card.Value(chatdb.FieldAvatar)
+				want, ok := tt.wantAvatars[handle]
+				if !ok {
+					assert.Equal(t, avatarPath, "", "unexpected avatar for %s", handle)
+					continue
+				}
+				assert.Assert(t, strings.HasPrefix(avatarPath, s.tempDir+"/avatars/avatar-"), avatarPath)
+				got, err := afero.ReadFile(fs, avatarPath)
+				assert.NilError(t, err)
+				assert.Equal(t, string(got), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,359 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package opsys
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-vcard"
+	"github.com/tagatac/bagoup/v2/phonenum"
+)
+
+// Policies for resolving collisions between contact cards which share a phone
+// number or email address.
+const (
+	// CollisionCombine indexes the handle by a card combining the names of the
+	// colliding cards, e.g. "Alice and Bob". This is the default.
+	CollisionCombine = "combine"
+	// CollisionFirst keeps the card which was read first.
+	CollisionFirst = "first"
+	// CollisionLast keeps the card which was read last.
+	CollisionLast = "last"
+	// CollisionMostFields keeps the card with the most fields, or the first
+	// card in case of a tie.
+	CollisionMostFields = "most-fields"
+	// CollisionFail returns an error if there are any collisions.
+	CollisionFail = "fail"
+)
+
+// ContactCollisionPolicies lists the valid values of
+// ContactOptions.CollisionPolicy.
+var ContactCollisionPolicies = []string{CollisionCombine, CollisionFirst, CollisionLast, CollisionMostFields, CollisionFail}
+
+// ContactCollision describes a phone number or email address shared by
+// multiple contact cards.
+type ContactCollision struct {
+	// Handle is the normalized phone number or email address.
+	Handle string
+	// Cards are the colliding cards, in the order in which they were read.
+	Cards []*vcard.Card
+	// Resolved is the card which the handle is indexed by, or nil under the
+	// fail policy.
+	Resolved *vcard.Card
+}
+
+// ContactOptions configure how contacts are read and indexed.
+type ContactOptions struct {
+	// DefaultRegion is the region used to normalize phone numbers without a
+	// country code (see phonenum.Normalize).
+	DefaultRegion string
+	// CSVColumns maps contact fields (see CSVContactFields) to patterns
+	// matching the CSV columns to read them from, overriding the columns of
+	// the detected layout.
+	CSVColumns map[string]string
+	// CollisionPolicy is one of ContactCollisionPolicies. If empty,
+	// CollisionCombine is used.
+	CollisionPolicy string
+	// Avatars enables writing contact photos to the temporary directory (see
+	// chatdb.FieldAvatar).
+	Avatars bool
+	// DownloadPhotos enables downloading contact photos linked by an http(s)
+	// URL, with Avatars.
+	DownloadPhotos bool
+}
+
+func (s *opSys) GetContactMap(contactsFilePaths []string, opts ContactOptions) (map[string]*vcard.Card, []ContactCollision, error) {
+	sources := make([][]*vcard.Card, 0, len(contactsFilePaths))
+	for _, contactsFilePath := range contactsFilePaths {
+		cards, err := s.readContactsFile(contactsFilePath, opts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read contacts file %q: %w", contactsFilePath, err)
+		}
+		sources = append(sources, cards)
+	}
+	return s.indexContactSources(sources, opts)
+}
+
+// indexContactSources merges the cards of the given sources (see
+// mergeContactSources), writes their photos if enabled, and indexes them by
+// handle.
+func (s *opSys) indexContactSources(sources [][]*vcard.Card, opts ContactOptions) (map[string]*vcard.Card, []ContactCollision, error) {
+	cards := mergeContactSources(sources, opts.DefaultRegion)
+	if opts.Avatars {
+		if err := s.writeAvatars(cards, opts.DownloadPhotos); err != nil {
+			return nil, nil, fmt.Errorf("write contact photos: %w", err)
+		}
+	}
+	idx := newContactIndex(opts)
+	for _, card := range cards {
+		idx.add(card)
+	}
+	return idx.result()
+}
+
+func (s *opSys) readContactsFile(contactsFilePath string, opts ContactOptions) ([]*vcard.Card, error) {
+	f, err := s.Fs.Open(contactsFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if strings.EqualFold(filepath.Ext(contactsFilePath), ".csv") {
+		return readCSVContacts(f, opts.CSVColumns)
+	}
+	return readVCards(f)
+}
+
+func readVCards(r io.Reader) ([]*vcard.Card, error) {
+	dec := vcard.NewDecoder(r)
+	cards := []*vcard.Card{}
+	for {
+		card, err := dec.Decode()
+		if err == io.EOF {
+			return cards, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decode vcard: %w", err)
+		}
+		cards = append(cards, &card)
+	}
+}
+
+// mergeContactSources merges cards which represent the same person, i.e.
+// cards with the same UID, or cards from different sources with the same name
+// which share a phone number or email address. Sources are given in order of
+// precedence: the fields of the merged card are those of the card from the
+// earliest source, with the phone numbers and email addresses of all of the
+// cards. Other cards which share a phone number or email address, e.g. a family
+// landline, are left to the collision policy.
+func mergeContactSources(sources [][]*vcard.Card, defaultRegion string) []*vcard.Card {
+	cards := []*vcard.Card{}
+	byUID := map[string]*vcard.Card{}
+	byHandle := map[string]*vcard.Card{}
+	for _, source := range sources {
+		// Handles are indexed after the whole source has been read, so that
+		// cards are only merged by handle across sources.
+		sourceHandles := map[string]*vcard.Card{}
+		for _, card := range source {
+			uid := card.Value(vcard.FieldUID)
+			person := byUID[uid]
+			if person == nil {
+				for _, handle := range cardHandles(card, defaultRegion) {
+					if p, ok := byHandle[handle]; ok && sameName(p, card) {
+						person = p
+						break
+					}
+				}
+			}
+			if person == nil {
+				person = card
+				cards = append(cards, card)
+			} else {
+				mergeCard(person, card, defaultRegion)
+			}
+			if uid != "" {
+				if _, ok := byUID[uid]; !ok {
+					byUID[uid] = person
+				}
+			}
+			for _, handle := range cardHandles(person, defaultRegion) {
+				if _, ok := sourceHandles[handle]; !ok {
+					sourceHandles[handle] = person
+				}
+			}
+		}
+		for handle, person := range sourceHandles {
+			if _, ok := byHandle[handle]; !ok {
+				byHandle[handle] = person
+			}
+		}
+	}
+	return cards
+}
+
+// sameName reports whether two cards have the same formatted name, ignoring
+// case. Cards without a name are never the same person.
+func sameName(a, b *vcard.Card) bool {
+	name := strings.TrimSpace(a.PreferredValue(vcard.FieldFormattedName))
+	return name != "" && strings.EqualFold(name, strings.TrimSpace(b.PreferredValue(vcard.FieldFormattedName)))
+}
+
+// mergeCard adds the phone numbers and email addresses of card which are
+// missing from person, as well as any other fields which person lacks.
+func mergeCard(person, card *vcard.Card, defaultRegion string) {
+	handles := map[string]bool{}
+	for _, handle := range cardHandles(person, defaultRegion) {
+		handles[handle] = true
+	}
+	for key, fields := range *card {
+		switch key {
+		case vcard.FieldTelephone, vcard.FieldEmail:
+			for _, field := range fields {
+				handle := phonenum.Normalize(field.Value, defaultRegion)
+				if !handles[handle] {
+					handles[handle] = true
+					person.Add(key, field)
+				}
+			}
+		default:
+			if _, ok := (*person)[key]; !ok {
+				(*person)[key] = fields
+			}
+		}
+	}
+}
+
+// cardHandles returns the normalized phone numbers and email addresses of the
+// card.
+func cardHandles(card *vcard.Card, defaultRegion string) []string {
+	phonesAndEmails := append(card.Values(vcard.FieldTelephone), card.Values(vcard.FieldEmail)...)
+	handles := make([]string, len(phonesAndEmails))
+	for i, phoneOrEmail := range phonesAndEmails {
+		handles[i] = phonenum.Normalize(phoneOrEmail, defaultRegion)
+	}
+	return handles
+}
+
+// contactIndex indexes cards by their normalized phone numbers and email
+// addresses, resolving collisions between cards according to the collision
+// policy.
+type contactIndex struct {
+	opts       ContactOptions
+	contactMap map[string]*vcard.Card
+	// collisions holds the cards involved in each collision, by handle.
+	collisions map[string][]*vcard.Card
+}
+
+func newContactIndex(opts ContactOptions) *contactIndex {
+	return &contactIndex{
+		opts:       opts,
+		contactMap: map[string]*vcard.Card{},
+		collisions: map[string][]*vcard.Card{},
+	}
+}
+
+// add indexes the card by its normalized phone numbers and email addresses.
+func (idx *contactIndex) add(card *vcard.Card) {
+	for _, phoneOrEmail := range cardHandles(card, idx.opts.DefaultRegion) {
+		c, ok := idx.contactMap[phoneOrEmail]
+		if !ok {
+			idx.contactMap[phoneOrEmail] = card
+			continue
+		}
+		if c == card {
+			continue
+		}
+		if cards, ok := idx.collisions[phoneOrEmail]; ok {
+			idx.collisions[phoneOrEmail] = append(cards, card)
+		} else {
+			idx.collisions[phoneOrEmail] = []*vcard.Card{c, card}
+		}
+		idx.contactMap[phoneOrEmail] = idx.resolve(c, card)
+	}
+}
+
+// resolve returns the card to index a handle by when it is already indexed by
+// the existing card.
+func (idx *contactIndex) resolve(existing, card *vcard.Card) *vcard.Card {
+	switch idx.opts.CollisionPolicy {
+	case CollisionFirst, CollisionFail:
+		return existing
+	case CollisionLast:
+		return card
+	case CollisionMostFields:
+		if countFields(card) > countFields(existing) {
+			return card
+		}
+		return existing
+	}
+	combinedCard := vcard.Card{}
+	combinedName := vcard.Name{
+		GivenName: fmt.Sprintf("%s or %s", givenName(existing), givenName(card)),
+	}
+	combinedCard.SetName(&combinedName)
+	combinedFormattedName := vcard.Field{Value: fmt.Sprintf(
+		"%s and %s",
+		existing.PreferredValue(vcard.FieldFormattedName),
+		card.PreferredValue(vcard.FieldFormattedName),
+	)}
+	combinedCard.Set(vcard.FieldFormattedName, &combinedFormattedName)
+	return &combinedCard
+}
+
+// result returns the contact map along with the collisions found, sorted by
+// handle. Under the fail policy, an error is returned if there were any
+// collisions.
+func (idx *contactIndex) result() (map[string]*vcard.Card, []ContactCollision, error) {
+	var collisions []ContactCollision
+	for handle, cards := range idx.collisions {
+		collision := ContactCollision{Handle: handle, Cards: cards}
+		if idx.opts.CollisionPolicy != CollisionFail {
+			collision.Resolved = idx.contactMap[handle]
+		}
+		collisions = append(collisions, collision)
+	}
+	sort.Slice(collisions, func(i, j int) bool { return collisions[i].Handle < collisions[j].Handle })
+	if idx.opts.CollisionPolicy == CollisionFail && len(collisions) > 0 {
+		return nil, collisions, fmt.Errorf("%d phone numbers or email addresses are shared by multiple contacts - FIX: correct your contacts or choose a different --collision-policy", len(collisions))
+	}
+	return idx.contactMap, collisions, nil
+}
+
+// countFields counts the values in the card, to rank cards by completeness.
+func countFields(card *vcard.Card) int {
+	n := 0
+	for _, fields := range *card {
+		n += len(fields)
+	}
+	return n
+}
+
+func givenName(card *vcard.Card) string {
+	if name := card.Name(); name != nil {
+		return name.GivenName
+	}
+	return ""
+}
+
+// newContactCard builds a vCard from contact fields read from a source other
+// than a vCard file, in the same shape as a vCard exported from Contacts.app.
+// If no formatted name is given, one is derived from the name or organization.
+func newContactCard(name vcard.Name, formatted, nickname, org string) *vcard.Card {
+	if formatted == "" {
+		formatted = formattedName(name, org)
+	}
+	card := vcard.Card{}
+	card.SetValue(vcard.FieldVersion, "3.0")
+	card.SetValue(vcard.FieldFormattedName, formatted)
+	card.SetName(&name)
+	if nickname != "" {
+		card.SetValue(vcard.FieldNickname, nickname)
+	}
+	if org != "" {
+		card.SetValue(vcard.FieldOrganization, org)
+	}
+	return &card
+}
+
+// formattedName mimics the FN that Contacts.app writes when exporting a vCard:
+// the full name, or the organization for company cards.
+func formattedName(name vcard.Name, org string) string {
+	parts := []string{}
+	for _, p := range []string{name.HonorificPrefix, name.GivenName, name.AdditionalName, name.FamilyName} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	fn := strings.Join(parts, " ")
+	if name.HonorificSuffix != "" {
+		fn += ", " + name.HonorificSuffix
+	}
+	if fn == "" {
+		return org
+	}
+	return fn
+}
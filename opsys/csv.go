@@ -0,0 +1,133 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package opsys
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/chatdb"
+)
+
+// CSVHeader lists the columns of a CSV chat file.
+var CSVHeader = []string{"chat_guid", "entity", "timestamp", "sender", "is_from_me", "text", "attachments", "service"}
+
+// The attachments of a message are joined by semicolons, with any semicolons
+// and backslashes in their names escaped by a backslash.
+const _csvAttachmentSeparator = ";"
+
+var _csvAttachmentEscaper = strings.NewReplacer(`\`, `\\`, _csvAttachmentSeparator, `\`+_csvAttachmentSeparator)
+
+type csvFile struct {
+	afero.File
+	entity string
+	header bool
+	w      *csv.Writer
+	// msg is the message being written, which is written as a row once its
+	// attachments and transcription are known.
+	msg         *chatdb.Message
+	attachments []string
+}
+
+func (s *opSys) NewCSVFile(entityName string, chatFile afero.File, header bool) OutFile {
+	return &csvFile{
+		File:   chatFile,
+		entity: entityName,
+		header: header,
+		w:      csv.NewWriter(chatFile),
+	}
+}
+
+func (f *csvFile) WriteMessage(msg chatdb.Message) error {
+	if err := f.writeRow(); err != nil {
+		return err
+	}
+	f.msg = &msg
+	return nil
+}
+
+// writeRow writes the message being written as a row, after the header row
+// if it has not been written yet.
+func (f *csvFile) writeRow() error {
+	if f.header {
+		if err := f.w.Write(CSVHeader); err != nil {
+			return fmt.Errorf("write header: %w", err)
+		}
+		f.header = false
+	}
+	if f.msg == nil {
+		return nil
+	}
+	msg := f.msg
+	text := messageText(*msg)
+	if msg.AudioTranscription != "" {
+		text = strings.TrimSpace(text + "\n" + msg.AudioTranscription)
+	}
+	row := []string{
+		msg.ChatGUID,
+		f.entity,
+		msg.Date.Format(time.RFC3339),
+		msg.Sender,
+		strconv.FormatBool(msg.FromMe),
+		text,
+		strings.Join(f.attachments, _csvAttachmentSeparator),
+		msg.Service(),
+	}
+	if err := f.w.Write(row); err != nil {
+		return fmt.Errorf("write message %d: %w", msg.ID, err)
+	}
+	f.msg, f.attachments = nil, nil
+	return nil
+}
+
+func (f *csvFile) WriteAttachment(att chatdb.Attachment) (bool, error) {
+	return false, f.ReferenceAttachment(att)
+}
+
+// ReferenceAttachment lists the attachment by the path of its copy in the
+// export, or else by its transfer name.
+func (f *csvFile) ReferenceAttachment(att chatdb.Attachment) error {
+	name := att.CopiedPath
+	if name == "" {
+		name = att.TransferName
+	}
+	if f.msg == nil {
+		return fmt.Errorf("no message in %q to attach %q to", f.Name(), name)
+	}
+	f.attachments = append(f.attachments, _csvAttachmentEscaper.Replace(name))
+	return nil
+}
+
+func (f *csvFile) WriteTranscription(transcription string) error {
+	if f.msg == nil {
+		return fmt.Errorf("no message in %q to attach the transcription to", f.Name())
+	}
+	f.msg.AudioTranscription = transcription
+	return nil
+}
+
+func (f *csvFile) SetAvatar(avatarPath string) {}
+
+func (f *csvFile) WriteAvatar(avatarPath string) error {
+	return nil
+}
+
+// WriteSeparator is a no-op, since the rows of a CSV file have no order to
+// break.
+func (f *csvFile) WriteSeparator() error {
+	return nil
+}
+
+func (f *csvFile) Stage() (int, error) {
+	return 0, f.writeRow()
+}
+
+func (f *csvFile) Flush() error {
+	f.w.Flush()
+	return f.w.Error()
+}
@@ -0,0 +1,93 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package opsys
+
+import (
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/chatdb"
+	"gotest.tools/v3/assert"
+)
+
+func TestCSVFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	s := &opSys{Fs: fs}
+	file, err := s.Create("testfile.csv")
+	assert.NilError(t, err)
+	date := time.Date(2020, 3, 1, 15, 34, 5, 0, time.UTC)
+
+	of := s.NewCSVFile("friend", file, true)
+	assert.Equal(t, of.Name(), "testfile.csv")
+	assert.Error(t, of.ReferenceAttachment(chatdb.Attachment{TransferName: "IMG_0001.png"}), `no message in "testfile.csv" to attach "IMG_0001.png" to`)
+	assert.Error(t, of.WriteTranscription("see you soon"), `no message in "testfile.csv" to attach the transcription to`)
+	assert.NilError(t, of.WriteMessage(chatdb.Message{ID: 1, Date: date, Sender: "Me", FromMe: true, Text: "hi, \"friend\"\nwant to play tennis?", ChatGUID: "iMessage;-;friend@gmail.com"}))
+	embedded, err := of.WriteAttachment(chatdb.Attachment{Filepath: "messages-export/friend/attachments/balls; rackets & nets.jpeg", CopiedPath: "messages-export/friend/attachments/balls; rackets & nets.jpeg", TransferName: "balls; rackets & nets.jpeg"})
+	assert.NilError(t, err)
+	assert.Equal(t, embedded, false)
+	assert.NilError(t, of.ReferenceAttachment(chatdb.Attachment{TransferName: "IMG_0001.png"}))
+	assert.NilError(t, of.WriteSeparator())
+	assert.NilError(t, of.WriteMessage(chatdb.Message{ID: 2, Date: date, Sender: "friend", Text: "\uFFFC", IsAudio: true, ChatGUID: "SMS;-;+15551234567"}))
+	assert.NilError(t, of.WriteTranscription("see you soon"))
+	of.SetAvatar("avatar.jpg")
+	assert.NilError(t, of.WriteAvatar("avatar.jpg"))
+	imgCount, err := of.Stage()
+	assert.NilError(t, err)
+	assert.Equal(t, imgCount, 0)
+	assert.NilError(t, of.Flush())
+
+	// Another entity appended to the same file, without a header
+	of = s.NewCSVFile("Book Club", file, false)
+	assert.NilError(t, of.WriteMessage(chatdb.Message{ID: 3, Date: date, Sender: "Alex", Text: "hello", ChatGUID: "iMessage;+;chat123456"}))
+	_, err = of.Stage()
+	assert.NilError(t, err)
+	assert.NilError(t, of.Flush())
+
+	contents, err := afero.ReadFile(fs, "testfile.csv")
+	assert.NilError(t, err)
+	assert.Equal(t, string(contents), `chat_guid,entity,timestamp,sender,is_from_me,text,attachments,service
+iMessage;-;friend@gmail.com,friend,2020-03-01T15:34:05Z,Me,true,"hi, ""friend""
+want to play tennis?",messages-export/friend/attachments/balls\; rackets & nets.jpeg;IMG_0001.png,iMessage
+SMS;-;+15551234567,friend,2020-03-01T15:34:05Z,friend,false,"🎤 Voice memo
+see you soon",,SMS
+iMessage;+;chat123456,Book Club,2020-03-01T15:34:05Z,Alex,false,hello,,iMessage
+`)
+
+	rows, err := csv.NewReader(strings.NewReader(string(contents))).ReadAll()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, splitCSVAttachments(rows[1][6]), []string{"messages-export/friend/attachments/balls; rackets & nets.jpeg", "IMG_0001.png"})
+
+	// Read-only file
+	roFile, err := afero.NewReadOnlyFs(fs).Open("testfile.csv")
+	assert.NilError(t, err)
+	roOF := s.NewCSVFile("friend", roFile, true)
+	_, err = roOF.Stage()
+	assert.NilError(t, err)
+	assert.Error(t, roOF.Flush(), "write testfile.csv: file handle is read only")
+}
+
+// splitCSVAttachments splits the attachments column of a CSV row back into the
+// attachment names.
+func splitCSVAttachments(s string) []string {
+	var names []string
+	var name strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+			if i < len(s) {
+				name.WriteByte(s[i])
+			}
+		case ';':
+			names = append(names, name.String())
+			name.Reset()
+		default:
+			name.WriteByte(s[i])
+		}
+	}
+	return append(names, name.String())
+}
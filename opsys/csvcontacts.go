@@ -0,0 +1,234 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package opsys
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/emersion/go-vcard"
+)
+
+// Contact fields which can be read from CSV columns.
+const (
+	CSVFieldFormattedName = "formatted-name"
+	CSVFieldGivenName     = "given-name"
+	CSVFieldMiddleName    = "middle-name"
+	CSVFieldFamilyName    = "family-name"
+	CSVFieldPrefix        = "prefix"
+	CSVFieldSuffix        = "suffix"
+	CSVFieldNickname      = "nickname"
+	CSVFieldOrganization  = "organization"
+	CSVFieldPhone         = "phone"
+	CSVFieldEmail         = "email"
+)
+
+// CSVContactFields lists the contact fields which can be mapped to CSV columns
+// with ContactOptions.CSVColumns.
+var CSVContactFields = []string{
+	CSVFieldFormattedName,
+	CSVFieldGivenName,
+	CSVFieldMiddleName,
+	CSVFieldFamilyName,
+	CSVFieldPrefix,
+	CSVFieldSuffix,
+	CSVFieldNickname,
+	CSVFieldOrganization,
+	CSVFieldPhone,
+	CSVFieldEmail,
+}
+
+// Google Contacts puts multiple values of the same type in a single cell.
+const _csvMultiValueSeparator = ":::"
+
+type csvLayout struct {
+	name string
+	// signature lists the columns which identify the layout.
+	signature []string
+	// columns maps contact fields to column name patterns, in which * matches
+	// any text.
+	columns map[string]string
+}
+
+// _csvLayouts are the known CSV contacts layouts, in the order in which they
+// are detected.
+var _csvLayouts = []csvLayout{
+	{
+		name:      "Google Contacts",
+		signature: []string{"First Name", "Name Prefix"},
+		columns: map[string]string{
+			CSVFieldGivenName:    "First Name",
+			CSVFieldMiddleName:   "Middle Name",
+			CSVFieldFamilyName:   "Last Name",
+			CSVFieldPrefix:       "Name Prefix",
+			CSVFieldSuffix:       "Name Suffix",
+			CSVFieldNickname:     "Nickname",
+			CSVFieldOrganization: "Organization Name",
+			CSVFieldPhone:        "Phone * - Value",
+			CSVFieldEmail:        "E-mail * - Value",
+		},
+	},
+	{
+		name:      "Google Contacts (legacy)",
+		signature: []string{"Given Name", "Family Name"},
+		columns: map[string]string{
+			CSVFieldFormattedName: "Name",
+			CSVFieldGivenName:     "Given Name",
+			CSVFieldMiddleName:    "Additional Name",
+			CSVFieldFamilyName:    "Family Name",
+			CSVFieldPrefix:        "Name Prefix",
+			CSVFieldSuffix:        "Name Suffix",
+			CSVFieldNickname:      "Nickname",
+			CSVFieldOrganization:  "Organization 1 - Name",
+			CSVFieldPhone:         "Phone * - Value",
+			CSVFieldEmail:         "E-mail * - Value",
+		},
+	},
+	{
+		name:      "Outlook",
+		signature: []string{"First Name", "E-mail Address"},
+		columns: map[string]string{
+			CSVFieldGivenName:    "First Name",
+			CSVFieldMiddleName:   "Middle Name",
+			CSVFieldFamilyName:   "Last Name",
+			CSVFieldPrefix:       "Title",
+			CSVFieldSuffix:       "Suffix",
+			CSVFieldNickname:     "Nickname",
+			CSVFieldOrganization: "Company",
+			CSVFieldPhone:        "*Phone*",
+			CSVFieldEmail:        "E-mail*Address",
+		},
+	},
+}
+
+// readCSVContacts converts each row of a CSV contacts file to a vCard. The
+// columns are determined by detecting a known layout from the header, and
+// then applying the given mapping from contact fields to column patterns.
+func readCSVContacts(r io.Reader, columnMap map[string]string) ([]*vcard.Card, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read CSV header: %w", err)
+	}
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	columns, err := newCSVColumns(header, columnMap)
+	if err != nil {
+		return nil, err
+	}
+	cards := []*vcard.Card{}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return cards, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read CSV record: %w", err)
+		}
+		cards = append(cards, columns.card(record))
+	}
+}
+
+// csvColumns maps contact fields to the indexes of the CSV columns they are
+// read from.
+type csvColumns map[string][]int
+
+func newCSVColumns(header []string, columnMap map[string]string) (csvColumns, error) {
+	patterns := map[string]string{}
+	if layout := detectCSVLayout(header); layout != nil {
+		maps.Copy(patterns, layout.columns)
+	}
+	for field, pattern := range columnMap {
+		if !slices.Contains(CSVContactFields, field) {
+			return nil, fmt.Errorf("unknown contact field %q in CSV column mapping - valid fields: %s", field, strings.Join(CSVContactFields, ", "))
+		}
+		patterns[field] = pattern
+	}
+	if patterns[CSVFieldPhone] == "" && patterns[CSVFieldEmail] == "" {
+		return nil, errors.New("unrecognized CSV contacts layout - FIX: map the phone and email columns with the --csv-column option")
+	}
+	columns := csvColumns{}
+	for field, pattern := range patterns {
+		re := columnPatternRegexp(pattern)
+		for i, h := range header {
+			if re.MatchString(strings.TrimSpace(h)) {
+				columns[field] = append(columns[field], i)
+			}
+		}
+	}
+	return columns, nil
+}
+
+func detectCSVLayout(header []string) *csvLayout {
+	for i, layout := range _csvLayouts {
+		if !slices.ContainsFunc(layout.signature, func(col string) bool { return !slices.Contains(header, col) }) {
+			return &_csvLayouts[i]
+		}
+	}
+	return nil
+}
+
+// columnPatternRegexp compiles a case-insensitive column name pattern, in
+// which * matches any text.
+func columnPatternRegexp(pattern string) *regexp.Regexp {
+	parts := strings.Split(pattern, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	return regexp.MustCompile("(?i)^" + strings.Join(parts, ".*") + "$")
+}
+
+func (c csvColumns) card(record []string) *vcard.Card {
+	name := vcard.Name{
+		GivenName:       c.first(record, CSVFieldGivenName),
+		AdditionalName:  c.first(record, CSVFieldMiddleName),
+		FamilyName:      c.first(record, CSVFieldFamilyName),
+		HonorificPrefix: c.first(record, CSVFieldPrefix),
+		HonorificSuffix: c.first(record, CSVFieldSuffix),
+	}
+	card := newContactCard(
+		name,
+		c.first(record, CSVFieldFormattedName),
+		c.first(record, CSVFieldNickname),
+		c.first(record, CSVFieldOrganization),
+	)
+	for _, phone := range c.all(record, CSVFieldPhone) {
+		card.AddValue(vcard.FieldTelephone, phone)
+	}
+	for _, email := range c.all(record, CSVFieldEmail) {
+		card.AddValue(vcard.FieldEmail, email)
+	}
+	return card
+}
+
+// first returns the first non-empty value of the given field in the record.
+func (c csvColumns) first(record []string, field string) string {
+	if values := c.all(record, field); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// all returns all of the non-empty values of the given field in the record.
+func (c csvColumns) all(record []string, field string) []string {
+	values := []string{}
+	for _, i := range c[field] {
+		if i >= len(record) {
+			continue
+		}
+		for _, v := range strings.Split(record[i], _csvMultiValueSeparator) {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
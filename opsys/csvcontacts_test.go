@@ -0,0 +1,167 @@
+package opsys
+
+import (
+	"testing"
+
+	"github.com/emersion/go-vcard"
+	"github.com/spf13/afero"
+	"gotest.tools/v3/assert"
+)
+
+func TestGetContactMapCSV(t *testing.T) {
+	tagCard := &vcard.Card{
+		"VERSION":  []*vcard.Field{{Value: "3.0"}},
+		"FN":       []*vcard.Field{{Value: "David Tagatac"}},
+		"N":        []*vcard.Field{{Value: "Tagatac;David;;;"}},
+		"NICKNAME": []*vcard.Field{{Value: "Tag"}},
+		"TEL": []*vcard.Field{
+			{Value: "(415) 555-5555"},
+			{Value: "+1 415 555 1234"},
+		},
+		"EMAIL": []*vcard.Field{{Value: "David@Tagatac.net"}},
+	}
+	noleCard := &vcard.Card{
+		"VERSION": []*vcard.Field{{Value: "3.0"}},
+		"FN":      []*vcard.Field{{Value: "Mr. Novak Djokovic"}},
+		"N":       []*vcard.Field{{Value: "Djokovic;Novak;;Mr.;"}},
+		"TEL":     []*vcard.Field{{Value: "+381 11 555 5555"}},
+	}
+	acmeCard := &vcard.Card{
+		"VERSION": []*vcard.Field{{Value: "3.0"}},
+		"FN":      []*vcard.Field{{Value: "Acme Pizza"}},
+		"N":       []*vcard.Field{{Value: ";;;;"}},
+		"ORG":     []*vcard.Field{{Value: "Acme Pizza"}},
+		"TEL":     []*vcard.Field{{Value: "415-555-0000"}},
+	}
+
+	tests := []struct {
+		msg      string
+		contents string
+		columns  map[string]string
+		wantMap  map[string]*vcard.Card
+		wantErr  string
+	}{
+		{
+			msg: "Google Contacts",
+			contents: "\ufeffFirst Name,Middle Name,Last Name,Name Prefix,Name Suffix,Nickname,File As,Organization Name,Labels,E-mail 1 - Label,E-mail 1 - Value,Phone 1 - Label,Phone 1 - Value,Phone 2 - Label,Phone 2 - Value\n" +
+				"David,,Tagatac,,,Tag,,,* myContacts,* Home,David@Tagatac.net,Mobile,(415) 555-5555 ::: +1 415 555 1234,,\n" +
+				"Novak,,Djokovic,Mr.,,,,,* myContacts,,,Work,+381 11 555 5555,,\n" +
+				",,,,,,,Acme Pizza,* myContacts,,,,,Work,415-555-0000\n",
+			wantMap: map[string]*vcard.Card{
+				"+14155555555":      tagCard,
+				"+14155551234":      tagCard,
+				"david@tagatac.net": tagCard,
+				"+381115555555":     noleCard,
+				"+14155550000":      acmeCard,
+			},
+		},
+		{
+			msg: "legacy Google Contacts",
+			contents: "Name,Given Name,Additional Name,Family Name,Name Prefix,Name Suffix,Nickname,Organization 1 - Name,E-mail 1 - Type,E-mail 1 - Value,Phone 1 - Type,Phone 1 - Value\n" +
+				"David Tagatac,David,,Tagatac,,,Tag,,* Home,David@Tagatac.net,Mobile,(415) 555-5555 ::: +1 415 555 1234\n" +
+				"Mr. Novak Djokovic,Novak,,Djokovic,Mr.,,,,,,Work,+381 11 555 5555\n",
+			wantMap: map[string]*vcard.Card{
+				"+14155555555":      tagCard,
+				"+14155551234":      tagCard,
+				"david@tagatac.net": tagCard,
+				"+381115555555":     noleCard,
+			},
+		},
+		{
+			msg: "Outlook",
+			contents: "First Name,Middle Name,Last Name,Title,Suffix,Nickname,E-mail Address,E-mail Display Name,E-mail 2 Address,Home Phone,Mobile Phone,Business Phone,Business Fax,TTY/TDD Phone,Company\n" +
+				"David,,Tagatac,,,Tag,David@Tagatac.net,David Tagatac (David@Tagatac.net),,,(415) 555-5555,,,+1 415 555 1234,\n" +
+				"Novak,,Djokovic,Mr.,,,,,,+381 11 555 5555,,,,,\n" +
+				",,,,,,,,,,,415-555-0000,415-555-0001,,Acme Pizza\n",
+			wantMap: map[string]*vcard.Card{
+				"+14155555555":      tagCard,
+				"+14155551234":      tagCard,
+				"david@tagatac.net": tagCard,
+				"+381115555555":     noleCard,
+				"+14155550000":      acmeCard,
+			},
+		},
+		{
+			msg: "custom layout",
+			contents: "Full Name,Nick,Mobile,Landline,Mail\n" +
+				"David Tagatac,Tag,(415) 555-5555,+1 415 555 1234,David@Tagatac.net\n",
+			columns: map[string]string{
+				"formatted-name": "full name",
+				"nickname":       "Nick",
+				"phone":          "Mobile",
+				"email":          "Mail",
+			},
+			wantMap: map[string]*vcard.Card{
+				"+14155555555": {
+					"VERSION":  []*vcard.Field{{Value: "3.0"}},
+					"FN":       []*vcard.Field{{Value: "David Tagatac"}},
+					"N":        []*vcard.Field{{Value: ";;;;"}},
+					"NICKNAME": []*vcard.Field{{Value: "Tag"}},
+					"TEL":      []*vcard.Field{{Value: "(415) 555-5555"}},
+					"EMAIL":    []*vcard.Field{{Value: "David@Tagatac.net"}},
+				},
+				"david@tagatac.net": {
+					"VERSION":  []*vcard.Field{{Value: "3.0"}},
+					"FN":       []*vcard.Field{{Value: "David Tagatac"}},
+					"N":        []*vcard.Field{{Value: ";;;;"}},
+					"NICKNAME": []*vcard.Field{{Value: "Tag"}},
+					"TEL":      []*vcard.Field{{Value: "(415) 555-5555"}},
+					"EMAIL":    []*vcard.Field{{Value: "David@Tagatac.net"}},
+				},
+			},
+		},
+		{
+			msg: "mapping overrides detected layout",
+			contents: "First Name,Last Name,E-mail Address,Mobile Phone,Home Phone\n" +
+				"David,Tagatac,David@Tagatac.net,(415) 555-5555,+1 415 555 1234\n",
+			columns: map[string]string{"phone": "Mobile*"},
+			wantMap: map[string]*vcard.Card{
+				"+14155555555": {
+					"VERSION": []*vcard.Field{{Value: "3.0"}},
+					"FN":      []*vcard.Field{{Value: "David Tagatac"}},
+					"N":       []*vcard.Field{{Value: "Tagatac;David;;;"}},
+					"TEL":     []*vcard.Field{{Value: "(415) 555-5555"}},
+					"EMAIL":   []*vcard.Field{{Value: "David@Tagatac.net"}},
+				},
+				"david@tagatac.net": {
+					"VERSION": []*vcard.Field{{Value: "3.0"}},
+					"FN":      []*vcard.Field{{Value: "David Tagatac"}},
+					"N":       []*vcard.Field{{Value: "Tagatac;David;;;"}},
+					"TEL":     []*vcard.Field{{Value: "(415) 555-5555"}},
+					"EMAIL":   []*vcard.Field{{Value: "David@Tagatac.net"}},
+				},
+			},
+		},
+		{
+			msg:      "unrecognized layout",
+			contents: "Full Name,Mobile\nDavid Tagatac,(415) 555-5555\n",
+			wantErr:  `read contacts file "contacts.CSV": unrecognized CSV contacts layout - FIX: map the phone and email columns with the --csv-column option`,
+		},
+		{
+			msg:      "unknown field",
+			contents: "Full Name,Mobile\nDavid Tagatac,(415) 555-5555\n",
+			columns:  map[string]string{"cell": "Mobile"},
+			wantErr:  `read contacts file "contacts.CSV": unknown contact field "cell" in CSV column mapping - valid fields: formatted-name, given-name, middle-name, family-name, prefix, suffix, nickname, organization, phone, email`,
+		},
+		{
+			msg:      "empty file",
+			contents: "",
+			wantErr:  `read contacts file "contacts.CSV": read CSV header: EOF`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			assert.NilError(t, afero.WriteFile(fs, "contacts.CSV", []byte(tt.contents), 0644))
+			s := NewOS(fs, nil, "")
+			contactMap, _, err := s.GetContactMap([]string{"contacts.CSV"}, ContactOptions{DefaultRegion: "US", CSVColumns: tt.columns})
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, tt.wantMap, contactMap)
+		})
+	}
+}
@@ -0,0 +1,306 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package opsys
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"html"
+	"html/template"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/chatdb"
+)
+
+const (
+	_epubMIMEType = "application/epub+zip"
+	// _epubContainer points reading systems to the package document.
+	_epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+    <rootfiles>
+        <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+    </rootfiles>
+</container>
+`
+)
+
+// _epubImageTypes maps the extensions of the images embedded in EPUBs to their
+// media types, which are the core image types of EPUB 3. Reading systems need
+// not support other types, so other images are referenced instead.
+var _epubImageTypes = map[string]string{
+	".gif":  "image/gif",
+	".jpeg": "image/jpeg",
+	".jpg":  "image/jpeg",
+	".png":  "image/png",
+	".svg":  "image/svg+xml",
+	".webp": "image/webp",
+}
+
+type (
+	// epubFile reuses the HTML rendering of the PDF files, split into a
+	// chapter per month, and packages it with the embedded images as an EPUB.
+	epubFile struct {
+		*pdfFile
+		fs         afero.Fs
+		entityName string
+		// prefix holds the lines (separators and avatars) written before the
+		// next message, so that they start its chapter.
+		prefix     []htmlFileLine
+		chapters   []epubChapter
+		images     []epubImage
+		imageHrefs map[string]string
+		first      time.Time
+		last       time.Time
+	}
+
+	epubChapter struct {
+		ID    string
+		Href  string
+		Title string
+		start int
+	}
+
+	epubImage struct {
+		ID        string
+		Href      string
+		MediaType string
+		Cover     bool
+		path      string
+	}
+
+	epubChapterData struct {
+		Title string
+		Lines []htmlFileLine
+	}
+
+	epubPackageData struct {
+		Identifier string
+		Title      string
+		Generator  string
+		Modified   string
+		First      string
+		Last       string
+		Chapters   []epubChapter
+		Images     []epubImage
+	}
+
+	epubNavData struct {
+		Title    string
+		Cover    string
+		First    string
+		Last     string
+		Chapters []epubChapter
+	}
+)
+
+func (s *opSys) NewEPUBFile(entityName string, chatFile afero.File) OutFile {
+	return &epubFile{
+		pdfFile:    newPDFFile(chatFile, false, "templates/epub_chapter.tmpl", entityName, s.bagoupVersion),
+		fs:         s.Fs,
+		entityName: entityName,
+		imageHrefs: map[string]string{},
+	}
+}
+
+func (f *epubFile) WriteMessage(msg chatdb.Message) error {
+	title := msg.Date.Format("January 2006")
+	if len(f.chapters) == 0 || f.chapters[len(f.chapters)-1].Title != title {
+		n := len(f.chapters) + 1
+		f.chapters = append(f.chapters, epubChapter{
+			ID:    fmt.Sprintf("chapter-%03d", n),
+			Href:  fmt.Sprintf("chapter-%03d.xhtml", n),
+			Title: title,
+			start: len(f.contents.Lines),
+		})
+	}
+	f.contents.Lines = append(f.contents.Lines, f.prefix...)
+	f.prefix = nil
+	if f.first.IsZero() {
+		f.first = msg.Date
+	}
+	f.last = msg.Date
+	return f.pdfFile.WriteMessage(msg)
+}
+
+// WriteAttachment embeds images of the core types (see _epubImageTypes) in the
+// book, and references other attachments.
+func (f *epubFile) WriteAttachment(att chatdb.Attachment) (bool, error) {
+	attPath := att.Filepath
+	if !isEPUBImage(attPath) {
+		return false, f.referenceFile(filepath.Base(attPath))
+	}
+	img := fmt.Sprintf("<img src=%q alt=%q/><br/>", f.addImage(attPath, false), html.EscapeString(filepath.Base(attPath)))
+	f.contents.Lines = append(f.contents.Lines, htmlFileLine{Element: template.HTML(img)})
+	return true, nil
+}
+
+func (f *epubFile) ReferenceAttachment(att chatdb.Attachment) error {
+	return f.referenceFile(att.TransferName)
+}
+
+func (f *epubFile) referenceFile(filename string) error {
+	att := fmt.Sprintf("<em>&lt;attached: %s&gt;</em><br/>", html.EscapeString(filename))
+	f.contents.Lines = append(f.contents.Lines, htmlFileLine{Element: template.HTML(att)})
+	return nil
+}
+
+// WriteTranscription quotes the transcription with characters rather than
+// the HTML entities used in PDFs, which are not defined in XHTML.
+func (f *epubFile) WriteTranscription(transcription string) error {
+	transcription = strings.ReplaceAll(html.EscapeString(transcription), "\n", "<br/>")
+	quote := fmt.Sprintf("<blockquote>🎤 Transcript: “%s”</blockquote>", transcription)
+	f.contents.Lines = append(f.contents.Lines, htmlFileLine{Element: template.HTML(quote)})
+	return nil
+}
+
+// SetAvatar uses the avatar as the cover of the book, unless it is not of a
+// core image type.
+func (f *epubFile) SetAvatar(avatarPath string) {
+	if isEPUBImage(avatarPath) {
+		f.contents.Avatar = f.addImage(avatarPath, true)
+	}
+}
+
+func (f *epubFile) WriteAvatar(avatarPath string) error {
+	if !isEPUBImage(avatarPath) {
+		return nil
+	}
+	avatar := fmt.Sprintf(`<img class="avatar" src=%q alt=""/>`, f.addImage(avatarPath, false))
+	f.prefix = append(f.prefix, htmlFileLine{Element: template.HTML(avatar)})
+	return nil
+}
+
+func (f *epubFile) WriteSeparator() error {
+	f.prefix = append(f.prefix, htmlFileLine{Element: template.HTML("<hr/>")})
+	return nil
+}
+
+func isEPUBImage(imgPath string) bool {
+	_, ok := _epubImageTypes[strings.ToLower(filepath.Ext(imgPath))]
+	return ok
+}
+
+// addImage adds the image at the given path, of a core image type, to the
+// book, once, and returns its location in the book.
+func (f *epubFile) addImage(imgPath string, cover bool) string {
+	if href, ok := f.imageHrefs[imgPath]; ok {
+		return href
+	}
+	ext := strings.ToLower(filepath.Ext(imgPath))
+	mediaType := _epubImageTypes[ext]
+	id := fmt.Sprintf("image-%03d", len(f.images)+1)
+	href := "images/" + id + ext
+	f.images = append(f.images, epubImage{ID: id, Href: href, MediaType: mediaType, Cover: cover, path: imgPath})
+	f.imageHrefs[imgPath] = href
+	return href
+}
+
+// Stage packages the chapters and images into an EPUB in memory. The images
+// are read one at a time, so no more files need to be open at once.
+func (f *epubFile) Stage() (int, error) {
+	zw := zip.NewWriter(&f.buf)
+	// The MIME type must come first, uncompressed.
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return 0, fmt.Errorf("add mimetype to EPUB: %w", err)
+	}
+	if _, err := w.Write([]byte(_epubMIMEType)); err != nil {
+		return 0, fmt.Errorf("add mimetype to EPUB: %w", err)
+	}
+	if err := addZipFile(zw, "META-INF/container.xml", []byte(_epubContainer)); err != nil {
+		return 0, err
+	}
+
+	for i, chapter := range f.chapters {
+		end := len(f.contents.Lines)
+		if i+1 < len(f.chapters) {
+			end = f.chapters[i+1].start
+		}
+		var buf bytes.Buffer
+		if err := executeTemplate(&buf, f.templatePath, epubChapterData{
+			Title: chapter.Title,
+			Lines: f.contents.Lines[chapter.start:end],
+		}); err != nil {
+			return 0, err
+		}
+		if err := addZipFile(zw, "OEBPS/"+chapter.Href, buf.Bytes()); err != nil {
+			return 0, err
+		}
+	}
+
+	first, last := f.first.Format(time.DateOnly), f.last.Format(time.DateOnly)
+	var nav bytes.Buffer
+	if err := executeTemplate(&nav, "templates/epub_nav.tmpl", epubNavData{
+		Title:    f.contents.Title,
+		Cover:    f.contents.Avatar,
+		First:    first,
+		Last:     last,
+		Chapters: f.chapters,
+	}); err != nil {
+		return 0, err
+	}
+	if err := addZipFile(zw, "OEBPS/nav.xhtml", nav.Bytes()); err != nil {
+		return 0, err
+	}
+	var pkg bytes.Buffer
+	if err := executeTemplate(&pkg, "templates/epub_package.tmpl", epubPackageData{
+		Identifier: epubIdentifier(f.entityName),
+		Title:      f.contents.Title,
+		Generator:  f.contents.Generator,
+		Modified:   time.Now().UTC().Format(time.RFC3339),
+		First:      first,
+		Last:       last,
+		Chapters:   f.chapters,
+		Images:     f.images,
+	}); err != nil {
+		return 0, err
+	}
+	if err := addZipFile(zw, "OEBPS/content.opf", pkg.Bytes()); err != nil {
+		return 0, err
+	}
+
+	for _, img := range f.images {
+		contents, err := afero.ReadFile(f.fs, img.path)
+		if err != nil {
+			return 0, fmt.Errorf("read image %q: %w", img.path, err)
+		}
+		if err := addZipFile(zw, "OEBPS/"+img.Href, contents); err != nil {
+			return 0, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return 0, fmt.Errorf("finish EPUB: %w", err)
+	}
+	return 0, nil
+}
+
+func addZipFile(zw *zip.Writer, name string, contents []byte) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return fmt.Errorf("add %s to EPUB: %w", name, err)
+	}
+	if _, err := w.Write(contents); err != nil {
+		return fmt.Errorf("add %s to EPUB: %w", name, err)
+	}
+	return nil
+}
+
+// epubIdentifier returns a name-based UUID (version 5) URN for the entity, so
+// that the book of a re-exported chat replaces the old one on e-readers.
+func epubIdentifier(entityName string) string {
+	sum := sha1.Sum([]byte("bagoup:" + entityName))
+	sum[6] = sum[6]&0x0f | 0x50
+	sum[8] = sum[8]&0x3f | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+func (f *epubFile) Flush() error {
+	_, err := f.buf.WriteTo(f.File)
+	return err
+}
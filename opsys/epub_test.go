@@ -0,0 +1,194 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package opsys
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/chatdb"
+	"gotest.tools/v3/assert"
+)
+
+func TestEPUBFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	s := &opSys{Fs: fs, bagoupVersion: "v1.2.3"}
+	assert.NilError(t, afero.WriteFile(fs, "attachments/tennisballs.jpeg", []byte("jpeg"), 0644))
+	assert.NilError(t, afero.WriteFile(fs, "avatars/friend.png", []byte("png"), 0644))
+	assert.NilError(t, afero.WriteFile(fs, "avatars/alex.png", []byte("png"), 0644))
+	file, err := s.Create("friend.epub")
+	assert.NilError(t, err)
+	date := time.Date(2020, 3, 1, 15, 34, 5, 0, time.UTC)
+
+	of := s.NewEPUBFile("friend", file)
+	assert.Equal(t, of.Name(), "friend.epub")
+	of.SetAvatar("avatars/friend.png")
+	assert.NilError(t, of.WriteMessage(chatdb.Message{ID: 1, Date: date, Sender: "Me", FromMe: true, Text: "want to play tennis?"}))
+	embedded, err := of.WriteAttachment(chatdb.Attachment{Filepath: "attachments/tennisballs.jpeg"})
+	assert.NilError(t, err)
+	assert.Equal(t, embedded, true)
+	embedded, err = of.WriteAttachment(chatdb.Attachment{Filepath: "attachments/rules & scores.pdf"})
+	assert.NilError(t, err)
+	assert.Equal(t, embedded, false)
+	// TIFF images are not a core media type of EPUB.
+	embedded, err = of.WriteAttachment(chatdb.Attachment{Filepath: "attachments/scan.tiff"})
+	assert.NilError(t, err)
+	assert.Equal(t, embedded, false)
+	assert.NilError(t, of.WriteSeparator())
+	assert.NilError(t, of.WriteAvatar("avatars/alex.png"))
+	assert.NilError(t, of.WriteMessage(chatdb.Message{ID: 2, Date: date.AddDate(0, 1, 0), Sender: "Alex", Text: "sure"}))
+	assert.NilError(t, of.ReferenceAttachment(chatdb.Attachment{TransferName: "IMG_0001.png"}))
+	assert.NilError(t, of.WriteTranscription("see you soon"))
+	assert.NilError(t, of.WriteAvatar("avatars/alex.png"))
+	assert.NilError(t, of.WriteAvatar("avatars/sam.bmp"))
+	assert.NilError(t, of.WriteMessage(chatdb.Message{ID: 3, Date: date.AddDate(0, 1, 1), Sender: "Alex", Text: "at 5"}))
+	imgCount, err := of.Stage()
+	assert.NilError(t, err)
+	assert.Equal(t, imgCount, 0)
+	assert.NilError(t, of.Flush())
+
+	contents, err := afero.ReadFile(fs, "friend.epub")
+	assert.NilError(t, err)
+	zr, err := zip.NewReader(bytes.NewReader(contents), int64(len(contents)))
+	assert.NilError(t, err)
+	names := []string{}
+	files := map[string]string{}
+	for _, zf := range zr.File {
+		names = append(names, zf.Name)
+		rc, err := zf.Open()
+		assert.NilError(t, err)
+		b, err := io.ReadAll(rc)
+		assert.NilError(t, err)
+		rc.Close()
+		files[zf.Name] = string(b)
+		if zf.Name != "mimetype" && zf.Name != "OEBPS/images/image-001.png" && zf.Name != "OEBPS/images/image-002.jpeg" && zf.Name != "OEBPS/images/image-003.png" {
+			// Every document in the book must be well-formed XML.
+			dec := xml.NewDecoder(bytes.NewReader(b))
+			for {
+				_, err := dec.Token()
+				if err == io.EOF {
+					break
+				}
+				assert.NilError(t, err, zf.Name)
+			}
+		}
+	}
+	assert.Equal(t, zr.File[0].Method, zip.Store)
+	assert.DeepEqual(t, names, []string{
+		"mimetype",
+		"META-INF/container.xml",
+		"OEBPS/chapter-001.xhtml",
+		"OEBPS/chapter-002.xhtml",
+		"OEBPS/nav.xhtml",
+		"OEBPS/content.opf",
+		"OEBPS/images/image-001.png",
+		"OEBPS/images/image-002.jpeg",
+		"OEBPS/images/image-003.png",
+	})
+	assert.Equal(t, files["mimetype"], "application/epub+zip")
+	assert.Equal(t, files["OEBPS/images/image-002.jpeg"], "jpeg")
+
+	assert.Assert(t, regexp.MustCompile(`<h1>March 2020</h1>\s*\[2020-03-01 15:34:05\] Me: want to play tennis\?<br/>\s*<img src="images/image-002.jpeg" alt="tennisballs.jpeg"/><br/>\s*<em>&lt;attached: rules &amp; scores.pdf&gt;</em><br/>\s*<em>&lt;attached: scan.tiff&gt;</em><br/>\s*</section>`).MatchString(files["OEBPS/chapter-001.xhtml"]), files["OEBPS/chapter-001.xhtml"])
+	assert.Assert(t, regexp.MustCompile(`<h1>April 2020</h1>\s*<hr/>\s*<img class="avatar" src="images/image-003.png" alt=""/>\s*\[2020-04-01 15:34:05\] Alex: sure`).MatchString(files["OEBPS/chapter-002.xhtml"]), files["OEBPS/chapter-002.xhtml"])
+	assert.Assert(t, regexp.MustCompile(`🎤 Transcript: “see you soon”</blockquote>\s*<img class="avatar" src="images/image-003.png" alt=""/>\s*\[2020-04-02 15:34:05\] Alex: at 5`).MatchString(files["OEBPS/chapter-002.xhtml"]), files["OEBPS/chapter-002.xhtml"])
+
+	assert.Assert(t, regexp.MustCompile(`<img src="images/image-001.png"`).MatchString(files["OEBPS/nav.xhtml"]))
+	assert.Assert(t, regexp.MustCompile(`<p>2020-03-01 to 2020-04-02</p>`).MatchString(files["OEBPS/nav.xhtml"]))
+	assert.Assert(t, regexp.MustCompile(`<li><a href="chapter-001.xhtml">March 2020</a></li>\s*<li><a href="chapter-002.xhtml">April 2020</a></li>`).MatchString(files["OEBPS/nav.xhtml"]))
+
+	opf := files["OEBPS/content.opf"]
+	for _, want := range []string{
+		`<dc:identifier id="book-id">` + epubIdentifier("friend") + `</dc:identifier>`,
+		`<dc:title>Messages with friend</dc:title>`,
+		`<dc:coverage>2020-03-01/2020-04-02</dc:coverage>`,
+		`<meta name="generator" content="bagoup v1.2.3"/>`,
+		`<item id="image-001" href="images/image-001.png" media-type="image/png" properties="cover-image"/>`,
+		`<item id="image-002" href="images/image-002.jpeg" media-type="image/jpeg"/>`,
+		`<itemref idref="chapter-002"/>`,
+	} {
+		assert.Assert(t, bytes.Contains([]byte(opf), []byte(want)), "%s not in %s", want, opf)
+	}
+
+	// Missing image
+	file, err = s.Create("missing.epub")
+	assert.NilError(t, err)
+	of = s.NewEPUBFile("friend", file)
+	assert.NilError(t, of.WriteMessage(chatdb.Message{ID: 1, Date: date, Sender: "Me", FromMe: true, Text: "look"}))
+	_, err = of.WriteAttachment(chatdb.Attachment{Filepath: "attachments/missing.png"})
+	assert.NilError(t, err)
+	_, err = of.Stage()
+	assert.ErrorContains(t, err, `read image "attachments/missing.png"`)
+}
+
+func TestEPUBContactPhotoCover(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	s := &opSys{Fs: fs}
+	png := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+	vcf := "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Friend\r\nTEL:+15551234567\r\nPHOTO;ENCODING=b;TYPE=PNG:" + base64.StdEncoding.EncodeToString([]byte(png)) + "\r\nEND:VCARD\r\n"
+	assert.NilError(t, afero.WriteFile(fs, "contacts.vcf", []byte(vcf), 0644))
+	contactMap, _, err := s.GetContactMap([]string{"contacts.vcf"}, ContactOptions{Avatars: true})
+	assert.NilError(t, err)
+	file, err := s.Create("friend.epub")
+	assert.NilError(t, err)
+
+	of := s.NewEPUBFile("Friend", file)
+	of.SetAvatar(contactMap["+15551234567"].Value(chatdb.FieldAvatar))
+	assert.NilError(t, of.WriteMessage(chatdb.Message{ID: 1, Date: time.Date(2020, 3, 1, 15, 34, 5, 0, time.UTC), Sender: "Friend", Text: "hi"}))
+	_, err = of.Stage()
+	assert.NilError(t, err)
+	assert.NilError(t, of.Flush())
+
+	contents, err := afero.ReadFile(fs, "friend.epub")
+	assert.NilError(t, err)
+	zr, err := zip.NewReader(bytes.NewReader(contents), int64(len(contents)))
+	assert.NilError(t, err)
+	files := map[string]string{}
+	for _, zf := range zr.File {
+		rc, err := zf.Open()
+		assert.NilError(t, err)
+		b, err := io.ReadAll(rc)
+		assert.NilError(t, err)
+		rc.Close()
+		files[zf.Name] = string(b)
+	}
+	assert.Assert(t, strings.Contains(files["OEBPS/content.opf"], `<item id="image-001" href="images/image-001.png" media-type="image/png" properties="cover-image"/>`), files["OEBPS/content.opf"])
+	assert.Equal(t, files["OEBPS/images/image-001.png"], png)
+	assert.Assert(t, strings.Contains(files["OEBPS/nav.xhtml"], `<img src="images/image-001.png"`), files["OEBPS/nav.xhtml"])
+}
+
+func TestEPUBUnsupportedCover(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	s := &opSys{Fs: fs}
+	file, err := s.Create("friend.epub")
+	assert.NilError(t, err)
+	of := s.NewEPUBFile("friend", file)
+	of.SetAvatar("avatars/friend.bmp")
+	assert.NilError(t, of.WriteMessage(chatdb.Message{ID: 1, Date: time.Date(2020, 3, 1, 15, 34, 5, 0, time.UTC), Sender: "friend", Text: "hi"}))
+	_, err = of.Stage()
+	assert.NilError(t, err)
+	assert.NilError(t, of.Flush())
+
+	contents, err := afero.ReadFile(fs, "friend.epub")
+	assert.NilError(t, err)
+	zr, err := zip.NewReader(bytes.NewReader(contents), int64(len(contents)))
+	assert.NilError(t, err)
+	for _, zf := range zr.File {
+		assert.Assert(t, !strings.HasPrefix(zf.Name, "OEBPS/images/"), zf.Name)
+	}
+}
+
+func TestEPUBIdentifier(t *testing.T) {
+	id := epubIdentifier("friend")
+	assert.Assert(t, regexp.MustCompile(`^urn:uuid:[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(id), id)
+	assert.Equal(t, epubIdentifier("friend"), id)
+	assert.Assert(t, epubIdentifier("Book Club") != id)
+}
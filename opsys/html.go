@@ -0,0 +1,261 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package opsys
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"mime"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/chatdb"
+)
+
+type (
+	// HTMLOptions configure the web pages written by an HTML OutFile.
+	HTMLOptions struct {
+		// SelfContained inlines the attachments as data URIs, so that the
+		// page is a single file which can be opened anywhere.
+		SelfContained bool
+		// MaxInlineBytes is the size above which attachments are left out of
+		// a self-contained page. If zero, all attachments are inlined.
+		MaxInlineBytes int64
+	}
+
+	htmlFile struct {
+		afero.File
+		fs       afero.Fs
+		opts     HTMLOptions
+		contents htmlChatData
+		gap      bool
+		buf      bytes.Buffer
+	}
+
+	htmlChatData struct {
+		Title     string
+		Generator string
+		Created   string
+		// SelfContained leaves out the link to the index, which is not
+		// included with a self-contained page.
+		SelfContained bool
+		Messages      []htmlMessage
+	}
+	htmlMessage struct {
+		// Gap is set for the first message after messages left out of a
+		// search export.
+		Gap           bool
+		FromMe        bool
+		Sender        string
+		Time          string
+		Text          string
+		Attachments   []htmlAttachment
+		Transcription string
+	}
+	htmlAttachment struct {
+		// Kind is img, video, audio, or link for attachments which can be
+		// found, omitted for those too large to inline, named for those which
+		// are not media and so are not inlined, and missing otherwise.
+		Kind string
+		// URL is escaped already, and may be a file URL, which html/template
+		// would otherwise reject.
+		URL  template.URL
+		Name string
+	}
+
+	// HTMLIndexEntry summarizes the chat files of an entity in an HTML export,
+	// for the index of the export.
+	HTMLIndexEntry struct {
+		Entity string
+		// Files are the paths to the entity's chat files, relative to the
+		// export folder.
+		Files    []string
+		Messages int
+		First    time.Time
+		Last     time.Time
+	}
+
+	htmlIndexData struct {
+		Title     string
+		Generator string
+		Created   string
+		Entries   []HTMLIndexEntry
+	}
+)
+
+func (s *opSys) NewHTMLFile(entityName string, chatFile afero.File, opts HTMLOptions) OutFile {
+	return &htmlFile{
+		File: chatFile,
+		fs:   s.Fs,
+		opts: opts,
+		contents: htmlChatData{
+			Title:         fmt.Sprintf("Messages with %s", entityName),
+			Generator:     fmt.Sprintf("bagoup %s", s.bagoupVersion),
+			Created:       time.Now().Format(time.RFC3339),
+			SelfContained: opts.SelfContained,
+			Messages:      []htmlMessage{},
+		},
+	}
+}
+
+func (f *htmlFile) WriteMessage(msg chatdb.Message) error {
+	f.contents.Messages = append(f.contents.Messages, htmlMessage{
+		Gap:    f.gap,
+		FromMe: msg.FromMe,
+		Sender: msg.Sender,
+		Time:   msg.Date.Format(time.DateTime),
+		Text:   messageText(msg),
+	})
+	f.gap = false
+	return nil
+}
+
+func (f *htmlFile) lastMessage() (*htmlMessage, error) {
+	if len(f.contents.Messages) == 0 {
+		return nil, fmt.Errorf("no message in %q to attach to", f.Name())
+	}
+	return &f.contents.Messages[len(f.contents.Messages)-1], nil
+}
+
+// WriteAttachment adds an image, a video or audio player, or else a link for
+// the attachment. Copied attachments are linked relative to the chat file,
+// so that the export folder can be moved. In a self-contained page, media are
+// inlined instead, and other attachments are only named.
+func (f *htmlFile) WriteAttachment(att chatdb.Attachment) (bool, error) {
+	msg, err := f.lastMessage()
+	if err != nil {
+		return false, err
+	}
+	name := filepath.Base(att.Filepath)
+	kind := "link"
+	switch {
+	case slices.Contains(_embeddableImageTypes, strings.ToLower(filepath.Ext(att.Filepath))):
+		kind = "img"
+	case strings.HasPrefix(att.MIMEType, "video/"):
+		kind = "video"
+	case strings.HasPrefix(att.MIMEType, "audio/"):
+		kind = "audio"
+	}
+	if f.opts.SelfContained {
+		if kind == "link" {
+			msg.Attachments = append(msg.Attachments, htmlAttachment{Kind: "named", Name: name})
+			return false, nil
+		}
+		dataURL, err := f.dataURL(att)
+		if err != nil {
+			return false, err
+		}
+		if dataURL == "" {
+			msg.Attachments = append(msg.Attachments, htmlAttachment{Kind: "omitted", Name: name})
+			return false, nil
+		}
+		msg.Attachments = append(msg.Attachments, htmlAttachment{Kind: kind, URL: dataURL, Name: name})
+		return true, nil
+	}
+	attURL := template.URL("file://" + urlEscapeFilePath(att.Filepath))
+	if att.CopiedPath != "" {
+		if rel, err := filepath.Rel(filepath.Dir(f.Name()), att.CopiedPath); err == nil {
+			attURL = template.URL(urlEscapeFilePath(rel))
+		}
+	}
+	msg.Attachments = append(msg.Attachments, htmlAttachment{Kind: kind, URL: attURL, Name: name})
+	return kind != "link", nil
+}
+
+// dataURL returns the attachment encoded as a data URI, or an empty URL if it
+// is larger than the maximum inline size.
+func (f *htmlFile) dataURL(att chatdb.Attachment) (template.URL, error) {
+	info, err := f.fs.Stat(att.Filepath)
+	if err != nil {
+		return "", fmt.Errorf("get size of attachment %q: %w", att.Filepath, err)
+	}
+	if f.opts.MaxInlineBytes > 0 && info.Size() > f.opts.MaxInlineBytes {
+		return "", nil
+	}
+	data, err := afero.ReadFile(f.fs, att.Filepath)
+	if err != nil {
+		return "", fmt.Errorf("read attachment %q: %w", att.Filepath, err)
+	}
+	mimeType := att.MIMEType
+	if mimeType == "" {
+		mimeType = mime.TypeByExtension(filepath.Ext(att.Filepath))
+	}
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	return template.URL(fmt.Sprintf("data:%s;base64,%s", mimeType, base64.StdEncoding.EncodeToString(data))), nil
+}
+
+func (f *htmlFile) ReferenceAttachment(att chatdb.Attachment) error {
+	msg, err := f.lastMessage()
+	if err != nil {
+		return err
+	}
+	msg.Attachments = append(msg.Attachments, htmlAttachment{Kind: "missing", Name: att.TransferName})
+	return nil
+}
+
+func (f *htmlFile) WriteTranscription(transcription string) error {
+	msg, err := f.lastMessage()
+	if err != nil {
+		return err
+	}
+	msg.Transcription = transcription
+	return nil
+}
+
+// SetAvatar is a no-op, since contact photos are only kept in a temporary
+// directory.
+func (f *htmlFile) SetAvatar(avatarPath string) {}
+
+// WriteAvatar is a no-op, like SetAvatar.
+func (f *htmlFile) WriteAvatar(avatarPath string) error {
+	return nil
+}
+
+func (f *htmlFile) WriteSeparator() error {
+	f.gap = true
+	return nil
+}
+
+func (f *htmlFile) Stage() (int, error) {
+	if err := executeTemplate(&f.buf, "templates/html.tmpl", f.contents); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+func (f *htmlFile) Flush() error {
+	_, err := f.buf.WriteTo(f.File)
+	return err
+}
+
+func executeTemplate(buf *bytes.Buffer, templatePath string, data any) error {
+	tmpl, err := template.ParseFS(_embedFS, templatePath)
+	if err != nil {
+		return fmt.Errorf("parse HTML template: %w", err)
+	}
+	if err := tmpl.Execute(buf, data); err != nil {
+		return fmt.Errorf("execute HTML template: %w", err)
+	}
+	return nil
+}
+
+func (s *opSys) WriteHTMLIndex(indexPath string, entries []HTMLIndexEntry) error {
+	var buf bytes.Buffer
+	if err := executeTemplate(&buf, "templates/html_index.tmpl", htmlIndexData{
+		Title:     "Messages",
+		Generator: fmt.Sprintf("bagoup %s", s.bagoupVersion),
+		Created:   time.Now().Format(time.RFC3339),
+		Entries:   entries,
+	}); err != nil {
+		return err
+	}
+	return afero.WriteFile(s, indexPath, buf.Bytes(), 0644)
+}
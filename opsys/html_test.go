@@ -0,0 +1,157 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package opsys
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/chatdb"
+	"gotest.tools/v3/assert"
+	is "gotest.tools/v3/assert/cmp"
+)
+
+func TestHTMLFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	s := &opSys{Fs: fs, bagoupVersion: "v2.0.0"}
+	file, err := s.Create("export/friend/chat.html")
+	assert.NilError(t, err)
+	of := s.NewHTMLFile("friend", file, HTMLOptions{})
+	date := time.Date(2020, 3, 1, 15, 34, 5, 0, time.UTC)
+
+	assert.Equal(t, of.Name(), "export/friend/chat.html")
+	_, err = of.WriteAttachment(chatdb.Attachment{Filepath: "tennisballs.jpeg"})
+	assert.Error(t, err, `no message in "export/friend/chat.html" to attach to`)
+
+	assert.NilError(t, of.WriteMessage(chatdb.Message{Date: date, Sender: "Me", FromMe: true, Text: "Want to play <tennis>?"}))
+	embedded, err := of.WriteAttachment(chatdb.Attachment{Filepath: "export/friend/attachments/tennis balls.jpeg", CopiedPath: "export/friend/attachments/tennis balls.jpeg", MIMEType: "image/jpeg"})
+	assert.NilError(t, err)
+	assert.Equal(t, embedded, true)
+	assert.NilError(t, of.WriteSeparator())
+	assert.NilError(t, of.WriteMessage(chatdb.Message{Date: date, Sender: "friend", Text: "\uFFFC", IsAudio: true}))
+	embedded, err = of.WriteAttachment(chatdb.Attachment{Filepath: "/Users/me/Library/Messages/Attachments/serve.mov", MIMEType: "video/quicktime"})
+	assert.NilError(t, err)
+	assert.Equal(t, embedded, true)
+	embedded, err = of.WriteAttachment(chatdb.Attachment{Filepath: "export/friend/attachments/Audio Message.caf", CopiedPath: "export/friend/attachments/Audio Message.caf", MIMEType: "audio/x-caf"})
+	assert.NilError(t, err)
+	assert.Equal(t, embedded, true)
+	assert.NilError(t, of.WriteTranscription("see you soon"))
+	embedded, err = of.WriteAttachment(chatdb.Attachment{Filepath: "export/friend/attachments/scores.pdf", CopiedPath: "export/friend/attachments/scores.pdf", MIMEType: "application/pdf"})
+	assert.NilError(t, err)
+	assert.Equal(t, embedded, false)
+	assert.NilError(t, of.ReferenceAttachment(chatdb.Attachment{TransferName: "IMG_0001.png"}))
+	of.SetAvatar("avatar.jpg")
+	assert.NilError(t, of.WriteAvatar("avatar.jpg"))
+
+	imgCount, err := of.Stage()
+	assert.NilError(t, err)
+	assert.Equal(t, imgCount, 0)
+	assert.NilError(t, of.Flush())
+
+	contents, err := afero.ReadFile(fs, "export/friend/chat.html")
+	assert.NilError(t, err)
+	page := string(contents)
+	for _, want := range []string{
+		`<title>Messages with friend</title>`,
+		`<meta name="generator" content="bagoup v2.0.0">`,
+		`<div class="message from-me">`,
+		`<div class="bubble">Want to play &lt;tennis&gt;?</div>`,
+		`<img src="attachments/tennis%20balls.jpeg" alt="tennis balls.jpeg"/>`,
+		`<hr/>`,
+		`<div class="bubble">🎤 Voice memo</div>`,
+		`<video controls preload="metadata" src="file:///Users/me/Library/Messages/Attachments/serve.mov"></video>`,
+		`<audio controls preload="metadata" src="attachments/Audio%20Message.caf"></audio>`,
+		`<blockquote>🎤 Transcript: &ldquo;see you soon&rdquo;</blockquote>`,
+		`<a href="attachments/scores.pdf">scores.pdf</a>`,
+		`<span class="missing">&lt;attached: IMG_0001.png&gt;</span>`,
+		`<a href="../index.html">All chats</a>`,
+	} {
+		assert.Check(t, is.Contains(page, want))
+	}
+	assert.Check(t, !strings.Contains(page, "￼"))
+	assert.Check(t, !strings.Contains(page, "avatar.jpg"))
+}
+
+func TestSelfContainedHTMLFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	assert.NilError(t, afero.WriteFile(fs, "tmp/IMG_0001.jpeg", []byte("jpeg"), 0644))
+	assert.NilError(t, afero.WriteFile(fs, "attachments/voice.m4a", []byte("m4a"), 0644))
+	assert.NilError(t, afero.WriteFile(fs, "attachments/serve.mov", []byte("a long movie"), 0644))
+	assert.NilError(t, afero.WriteFile(fs, "attachments/drawing.svg", []byte("<svg/>"), 0644))
+	s := &opSys{Fs: fs}
+	file, err := s.Create("export/friend/chat.html")
+	assert.NilError(t, err)
+	of := s.NewHTMLFile("friend", file, HTMLOptions{SelfContained: true, MaxInlineBytes: 10})
+
+	assert.NilError(t, of.WriteMessage(chatdb.Message{Sender: "friend", Text: "￼￼￼￼￼"}))
+	for _, tc := range []struct {
+		att          chatdb.Attachment
+		wantEmbedded bool
+	}{
+		// A HEIC image converted to JPEG
+		{att: chatdb.Attachment{Filepath: "tmp/IMG_0001.jpeg", MIMEType: "image/jpeg"}, wantEmbedded: true},
+		{att: chatdb.Attachment{Filepath: "attachments/voice.m4a", MIMEType: "audio/x-m4a"}, wantEmbedded: true},
+		{att: chatdb.Attachment{Filepath: "attachments/serve.mov", MIMEType: "video/quicktime"}},
+		{att: chatdb.Attachment{Filepath: "attachments/drawing.svg"}, wantEmbedded: true},
+		{att: chatdb.Attachment{Filepath: "attachments/scores.pdf", MIMEType: "application/pdf"}},
+	} {
+		embedded, err := of.WriteAttachment(tc.att)
+		assert.NilError(t, err)
+		assert.Equal(t, embedded, tc.wantEmbedded, tc.att.Filepath)
+	}
+	_, err = of.WriteAttachment(chatdb.Attachment{Filepath: "attachments/missing.png"})
+	assert.ErrorContains(t, err, `get size of attachment "attachments/missing.png": `)
+	_, err = of.Stage()
+	assert.NilError(t, err)
+	assert.NilError(t, of.Flush())
+
+	contents, err := afero.ReadFile(fs, "export/friend/chat.html")
+	assert.NilError(t, err)
+	page := string(contents)
+	for _, want := range []string{
+		`<img src="data:image/jpeg;base64,anBlZw==" alt="IMG_0001.jpeg"/>`,
+		`<audio controls preload="metadata" src="data:audio/x-m4a;base64,bTRh"></audio>`,
+		`<span class="missing">&lt;attached: serve.mov (too large to include)&gt;</span>`,
+		`<img src="data:image/svg&#43;xml;base64,PHN2Zy8&#43;" alt="drawing.svg"/>`,
+		`<span class="missing">&lt;attached: scores.pdf (not included)&gt;</span>`,
+	} {
+		assert.Check(t, is.Contains(page, want))
+	}
+	assert.Check(t, !strings.Contains(page, "index.html"))
+	assert.Check(t, !strings.Contains(page, "<a href"))
+}
+
+func TestWriteHTMLIndex(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	s := &opSys{Fs: fs, bagoupVersion: "v2.0.0"}
+	assert.NilError(t, s.WriteHTMLIndex("export/index.html", []HTMLIndexEntry{
+		{
+			Entity:   "Book Club",
+			Files:    []string{"Book Club/iMessage;+;chat123456.html"},
+			Messages: 120,
+			First:    time.Date(2021, 5, 1, 9, 0, 0, 0, time.UTC),
+			Last:     time.Date(2023, 6, 15, 21, 30, 0, 0, time.UTC),
+		},
+		{
+			Entity: "friend",
+			Files:  []string{"friend/iMessage;-;friend@gmail.com.html", "friend/SMS;-;+15551234567.html"},
+		},
+	}))
+
+	contents, err := afero.ReadFile(fs, "export/index.html")
+	assert.NilError(t, err)
+	page := string(contents)
+	for _, want := range []string{
+		`<a href="Book%20Club/iMessage;&#43;;chat123456.html">Book Club</a>`,
+		`<td class="count">120</td>`,
+		`<td>2021-05-01</td>`,
+		`<td>2023-06-15</td>`,
+		`<a href="friend/iMessage;-;friend@gmail.com.html">friend</a><br/><a href="friend/SMS;-;&#43;15551234567.html">friend (1)</a>`,
+		`<td class="count">0</td>`,
+	} {
+		assert.Check(t, is.Contains(page, want))
+	}
+}
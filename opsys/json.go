@@ -0,0 +1,170 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package opsys
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/chatdb"
+)
+
+type (
+	jsonFile struct {
+		afero.File
+		contents jsonChat
+		// gap is set by WriteSeparator, to mark the next message.
+		gap bool
+		buf bytes.Buffer
+	}
+
+	jsonChat struct {
+		Entity       string        `json:"entity"`
+		GUIDs        []string      `json:"guids"`
+		Participants []string      `json:"participants"`
+		Generator    string        `json:"generator"`
+		Created      string        `json:"created"`
+		Messages     []jsonMessage `json:"messages"`
+	}
+	jsonMessage struct {
+		ID       int    `json:"id"`
+		ChatGUID string `json:"chat_guid"`
+		Date     string `json:"date"`
+		Sender   string `json:"sender"`
+		FromMe   bool   `json:"from_me"`
+		// Text is the raw text of the message, including the object
+		// replacement characters marking its attachments.
+		Text string `json:"text"`
+		// DisplayText is the text as shown in the other formats, without the
+		// object replacement characters, and labeled if it is a voice memo.
+		DisplayText string `json:"display_text"`
+		Valid       bool   `json:"valid"`
+		// Status tells recovered text apart from valid text (see
+		// chatdb.TextStatus).
+		Status             string           `json:"status"`
+		VoiceMemo          bool             `json:"voice_memo,omitempty"`
+		AudioTranscription string           `json:"audio_transcription,omitempty"`
+		Attachments        []jsonAttachment `json:"attachments"`
+		// Gap marks the first message after messages left out of a search
+		// export.
+		Gap bool `json:"gap,omitempty"`
+	}
+	jsonAttachment struct {
+		OriginalPath string `json:"original_path"`
+		CopiedPath   string `json:"copied_path,omitempty"`
+		MIMEType     string `json:"mime_type"`
+		TransferName string `json:"transfer_name"`
+	}
+)
+
+func (s *opSys) NewJSONFile(entityName string, guids, participants []string, chatFile afero.File) OutFile {
+	return &jsonFile{
+		File: chatFile,
+		contents: jsonChat{
+			Entity:       entityName,
+			GUIDs:        guids,
+			Participants: append([]string{}, participants...),
+			Generator:    fmt.Sprintf("bagoup %s", s.bagoupVersion),
+			Created:      time.Now().Format(time.RFC3339),
+			Messages:     []jsonMessage{},
+		},
+	}
+}
+
+func newJSONMessage(msg chatdb.Message, gap bool) jsonMessage {
+	return jsonMessage{
+		ID:          msg.ID,
+		ChatGUID:    msg.ChatGUID,
+		Date:        msg.Date.Format(time.RFC3339),
+		Sender:      msg.Sender,
+		FromMe:      msg.FromMe,
+		Text:        msg.Text,
+		DisplayText: messageText(msg),
+		Valid:       msg.Status != chatdb.TextInvalid,
+		Status:      msg.Status.String(),
+		VoiceMemo:   msg.IsAudio,
+		Attachments: []jsonAttachment{},
+		Gap:         gap,
+	}
+}
+
+func newJSONAttachment(att chatdb.Attachment) jsonAttachment {
+	return jsonAttachment{
+		OriginalPath: att.Filename,
+		CopiedPath:   att.CopiedPath,
+		MIMEType:     att.MIMEType,
+		TransferName: att.TransferName,
+	}
+}
+
+// WriteMessage adds the message, and its sender to the participants if they
+// are no longer a member of the chat.
+func (f *jsonFile) WriteMessage(msg chatdb.Message) error {
+	f.contents.Messages = append(f.contents.Messages, newJSONMessage(msg, f.gap))
+	f.gap = false
+	if !msg.FromMe && msg.Sender != "" && !slices.Contains(f.contents.Participants, msg.Sender) {
+		f.contents.Participants = append(f.contents.Participants, msg.Sender)
+	}
+	return nil
+}
+
+// lastMessage returns the message to which attachments and transcriptions
+// belong, i.e. the last message written.
+func (f *jsonFile) lastMessage() (*jsonMessage, error) {
+	if len(f.contents.Messages) == 0 {
+		return nil, fmt.Errorf("no message in %q to attach to", f.Name())
+	}
+	return &f.contents.Messages[len(f.contents.Messages)-1], nil
+}
+
+func (f *jsonFile) WriteAttachment(att chatdb.Attachment) (bool, error) {
+	return false, f.ReferenceAttachment(att)
+}
+
+func (f *jsonFile) ReferenceAttachment(att chatdb.Attachment) error {
+	msg, err := f.lastMessage()
+	if err != nil {
+		return err
+	}
+	msg.Attachments = append(msg.Attachments, newJSONAttachment(att))
+	return nil
+}
+
+func (f *jsonFile) WriteTranscription(transcription string) error {
+	msg, err := f.lastMessage()
+	if err != nil {
+		return err
+	}
+	msg.AudioTranscription = transcription
+	return nil
+}
+
+func (f *jsonFile) SetAvatar(avatarPath string) {}
+
+func (f *jsonFile) WriteAvatar(avatarPath string) error {
+	return nil
+}
+
+func (f *jsonFile) WriteSeparator() error {
+	f.gap = true
+	return nil
+}
+
+func (f *jsonFile) Stage() (int, error) {
+	enc := json.NewEncoder(&f.buf)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(f.contents); err != nil {
+		return 0, fmt.Errorf("encode JSON: %w", err)
+	}
+	return 0, nil
+}
+
+func (f *jsonFile) Flush() error {
+	_, err := f.buf.WriteTo(f.File)
+	return err
+}
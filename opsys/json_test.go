@@ -0,0 +1,76 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package opsys
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/chatdb"
+	"gotest.tools/v3/assert"
+)
+
+func TestJSONFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	s := &opSys{Fs: fs, bagoupVersion: "v2.0.0"}
+	file, err := s.Create("testfile.json")
+	assert.NilError(t, err)
+	of := s.NewJSONFile("friend", []string{"iMessage;-;friend@gmail.com", "iMessage;+;chat123456"}, []string{"friend", "Alex"}, file)
+
+	assert.Equal(t, of.Name(), "testfile.json")
+	assert.Error(t, of.WriteTranscription("too early"), `no message in "testfile.json" to attach to`)
+
+	date := time.Date(2020, 3, 1, 15, 34, 5, 0, time.UTC)
+	assert.NilError(t, of.WriteMessage(chatdb.Message{ID: 1, ChatGUID: "iMessage;-;friend@gmail.com", Date: date, Sender: "Me", FromMe: true, Text: "hi", Status: chatdb.TextValid}))
+	assert.NilError(t, of.WriteMessage(chatdb.Message{ID: 2, ChatGUID: "iMessage;-;friend@gmail.com", Date: date, Sender: "friend", Text: "\uFFFC", Status: chatdb.TextRecovered, IsAudio: true}))
+	embedded, err := of.WriteAttachment(chatdb.Attachment{
+		Filename:     "~/Library/Messages/Attachments/IMG_0001.heic",
+		Filepath:     "export/friend/attachments/IMG_0001.jpeg",
+		CopiedPath:   "export/friend/attachments/IMG_0001.heic",
+		MIMEType:     "image/jpeg",
+		TransferName: "IMG_0001.heic",
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, embedded, false)
+	assert.NilError(t, of.ReferenceAttachment(chatdb.Attachment{MIMEType: "image/png", TransferName: "missing.png"}))
+	assert.NilError(t, of.WriteTranscription("see you soon"))
+	assert.NilError(t, of.WriteSeparator())
+	assert.NilError(t, of.WriteMessage(chatdb.Message{ID: 9, ChatGUID: "iMessage;+;chat123456", Date: date, Sender: "Sam", Status: chatdb.TextInvalid}))
+
+	// Avatars are not included.
+	of.SetAvatar("avatar.jpg")
+	assert.NilError(t, of.WriteAvatar("avatar.jpg"))
+
+	imgCount, err := of.Stage()
+	assert.NilError(t, err)
+	assert.Equal(t, imgCount, 0)
+	assert.NilError(t, of.Flush())
+
+	contents, err := afero.ReadFile(fs, "testfile.json")
+	assert.NilError(t, err)
+	var chat jsonChat
+	assert.NilError(t, json.Unmarshal(contents, &chat))
+	assert.Assert(t, chat.Created != "")
+	chat.Created = ""
+	assert.DeepEqual(t, chat, jsonChat{
+		Entity:       "friend",
+		GUIDs:        []string{"iMessage;-;friend@gmail.com", "iMessage;+;chat123456"},
+		Participants: []string{"friend", "Alex", "Sam"},
+		Generator:    "bagoup v2.0.0",
+		Messages: []jsonMessage{
+			{ID: 1, ChatGUID: "iMessage;-;friend@gmail.com", Date: "2020-03-01T15:34:05Z", Sender: "Me", FromMe: true, Text: "hi", DisplayText: "hi", Valid: true, Status: "valid", Attachments: []jsonAttachment{}},
+			{
+				ID: 2, ChatGUID: "iMessage;-;friend@gmail.com", Date: "2020-03-01T15:34:05Z", Sender: "friend", Text: "\uFFFC", DisplayText: "🎤 Voice memo", Valid: true, Status: "recovered",
+				VoiceMemo: true, AudioTranscription: "see you soon",
+				Attachments: []jsonAttachment{
+					{OriginalPath: "~/Library/Messages/Attachments/IMG_0001.heic", CopiedPath: "export/friend/attachments/IMG_0001.heic", MIMEType: "image/jpeg", TransferName: "IMG_0001.heic"},
+					{MIMEType: "image/png", TransferName: "missing.png"},
+				},
+			},
+			{ID: 9, ChatGUID: "iMessage;+;chat123456", Date: "2020-03-01T15:34:05Z", Sender: "Sam", Status: "invalid", Attachments: []jsonAttachment{}, Gap: true},
+		},
+	})
+}
@@ -0,0 +1,116 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package opsys
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/chatdb"
+)
+
+type (
+	jsonlFile struct {
+		afero.File
+		entity string
+		guids  []string
+		w      *bufio.Writer
+		// msg is the message being written, which is encoded once its
+		// attachments and transcription are known.
+		msg *jsonlMessage
+		gap bool
+	}
+
+	// jsonlMessage is a message labeled with its entity and chats, since the
+	// lines of a JSON Lines file may come from any number of chats.
+	jsonlMessage struct {
+		Entity string   `json:"entity"`
+		GUIDs  []string `json:"guids"`
+		jsonMessage
+	}
+)
+
+func (s *opSys) NewJSONLFile(entityName string, guids []string, chatFile afero.File) OutFile {
+	return &jsonlFile{
+		File:   chatFile,
+		entity: entityName,
+		guids:  guids,
+		w:      bufio.NewWriter(chatFile),
+	}
+}
+
+func (f *jsonlFile) WriteMessage(msg chatdb.Message) error {
+	if err := f.encodeMessage(); err != nil {
+		return err
+	}
+	f.msg = &jsonlMessage{
+		Entity:      f.entity,
+		GUIDs:       f.guids,
+		jsonMessage: newJSONMessage(msg, f.gap),
+	}
+	f.gap = false
+	return nil
+}
+
+// encodeMessage writes the message being written as a line of JSON.
+func (f *jsonlFile) encodeMessage() error {
+	if f.msg == nil {
+		return nil
+	}
+	if err := json.NewEncoder(f.w).Encode(f.msg); err != nil {
+		return fmt.Errorf("encode message %d: %w", f.msg.ID, err)
+	}
+	f.msg = nil
+	return nil
+}
+
+func (f *jsonlFile) lastMessage() (*jsonlMessage, error) {
+	if f.msg == nil {
+		return nil, fmt.Errorf("no message in %q to attach to", f.Name())
+	}
+	return f.msg, nil
+}
+
+func (f *jsonlFile) WriteAttachment(att chatdb.Attachment) (bool, error) {
+	return false, f.ReferenceAttachment(att)
+}
+
+func (f *jsonlFile) ReferenceAttachment(att chatdb.Attachment) error {
+	msg, err := f.lastMessage()
+	if err != nil {
+		return err
+	}
+	msg.Attachments = append(msg.Attachments, newJSONAttachment(att))
+	return nil
+}
+
+func (f *jsonlFile) WriteTranscription(transcription string) error {
+	msg, err := f.lastMessage()
+	if err != nil {
+		return err
+	}
+	msg.AudioTranscription = transcription
+	return nil
+}
+
+func (f *jsonlFile) SetAvatar(avatarPath string) {}
+
+func (f *jsonlFile) WriteAvatar(avatarPath string) error {
+	return nil
+}
+
+func (f *jsonlFile) WriteSeparator() error {
+	f.gap = true
+	return nil
+}
+
+func (f *jsonlFile) Stage() (int, error) {
+	return 0, f.encodeMessage()
+}
+
+func (f *jsonlFile) Flush() error {
+	return f.w.Flush()
+}
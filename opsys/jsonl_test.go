@@ -0,0 +1,52 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package opsys
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/chatdb"
+	"gotest.tools/v3/assert"
+)
+
+func TestJSONLFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	s := &opSys{Fs: fs}
+	file, err := s.Create("testfile.jsonl")
+	assert.NilError(t, err)
+	date := time.Date(2020, 3, 1, 15, 34, 5, 0, time.UTC)
+
+	// Two chats streamed to the same file
+	of := s.NewJSONLFile("friend", []string{"iMessage;-;friend@gmail.com"}, file)
+	assert.Equal(t, of.Name(), "testfile.jsonl")
+	assert.Error(t, of.ReferenceAttachment(chatdb.Attachment{}), `no message in "testfile.jsonl" to attach to`)
+	assert.NilError(t, of.WriteMessage(chatdb.Message{ID: 1, ChatGUID: "iMessage;-;friend@gmail.com", Date: date, Sender: "Me", FromMe: true, Text: "hi", Status: chatdb.TextValid}))
+	embedded, err := of.WriteAttachment(chatdb.Attachment{Filename: "~/Library/Messages/Attachments/IMG_0001.heic", MIMEType: "image/heic", TransferName: "IMG_0001.heic"})
+	assert.NilError(t, err)
+	assert.Equal(t, embedded, false)
+	assert.NilError(t, of.WriteTranscription("see you soon"))
+	assert.NilError(t, of.WriteSeparator())
+	assert.NilError(t, of.WriteMessage(chatdb.Message{ID: 2, ChatGUID: "iMessage;-;friend@gmail.com", Date: date, Sender: "friend", Status: chatdb.TextInvalid}))
+	of.SetAvatar("avatar.jpg")
+	assert.NilError(t, of.WriteAvatar("avatar.jpg"))
+	imgCount, err := of.Stage()
+	assert.NilError(t, err)
+	assert.Equal(t, imgCount, 0)
+	assert.NilError(t, of.Flush())
+
+	of = s.NewJSONLFile("Book Club", []string{"iMessage;+;chat123456"}, file)
+	assert.NilError(t, of.WriteMessage(chatdb.Message{ID: 3, ChatGUID: "iMessage;+;chat123456", Date: date, Sender: "Alex", Text: "hello", Status: chatdb.TextRecovered}))
+	_, err = of.Stage()
+	assert.NilError(t, err)
+	assert.NilError(t, of.Flush())
+
+	contents, err := afero.ReadFile(fs, "testfile.jsonl")
+	assert.NilError(t, err)
+	assert.Equal(t, string(contents), `{"entity":"friend","guids":["iMessage;-;friend@gmail.com"],"id":1,"chat_guid":"iMessage;-;friend@gmail.com","date":"2020-03-01T15:34:05Z","sender":"Me","from_me":true,"text":"hi","display_text":"hi","valid":true,"status":"valid","audio_transcription":"see you soon","attachments":[{"original_path":"~/Library/Messages/Attachments/IMG_0001.heic","mime_type":"image/heic","transfer_name":"IMG_0001.heic"}]}
+{"entity":"friend","guids":["iMessage;-;friend@gmail.com"],"id":2,"chat_guid":"iMessage;-;friend@gmail.com","date":"2020-03-01T15:34:05Z","sender":"friend","from_me":false,"text":"","display_text":"","valid":false,"status":"invalid","attachments":[],"gap":true}
+{"entity":"Book Club","guids":["iMessage;+;chat123456"],"id":3,"chat_guid":"iMessage;+;chat123456","date":"2020-03-01T15:34:05Z","sender":"Alex","from_me":false,"text":"hello","display_text":"hello","valid":true,"status":"recovered","attachments":[]}
+`)
+}
@@ -0,0 +1,234 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package opsys
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/chatdb"
+	"gopkg.in/yaml.v3"
+)
+
+// DailyNotesDir is the folder of the vault holding the per-day notes of a
+// Markdown export with MarkdownOptions.DailyNotes.
+const DailyNotesDir = "Daily"
+
+var (
+	// _markdownEscaper escapes the characters which Markdown, or Obsidian's
+	// extensions to it, read as formatting, links, embeds, tags, or comments.
+	_markdownEscaper = strings.NewReplacer(
+		`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `~`, `\~`, `=`, `\=`,
+		`[`, `\[`, `]`, `\]`, `<`, `\<`, `>`, `\>`, `#`, `\#`, `|`, `\|`,
+		`$`, `\$`, `%`, `\%`,
+	)
+	// List items and thematic breaks start with these, e.g. "- ", "1. ", or
+	// "---", which would also make the line above a heading.
+	_markdownLineStartRE = regexp.MustCompile(`(?m)^([ \t]*)([-+])|^([ \t]*\d+)([.)])`)
+)
+
+type (
+	// MarkdownOptions configure the notes written by a Markdown OutFile.
+	MarkdownOptions struct {
+		// VaultPath is the root of the Obsidian vault, i.e. the export
+		// folder, relative to which attachments are embedded and notes are
+		// linked.
+		VaultPath string
+		// DailyNotes moves the messages into a note per day, in the
+		// DailyNotesDir folder of the vault, leaving the chat's note with
+		// links to the days.
+		DailyNotes bool
+	}
+
+	markdownFile struct {
+		afero.File
+		fs          afero.Fs
+		opts        MarkdownOptions
+		entity      string
+		frontMatter markdownFrontMatter
+		days        []markdownDay
+		gap         bool
+		buf         bytes.Buffer
+	}
+
+	markdownFrontMatter struct {
+		Entity       string   `yaml:"entity"`
+		Participants []string `yaml:"participants"`
+		FirstMessage string   `yaml:"first_message,omitempty"`
+		LastMessage  string   `yaml:"last_message,omitempty"`
+		ChatGUIDs    []string `yaml:"chat_guids"`
+		Generator    string   `yaml:"generator"`
+		Created      string   `yaml:"created"`
+	}
+
+	// markdownDay holds the messages of a day, in Markdown.
+	markdownDay struct {
+		date string
+		body *strings.Builder
+	}
+)
+
+func (s *opSys) NewMarkdownFile(entityName string, guids []string, chatFile afero.File, opts MarkdownOptions) OutFile {
+	return &markdownFile{
+		File:   chatFile,
+		fs:     s.Fs,
+		opts:   opts,
+		entity: entityName,
+		frontMatter: markdownFrontMatter{
+			Entity:       entityName,
+			Participants: []string{},
+			ChatGUIDs:    guids,
+			Generator:    fmt.Sprintf("bagoup %s", s.bagoupVersion),
+			Created:      time.Now().Format(time.RFC3339),
+		},
+	}
+}
+
+func (f *markdownFile) WriteMessage(msg chatdb.Message) error {
+	date := msg.Date.Format(time.DateOnly)
+	if len(f.days) == 0 || f.days[len(f.days)-1].date != date {
+		f.days = append(f.days, markdownDay{date: date, body: &strings.Builder{}})
+	}
+	body := f.days[len(f.days)-1].body
+	if body.Len() > 0 {
+		body.WriteString("\n")
+	}
+	if f.gap {
+		body.WriteString("---\n\n")
+		f.gap = false
+	}
+	fmt.Fprintf(body, "**%s** %s\n", escapeMarkdown(msg.Sender), msg.Date.Format(time.TimeOnly))
+	if text := messageText(msg); text != "" {
+		body.WriteString(escapeMarkdown(text) + "\n")
+	}
+
+	if f.frontMatter.FirstMessage == "" {
+		f.frontMatter.FirstMessage = msg.Date.Format(time.RFC3339)
+	}
+	f.frontMatter.LastMessage = msg.Date.Format(time.RFC3339)
+	if !msg.FromMe && msg.Sender != "" && !slices.Contains(f.frontMatter.Participants, msg.Sender) {
+		f.frontMatter.Participants = append(f.frontMatter.Participants, msg.Sender)
+	}
+	return nil
+}
+
+// writeLine adds a line for the attachment or transcription of the last
+// message.
+func (f *markdownFile) writeLine(line, what string) error {
+	if len(f.days) == 0 {
+		return fmt.Errorf("no message in %q to attach %s to", f.Name(), what)
+	}
+	f.days[len(f.days)-1].body.WriteString(line + "\n")
+	return nil
+}
+
+// WriteAttachment embeds a copied attachment with a wikilink relative to the
+// vault, or else links to the attachment where it was found.
+func (f *markdownFile) WriteAttachment(att chatdb.Attachment) (bool, error) {
+	if att.CopiedPath != "" {
+		if rel, err := filepath.Rel(f.opts.VaultPath, att.CopiedPath); err == nil {
+			return true, f.writeLine(fmt.Sprintf("![[%s]]", filepath.ToSlash(rel)), fmt.Sprintf("%q", att.CopiedPath))
+		}
+	}
+	return false, f.writeLine(fmt.Sprintf("[%s](<file://%s>)", filepath.Base(att.Filepath), filepath.ToSlash(att.Filepath)), fmt.Sprintf("%q", att.Filepath))
+}
+
+func (f *markdownFile) ReferenceAttachment(att chatdb.Attachment) error {
+	return f.writeLine(fmt.Sprintf("*\\<attached: %s\\>*", escapeMarkdown(att.TransferName)), fmt.Sprintf("%q", att.TransferName))
+}
+
+func (f *markdownFile) WriteTranscription(transcription string) error {
+	return f.writeLine(fmt.Sprintf("> 🎤 Transcript: \"%s\"", strings.ReplaceAll(escapeMarkdown(transcription), "\n", "\n> ")), "the transcription")
+}
+
+// escapeMarkdown escapes text from a message so that it is shown as written,
+// rather than changing the structure of the note.
+func escapeMarkdown(text string) string {
+	return _markdownLineStartRE.ReplaceAllString(_markdownEscaper.Replace(text), `$1$3\$2$4`)
+}
+
+func (f *markdownFile) SetAvatar(avatarPath string) {}
+
+func (f *markdownFile) WriteAvatar(avatarPath string) error {
+	return nil
+}
+
+func (f *markdownFile) WriteSeparator() error {
+	f.gap = true
+	return nil
+}
+
+func (f *markdownFile) Stage() (int, error) {
+	frontMatter, err := yaml.Marshal(f.frontMatter)
+	if err != nil {
+		return 0, fmt.Errorf("encode front matter: %w", err)
+	}
+	fmt.Fprintf(&f.buf, "---\n%s---\n\n# Messages with %s\n", frontMatter, f.entity)
+	for _, day := range f.days {
+		if f.opts.DailyNotes {
+			fmt.Fprintf(&f.buf, "\n- [[%s/%s]]", DailyNotesDir, day.date)
+			continue
+		}
+		fmt.Fprintf(&f.buf, "\n## %s\n\n%s", day.date, day.body.String())
+	}
+	if f.opts.DailyNotes && len(f.days) > 0 {
+		f.buf.WriteString("\n")
+	}
+	return 0, nil
+}
+
+func (f *markdownFile) Flush() error {
+	if _, err := f.buf.WriteTo(f.File); err != nil {
+		return err
+	}
+	if !f.opts.DailyNotes {
+		return nil
+	}
+	for _, day := range f.days {
+		if err := f.appendDailyNote(day); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendDailyNote adds the messages of the day to its note, under a heading
+// linking to the chat's note.
+func (f *markdownFile) appendDailyNote(day markdownDay) error {
+	dir := filepath.Join(f.opts.VaultPath, DailyNotesDir)
+	if err := f.fs.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("create directory %q: %w", dir, err)
+	}
+	notePath := filepath.Join(dir, day.date+".md")
+	exists, err := afero.Exists(f.fs, notePath)
+	if err != nil {
+		return fmt.Errorf("check daily note %q: %w", notePath, err)
+	}
+	note, err := f.fs.OpenFile(notePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("open daily note %q: %w", notePath, err)
+	}
+	defer note.Close()
+	var section strings.Builder
+	if !exists {
+		fmt.Fprintf(&section, "# %s\n", day.date)
+	}
+	chatNote, err := filepath.Rel(f.opts.VaultPath, f.Name())
+	if err != nil {
+		return fmt.Errorf("get path of %q relative to the vault: %w", f.Name(), err)
+	}
+	chatNote = strings.TrimSuffix(filepath.ToSlash(chatNote), ".md")
+	fmt.Fprintf(&section, "\n## [[%s|%s]]\n\n%s", chatNote, f.entity, day.body.String())
+	if _, err := note.WriteString(section.String()); err != nil {
+		return fmt.Errorf("write daily note %q: %w", notePath, err)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package opsys
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/chatdb"
+	"gotest.tools/v3/assert"
+)
+
+// withoutCreated removes the creation time from the front matter of a note.
+func withoutCreated(t *testing.T, fs afero.Fs, path string) string {
+	contents, err := afero.ReadFile(fs, path)
+	assert.NilError(t, err)
+	return regexp.MustCompile(`(?m)^created: .*\n`).ReplaceAllString(string(contents), "")
+}
+
+func writeMarkdownMessages(t *testing.T, of OutFile) {
+	date := time.Date(2020, 3, 1, 15, 34, 5, 0, time.UTC)
+	assert.Error(t, of.ReferenceAttachment(chatdb.Attachment{TransferName: "IMG_0001.png"}), `no message in "vault/friend/iMessage;-;friend@gmail.com.md" to attach "IMG_0001.png" to`)
+	assert.NilError(t, of.WriteMessage(chatdb.Message{ID: 1, Date: date, Sender: "Me", FromMe: true, Text: "want to play tennis?\uFFFC"}))
+	embedded, err := of.WriteAttachment(chatdb.Attachment{Filepath: "vault/friend/attachments/tennisballs.jpeg", CopiedPath: "vault/friend/attachments/tennisballs.jpeg"})
+	assert.NilError(t, err)
+	assert.Equal(t, embedded, true)
+	embedded, err = of.WriteAttachment(chatdb.Attachment{Filepath: "/Users/me/Library/Messages/Attachments/racket.jpeg"})
+	assert.NilError(t, err)
+	assert.Equal(t, embedded, false)
+	assert.NilError(t, of.ReferenceAttachment(chatdb.Attachment{TransferName: "IMG_0001.png"}))
+	assert.NilError(t, of.WriteSeparator())
+	assert.NilError(t, of.WriteMessage(chatdb.Message{ID: 2, Date: date.Add(24 * time.Hour), Sender: "friend", Text: "\uFFFC", IsAudio: true}))
+	assert.NilError(t, of.WriteTranscription("see you soon"))
+	of.SetAvatar("avatar.jpg")
+	assert.NilError(t, of.WriteAvatar("avatar.jpg"))
+	imgCount, err := of.Stage()
+	assert.NilError(t, err)
+	assert.Equal(t, imgCount, 0)
+	assert.NilError(t, of.Flush())
+}
+
+func TestMarkdownFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	s := &opSys{Fs: fs, bagoupVersion: "v1.2.3"}
+	file, err := s.Create("vault/friend/iMessage;-;friend@gmail.com.md")
+	assert.NilError(t, err)
+
+	of := s.NewMarkdownFile("friend", []string{"iMessage;-;friend@gmail.com"}, file, MarkdownOptions{VaultPath: "vault"})
+	assert.Equal(t, of.Name(), "vault/friend/iMessage;-;friend@gmail.com.md")
+	writeMarkdownMessages(t, of)
+	assert.Equal(t, withoutCreated(t, fs, "vault/friend/iMessage;-;friend@gmail.com.md"), `---
+entity: friend
+participants:
+    - friend
+first_message: "2020-03-01T15:34:05Z"
+last_message: "2020-03-02T15:34:05Z"
+chat_guids:
+    - iMessage;-;friend@gmail.com
+generator: bagoup v1.2.3
+---
+
+# Messages with friend
+
+## 2020-03-01
+
+**Me** 15:34:05
+want to play tennis?
+![[friend/attachments/tennisballs.jpeg]]
+[racket.jpeg](<file:///Users/me/Library/Messages/Attachments/racket.jpeg>)
+*\<attached: IMG\_0001.png\>*
+
+## 2020-03-02
+
+---
+
+**friend** 15:34:05
+🎤 Voice memo
+> 🎤 Transcript: "see you soon"
+`)
+
+	// Read-only file
+	roFile, err := afero.NewReadOnlyFs(fs).Open("vault/friend/iMessage;-;friend@gmail.com.md")
+	assert.NilError(t, err)
+	roOF := s.NewMarkdownFile("friend", nil, roFile, MarkdownOptions{VaultPath: "vault"})
+	_, err = roOF.Stage()
+	assert.NilError(t, err)
+	assert.Error(t, roOF.Flush(), "write vault/friend/iMessage;-;friend@gmail.com.md: file handle is read only")
+}
+
+func TestMarkdownDailyNotes(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	s := &opSys{Fs: fs, bagoupVersion: "v1.2.3"}
+	assert.NilError(t, afero.WriteFile(fs, "vault/Daily/2020-03-01.md", []byte("# 2020-03-01\n\n## [[Book Club/iMessage;+;chat123456|Book Club]]\n\n**Alex** 09:00:00\nhello\n"), 0644))
+	file, err := s.Create("vault/friend/iMessage;-;friend@gmail.com.md")
+	assert.NilError(t, err)
+
+	of := s.NewMarkdownFile("friend", []string{"iMessage;-;friend@gmail.com"}, file, MarkdownOptions{VaultPath: "vault", DailyNotes: true})
+	writeMarkdownMessages(t, of)
+	assert.Equal(t, withoutCreated(t, fs, "vault/friend/iMessage;-;friend@gmail.com.md"), `---
+entity: friend
+participants:
+    - friend
+first_message: "2020-03-01T15:34:05Z"
+last_message: "2020-03-02T15:34:05Z"
+chat_guids:
+    - iMessage;-;friend@gmail.com
+generator: bagoup v1.2.3
+---
+
+# Messages with friend
+
+- [[Daily/2020-03-01]]
+- [[Daily/2020-03-02]]
+`)
+	day1, err := afero.ReadFile(fs, "vault/Daily/2020-03-01.md")
+	assert.NilError(t, err)
+	assert.Equal(t, string(day1), `# 2020-03-01
+
+## [[Book Club/iMessage;+;chat123456|Book Club]]
+
+**Alex** 09:00:00
+hello
+
+## [[friend/iMessage;-;friend@gmail.com|friend]]
+
+**Me** 15:34:05
+want to play tennis?
+![[friend/attachments/tennisballs.jpeg]]
+[racket.jpeg](<file:///Users/me/Library/Messages/Attachments/racket.jpeg>)
+*\<attached: IMG\_0001.png\>*
+`)
+	day2, err := afero.ReadFile(fs, "vault/Daily/2020-03-02.md")
+	assert.NilError(t, err)
+	assert.Equal(t, string(day2), `# 2020-03-02
+
+## [[friend/iMessage;-;friend@gmail.com|friend]]
+
+---
+
+**friend** 15:34:05
+🎤 Voice memo
+> 🎤 Transcript: "see you soon"
+`)
+
+	// Read-only vault
+	file, err = s.Create("vault/friend/iMessage;-;friend@gmail.com.md")
+	assert.NilError(t, err)
+	s = &opSys{Fs: afero.NewReadOnlyFs(fs)}
+	of = s.NewMarkdownFile("friend", nil, file, MarkdownOptions{VaultPath: "vault", DailyNotes: true})
+	assert.NilError(t, of.WriteMessage(chatdb.Message{ID: 1, Date: time.Date(2020, 3, 1, 15, 34, 5, 0, time.UTC), Sender: "Me", FromMe: true, Text: "hi"}))
+	_, err = of.Stage()
+	assert.NilError(t, err)
+	assert.ErrorContains(t, of.Flush(), `create directory "vault/Daily"`)
+}
+
+func TestEscapeMarkdown(t *testing.T) {
+	tests := []struct {
+		msg  string
+		text string
+		want string
+	}{
+		{
+			msg:  "plain text",
+			text: "want to play tennis?",
+			want: "want to play tennis?",
+		},
+		{
+			msg:  "formatting",
+			text: "*really* _want_ to ~~play~~ ==tennis== `now`",
+			want: "\\*really\\* \\_want\\_ to \\~\\~play\\~\\~ \\=\\=tennis\\=\\= \\`now\\`",
+		},
+		{
+			msg:  "links, embeds, tags, and comments",
+			text: "![[court.jpeg]] [[Wimbledon]] #tennis <b>50%%</b> $5",
+			want: `!\[\[court.jpeg\]\] \[\[Wimbledon\]\] \#tennis \<b\>50\%\%\</b\> \$5`,
+		},
+		{
+			msg:  "headings, quotes, and tables",
+			text: "# Scores\n> 6-4 | 6-3",
+			want: `\# Scores` + "\n" + `\> 6-4 \| 6-3`,
+		},
+		{
+			msg:  "lists and thematic breaks",
+			text: "---\n- balls\n  + rackets\n1. serve\n2) volley",
+			want: `\---` + "\n" + `\- balls` + "\n" + `  \+ rackets` + "\n" + `1\. serve` + "\n" + `2\) volley`,
+		},
+		{
+			msg:  "backslashes",
+			text: `C:\tennis\*`,
+			want: `C:\\tennis\\\*`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			assert.Equal(t, escapeMarkdown(tt.text), tt.want)
+		})
+	}
+}
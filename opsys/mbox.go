@@ -0,0 +1,278 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package opsys
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/chatdb"
+)
+
+// _mboxDomain is a reserved domain (RFC 2606), which is never delivered to,
+// for the addresses made up for phone numbers and me.
+const _mboxDomain = "bagoup.invalid"
+
+// _mboxFromLine matches the lines of an email which must be quoted in an mbox,
+// following the mboxrd convention.
+var _mboxFromLine = regexp.MustCompile(`(?m)^(>*From )`)
+
+type (
+	// mboxFile writes each message as an RFC 5322 email, threaded by chat.
+	// The messages are held until Flush, so that every participant of a chat
+	// is known when addressing its emails.
+	mboxFile struct {
+		afero.File
+		fs           afero.Fs
+		entityName   string
+		me           *mail.Address
+		emails       []mboxEmail
+		participants map[string][]*mail.Address
+	}
+
+	mboxEmail struct {
+		msg         chatdb.Message
+		notes       []string
+		attachments []chatdb.Attachment
+	}
+)
+
+func (s *opSys) NewMBoxFile(entityName, selfHandle string, chatFile afero.File) OutFile {
+	return &mboxFile{
+		File:         chatFile,
+		fs:           s.Fs,
+		entityName:   entityName,
+		me:           &mail.Address{Name: selfHandle, Address: "me@" + _mboxDomain},
+		participants: map[string][]*mail.Address{},
+	}
+}
+
+// handleAddress returns the email address of a handle, making one up for a
+// phone number.
+func handleAddress(handle string) string {
+	if strings.Contains(handle, "@") {
+		return handle
+	}
+	return strings.ReplaceAll(handle, " ", "") + "@" + _mboxDomain
+}
+
+func (f *mboxFile) senderAddress(msg chatdb.Message) *mail.Address {
+	if msg.FromMe {
+		return f.me
+	}
+	if msg.SenderHandle == "" {
+		return &mail.Address{Name: msg.Sender, Address: "unknown@" + _mboxDomain}
+	}
+	return &mail.Address{Name: msg.Sender, Address: handleAddress(msg.SenderHandle)}
+}
+
+func (f *mboxFile) addParticipant(chatGUID string, addr *mail.Address) {
+	if !slices.ContainsFunc(f.participants[chatGUID], func(a *mail.Address) bool { return a.Address == addr.Address }) {
+		f.participants[chatGUID] = append(f.participants[chatGUID], addr)
+	}
+}
+
+func (f *mboxFile) WriteMessage(msg chatdb.Message) error {
+	if _, ok := f.participants[msg.ChatGUID]; !ok {
+		f.participants[msg.ChatGUID] = []*mail.Address{f.me}
+		// The GUID of a one-on-one chat ends with the other participant's
+		// handle, e.g. iMessage;-;friend@gmail.com.
+		if parts := strings.SplitN(msg.ChatGUID, ";", 3); len(parts) == 3 && parts[1] == "-" {
+			f.addParticipant(msg.ChatGUID, &mail.Address{Name: f.entityName, Address: handleAddress(parts[2])})
+		}
+	}
+	if !msg.FromMe && msg.SenderHandle != "" {
+		f.addParticipant(msg.ChatGUID, f.senderAddress(msg))
+	}
+	f.emails = append(f.emails, mboxEmail{msg: msg})
+	return nil
+}
+
+func (f *mboxFile) lastEmail(what string) (*mboxEmail, error) {
+	if len(f.emails) == 0 {
+		return nil, fmt.Errorf("no message in %q to attach %s to", f.Name(), what)
+	}
+	return &f.emails[len(f.emails)-1], nil
+}
+
+// WriteAttachment attaches the file to the email of the last message, to be
+// read when the email is written.
+func (f *mboxFile) WriteAttachment(att chatdb.Attachment) (bool, error) {
+	email, err := f.lastEmail(fmt.Sprintf("%q", att.Filepath))
+	if err != nil {
+		return false, err
+	}
+	email.attachments = append(email.attachments, att)
+	return true, nil
+}
+
+func (f *mboxFile) ReferenceAttachment(att chatdb.Attachment) error {
+	email, err := f.lastEmail(fmt.Sprintf("%q", att.TransferName))
+	if err != nil {
+		return err
+	}
+	email.notes = append(email.notes, fmt.Sprintf("<attached: %s>", att.TransferName))
+	return nil
+}
+
+func (f *mboxFile) WriteTranscription(transcription string) error {
+	email, err := f.lastEmail("the transcription")
+	if err != nil {
+		return err
+	}
+	email.notes = append(email.notes, fmt.Sprintf("🎤 Transcript: \"%s\"", transcription))
+	return nil
+}
+
+func (f *mboxFile) SetAvatar(avatarPath string) {}
+
+func (f *mboxFile) WriteAvatar(avatarPath string) error {
+	return nil
+}
+
+func (f *mboxFile) WriteSeparator() error {
+	return nil
+}
+
+func (f *mboxFile) Stage() (int, error) {
+	return 0, nil
+}
+
+// Flush writes the emails to the mbox, reading their attachments one at a
+// time.
+func (f *mboxFile) Flush() error {
+	w := bufio.NewWriter(f.File)
+	for _, email := range f.emails {
+		if err := f.writeEmail(w, email); err != nil {
+			return fmt.Errorf("write message %d: %w", email.msg.ID, err)
+		}
+	}
+	return w.Flush()
+}
+
+func (f *mboxFile) writeEmail(w io.Writer, email mboxEmail) error {
+	msg := email.msg
+	from := f.senderAddress(msg)
+	to := []string{}
+	for _, addr := range f.participants[msg.ChatGUID] {
+		if addr.Address != from.Address {
+			to = append(to, addr.String())
+		}
+	}
+	if len(to) == 0 {
+		to = append(to, "undisclosed-recipients:;")
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\n", from)
+	fmt.Fprintf(&buf, "To: %s\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Date: %s\n", msg.Date.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Subject: %s\n", mime.QEncoding.Encode("utf-8", "Messages with "+f.entityName))
+	if msg.ChatGUID != "" {
+		chatID := fmt.Sprintf("%x", sha1.Sum([]byte(msg.ChatGUID)))[:16]
+		fmt.Fprintf(&buf, "Message-ID: <%d.%s@%s>\n", msg.ID, chatID, _mboxDomain)
+		// Every message of the chat replies to the same root, so that email
+		// clients show the chat as one thread.
+		fmt.Fprintf(&buf, "In-Reply-To: <%s@%s>\n", chatID, _mboxDomain)
+		fmt.Fprintf(&buf, "References: <%s@%s>\n", chatID, _mboxDomain)
+		fmt.Fprintf(&buf, "X-Chat-GUID: %s\n", msg.ChatGUID)
+	} else {
+		fmt.Fprintf(&buf, "Message-ID: <%d@%s>\n", msg.ID, _mboxDomain)
+	}
+	buf.WriteString("MIME-Version: 1.0\n")
+
+	// Remove the object replacement characters (U+FFFC) standing in for
+	// attachments.
+	text := messageText(msg)
+	text = strings.Join(slices.DeleteFunc(append([]string{text}, email.notes...), func(s string) bool { return s == "" }), "\n")
+	if len(email.attachments) == 0 {
+		buf.WriteString("Content-Type: text/plain; charset=utf-8\nContent-Transfer-Encoding: quoted-printable\n\n")
+		if err := writeQuotedPrintable(&buf, text); err != nil {
+			return err
+		}
+	} else {
+		mw := multipart.NewWriter(&buf)
+		fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%q\n\n", mw.Boundary())
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"text/plain; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return err
+		}
+		if err := writeQuotedPrintable(part, text); err != nil {
+			return err
+		}
+		for _, att := range email.attachments {
+			if err := f.writeAttachmentPart(mw, att); err != nil {
+				return err
+			}
+		}
+		if err := mw.Close(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "From %s %s\n", from.Address, msg.Date.UTC().Format(time.ANSIC)); err != nil {
+		return err
+	}
+	contents := strings.ReplaceAll(buf.String(), "\r\n", "\n")
+	contents = _mboxFromLine.ReplaceAllString(strings.TrimSuffix(contents, "\n"), ">$1")
+	_, err := fmt.Fprintf(w, "%s\n\n", contents)
+	return err
+}
+
+func writeQuotedPrintable(w io.Writer, text string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(text)); err != nil {
+		return err
+	}
+	if err := qp.Close(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func (f *mboxFile) writeAttachmentPart(mw *multipart.Writer, att chatdb.Attachment) error {
+	contents, err := afero.ReadFile(f.fs, att.Filepath)
+	if err != nil {
+		return fmt.Errorf("read attachment %q: %w", att.Filepath, err)
+	}
+	mimeType := att.MIMEType
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {mimeType},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": filepath.Base(att.Filepath)})},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return err
+	}
+	encoded := base64.StdEncoding.EncodeToString(contents)
+	for len(encoded) > 76 {
+		if _, err := io.WriteString(part, encoded[:76]+"\n"); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err = io.WriteString(part, encoded+"\n")
+	return err
+}
@@ -0,0 +1,129 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package opsys
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/chatdb"
+	"gotest.tools/v3/assert"
+)
+
+func TestMBoxFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	s := &opSys{Fs: fs}
+	assert.NilError(t, afero.WriteFile(fs, "attachments/tennisballs.jpeg", []byte("jpeg"), 0644))
+	file, err := s.Create("friend.mbox")
+	assert.NilError(t, err)
+	date := time.Date(2020, 3, 1, 15, 34, 5, 0, time.UTC)
+
+	of := s.NewMBoxFile("Novak Djokovic", "Me", file)
+	assert.Equal(t, of.Name(), "friend.mbox")
+	_, err = of.WriteAttachment(chatdb.Attachment{Filepath: "attachments/tennisballs.jpeg"})
+	assert.Error(t, err, `no message in "friend.mbox" to attach "attachments/tennisballs.jpeg" to`)
+	assert.NilError(t, of.WriteMessage(chatdb.Message{ID: 1, Date: date, Sender: "Me", FromMe: true, Text: "From now on, tennis?\n\uFFFC", ChatGUID: "iMessage;-;novak@djokovic.com"}))
+	embedded, err := of.WriteAttachment(chatdb.Attachment{Filepath: "attachments/tennisballs.jpeg", MIMEType: "image/jpeg"})
+	assert.NilError(t, err)
+	assert.Equal(t, embedded, true)
+	assert.NilError(t, of.WriteSeparator())
+	assert.NilError(t, of.WriteMessage(chatdb.Message{ID: 2, Date: date.Add(time.Minute), Sender: "Novak", SenderHandle: "+15551234567", Text: "sûre", IsAudio: true, ChatGUID: "SMS;-;+15551234567"}))
+	assert.NilError(t, of.ReferenceAttachment(chatdb.Attachment{TransferName: "IMG_0001.png"}))
+	assert.NilError(t, of.WriteTranscription("see you soon"))
+	of.SetAvatar("avatar.jpg")
+	assert.NilError(t, of.WriteAvatar("avatar.jpg"))
+	imgCount, err := of.Stage()
+	assert.NilError(t, err)
+	assert.Equal(t, imgCount, 0)
+	assert.NilError(t, of.Flush())
+
+	contents, err := afero.ReadFile(fs, "friend.mbox")
+	assert.NilError(t, err)
+	mbox := string(contents)
+	assert.Assert(t, strings.HasPrefix(mbox, "From me@bagoup.invalid Sun Mar  1 15:34:05 2020\nFrom: \"Me\" <me@bagoup.invalid>\n"), mbox)
+	emails := strings.Split(mbox, "\n\nFrom ")
+	assert.Equal(t, len(emails), 2)
+
+	// Email with an attachment
+	email, err := mail.ReadMessage(strings.NewReader(strings.SplitN(emails[0], "\n", 2)[1]))
+	assert.NilError(t, err)
+	assert.Equal(t, email.Header.Get("To"), `"Novak Djokovic" <novak@djokovic.com>`)
+	assert.Equal(t, email.Header.Get("Date"), "Sun, 01 Mar 2020 15:34:05 +0000")
+	assert.Equal(t, email.Header.Get("Subject"), "Messages with Novak Djokovic")
+	assert.Equal(t, email.Header.Get("Message-ID"), "<1.dbab6f8610c8a3b3@bagoup.invalid>")
+	assert.Equal(t, email.Header.Get("References"), "<dbab6f8610c8a3b3@bagoup.invalid>")
+	assert.Equal(t, email.Header.Get("X-Chat-GUID"), "iMessage;-;novak@djokovic.com")
+	mediaType, params, err := mime.ParseMediaType(email.Header.Get("Content-Type"))
+	assert.NilError(t, err)
+	assert.Equal(t, mediaType, "multipart/mixed")
+	mr := multipart.NewReader(email.Body, params["boundary"])
+	part, err := mr.NextPart()
+	assert.NilError(t, err)
+	text, err := io.ReadAll(part)
+	assert.NilError(t, err)
+	// The mbox quotes the line starting with "From ".
+	assert.Equal(t, string(text), ">From now on, tennis?\n")
+	part, err = mr.NextPart()
+	assert.NilError(t, err)
+	assert.Equal(t, part.Header.Get("Content-Type"), "image/jpeg")
+	assert.Equal(t, part.FileName(), "tennisballs.jpeg")
+	assert.Equal(t, part.Header.Get("Content-Transfer-Encoding"), "base64")
+	_, err = mr.NextPart()
+	assert.Equal(t, err, io.EOF)
+
+	// Email from a phone number, with a quoted-printable body
+	email, err = mail.ReadMessage(strings.NewReader(strings.SplitN(emails[1], "\n", 2)[1]))
+	assert.NilError(t, err)
+	assert.Equal(t, strings.SplitN(emails[1], "\n", 2)[0], "+15551234567@bagoup.invalid Sun Mar  1 15:35:05 2020")
+	assert.Equal(t, email.Header.Get("From"), `"Novak" <+15551234567@bagoup.invalid>`)
+	assert.Equal(t, email.Header.Get("To"), `"Me" <me@bagoup.invalid>`)
+	assert.Equal(t, email.Header.Get("Content-Transfer-Encoding"), "quoted-printable")
+	body, err := io.ReadAll(email.Body)
+	assert.NilError(t, err)
+	assert.Equal(t, string(body), "=F0=9F=8E=A4 Voice memo s=C3=BBre\n<attached: IMG_0001.png>\n=F0=9F=8E=A4 Transcript: \"see you soon\"\n\n")
+
+	// Missing attachment
+	file, err = s.Create("missing.mbox")
+	assert.NilError(t, err)
+	of = s.NewMBoxFile("friend", "Me", file)
+	assert.NilError(t, of.WriteMessage(chatdb.Message{ID: 3, Date: date, Sender: "Me", FromMe: true}))
+	_, err = of.WriteAttachment(chatdb.Attachment{Filepath: "attachments/missing.png"})
+	assert.NilError(t, err)
+	assert.ErrorContains(t, of.Flush(), `write message 3: read attachment "attachments/missing.png"`)
+}
+
+func TestMBoxGroupRecipients(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	s := &opSys{Fs: fs}
+	file, err := s.Create("club.mbox")
+	assert.NilError(t, err)
+	date := time.Date(2020, 3, 1, 15, 34, 5, 0, time.UTC)
+	of := s.NewMBoxFile("Book Club", "Me", file)
+	assert.NilError(t, of.WriteMessage(chatdb.Message{ID: 1, Date: date, Sender: "Me", FromMe: true, Text: "hi all", ChatGUID: "iMessage;+;chat123456"}))
+	assert.NilError(t, of.WriteMessage(chatdb.Message{ID: 2, Date: date, Sender: "Alex", SenderHandle: "alex@example.com", Text: "hello", ChatGUID: "iMessage;+;chat123456"}))
+	assert.NilError(t, of.WriteMessage(chatdb.Message{ID: 3, Date: date, Sender: "Sam", SenderHandle: "+1 555 987 6543", Text: "hey", ChatGUID: "iMessage;+;chat123456"}))
+	_, err = of.Stage()
+	assert.NilError(t, err)
+	assert.NilError(t, of.Flush())
+
+	contents, err := afero.ReadFile(fs, "club.mbox")
+	assert.NilError(t, err)
+	tos := []string{}
+	for _, line := range strings.Split(string(contents), "\n") {
+		if strings.HasPrefix(line, "To: ") {
+			tos = append(tos, line)
+		}
+	}
+	assert.DeepEqual(t, tos, []string{
+		`To: "Alex" <alex@example.com>, "Sam" <+15559876543@bagoup.invalid>`,
+		`To: "Me" <me@bagoup.invalid>, "Sam" <+15559876543@bagoup.invalid>`,
+		`To: "Me" <me@bagoup.invalid>, "Alex" <alex@example.com>`,
+	})
+}
@@ -17,6 +17,7 @@ import (
 	semver "github.com/Masterminds/semver/v3"
 	vcard "github.com/emersion/go-vcard"
 	afero "github.com/spf13/afero"
+	chatdb "github.com/tagatac/bagoup/v2/chatdb"
 	opsys "github.com/tagatac/bagoup/v2/opsys"
 	pdfgen "github.com/tagatac/bagoup/v2/opsys/pdfgen"
 	gomock "go.uber.org/mock/gomock"
@@ -147,19 +148,51 @@ func (mr *MockOSMockRecorder) FileExist(fp any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FileExist", reflect.TypeOf((*MockOS)(nil).FileExist), fp)
 }
 
-// GetContactMap mocks base method.
-func (m *MockOS) GetContactMap(path string) (map[string]*vcard.Card, error) {
+// GetAddressBookContactMap mocks base method.
+func (m *MockOS) GetAddressBookContactMap(addressBookDir string, opts opsys.ContactOptions) (map[string]*vcard.Card, []opsys.ContactCollision, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetContactMap", path)
+	ret := m.ctrl.Call(m, "GetAddressBookContactMap", addressBookDir, opts)
 	ret0, _ := ret[0].(map[string]*vcard.Card)
+	ret1, _ := ret[1].([]opsys.ContactCollision)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetAddressBookContactMap indicates an expected call of GetAddressBookContactMap.
+func (mr *MockOSMockRecorder) GetAddressBookContactMap(addressBookDir, opts any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAddressBookContactMap", reflect.TypeOf((*MockOS)(nil).GetAddressBookContactMap), addressBookDir, opts)
+}
+
+// GetAliases mocks base method.
+func (m *MockOS) GetAliases(path, defaultRegion string) (chatdb.Aliases, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAliases", path, defaultRegion)
+	ret0, _ := ret[0].(chatdb.Aliases)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
+// GetAliases indicates an expected call of GetAliases.
+func (mr *MockOSMockRecorder) GetAliases(path, defaultRegion any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAliases", reflect.TypeOf((*MockOS)(nil).GetAliases), path, defaultRegion)
+}
+
+// GetContactMap mocks base method.
+func (m *MockOS) GetContactMap(paths []string, opts opsys.ContactOptions) (map[string]*vcard.Card, []opsys.ContactCollision, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetContactMap", paths, opts)
+	ret0, _ := ret[0].(map[string]*vcard.Card)
+	ret1, _ := ret[1].([]opsys.ContactCollision)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
 // GetContactMap indicates an expected call of GetContactMap.
-func (mr *MockOSMockRecorder) GetContactMap(path any) *gomock.Call {
+func (mr *MockOSMockRecorder) GetContactMap(paths, opts any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetContactMap", reflect.TypeOf((*MockOS)(nil).GetContactMap), path)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetContactMap", reflect.TypeOf((*MockOS)(nil).GetContactMap), paths, opts)
 }
 
 // GetMacOSVersion mocks base method.
@@ -249,6 +282,104 @@ func (mr *MockOSMockRecorder) Name() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Name", reflect.TypeOf((*MockOS)(nil).Name))
 }
 
+// NewCSVFile mocks base method.
+func (m *MockOS) NewCSVFile(entityName string, chatFile afero.File, header bool) opsys.OutFile {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NewCSVFile", entityName, chatFile, header)
+	ret0, _ := ret[0].(opsys.OutFile)
+	return ret0
+}
+
+// NewCSVFile indicates an expected call of NewCSVFile.
+func (mr *MockOSMockRecorder) NewCSVFile(entityName, chatFile, header any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewCSVFile", reflect.TypeOf((*MockOS)(nil).NewCSVFile), entityName, chatFile, header)
+}
+
+// NewEPUBFile mocks base method.
+func (m *MockOS) NewEPUBFile(entityName string, chatFile afero.File) opsys.OutFile {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NewEPUBFile", entityName, chatFile)
+	ret0, _ := ret[0].(opsys.OutFile)
+	return ret0
+}
+
+// NewEPUBFile indicates an expected call of NewEPUBFile.
+func (mr *MockOSMockRecorder) NewEPUBFile(entityName, chatFile any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewEPUBFile", reflect.TypeOf((*MockOS)(nil).NewEPUBFile), entityName, chatFile)
+}
+
+// NewHTMLFile mocks base method.
+func (m *MockOS) NewHTMLFile(entityName string, chatFile afero.File, opts opsys.HTMLOptions) opsys.OutFile {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NewHTMLFile", entityName, chatFile, opts)
+	ret0, _ := ret[0].(opsys.OutFile)
+	return ret0
+}
+
+// NewHTMLFile indicates an expected call of NewHTMLFile.
+func (mr *MockOSMockRecorder) NewHTMLFile(entityName, chatFile, opts any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewHTMLFile", reflect.TypeOf((*MockOS)(nil).NewHTMLFile), entityName, chatFile, opts)
+}
+
+// NewJSONFile mocks base method.
+func (m *MockOS) NewJSONFile(entityName string, guids, participants []string, chatFile afero.File) opsys.OutFile {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NewJSONFile", entityName, guids, participants, chatFile)
+	ret0, _ := ret[0].(opsys.OutFile)
+	return ret0
+}
+
+// NewJSONFile indicates an expected call of NewJSONFile.
+func (mr *MockOSMockRecorder) NewJSONFile(entityName, guids, participants, chatFile any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewJSONFile", reflect.TypeOf((*MockOS)(nil).NewJSONFile), entityName, guids, participants, chatFile)
+}
+
+// NewJSONLFile mocks base method.
+func (m *MockOS) NewJSONLFile(entityName string, guids []string, chatFile afero.File) opsys.OutFile {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NewJSONLFile", entityName, guids, chatFile)
+	ret0, _ := ret[0].(opsys.OutFile)
+	return ret0
+}
+
+// NewJSONLFile indicates an expected call of NewJSONLFile.
+func (mr *MockOSMockRecorder) NewJSONLFile(entityName, guids, chatFile any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewJSONLFile", reflect.TypeOf((*MockOS)(nil).NewJSONLFile), entityName, guids, chatFile)
+}
+
+// NewMBoxFile mocks base method.
+func (m *MockOS) NewMBoxFile(entityName, selfHandle string, chatFile afero.File) opsys.OutFile {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NewMBoxFile", entityName, selfHandle, chatFile)
+	ret0, _ := ret[0].(opsys.OutFile)
+	return ret0
+}
+
+// NewMBoxFile indicates an expected call of NewMBoxFile.
+func (mr *MockOSMockRecorder) NewMBoxFile(entityName, selfHandle, chatFile any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewMBoxFile", reflect.TypeOf((*MockOS)(nil).NewMBoxFile), entityName, selfHandle, chatFile)
+}
+
+// NewMarkdownFile mocks base method.
+func (m *MockOS) NewMarkdownFile(entityName string, guids []string, chatFile afero.File, opts opsys.MarkdownOptions) opsys.OutFile {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NewMarkdownFile", entityName, guids, chatFile, opts)
+	ret0, _ := ret[0].(opsys.OutFile)
+	return ret0
+}
+
+// NewMarkdownFile indicates an expected call of NewMarkdownFile.
+func (mr *MockOSMockRecorder) NewMarkdownFile(entityName, guids, chatFile, opts any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewMarkdownFile", reflect.TypeOf((*MockOS)(nil).NewMarkdownFile), entityName, guids, chatFile, opts)
+}
+
 // NewTxtOutFile mocks base method.
 func (m *MockOS) NewTxtOutFile(arg0 afero.File) opsys.OutFile {
 	m.ctrl.T.Helper()
@@ -378,6 +509,21 @@ func (mr *MockOSMockRecorder) Rename(oldname, newname any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Rename", reflect.TypeOf((*MockOS)(nil).Rename), oldname, newname)
 }
 
+// RenderSketch mocks base method.
+func (m *MockOS) RenderSketch(sketch chatdb.Sketch, filename string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RenderSketch", sketch, filename)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RenderSketch indicates an expected call of RenderSketch.
+func (mr *MockOSMockRecorder) RenderSketch(sketch, filename any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RenderSketch", reflect.TypeOf((*MockOS)(nil).RenderSketch), sketch, filename)
+}
+
 // RmTempDir mocks base method.
 func (m *MockOS) RmTempDir() error {
 	m.ctrl.T.Helper()
@@ -420,3 +566,17 @@ func (mr *MockOSMockRecorder) Stat(name any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stat", reflect.TypeOf((*MockOS)(nil).Stat), name)
 }
+
+// WriteHTMLIndex mocks base method.
+func (m *MockOS) WriteHTMLIndex(indexPath string, entries []opsys.HTMLIndexEntry) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WriteHTMLIndex", indexPath, entries)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WriteHTMLIndex indicates an expected call of WriteHTMLIndex.
+func (mr *MockOSMockRecorder) WriteHTMLIndex(indexPath, entries any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteHTMLIndex", reflect.TypeOf((*MockOS)(nil).WriteHTMLIndex), indexPath, entries)
+}
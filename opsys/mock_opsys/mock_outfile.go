@@ -12,6 +12,7 @@ package mock_opsys
 import (
 	reflect "reflect"
 
+	chatdb "github.com/tagatac/bagoup/v2/chatdb"
 	gomock "go.uber.org/mock/gomock"
 )
 
@@ -68,17 +69,29 @@ func (mr *MockOutFileMockRecorder) Name() *gomock.Call {
 }
 
 // ReferenceAttachment mocks base method.
-func (m *MockOutFile) ReferenceAttachment(filename string) error {
+func (m *MockOutFile) ReferenceAttachment(att chatdb.Attachment) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ReferenceAttachment", filename)
+	ret := m.ctrl.Call(m, "ReferenceAttachment", att)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // ReferenceAttachment indicates an expected call of ReferenceAttachment.
-func (mr *MockOutFileMockRecorder) ReferenceAttachment(filename any) *gomock.Call {
+func (mr *MockOutFileMockRecorder) ReferenceAttachment(att any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReferenceAttachment", reflect.TypeOf((*MockOutFile)(nil).ReferenceAttachment), filename)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReferenceAttachment", reflect.TypeOf((*MockOutFile)(nil).ReferenceAttachment), att)
+}
+
+// SetAvatar mocks base method.
+func (m *MockOutFile) SetAvatar(avatarPath string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetAvatar", avatarPath)
+}
+
+// SetAvatar indicates an expected call of SetAvatar.
+func (mr *MockOutFileMockRecorder) SetAvatar(avatarPath any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetAvatar", reflect.TypeOf((*MockOutFile)(nil).SetAvatar), avatarPath)
 }
 
 // Stage mocks base method.
@@ -97,22 +110,36 @@ func (mr *MockOutFileMockRecorder) Stage() *gomock.Call {
 }
 
 // WriteAttachment mocks base method.
-func (m *MockOutFile) WriteAttachment(attPath string) (bool, error) {
+func (m *MockOutFile) WriteAttachment(att chatdb.Attachment) (bool, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "WriteAttachment", attPath)
+	ret := m.ctrl.Call(m, "WriteAttachment", att)
 	ret0, _ := ret[0].(bool)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // WriteAttachment indicates an expected call of WriteAttachment.
-func (mr *MockOutFileMockRecorder) WriteAttachment(attPath any) *gomock.Call {
+func (mr *MockOutFileMockRecorder) WriteAttachment(att any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteAttachment", reflect.TypeOf((*MockOutFile)(nil).WriteAttachment), attPath)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteAttachment", reflect.TypeOf((*MockOutFile)(nil).WriteAttachment), att)
+}
+
+// WriteAvatar mocks base method.
+func (m *MockOutFile) WriteAvatar(avatarPath string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WriteAvatar", avatarPath)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WriteAvatar indicates an expected call of WriteAvatar.
+func (mr *MockOutFileMockRecorder) WriteAvatar(avatarPath any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteAvatar", reflect.TypeOf((*MockOutFile)(nil).WriteAvatar), avatarPath)
 }
 
 // WriteMessage mocks base method.
-func (m *MockOutFile) WriteMessage(msg string) error {
+func (m *MockOutFile) WriteMessage(msg chatdb.Message) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "WriteMessage", msg)
 	ret0, _ := ret[0].(error)
@@ -124,3 +151,31 @@ func (mr *MockOutFileMockRecorder) WriteMessage(msg any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteMessage", reflect.TypeOf((*MockOutFile)(nil).WriteMessage), msg)
 }
+
+// WriteSeparator mocks base method.
+func (m *MockOutFile) WriteSeparator() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WriteSeparator")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WriteSeparator indicates an expected call of WriteSeparator.
+func (mr *MockOutFileMockRecorder) WriteSeparator() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteSeparator", reflect.TypeOf((*MockOutFile)(nil).WriteSeparator))
+}
+
+// WriteTranscription mocks base method.
+func (m *MockOutFile) WriteTranscription(transcription string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WriteTranscription", transcription)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WriteTranscription indicates an expected call of WriteTranscription.
+func (mr *MockOutFileMockRecorder) WriteTranscription(transcription any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteTranscription", reflect.TypeOf((*MockOutFile)(nil).WriteTranscription), transcription)
+}
@@ -7,24 +7,28 @@ package opsys
 
 import (
 	"fmt"
-	"io"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
-	"unicode"
+	"time"
 
 	"github.com/Masterminds/semver/v3"
 	"github.com/emersion/go-vcard"
 	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/chatdb"
 	"github.com/tagatac/bagoup/v2/opsys/pdfgen"
 	"github.com/tagatac/bagoup/v2/opsys/scall"
 	"github.com/tagatac/gorecurcopy"
 )
 
+// The timeout for downloading contact photos referenced by URL.
+const _httpTimeout = 10 * time.Second
+
 //go:generate mockgen -destination=mock_opsys/mock_opsys.go github.com/tagatac/bagoup/v2/opsys OS
 
 type (
@@ -39,9 +43,23 @@ type (
 		// assuming it is macOS.
 		GetMacOSVersion() (*semver.Version, error)
 		// GetContactMap gets a map of vcards indexed by phone numbers and email
-		// addresses specified in those cards, from the vcard file at the given
-		// path.
-		GetContactMap(path string) (map[string]*vcard.Card, error)
+		// addresses specified in those cards, from the vCard or CSV files at the
+		// given paths, in order of precedence. Cards for the same person in
+		// multiple files are merged. Phone numbers and email addresses are
+		// normalized with phonenum.Normalize. Values shared by multiple cards
+		// are resolved according to the collision policy and returned as
+		// collisions. If avatars are enabled, contact photos are written to the
+		// temporary directory.
+		GetContactMap(paths []string, opts ContactOptions) (map[string]*vcard.Card, []ContactCollision, error)
+		// GetAddressBookContactMap gets a map of vcards like GetContactMap, but
+		// from the macOS AddressBook directory at the given path (usually
+		// ~/Library/Application Support/AddressBook), merging the databases of
+		// all of its sources.
+		GetAddressBookContactMap(addressBookDir string, opts ContactOptions) (map[string]*vcard.Card, []ContactCollision, error)
+		// GetAliases reads the YAML or TOML file at the given path, which maps
+		// handles and chat GUIDs to aliases. Handles are normalized with
+		// phonenum.Normalize.
+		GetAliases(path, defaultRegion string) (chatdb.Aliases, error)
 		// ReadFile is a thin wrapper on the afero ReadFile utility.
 		ReadFile(fp string) (string, error)
 		// CopyFile copies the src file to the dstDir directory. If the file is
@@ -53,6 +71,9 @@ type (
 		GetTempDir() (string, error)
 		// RmTempDir removes the temporary directory.
 		RmTempDir() error
+		// RenderSketch draws the given sketch as an SVG image with the given
+		// filename in the temporary directory, returning the path to the image.
+		RenderSketch(sketch chatdb.Sketch, filename string) (string, error)
 		// GetOpenFilesLimit gets the current limit on the number of open files.
 		GetOpenFilesLimit() (int, error)
 		// SetOpenFilesLimit sets the open files limit to the given value to
@@ -62,12 +83,42 @@ type (
 		NewTxtOutFile(afero.File) OutFile
 		NewWeasyPrintFile(entityName string, chatFile afero.File, includePPA bool) OutFile
 		NewWkhtmltopdfFile(entityName string, chatFile afero.File, pdfg pdfgen.PDFGenerator, includePPA bool) OutFile
+		// NewJSONFile returns an OutFile which writes the chats with the given
+		// GUIDs and participants as a JSON document, with the details of each
+		// message and attachment.
+		NewJSONFile(entityName string, guids, participants []string, chatFile afero.File) OutFile
+		// NewJSONLFile returns an OutFile which writes each message of the
+		// chats with the given GUIDs as a line of JSON, as soon as it is
+		// complete, so that any number of chats can be streamed to a single
+		// file.
+		NewJSONLFile(entityName string, guids []string, chatFile afero.File) OutFile
+		// NewCSVFile returns an OutFile which writes each message as a row of
+		// CSV (see CSVHeader), after a header row if header is true.
+		NewCSVFile(entityName string, chatFile afero.File, header bool) OutFile
+		// NewHTMLFile returns an OutFile which writes the messages as a web
+		// page of chat bubbles, with players for video and audio attachments.
+		NewHTMLFile(entityName string, chatFile afero.File, opts HTMLOptions) OutFile
+		// WriteHTMLIndex writes a web page linking to the chat files of each
+		// entity in an HTML export.
+		WriteHTMLIndex(indexPath string, entries []HTMLIndexEntry) error
+		// NewMarkdownFile returns an OutFile which writes the chats with the
+		// given GUIDs as a Markdown note for an Obsidian vault, with YAML
+		// front matter.
+		NewMarkdownFile(entityName string, guids []string, chatFile afero.File, opts MarkdownOptions) OutFile
+		// NewEPUBFile returns an OutFile which writes an e-book of the chat,
+		// with a chapter per month and the images embedded.
+		NewEPUBFile(entityName string, chatFile afero.File) OutFile
+		// NewMBoxFile returns an OutFile which writes each message of the chat
+		// as an email in an mbox, with the attachments as MIME parts, and the
+		// self handle naming me.
+		NewMBoxFile(entityName, selfHandle string, chatFile afero.File) OutFile
 	}
 
 	opSys struct {
 		afero.Fs
 		osStat      func(string) (os.FileInfo, error)
 		execCommand func(string, ...string) *exec.Cmd
+		httpClient  *http.Client
 		scall.Syscall
 		tempDir            string
 		openFilesLimitHard uint64
@@ -82,6 +133,7 @@ func NewOS(fs afero.Fs, osStat func(string) (os.FileInfo, error), bagoupVersion
 		Fs:            fs,
 		osStat:        osStat,
 		execCommand:   exec.Command,
+		httpClient:    &http.Client{Timeout: _httpTimeout},
 		Syscall:       scall.NewSyscall(),
 		bagoupVersion: bagoupVersion,
 	}
@@ -121,65 +173,6 @@ func (s opSys) GetMacOSVersion() (*semver.Version, error) {
 	return v, nil
 }
 
-func (s opSys) GetContactMap(contactsFilePath string) (map[string]*vcard.Card, error) {
-	f, err := s.Fs.Open(contactsFilePath)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-
-	dec := vcard.NewDecoder(f)
-	contactMap := map[string]*vcard.Card{}
-	for {
-		card, err := dec.Decode()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("decode vcard: %w", err)
-		}
-		phones := card.Values(vcard.FieldTelephone)
-		for i, phone := range phones {
-			phones[i] = sanitizePhone(phone)
-		}
-		phonesAndEmails := append(phones, card.Values(vcard.FieldEmail)...)
-		for _, phoneOrEmail := range phonesAndEmails {
-			c, ok := contactMap[phoneOrEmail]
-			if !ok {
-				contactMap[phoneOrEmail] = &card
-				continue
-			}
-			combinedCard := vcard.Card{}
-			combinedName := vcard.Name{
-				GivenName: fmt.Sprintf("%s or %s", c.Name().GivenName, card.Name().GivenName),
-			}
-			combinedCard.SetName(&combinedName)
-			combinedFormattedName := vcard.Field{Value: fmt.Sprintf(
-				"%s and %s",
-				c.PreferredValue(vcard.FieldFormattedName),
-				card.PreferredValue(vcard.FieldFormattedName),
-			)}
-			combinedCard.Set(vcard.FieldFormattedName, &combinedFormattedName)
-			contactMap[phoneOrEmail] = &combinedCard
-			continue
-		}
-	}
-	return contactMap, nil
-}
-
-// Adapted from https://stackoverflow.com/a/44009184/5403337
-func sanitizePhone(dirty string) string {
-	return strings.Map(
-		func(r rune) rune {
-			if strings.ContainsRune("()-", r) || unicode.IsSpace(r) {
-				return -1
-			}
-			return r
-		},
-		dirty,
-	)
-}
-
 func (s opSys) ReadFile(fp string) (string, error) {
 	contents, err := afero.ReadFile(s.Fs, fp)
 	return string(contents), err
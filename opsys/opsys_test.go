@@ -191,6 +191,24 @@ func TestGetContactMap(t *testing.T) {
 			{Value: "myContacts"},
 		},
 	}
+	normCard := &vcard.Card{
+		"VERSION": []*vcard.Field{
+			{Value: "3.0"},
+		},
+		"FN": []*vcard.Field{
+			{Value: "David Tagatac"},
+		},
+		"N": []*vcard.Field{
+			{Value: "Tagatac;David;;;"},
+		},
+		"TEL": []*vcard.Field{
+			{Value: "(415) 555-5555", Params: vcard.Params{"TYPE": []string{"CELL"}}},
+			{Value: "+1 415.555.1234 ext. 5", Params: vcard.Params{"TYPE": []string{"WORK"}}},
+		},
+		"EMAIL": []*vcard.Field{
+			{Value: "David@Tagatac.net", Params: vcard.Params{"TYPE": []string{"INTERNET"}}},
+		},
+	}
 	combinedCard := &vcard.Card{
 		"FN": []*vcard.Field{
 			{Value: "Novak Djokovic and Jelena Djokovic"},
@@ -199,12 +217,129 @@ func TestGetContactMap(t *testing.T) {
 			{Value: ";Novak or Jelena;;;"},
 		},
 	}
+	jelenaCard := &vcard.Card{
+		"FN": []*vcard.Field{
+			{Value: "Jelena Djokovic"},
+		},
+		"N": []*vcard.Field{
+			{Value: "Djokovic;Jelena;;;"},
+		},
+		"EMAIL": []*vcard.Field{
+			{Value: "info@novakdjokovic.com", Params: vcard.Params{"TYPE": []string{"INTERNET"}}},
+		},
+	}
+	sharedEmailVCF := `BEGIN:VCARD
+VERSION:3.0
+FN:Novak Djokovic
+N:Djokovic;Novak;;;
+TEL;TYPE=CELL:+3815555555
+EMAIL;TYPE=INTERNET:info@novakdjokovic.com
+CATEGORIES:myContacts
+END:VCARD
+BEGIN:VCARD
+FN:Jelena Djokovic
+N:Djokovic;Jelena;;;
+EMAIL;TYPE=INTERNET:info@novakdjokovic.com
+END:VCARD
+`
+
+	personalVCF := `BEGIN:VCARD
+VERSION:3.0
+UID:nole
+FN:Novak Djokovic
+TEL:+3815555555
+END:VCARD
+BEGIN:VCARD
+VERSION:3.0
+FN:David Tagatac
+NICKNAME:Tag
+TEL:(415) 555-5555
+END:VCARD
+`
+	workVCF := `BEGIN:VCARD
+VERSION:3.0
+FN:David Tagatac
+ORG:Acme
+TEL:+1 415 555 5555
+EMAIL:david@acme.com
+END:VCARD
+BEGIN:VCARD
+VERSION:3.0
+UID:nole
+FN:N. Djokovic
+EMAIL:info@novakdjokovic.com
+END:VCARD
+`
+	mergedTagCard := &vcard.Card{
+		"VERSION":  []*vcard.Field{{Value: "3.0"}},
+		"FN":       []*vcard.Field{{Value: "David Tagatac"}},
+		"NICKNAME": []*vcard.Field{{Value: "Tag"}},
+		"TEL":      []*vcard.Field{{Value: "(415) 555-5555"}},
+		"ORG":      []*vcard.Field{{Value: "Acme"}},
+		"EMAIL":    []*vcard.Field{{Value: "david@acme.com"}},
+	}
+	mergedNoleCard := &vcard.Card{
+		"VERSION": []*vcard.Field{{Value: "3.0"}},
+		"UID":     []*vcard.Field{{Value: "nole"}},
+		"FN":      []*vcard.Field{{Value: "Novak Djokovic"}},
+		"TEL":     []*vcard.Field{{Value: "+3815555555"}},
+		"EMAIL":   []*vcard.Field{{Value: "info@novakdjokovic.com"}},
+	}
+
+	familyVCF := `BEGIN:VCARD
+VERSION:3.0
+FN:Jelena Djokovic
+TEL:+3815555555
+END:VCARD
+`
+	landlineNoleCard := &vcard.Card{
+		"VERSION": []*vcard.Field{{Value: "3.0"}},
+		"UID":     []*vcard.Field{{Value: "nole"}},
+		"FN":      []*vcard.Field{{Value: "Novak Djokovic"}},
+		"TEL":     []*vcard.Field{{Value: "+3815555555"}},
+	}
+	landlineJelenaCard := &vcard.Card{
+		"VERSION": []*vcard.Field{{Value: "3.0"}},
+		"FN":      []*vcard.Field{{Value: "Jelena Djokovic"}},
+		"TEL":     []*vcard.Field{{Value: "+3815555555"}},
+	}
+	landlineCombinedCard := &vcard.Card{
+		"FN": []*vcard.Field{{Value: "Novak Djokovic and Jelena Djokovic"}},
+		"N":  []*vcard.Field{{Value: "; or ;;;"}},
+	}
+
+	unnamedCard1 := &vcard.Card{
+		"VERSION": []*vcard.Field{{Value: "3.0"}},
+		"TEL":     []*vcard.Field{{Value: "+3815555555"}},
+		"EMAIL":   []*vcard.Field{{Value: "info@novakdjokovic.com"}},
+	}
+	unnamedCard2 := &vcard.Card{
+		"VERSION": []*vcard.Field{{Value: "3.0"}},
+		"TEL":     []*vcard.Field{{Value: "+3815555556"}},
+		"EMAIL":   []*vcard.Field{{Value: "info@novakdjokovic.com"}},
+	}
+
+	sameNameCard1 := &vcard.Card{
+		"VERSION": []*vcard.Field{{Value: "3.0"}},
+		"FN":      []*vcard.Field{{Value: "Novak Djokovic"}},
+		"TEL":     []*vcard.Field{{Value: "+3815555555"}},
+		"EMAIL":   []*vcard.Field{{Value: "info@novakdjokovic.com"}},
+	}
+	sameNameCard2 := &vcard.Card{
+		"VERSION": []*vcard.Field{{Value: "3.0"}},
+		"FN":      []*vcard.Field{{Value: "Novak Djokovic"}},
+		"TEL":     []*vcard.Field{{Value: "+3815555556"}},
+		"EMAIL":   []*vcard.Field{{Value: "info@novakdjokovic.com"}},
+	}
 
 	tests := []struct {
-		msg     string
-		setupFs func(afero.Fs)
-		wantMap map[string]*vcard.Card
-		wantErr string
+		msg            string
+		paths          []string
+		policy         string
+		setupFs        func(afero.Fs)
+		wantMap        map[string]*vcard.Card
+		wantCollisions []ContactCollision
+		wantErr        string
 	}{
 		{
 			msg: "two contacts",
@@ -235,22 +370,88 @@ END:VCARD
 				"info@novakdjokovic.com": noleCard,
 			},
 		},
+		{
+			msg: "normalized phone numbers and email addresses",
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "contacts.vcf", []byte(
+					`BEGIN:VCARD
+VERSION:3.0
+FN:David Tagatac
+N:Tagatac;David;;;
+TEL;TYPE=CELL:(415) 555-5555
+TEL;TYPE=WORK:+1 415.555.1234 ext. 5
+EMAIL;TYPE=INTERNET:David@Tagatac.net
+END:VCARD
+`), 0644)
+			},
+			wantMap: map[string]*vcard.Card{
+				"+14155555555":      normCard,
+				"+14155551234":      normCard,
+				"david@tagatac.net": normCard,
+			},
+		},
 		{
 			msg:     "no contacts file",
-			wantErr: `open contacts.vcf: file does not exist`,
+			wantErr: `read contacts file "contacts.vcf": open contacts.vcf: file does not exist`,
 		},
 		{
 			msg: "bad vcard file",
 			setupFs: func(fs afero.Fs) {
 				afero.WriteFile(fs, "contacts.vcf", []byte("BEGIN::VCARD\n"), 0644)
 			},
-			wantErr: "decode vcard: vcard: invalid BEGIN value",
+			wantErr: `read contacts file "contacts.vcf": decode vcard: vcard: invalid BEGIN value`,
 		},
 		{
 			msg: "shared email address",
 			setupFs: func(fs afero.Fs) {
-				afero.WriteFile(fs, "contacts.vcf", []byte(
-					`BEGIN:VCARD
+				afero.WriteFile(fs, "contacts.vcf", []byte(sharedEmailVCF), 0644)
+			},
+			wantMap: map[string]*vcard.Card{
+				"+3815555555":            noleCard,
+				"info@novakdjokovic.com": combinedCard,
+			},
+			wantCollisions: []ContactCollision{
+				{Handle: "info@novakdjokovic.com", Cards: []*vcard.Card{noleCard, jelenaCard}, Resolved: combinedCard},
+			},
+		},
+		{
+			msg:    "shared email address, first wins",
+			policy: CollisionFirst,
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "contacts.vcf", []byte(sharedEmailVCF), 0644)
+			},
+			wantMap: map[string]*vcard.Card{
+				"+3815555555":            noleCard,
+				"info@novakdjokovic.com": noleCard,
+			},
+			wantCollisions: []ContactCollision{
+				{Handle: "info@novakdjokovic.com", Cards: []*vcard.Card{noleCard, jelenaCard}, Resolved: noleCard},
+			},
+		},
+		{
+			msg:    "shared email address, last wins",
+			policy: CollisionLast,
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "contacts.vcf", []byte(sharedEmailVCF), 0644)
+			},
+			wantMap: map[string]*vcard.Card{
+				"+3815555555":            noleCard,
+				"info@novakdjokovic.com": jelenaCard,
+			},
+			wantCollisions: []ContactCollision{
+				{Handle: "info@novakdjokovic.com", Cards: []*vcard.Card{noleCard, jelenaCard}, Resolved: jelenaCard},
+			},
+		},
+		{
+			msg:    "shared email address, most fields wins",
+			policy: CollisionMostFields,
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "contacts.vcf", []byte(`BEGIN:VCARD
+FN:Jelena Djokovic
+N:Djokovic;Jelena;;;
+EMAIL;TYPE=INTERNET:info@novakdjokovic.com
+END:VCARD
+BEGIN:VCARD
 VERSION:3.0
 FN:Novak Djokovic
 N:Djokovic;Novak;;;
@@ -258,18 +459,121 @@ TEL;TYPE=CELL:+3815555555
 EMAIL;TYPE=INTERNET:info@novakdjokovic.com
 CATEGORIES:myContacts
 END:VCARD
+`), 0644)
+			},
+			wantMap: map[string]*vcard.Card{
+				"+3815555555":            noleCard,
+				"info@novakdjokovic.com": noleCard,
+			},
+			wantCollisions: []ContactCollision{
+				{Handle: "info@novakdjokovic.com", Cards: []*vcard.Card{jelenaCard, noleCard}, Resolved: noleCard},
+			},
+		},
+		{
+			msg:    "shared email address without names",
+			policy: CollisionFirst,
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "contacts.vcf", []byte(`BEGIN:VCARD
+VERSION:3.0
+TEL:+3815555555
+EMAIL:info@novakdjokovic.com
+END:VCARD
 BEGIN:VCARD
-FN:Jelena Djokovic
-N:Djokovic;Jelena;;;
-EMAIL;TYPE=INTERNET:info@novakdjokovic.com
+VERSION:3.0
+TEL:+3815555556
+EMAIL:info@novakdjokovic.com
 END:VCARD
 `), 0644)
 			},
 			wantMap: map[string]*vcard.Card{
-				"+3815555555":            noleCard,
-				"info@novakdjokovic.com": combinedCard,
+				"+3815555555":            unnamedCard1,
+				"+3815555556":            unnamedCard2,
+				"info@novakdjokovic.com": unnamedCard1,
+			},
+			wantCollisions: []ContactCollision{
+				{Handle: "info@novakdjokovic.com", Cards: []*vcard.Card{unnamedCard1, unnamedCard2}, Resolved: unnamedCard1},
 			},
 		},
+		{
+			msg:    "shared email address with the same name",
+			policy: CollisionFirst,
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "contacts.vcf", []byte(`BEGIN:VCARD
+VERSION:3.0
+FN:Novak Djokovic
+TEL:+3815555555
+EMAIL:info@novakdjokovic.com
+END:VCARD
+BEGIN:VCARD
+VERSION:3.0
+FN:Novak Djokovic
+TEL:+3815555556
+EMAIL:info@novakdjokovic.com
+END:VCARD
+`), 0644)
+			},
+			wantMap: map[string]*vcard.Card{
+				"+3815555555":            sameNameCard1,
+				"+3815555556":            sameNameCard2,
+				"info@novakdjokovic.com": sameNameCard1,
+			},
+			wantCollisions: []ContactCollision{
+				{Handle: "info@novakdjokovic.com", Cards: []*vcard.Card{sameNameCard1, sameNameCard2}, Resolved: sameNameCard1},
+			},
+		},
+		{
+			msg:   "multiple files merged by UID and by shared phone number",
+			paths: []string{"personal.vcf", "work.vcf"},
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "personal.vcf", []byte(personalVCF), 0644)
+				afero.WriteFile(fs, "work.vcf", []byte(workVCF), 0644)
+			},
+			wantMap: map[string]*vcard.Card{
+				"+14155555555":           mergedTagCard,
+				"david@acme.com":         mergedTagCard,
+				"+3815555555":            mergedNoleCard,
+				"info@novakdjokovic.com": mergedNoleCard,
+			},
+		},
+		{
+			msg:   "multiple files sharing a phone number under different names",
+			paths: []string{"personal.vcf", "family.vcf"},
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "personal.vcf", []byte(personalVCF), 0644)
+				afero.WriteFile(fs, "family.vcf", []byte(familyVCF), 0644)
+			},
+			wantMap: map[string]*vcard.Card{
+				"+14155555555": {
+					"VERSION":  []*vcard.Field{{Value: "3.0"}},
+					"FN":       []*vcard.Field{{Value: "David Tagatac"}},
+					"NICKNAME": []*vcard.Field{{Value: "Tag"}},
+					"TEL":      []*vcard.Field{{Value: "(415) 555-5555"}},
+				},
+				"+3815555555": landlineCombinedCard,
+			},
+			wantCollisions: []ContactCollision{
+				{Handle: "+3815555555", Cards: []*vcard.Card{landlineNoleCard, landlineJelenaCard}, Resolved: landlineCombinedCard},
+			},
+		},
+		{
+			msg:   "missing second file",
+			paths: []string{"contacts.vcf", "work.vcf"},
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "contacts.vcf", []byte(personalVCF), 0644)
+			},
+			wantErr: `read contacts file "work.vcf": open work.vcf: file does not exist`,
+		},
+		{
+			msg:    "shared email address, fail",
+			policy: CollisionFail,
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "contacts.vcf", []byte(sharedEmailVCF), 0644)
+			},
+			wantCollisions: []ContactCollision{
+				{Handle: "info@novakdjokovic.com", Cards: []*vcard.Card{noleCard, jelenaCard}},
+			},
+			wantErr: "1 phone numbers or email addresses are shared by multiple contacts - FIX: correct your contacts or choose a different --collision-policy",
+		},
 	}
 
 	for _, tt := range tests {
@@ -278,8 +582,13 @@ END:VCARD
 			if tt.setupFs != nil {
 				tt.setupFs(fs)
 			}
+			paths := tt.paths
+			if paths == nil {
+				paths = []string{"contacts.vcf"}
+			}
 			s := NewOS(fs, nil, "")
-			contactMap, err := s.GetContactMap("contacts.vcf")
+			contactMap, collisions, err := s.GetContactMap(paths, ContactOptions{DefaultRegion: "US", CollisionPolicy: tt.policy})
+			assert.DeepEqual(t, tt.wantCollisions, collisions)
 			if tt.wantErr != "" {
 				assert.Error(t, err, tt.wantErr)
 				return
@@ -290,31 +599,6 @@ END:VCARD
 	}
 }
 
-func TestSanitizePhone(t *testing.T) {
-	tests := []struct {
-		msg   string
-		dirty string
-		clean string
-	}{
-		{
-			msg:   "already clean",
-			dirty: "+14155555555",
-			clean: "+14155555555",
-		},
-		{
-			msg:   "dirty",
-			dirty: "+1 (415) 555-5555",
-			clean: "+14155555555",
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.msg, func(t *testing.T) {
-			assert.Equal(t, sanitizePhone(tt.dirty), tt.clean)
-		})
-	}
-}
-
 func TestReadFile(t *testing.T) {
 	t.Run("successful read", func(t *testing.T) {
 		fs := afero.NewMemMapFs()
@@ -15,26 +15,62 @@ import (
 	"time"
 
 	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/chatdb"
 )
 
-//go:embed templates/* all:testdata/*
+// The test fixtures read through _embedFS are listed one by one, so that the
+// others, e.g. AddressBook databases, are not compiled into bagoup.
+//
+//go:embed templates/*
+//go:embed testdata/outfile_html_invalid.tmpl testdata/signallogo.pluginPayloadAttachment testdata/tennisballs.jpeg testdata/text.txt
 var _embedFS embed.FS
 
 //go:generate mockgen -destination=mock_opsys/mock_outfile.go github.com/tagatac/bagoup/v2/opsys OutFile
 
-// Outfile represents single messages export file, either text or PDF.
+// Output formats for the --format flag. PDF output is chosen with the --pdf
+// flag instead.
+const (
+	FormatTxt    = "txt"
+	FormatJSON   = "json"
+	FormatJSONL  = "jsonl"
+	FormatCSV    = "csv"
+	FormatHTML   = "html"
+	FormatMD     = "md"
+	FormatEPUB   = "epub"
+	FormatMBox   = "mbox"
+	FormatSQLite = "sqlite"
+)
+
+// OutputFormats lists the valid output formats.
+var OutputFormats = []string{FormatTxt, FormatJSON, FormatJSONL, FormatCSV, FormatHTML, FormatMD, FormatEPUB, FormatMBox, FormatSQLite}
+
+// Outfile represents single messages export file, e.g. text, PDF, or JSON.
 type OutFile interface {
 	// Name returns the filepath of the Outfile.
 	Name() string
 	// WriteMessage adds the given message to the Outfile.
-	WriteMessage(msg string) error
-	// WriteAttachment embeds the given attachment in the Outfile, or adds a
-	// reference to it if embedding is not possible (e.g. if the Outfile is
-	// plain text, or the attachment is a movie). The return value lets the
-	// caller know whether the file was embedded or not.
-	WriteAttachment(attPath string) (bool, error)
-	// ReferenceAttachment adds a reference to the given filename in the Outfile.
-	ReferenceAttachment(filename string) error
+	WriteMessage(msg chatdb.Message) error
+	// WriteAttachment embeds the attachment at att.Filepath in the Outfile,
+	// or adds a reference to it if embedding is not possible (e.g. if the
+	// Outfile is plain text, or the attachment is a movie). The return value
+	// lets the caller know whether the file was embedded or not.
+	WriteAttachment(att chatdb.Attachment) (bool, error)
+	// ReferenceAttachment adds a reference to the given attachment, by its
+	// transfer name, in the Outfile, e.g. if the attachment file is missing.
+	ReferenceAttachment(att chatdb.Attachment) error
+	// WriteTranscription adds the transcription of an audio message to the
+	// Outfile, quoted beneath the audio attachment.
+	WriteTranscription(transcription string) error
+	// SetAvatar sets the image shown in the header of the Outfile, e.g. the
+	// photo of the contact. It is ignored for plain text.
+	SetAvatar(avatarPath string)
+	// WriteAvatar adds a small image beside the next message in the Outfile,
+	// e.g. the photo of its sender in a group chat. It is ignored for plain
+	// text.
+	WriteAvatar(avatarPath string) error
+	// WriteSeparator adds a break between runs of messages which are not
+	// adjacent in the chat, e.g. between search matches and their context.
+	WriteSeparator() error
 	// Stage prepares the OutFile for flushing to disk, and returns the number
 	// of images embedded in the OutFile.
 	Stage() (int, error)
@@ -42,6 +78,16 @@ type OutFile interface {
 	Flush() error
 }
 
+// messageText returns the text of the message without the object replacement
+// characters (U+FFFC) standing in for attachments, with voice memos labeled.
+func messageText(msg chatdb.Message) string {
+	text := strings.TrimSpace(strings.ReplaceAll(msg.Text, "\uFFFC", ""))
+	if msg.IsAudio {
+		text = strings.TrimSpace(chatdb.VoiceMemoLabel + " " + text)
+	}
+	return text
+}
+
 type txtFile struct {
 	afero.File
 }
@@ -50,17 +96,39 @@ func (opSys) NewTxtOutFile(chatFile afero.File) OutFile {
 	return txtFile{File: chatFile}
 }
 
-func (f txtFile) WriteMessage(msg string) error {
-	_, err := f.File.WriteString(msg)
+func (f txtFile) WriteMessage(msg chatdb.Message) error {
+	return f.writeString(msg.String())
+}
+
+func (f txtFile) writeString(s string) error {
+	_, err := f.File.WriteString(s)
 	return err
 }
 
-func (f txtFile) WriteAttachment(attPath string) (bool, error) {
-	return false, f.ReferenceAttachment(filepath.Base(attPath))
+func (f txtFile) WriteAttachment(att chatdb.Attachment) (bool, error) {
+	return false, f.referenceFile(filepath.Base(att.Filepath))
+}
+
+func (f txtFile) ReferenceAttachment(att chatdb.Attachment) error {
+	return f.referenceFile(att.TransferName)
+}
+
+func (f txtFile) referenceFile(filename string) error {
+	return f.writeString(fmt.Sprintf("<attached: %s>\n", filename))
+}
+
+func (f txtFile) WriteTranscription(transcription string) error {
+	return f.writeString(fmt.Sprintf("    🎤 Transcript: \"%s\"\n", transcription))
+}
+
+func (f txtFile) SetAvatar(avatarPath string) {}
+
+func (f txtFile) WriteAvatar(avatarPath string) error {
+	return nil
 }
 
-func (f txtFile) ReferenceAttachment(filename string) error {
-	return f.WriteMessage(fmt.Sprintf("<attached: %s>\n", filename))
+func (f txtFile) WriteSeparator() error {
+	return f.writeString("--\n")
 }
 
 func (f txtFile) Stage() (int, error) {
@@ -100,6 +168,7 @@ type (
 
 	htmlFileData struct {
 		Title     string
+		Avatar    string
 		Generator string
 		Created   string
 		Lines     []htmlFileLine
@@ -127,8 +196,8 @@ func newPDFFile(chatFile afero.File, includePPA bool, templatePath, entityName,
 	}
 }
 
-func (f *pdfFile) WriteMessage(msg string) error {
-	msg = strings.ReplaceAll(html.EscapeString(msg), "\n", "<br/>")
+func (f *pdfFile) WriteMessage(message chatdb.Message) error {
+	msg := strings.ReplaceAll(html.EscapeString(message.String()), "\n", "<br/>")
 	// Remove object replacement characters (U+FFFC) from the message. These
 	// characters are used by the chat database to represent attachments, but
 	// they are not valid in HTML. https://en.wiktionary.org/wiki/%EF%BF%BC
@@ -137,7 +206,8 @@ func (f *pdfFile) WriteMessage(msg string) error {
 	return nil
 }
 
-func (f *pdfFile) WriteAttachment(attPath string) (bool, error) {
+func (f *pdfFile) WriteAttachment(attachment chatdb.Attachment) (bool, error) {
+	attPath := attachment.Filepath
 	embedded := false
 	var att template.HTML
 	ext := strings.ToLower(filepath.Ext(attPath))
@@ -149,7 +219,7 @@ func (f *pdfFile) WriteAttachment(attPath string) (bool, error) {
 		}
 	}
 	if !embedded {
-		return false, f.ReferenceAttachment(filepath.Base(attPath))
+		return false, f.referenceFile(filepath.Base(attPath))
 	}
 	f.contents.Lines = append(f.contents.Lines, htmlFileLine{Element: att})
 	return true, nil
@@ -166,19 +236,41 @@ func urlEscapeFilePath(path string) string {
 	return strings.Join(parts, string(filepath.Separator))
 }
 
-func (f *pdfFile) ReferenceAttachment(filename string) error {
+func (f *pdfFile) ReferenceAttachment(att chatdb.Attachment) error {
+	return f.referenceFile(att.TransferName)
+}
+
+func (f *pdfFile) referenceFile(filename string) error {
 	att := template.HTML(fmt.Sprintf("<em>&lt;attached: %s&gt;</em><br/>", filename))
 	f.contents.Lines = append(f.contents.Lines, htmlFileLine{Element: att})
 	return nil
 }
 
+func (f *pdfFile) WriteTranscription(transcription string) error {
+	transcription = strings.ReplaceAll(html.EscapeString(transcription), "\n", "<br/>")
+	quote := template.HTML(fmt.Sprintf("<blockquote>🎤 Transcript: &ldquo;%s&rdquo;</blockquote>", transcription))
+	f.contents.Lines = append(f.contents.Lines, htmlFileLine{Element: quote})
+	return nil
+}
+
+func (f *pdfFile) SetAvatar(avatarPath string) {
+	f.contents.Avatar = urlEscapeFilePath(avatarPath)
+}
+
+func (f *pdfFile) WriteAvatar(avatarPath string) error {
+	avatar := template.HTML(fmt.Sprintf(`<img class="avatar" src=%q alt=""/>`, urlEscapeFilePath(avatarPath)))
+	f.contents.Lines = append(f.contents.Lines, htmlFileLine{Element: avatar})
+	return nil
+}
+
+func (f *pdfFile) WriteSeparator() error {
+	f.contents.Lines = append(f.contents.Lines, htmlFileLine{Element: template.HTML("<hr/>")})
+	return nil
+}
+
 func (f *pdfFile) Stage() (int, error) {
-	tmpl, err := template.ParseFS(_embedFS, f.templatePath)
-	if err != nil {
-		return 0, fmt.Errorf("parse HTML template: %w", err)
-	}
-	if err := tmpl.Execute(&f.buf, f.contents); err != nil {
-		return 0, fmt.Errorf("execute HTML template: %w", err)
+	if err := executeTemplate(&f.buf, f.templatePath, f.contents); err != nil {
+		return 0, err
 	}
 	return strings.Count(f.buf.String(), "<img"), nil
 }
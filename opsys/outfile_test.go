@@ -4,9 +4,12 @@
 package opsys
 
 import (
+	"html/template"
 	"testing"
+	"time"
 
 	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/chatdb"
 	"gotest.tools/v3/assert"
 )
 
@@ -31,17 +34,33 @@ func TestTxtFile(t *testing.T) {
 	assert.Equal(t, rwOF.Name(), "testfile.txt")
 
 	// Write message
-	assert.NilError(t, rwOF.WriteMessage("test message\n"))
-	assert.Error(t, roOF.WriteMessage("test message\n"), "write testfile.txt: file handle is read only")
+	assert.NilError(t, rwOF.WriteMessage(chatdb.Message{Date: time.Date(2020, 3, 1, 15, 34, 5, 0, time.UTC), Sender: "friend", Text: "\uFFFC", IsAudio: true}))
+	assert.Error(t, roOF.WriteMessage(chatdb.Message{Text: "test message"}), "write testfile.txt: file handle is read only")
 
 	// Write attachment
-	embedded, err := rwOF.WriteAttachment("tennisballs.jpeg")
+	embedded, err := rwOF.WriteAttachment(chatdb.Attachment{Filepath: "attachments/tennisballs.jpeg"})
 	assert.NilError(t, err)
 	assert.Equal(t, embedded, false)
-	embedded, err = roOF.WriteAttachment("tennisballs.jpeg")
+	embedded, err = roOF.WriteAttachment(chatdb.Attachment{Filepath: "attachments/tennisballs.jpeg"})
 	assert.Error(t, err, "write testfile.txt: file handle is read only")
 	assert.Equal(t, embedded, false)
 
+	// Reference attachment
+	assert.NilError(t, rwOF.ReferenceAttachment(chatdb.Attachment{Filename: "missing.png", TransferName: "IMG_0001.png"}))
+	assert.Error(t, roOF.ReferenceAttachment(chatdb.Attachment{TransferName: "IMG_0001.png"}), "write testfile.txt: file handle is read only")
+
+	// Write transcription
+	assert.NilError(t, rwOF.WriteTranscription("test transcription"))
+	assert.Error(t, roOF.WriteTranscription("test transcription"), "write testfile.txt: file handle is read only")
+
+	// Write separator
+	assert.NilError(t, rwOF.WriteSeparator())
+	assert.Error(t, roOF.WriteSeparator(), "write testfile.txt: file handle is read only")
+
+	// Set and write avatars (no-op)
+	rwOF.SetAvatar("avatar.jpg")
+	assert.NilError(t, rwOF.WriteAvatar("avatar.jpg"))
+
 	// Stage (no-op) and close the text file
 	imgCount, err := rwOF.Stage()
 	assert.NilError(t, err)
@@ -52,5 +71,26 @@ func TestTxtFile(t *testing.T) {
 	// Check file contents
 	contents, err := afero.ReadFile(rwFS, "testfile.txt")
 	assert.NilError(t, err)
-	assert.Equal(t, string(contents), "test message\n<attached: tennisballs.jpeg>\n")
+	assert.Equal(t, string(contents), "[2020-03-01 15:34:05] friend: 🎤 Voice memo \uFFFC\n<attached: tennisballs.jpeg>\n<attached: IMG_0001.png>\n    🎤 Transcript: \"test transcription\"\n--\n")
+}
+
+func TestPDFFileWriteTranscription(t *testing.T) {
+	f := newPDFFile(nil, false, "", "Test Entity", "test version")
+	assert.NilError(t, f.WriteTranscription("I'll be there\nin <5> minutes"))
+	assert.DeepEqual(t, f.contents.Lines, []htmlFileLine{
+		{Element: template.HTML("<blockquote>🎤 Transcript: &ldquo;I&#39;ll be there<br/>in &lt;5&gt; minutes&rdquo;</blockquote>")},
+	})
+}
+
+func TestPDFFileWriteSeparator(t *testing.T) {
+	f := newPDFFile(nil, false, "", "Test Entity", "test version")
+	date := time.Date(2020, 3, 1, 15, 34, 5, 0, time.UTC)
+	assert.NilError(t, f.WriteMessage(chatdb.Message{Date: date, Sender: "friend", Text: "before"}))
+	assert.NilError(t, f.WriteSeparator())
+	assert.NilError(t, f.WriteMessage(chatdb.Message{Date: date, Sender: "friend", Text: "after"}))
+	assert.DeepEqual(t, f.contents.Lines, []htmlFileLine{
+		{Element: template.HTML("[2020-03-01 15:34:05] friend: before<br/>")},
+		{Element: template.HTML("<hr/>")},
+		{Element: template.HTML("[2020-03-01 15:34:05] friend: after<br/>")},
+	})
 }
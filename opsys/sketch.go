@@ -0,0 +1,63 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package opsys
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+
+	"github.com/tagatac/bagoup/v2/chatdb"
+)
+
+const _sketchDefaultColor = "#000000"
+
+func (s *opSys) RenderSketch(sketch chatdb.Sketch, filename string) (string, error) {
+	tempDir, err := s.GetTempDir()
+	if err != nil {
+		return "", fmt.Errorf("get temporary directory: %w", err)
+	}
+	svgPath := filepath.Join(tempDir, filename)
+	f, err := s.Fs.Create(svgPath)
+	if err != nil {
+		return "", fmt.Errorf("create file %q: %w", svgPath, err)
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	writeSketchSVG(w, sketch)
+	if err := w.Flush(); err != nil {
+		return "", fmt.Errorf("write sketch to file %q: %w", svgPath, err)
+	}
+	return svgPath, nil
+}
+
+// writeSketchSVG draws each segment of each stroke as a separate line, so that
+// the varying pen width of handwriting is preserved. Single-point strokes are
+// drawn as dots.
+func writeSketchSVG(w *bufio.Writer, sketch chatdb.Sketch) {
+	fmt.Fprintf(w,
+		`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n",
+		sketch.Width, sketch.Height, sketch.Width, sketch.Height,
+	)
+	for _, stroke := range sketch.Strokes {
+		color := stroke.Color
+		if color == "" {
+			color = _sketchDefaultColor
+		}
+		fmt.Fprintf(w, `<g stroke="%s" fill="%s" stroke-linecap="round">`+"\n", color, color)
+		if len(stroke.Points) == 1 {
+			p := stroke.Points[0]
+			fmt.Fprintf(w, `<circle cx="%d" cy="%d" r="%g"/>`+"\n", p.X, p.Y, float64(p.Width)/2)
+		}
+		for i := 1; i < len(stroke.Points); i++ {
+			p0, p1 := stroke.Points[i-1], stroke.Points[i]
+			fmt.Fprintf(w,
+				`<line x1="%d" y1="%d" x2="%d" y2="%d" stroke-width="%g"/>`+"\n",
+				p0.X, p0.Y, p1.X, p1.Y, float64(p0.Width+p1.Width)/2,
+			)
+		}
+		fmt.Fprintln(w, "</g>")
+	}
+	fmt.Fprintln(w, "</svg>")
+}
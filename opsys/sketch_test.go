@@ -0,0 +1,77 @@
+package opsys
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/chatdb"
+	"gotest.tools/v3/assert"
+)
+
+func TestRenderSketch(t *testing.T) {
+	sketch := chatdb.Sketch{
+		Width:  200,
+		Height: 100,
+		Strokes: []chatdb.Stroke{
+			{Points: []chatdb.Point{{X: 10, Y: 20, Width: 3}, {X: 30, Y: 40, Width: 5}, {X: 50, Y: 40, Width: 5}}},
+			{Color: "#ff0080", Points: []chatdb.Point{{X: 50, Y: 60, Width: 4}}},
+		},
+	}
+
+	tests := []struct {
+		msg      string
+		roFS     bool
+		tempDir  string
+		wantPath string
+		wantSVG  string
+		wantErr  string
+	}{
+		{
+			msg:      "success",
+			tempDir:  "/tmp/bagoup12345",
+			wantPath: "/tmp/bagoup12345/sketch-42.svg",
+			wantSVG: `<svg xmlns="http://www.w3.org/2000/svg" width="200" height="100" viewBox="0 0 200 100">
+<g stroke="#000000" fill="#000000" stroke-linecap="round">
+<line x1="10" y1="20" x2="30" y2="40" stroke-width="4"/>
+<line x1="30" y1="40" x2="50" y2="40" stroke-width="5"/>
+</g>
+<g stroke="#ff0080" fill="#ff0080" stroke-linecap="round">
+<circle cx="50" cy="60" r="2"/>
+</g>
+</svg>
+`,
+		},
+		{
+			msg:     "temp dir creation fails",
+			roFS:    true,
+			wantErr: "get temporary directory: create temporary directory",
+		},
+		{
+			msg:     "file creation fails",
+			roFS:    true,
+			tempDir: "/tmp/bagoup12345",
+			wantErr: `create file "/tmp/bagoup12345/sketch-42.svg": operation not permitted`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			if tt.roFS {
+				fs = afero.NewReadOnlyFs(fs)
+			}
+			s := &opSys{Fs: fs, tempDir: tt.tempDir}
+
+			svgPath, err := s.RenderSketch(sketch, "sketch-42.svg")
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, svgPath, tt.wantPath)
+			svg, err := afero.ReadFile(fs, svgPath)
+			assert.NilError(t, err)
+			assert.Equal(t, string(svg), tt.wantSVG)
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/chatdb"
 	"github.com/tagatac/bagoup/v2/exectest"
 	"gotest.tools/v3/assert"
 )
@@ -15,6 +16,7 @@ func TestWeasyPrintFile(t *testing.T) {
 		msg                     string
 		includePPA              bool
 		includeProblematicPaths bool
+		avatar                  string
 		templatePath            string
 		wantHTML                template.HTML
 		wantImgCount            int
@@ -55,11 +57,24 @@ func TestWeasyPrintFile(t *testing.T) {
                 max-width: 7.4in;
                 max-height: 11.1in;
             }
+            img.avatar {
+                width: 1.6em;
+                height: 1.6em;
+                border-radius: 50%;
+                object-fit: cover;
+                vertical-align: middle;
+                margin-right: 0.3em;
+            }
+            header img.avatar {
+                width: 0.6in;
+                height: 0.6in;
+            }
         </style>
 
     </head>
     <body>
-        test message<br/>
+        
+        [2020-03-01 15:34:05] friend: test message<br/>
         <img src="tennisballs.jpeg" alt="tennisballs.jpeg"/><br/>
         <em>&lt;attached: video.mov&gt;</em><br/>
         <em>&lt;attached: signallogo.pluginPayloadAttachment&gt;</em><br/>
@@ -70,6 +85,68 @@ func TestWeasyPrintFile(t *testing.T) {
 			),
 			wantImgCount: 1,
 		},
+		{
+			msg:    "avatars",
+			avatar: "avatars/avatar 1.jpg",
+			wantHTML: template.HTML(
+				`
+
+<!doctype html>
+<html>
+    <head>
+        <title>Messages with Test Entity</title>
+        <meta charset="utf-8">
+        <meta name="generator" content="bagoup test version">
+        <meta name="DCTERMS.created" content="2006-01-02T15:04:05Z07:00">
+
+        <style>
+            @page {
+                margin: 0.35in;
+                margin-top: 0.3in;
+                margin-bottom: 0.3in;
+            }
+            body {
+                font-size: 9.5pt;
+                font-family: "Times New Roman", "Liberation Serif", serif;
+                word-wrap: break-word;
+            }
+            .emoji {
+                font-family: "Apple Color Emoji", "Noto Color Emoji";
+            }
+            img {
+                image-resolution: 120dpi;
+                max-width: 7.4in;
+                max-height: 11.1in;
+            }
+            img.avatar {
+                width: 1.6em;
+                height: 1.6em;
+                border-radius: 50%;
+                object-fit: cover;
+                vertical-align: middle;
+                margin-right: 0.3em;
+            }
+            header img.avatar {
+                width: 0.6in;
+                height: 0.6in;
+            }
+        </style>
+
+    </head>
+    <body>
+        <header><img class="avatar" src="avatars/avatar%201.jpg" alt=""/> <strong>Messages with Test Entity</strong></header>
+        <img class="avatar" src="avatars/avatar%201.jpg" alt=""/>
+        [2020-03-01 15:34:05] friend: test message<br/>
+        <img src="tennisballs.jpeg" alt="tennisballs.jpeg"/><br/>
+        <em>&lt;attached: video.mov&gt;</em><br/>
+        <em>&lt;attached: signallogo.pluginPayloadAttachment&gt;</em><br/>
+        
+    </body>
+</html>
+`,
+			),
+			wantImgCount: 3,
+		},
 		{
 			msg:        "include plugin payload attachments",
 			includePPA: true,
@@ -103,11 +180,24 @@ func TestWeasyPrintFile(t *testing.T) {
                 max-width: 7.4in;
                 max-height: 11.1in;
             }
+            img.avatar {
+                width: 1.6em;
+                height: 1.6em;
+                border-radius: 50%;
+                object-fit: cover;
+                vertical-align: middle;
+                margin-right: 0.3em;
+            }
+            header img.avatar {
+                width: 0.6in;
+                height: 0.6in;
+            }
         </style>
 
     </head>
     <body>
-        test message<br/>
+        
+        [2020-03-01 15:34:05] friend: test message<br/>
         <img src="tennisballs.jpeg" alt="tennisballs.jpeg"/><br/>
         <em>&lt;attached: video.mov&gt;</em><br/>
         <img src="signallogo.pluginPayloadAttachment" alt="signallogo.pluginPayloadAttachment"/><br/>
@@ -151,11 +241,24 @@ func TestWeasyPrintFile(t *testing.T) {
                 max-width: 7.4in;
                 max-height: 11.1in;
             }
+            img.avatar {
+                width: 1.6em;
+                height: 1.6em;
+                border-radius: 50%;
+                object-fit: cover;
+                vertical-align: middle;
+                margin-right: 0.3em;
+            }
+            header img.avatar {
+                width: 0.6in;
+                height: 0.6in;
+            }
         </style>
 
     </head>
     <body>
-        test message<br/>
+        
+        [2020-03-01 15:34:05] friend: test message<br/>
         <img src="problematic-paths/question%3Fmark.jpeg" alt="question?mark.jpeg"/><br/>
         <img src="problematic-paths/narrow%E2%80%AFno-break%E2%80%AFspace.jpeg" alt="narrow\u202fno-break\u202fspace.jpeg"/><br/>
         
@@ -207,11 +310,24 @@ func TestWeasyPrintFile(t *testing.T) {
                 max-width: 7.4in;
                 max-height: 11.1in;
             }
+            img.avatar {
+                width: 1.6em;
+                height: 1.6em;
+                border-radius: 50%;
+                object-fit: cover;
+                vertical-align: middle;
+                margin-right: 0.3em;
+            }
+            header img.avatar {
+                width: 0.6in;
+                height: 0.6in;
+            }
         </style>
 
     </head>
     <body>
-        test message<br/>
+        
+        [2020-03-01 15:34:05] friend: test message<br/>
         <img src="tennisballs.jpeg" alt="tennisballs.jpeg"/><br/>
         <em>&lt;attached: video.mov&gt;</em><br/>
         <em>&lt;attached: signallogo.pluginPayloadAttachment&gt;</em><br/>
@@ -247,25 +363,31 @@ func TestWeasyPrintFile(t *testing.T) {
 			// Get name
 			assert.Equal(t, of.Name(), "testfile.pdf")
 
+			// Set and write avatars
+			if tt.avatar != "" {
+				of.SetAvatar(tt.avatar)
+				assert.NilError(t, of.WriteAvatar(tt.avatar))
+			}
+
 			// Write message
-			assert.NilError(t, of.WriteMessage("test message\uFFFC\n"))
+			assert.NilError(t, of.WriteMessage(chatdb.Message{Date: time.Date(2020, 3, 1, 15, 34, 5, 0, time.UTC), Sender: "friend", Text: "test message\uFFFC"}))
 
 			// Write attachments
 			if tt.includeProblematicPaths {
-				embedded, err := of.WriteAttachment("problematic-paths/question?mark.jpeg")
+				embedded, err := of.WriteAttachment(chatdb.Attachment{Filepath: "problematic-paths/question?mark.jpeg"})
 				assert.NilError(t, err)
 				assert.Equal(t, embedded, true)
-				embedded, err = of.WriteAttachment("problematic-paths/narrow no-break space.jpeg")
+				embedded, err = of.WriteAttachment(chatdb.Attachment{Filepath: "problematic-paths/narrow no-break space.jpeg"})
 				assert.NilError(t, err)
 				assert.Equal(t, embedded, true)
 			} else {
-				embedded, err := of.WriteAttachment("tennisballs.jpeg")
+				embedded, err := of.WriteAttachment(chatdb.Attachment{Filepath: "tennisballs.jpeg"})
 				assert.NilError(t, err)
 				assert.Equal(t, embedded, true)
-				embedded, err = of.WriteAttachment("video.mov")
+				embedded, err = of.WriteAttachment(chatdb.Attachment{Filepath: "video.mov"})
 				assert.NilError(t, err)
 				assert.Equal(t, embedded, false)
-				embedded, err = of.WriteAttachment("signallogo.pluginPayloadAttachment")
+				embedded, err = of.WriteAttachment(chatdb.Attachment{Filepath: "signallogo.pluginPayloadAttachment"})
 				assert.NilError(t, err)
 				assert.Equal(t, embedded, tt.includePPA)
 			}
@@ -4,8 +4,10 @@ import (
 	"errors"
 	"html/template"
 	"testing"
+	"time"
 
 	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/v2/chatdb"
 	"github.com/tagatac/bagoup/v2/opsys/pdfgen/mock_pdfgen"
 	"go.uber.org/mock/gomock"
 	"gotest.tools/v3/assert"
@@ -16,6 +18,7 @@ func TestWkhtmltopdfFile(t *testing.T) {
 		msg                     string
 		includePPA              bool
 		includeProblematicPaths bool
+		avatar                  string
 		templatePath            string
 		setupMock               func(*mock_pdfgen.MockPDFGenerator)
 		wantHTML                template.HTML
@@ -49,6 +52,17 @@ func TestWkhtmltopdfFile(t *testing.T) {
                 max-width: 875px;
                 max-height: 1300px;
             }
+            img.avatar {
+                width: 24px;
+                height: 24px;
+                border-radius: 50%;
+                vertical-align: middle;
+                margin-right: 4px;
+            }
+            header img.avatar {
+                width: 64px;
+                height: 64px;
+            }
         </style>
 
         
@@ -65,7 +79,8 @@ func TestWkhtmltopdfFile(t *testing.T) {
 
     </head>
     <body>
-        test message<br/>
+        
+        [2020-03-01 15:34:05] friend: test message<br/>
         <img src="tennisballs.jpeg" alt="tennisballs.jpeg"/><br/>
         <em>&lt;attached: video.mov&gt;</em><br/>
         <em>&lt;attached: signallogo.pluginPayloadAttachment&gt;</em><br/>
@@ -76,6 +91,73 @@ func TestWkhtmltopdfFile(t *testing.T) {
 			),
 			wantImgCount: 1,
 		},
+		{
+			msg:    "avatars",
+			avatar: "avatars/avatar 1.jpg",
+			setupMock: func(pMock *mock_pdfgen.MockPDFGenerator) {
+				gomock.InOrder(
+					pMock.EXPECT().AddPage(gomock.Any()),
+					pMock.EXPECT().Create(),
+				)
+			},
+			wantHTML: template.HTML(
+				`
+
+<!doctype html>
+<html>
+    <head>
+        <title>Messages with Test Entity</title>
+        <meta charset="utf-8">
+
+        <style>
+            body {
+                font-family: Helvetica, "Liberation Sans", sans-serif;
+                word-wrap: break-word;
+            }
+            img {
+                max-width: 875px;
+                max-height: 1300px;
+            }
+            img.avatar {
+                width: 24px;
+                height: 24px;
+                border-radius: 50%;
+                vertical-align: middle;
+                margin-right: 4px;
+            }
+            header img.avatar {
+                width: 64px;
+                height: 64px;
+            }
+        </style>
+
+        
+        <style>
+            img.emoji {
+                height: 1em;
+                width: 1em;
+                margin: 0 .05em 0 .1em;
+                vertical-align: -0.1em;
+            }
+        </style>
+        <script src="https://cdn.jsdelivr.net/npm/@twemoji/api@latest/dist/twemoji.min.js" crossorigin="anonymous"></script>
+        <script>window.onload = function () { twemoji.parse(document.body); }</script>
+
+    </head>
+    <body>
+        <header><img class="avatar" src="avatars/avatar%201.jpg" alt=""/> <strong>Messages with Test Entity</strong></header>
+        <img class="avatar" src="avatars/avatar%201.jpg" alt=""/>
+        [2020-03-01 15:34:05] friend: test message<br/>
+        <img src="tennisballs.jpeg" alt="tennisballs.jpeg"/><br/>
+        <em>&lt;attached: video.mov&gt;</em><br/>
+        <em>&lt;attached: signallogo.pluginPayloadAttachment&gt;</em><br/>
+        
+    </body>
+</html>
+`,
+			),
+			wantImgCount: 3,
+		},
 		{
 			msg:        "include plugin payload attachments",
 			includePPA: true,
@@ -103,6 +185,17 @@ func TestWkhtmltopdfFile(t *testing.T) {
                 max-width: 875px;
                 max-height: 1300px;
             }
+            img.avatar {
+                width: 24px;
+                height: 24px;
+                border-radius: 50%;
+                vertical-align: middle;
+                margin-right: 4px;
+            }
+            header img.avatar {
+                width: 64px;
+                height: 64px;
+            }
         </style>
 
         
@@ -119,7 +212,8 @@ func TestWkhtmltopdfFile(t *testing.T) {
 
     </head>
     <body>
-        test message<br/>
+        
+        [2020-03-01 15:34:05] friend: test message<br/>
         <img src="tennisballs.jpeg" alt="tennisballs.jpeg"/><br/>
         <em>&lt;attached: video.mov&gt;</em><br/>
         <img src="signallogo.pluginPayloadAttachment" alt="signallogo.pluginPayloadAttachment"/><br/>
@@ -157,6 +251,17 @@ func TestWkhtmltopdfFile(t *testing.T) {
                 max-width: 875px;
                 max-height: 1300px;
             }
+            img.avatar {
+                width: 24px;
+                height: 24px;
+                border-radius: 50%;
+                vertical-align: middle;
+                margin-right: 4px;
+            }
+            header img.avatar {
+                width: 64px;
+                height: 64px;
+            }
         </style>
 
         
@@ -173,7 +278,8 @@ func TestWkhtmltopdfFile(t *testing.T) {
 
     </head>
     <body>
-        test message<br/>
+        
+        [2020-03-01 15:34:05] friend: test message<br/>
         <img src="problematic-paths/question%3Fmark.jpeg" alt="question?mark.jpeg"/><br/>
         <img src="problematic-paths/narrow%E2%80%AFno-break%E2%80%AFspace.jpeg" alt="narrow\u202fno-break\u202fspace.jpeg"/><br/>
         
@@ -219,6 +325,17 @@ func TestWkhtmltopdfFile(t *testing.T) {
                 max-width: 875px;
                 max-height: 1300px;
             }
+            img.avatar {
+                width: 24px;
+                height: 24px;
+                border-radius: 50%;
+                vertical-align: middle;
+                margin-right: 4px;
+            }
+            header img.avatar {
+                width: 64px;
+                height: 64px;
+            }
         </style>
 
         
@@ -235,7 +352,8 @@ func TestWkhtmltopdfFile(t *testing.T) {
 
     </head>
     <body>
-        test message<br/>
+        
+        [2020-03-01 15:34:05] friend: test message<br/>
         <img src="tennisballs.jpeg" alt="tennisballs.jpeg"/><br/>
         <em>&lt;attached: video.mov&gt;</em><br/>
         <em>&lt;attached: signallogo.pluginPayloadAttachment&gt;</em><br/>
@@ -271,25 +389,31 @@ func TestWkhtmltopdfFile(t *testing.T) {
 			// Get name
 			assert.Equal(t, of.Name(), "testfile.pdf")
 
+			// Set and write avatars
+			if tt.avatar != "" {
+				of.SetAvatar(tt.avatar)
+				assert.NilError(t, of.WriteAvatar(tt.avatar))
+			}
+
 			// Write message
-			assert.NilError(t, of.WriteMessage("test message\uFFFC\n"))
+			assert.NilError(t, of.WriteMessage(chatdb.Message{Date: time.Date(2020, 3, 1, 15, 34, 5, 0, time.UTC), Sender: "friend", Text: "test message\uFFFC"}))
 
 			// Write attachments
 			if tt.includeProblematicPaths {
-				embedded, err := of.WriteAttachment("problematic-paths/question?mark.jpeg")
+				embedded, err := of.WriteAttachment(chatdb.Attachment{Filepath: "problematic-paths/question?mark.jpeg"})
 				assert.NilError(t, err)
 				assert.Equal(t, embedded, true)
-				embedded, err = of.WriteAttachment("problematic-paths/narrow no-break space.jpeg")
+				embedded, err = of.WriteAttachment(chatdb.Attachment{Filepath: "problematic-paths/narrow no-break space.jpeg"})
 				assert.NilError(t, err)
 				assert.Equal(t, embedded, true)
 			} else {
-				embedded, err := of.WriteAttachment("tennisballs.jpeg")
+				embedded, err := of.WriteAttachment(chatdb.Attachment{Filepath: "tennisballs.jpeg"})
 				assert.NilError(t, err)
 				assert.Equal(t, embedded, true)
-				embedded, err = of.WriteAttachment("video.mov")
+				embedded, err = of.WriteAttachment(chatdb.Attachment{Filepath: "video.mov"})
 				assert.NilError(t, err)
 				assert.Equal(t, embedded, false)
-				embedded, err = of.WriteAttachment("signallogo.pluginPayloadAttachment")
+				embedded, err = of.WriteAttachment(chatdb.Attachment{Filepath: "signallogo.pluginPayloadAttachment"})
 				assert.NilError(t, err)
 				assert.Equal(t, embedded, tt.includePPA)
 			}
@@ -0,0 +1,105 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+// Package phonenum normalizes the phone numbers and email addresses found in
+// contact cards and in the Messages database, so that they can be matched to
+// each other regardless of formatting.
+package phonenum
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const (
+	_e164MaxDigits = 15
+	// Numbers with fewer digits than this are treated as short codes (e.g.
+	// verification code senders), which have no international format.
+	_minNationalDigits = 7
+	// Everything from one of these characters onward is an extension (x, ext,
+	// ;ext=, #) or a dialing pause (",", ";", p, w), neither of which appear in
+	// Messages handles.
+	_extensionMarkers = ";,#xepw"
+)
+
+// ValidRegion reports whether the given ISO 3166-1 alpha-2 code (in any case)
+// is supported as a default region. The empty string is valid, meaning no
+// default region.
+func ValidRegion(region string) bool {
+	if region == "" {
+		return true
+	}
+	_, ok := _regions[strings.ToUpper(region)]
+	return ok
+}
+
+// Normalize returns the canonical form of a phone number or email address.
+// Email addresses are lower-cased. Phone numbers are converted to E.164 (e.g.
+// +15551234567), using the dialing rules of defaultRegion for numbers written
+// without a country code. If no default region is given, such numbers are
+// reduced to their digits. Anything else (e.g. a group chat identifier) is
+// returned trimmed but otherwise unchanged.
+func Normalize(handle, defaultRegion string) string {
+	handle = strings.TrimSpace(handle)
+	if strings.Contains(handle, "@") {
+		return strings.ToLower(strings.TrimPrefix(handle, "mailto:"))
+	}
+	if number, err := ToE164(handle, defaultRegion); err == nil {
+		return number
+	}
+	return handle
+}
+
+// ToE164 converts the given phone number to E.164 format. Numbers without an
+// international prefix are interpreted according to defaultRegion, dropping
+// the national trunk prefix. Short codes, numbers shorter than a full national
+// number of defaultRegion (e.g. a US number without its area code), and numbers
+// without an international prefix when there is no default region, are returned
+// as plain digits.
+func ToE164(number, defaultRegion string) (string, error) {
+	s := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(number)), "tel:")
+	if i := strings.IndexAny(s, _extensionMarkers); i >= 0 {
+		s = s[:i]
+	}
+	// An optional trunk prefix is sometimes written in international numbers,
+	// e.g. +44 (0)20 7946 0000.
+	s = strings.ReplaceAll(s, "(0)", "")
+	var digits strings.Builder
+	international := false
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r >= '０' && r <= '９':
+			digits.WriteRune('0' + r - '０')
+		case (r == '+' || r == '＋') && digits.Len() == 0 && !international:
+			international = true
+		case unicode.IsSpace(r) || strings.ContainsRune(".-()/", r):
+		default:
+			return "", fmt.Errorf("invalid character %q in phone number %q", r, number)
+		}
+	}
+	d := digits.String()
+	if d == "" {
+		return "", fmt.Errorf("no digits in phone number %q", number)
+	}
+	reg, hasRegion := _regions[strings.ToUpper(defaultRegion)]
+	if !international && hasRegion && strings.HasPrefix(d, reg.idd) && len(d)-len(reg.idd) >= _minNationalDigits {
+		international, d = true, d[len(reg.idd):]
+	}
+	if !international {
+		if len(d) < _minNationalDigits || !hasRegion {
+			return d, nil
+		}
+		national := strings.TrimPrefix(d, reg.trunkPrefix)
+		if len(national) < reg.nationalDigits {
+			return d, nil
+		}
+		d = reg.countryCode + national
+	}
+	if len(d) > _e164MaxDigits {
+		return "", fmt.Errorf("phone number %q is too long for E.164", number)
+	}
+	return "+" + d, nil
+}
@@ -0,0 +1,174 @@
+package phonenum
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestValidRegion(t *testing.T) {
+	assert.Assert(t, ValidRegion(""))
+	assert.Assert(t, ValidRegion("US"))
+	assert.Assert(t, ValidRegion("gb"))
+	assert.Assert(t, !ValidRegion("XX"))
+	assert.Assert(t, !ValidRegion("USA"))
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		msg    string
+		handle string
+		region string
+		want   string
+	}{
+		{
+			msg:    "already E.164",
+			handle: "+15551234567",
+			want:   "+15551234567",
+		},
+		{
+			msg:    "international with punctuation",
+			handle: "+1 (555) 123-4567",
+			want:   "+15551234567",
+		},
+		{
+			msg:    "international with dots",
+			handle: "+1 555.123.4567",
+			want:   "+15551234567",
+		},
+		{
+			msg:    "national with default region",
+			handle: "555 123 4567",
+			region: "US",
+			want:   "+15551234567",
+		},
+		{
+			msg:    "national with trunk prefix",
+			handle: "1-555-123-4567",
+			region: "us",
+			want:   "+15551234567",
+		},
+		{
+			msg:    "national without default region",
+			handle: "(555) 123-4567",
+			want:   "5551234567",
+		},
+		{
+			msg:    "UK trunk prefix",
+			handle: "020 7946 0000",
+			region: "GB",
+			want:   "+442079460000",
+		},
+		{
+			msg:    "optional trunk prefix in international number",
+			handle: "+44 (0)20 7946 0000",
+			want:   "+442079460000",
+		},
+		{
+			msg:    "international dialing prefix",
+			handle: "00 33 1 23 45 67 89",
+			region: "FR",
+			want:   "+33123456789",
+		},
+		{
+			msg:    "extension",
+			handle: "+1 555 123 4567 ext. 89",
+			want:   "+15551234567",
+		},
+		{
+			msg:    "x extension",
+			handle: "555-123-4567x89",
+			region: "US",
+			want:   "+15551234567",
+		},
+		{
+			msg:    "tel URI with extension",
+			handle: "tel:+1-555-123-4567;ext=89",
+			want:   "+15551234567",
+		},
+		{
+			msg:    "local number without area code",
+			handle: "555 1234",
+			region: "US",
+			want:   "5551234",
+		},
+		{
+			msg:    "short code",
+			handle: "86753",
+			region: "US",
+			want:   "86753",
+		},
+		{
+			msg:    "too long",
+			handle: "+1234567890123456",
+			want:   "+1234567890123456",
+		},
+		{
+			msg:    "email",
+			handle: " David@Tagatac.NET ",
+			want:   "david@tagatac.net",
+		},
+		{
+			msg:    "group chat identifier",
+			handle: "chat123456789012345678",
+			region: "US",
+			want:   "chat123456789012345678",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			assert.Equal(t, Normalize(tt.handle, tt.region), tt.want)
+		})
+	}
+}
+
+func TestToE164(t *testing.T) {
+	tests := []struct {
+		msg     string
+		number  string
+		region  string
+		want    string
+		wantErr string
+	}{
+		{
+			msg:    "fullwidth digits",
+			number: "＋１ ５５５ １２３ ４５６７",
+			want:   "+15551234567",
+		},
+		{
+			msg:    "Russian trunk prefix",
+			number: "8 916 123-45-67",
+			region: "RU",
+			want:   "+79161234567",
+		},
+		{
+			msg:     "letters",
+			number:  "1-800-FLOWERS",
+			region:  "US",
+			wantErr: `invalid character 'f' in phone number "1-800-FLOWERS"`,
+		},
+		{
+			msg:     "no digits",
+			number:  "+",
+			wantErr: `no digits in phone number "+"`,
+		},
+		{
+			msg:     "too long",
+			number:  "+1234567890123456",
+			wantErr: `phone number "+1234567890123456" is too long for E.164`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			number, err := ToE164(tt.number, tt.region)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, number, tt.want)
+		})
+	}
+}
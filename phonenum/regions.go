@@ -0,0 +1,80 @@
+// Copyright (C) 2026  David Tagatac <david@tagatac.net>
+// See cmd/bagoup/main.go for usage terms.
+
+package phonenum
+
+// region holds the dialing rules needed to convert a nationally formatted
+// number to E.164.
+type region struct {
+	// countryCode is the country calling code, without the leading plus.
+	countryCode string
+	// nationalDigits is the length of the shortest full national number,
+	// without the trunk prefix. Shorter numbers are local numbers written
+	// without an area code, which cannot be converted to E.164.
+	nationalDigits int
+	// trunkPrefix is dialed before the national number for domestic calls, and
+	// dropped in the international format (e.g. the 0 in 020 7946 0000).
+	trunkPrefix string
+	// idd is the international direct dialing prefix used in place of the plus
+	// sign (e.g. 011 in +1 and 00 in most of Europe).
+	idd string
+}
+
+// _regions maps ISO 3166-1 alpha-2 codes to their dialing rules. Regions
+// sharing a country code (e.g. the NANP) are listed separately so that any of
+// them can be given as the default region.
+var _regions = map[string]region{
+	"AE": {countryCode: "971", nationalDigits: 8, trunkPrefix: "0", idd: "00"},
+	"AR": {countryCode: "54", nationalDigits: 10, trunkPrefix: "0", idd: "00"},
+	"AT": {countryCode: "43", nationalDigits: 7, trunkPrefix: "0", idd: "00"},
+	"AU": {countryCode: "61", nationalDigits: 9, trunkPrefix: "0", idd: "0011"},
+	"BE": {countryCode: "32", nationalDigits: 8, trunkPrefix: "0", idd: "00"},
+	"BR": {countryCode: "55", nationalDigits: 10, trunkPrefix: "0", idd: "00"},
+	"CA": {countryCode: "1", nationalDigits: 10, trunkPrefix: "1", idd: "011"},
+	"CH": {countryCode: "41", nationalDigits: 9, trunkPrefix: "0", idd: "00"},
+	"CL": {countryCode: "56", nationalDigits: 9, idd: "00"},
+	"CN": {countryCode: "86", nationalDigits: 9, trunkPrefix: "0", idd: "00"},
+	"CO": {countryCode: "57", nationalDigits: 10, idd: "00"},
+	"CZ": {countryCode: "420", nationalDigits: 9, idd: "00"},
+	"DE": {countryCode: "49", nationalDigits: 7, trunkPrefix: "0", idd: "00"},
+	"DK": {countryCode: "45", nationalDigits: 8, idd: "00"},
+	"EG": {countryCode: "20", nationalDigits: 9, trunkPrefix: "0", idd: "00"},
+	"ES": {countryCode: "34", nationalDigits: 9, idd: "00"},
+	"FI": {countryCode: "358", nationalDigits: 7, trunkPrefix: "0", idd: "00"},
+	"FR": {countryCode: "33", nationalDigits: 9, trunkPrefix: "0", idd: "00"},
+	"GB": {countryCode: "44", nationalDigits: 9, trunkPrefix: "0", idd: "00"},
+	"GR": {countryCode: "30", nationalDigits: 10, idd: "00"},
+	"HK": {countryCode: "852", nationalDigits: 8, idd: "001"},
+	"HU": {countryCode: "36", nationalDigits: 8, trunkPrefix: "06", idd: "00"},
+	"ID": {countryCode: "62", nationalDigits: 8, trunkPrefix: "0", idd: "001"},
+	"IE": {countryCode: "353", nationalDigits: 7, trunkPrefix: "0", idd: "00"},
+	"IL": {countryCode: "972", nationalDigits: 8, trunkPrefix: "0", idd: "00"},
+	"IN": {countryCode: "91", nationalDigits: 10, trunkPrefix: "0", idd: "00"},
+	"IT": {countryCode: "39", nationalDigits: 7, idd: "00"},
+	"JP": {countryCode: "81", nationalDigits: 9, trunkPrefix: "0", idd: "010"},
+	"KR": {countryCode: "82", nationalDigits: 8, trunkPrefix: "0", idd: "001"},
+	"MX": {countryCode: "52", nationalDigits: 10, idd: "00"},
+	"MY": {countryCode: "60", nationalDigits: 8, trunkPrefix: "0", idd: "00"},
+	"NG": {countryCode: "234", nationalDigits: 8, trunkPrefix: "0", idd: "009"},
+	"NL": {countryCode: "31", nationalDigits: 9, trunkPrefix: "0", idd: "00"},
+	"NO": {countryCode: "47", nationalDigits: 8, idd: "00"},
+	"NZ": {countryCode: "64", nationalDigits: 8, trunkPrefix: "0", idd: "00"},
+	"PH": {countryCode: "63", nationalDigits: 9, trunkPrefix: "0", idd: "00"},
+	"PK": {countryCode: "92", nationalDigits: 9, trunkPrefix: "0", idd: "00"},
+	"PL": {countryCode: "48", nationalDigits: 9, idd: "00"},
+	"PR": {countryCode: "1", nationalDigits: 10, trunkPrefix: "1", idd: "011"},
+	"PT": {countryCode: "351", nationalDigits: 9, idd: "00"},
+	"RO": {countryCode: "40", nationalDigits: 9, trunkPrefix: "0", idd: "00"},
+	"RS": {countryCode: "381", nationalDigits: 8, trunkPrefix: "0", idd: "00"},
+	"RU": {countryCode: "7", nationalDigits: 10, trunkPrefix: "8", idd: "810"},
+	"SA": {countryCode: "966", nationalDigits: 9, trunkPrefix: "0", idd: "00"},
+	"SE": {countryCode: "46", nationalDigits: 7, trunkPrefix: "0", idd: "00"},
+	"SG": {countryCode: "65", nationalDigits: 8, idd: "000"},
+	"TH": {countryCode: "66", nationalDigits: 8, trunkPrefix: "0", idd: "001"},
+	"TR": {countryCode: "90", nationalDigits: 10, trunkPrefix: "0", idd: "00"},
+	"TW": {countryCode: "886", nationalDigits: 8, trunkPrefix: "0", idd: "002"},
+	"UA": {countryCode: "380", nationalDigits: 9, trunkPrefix: "0", idd: "00"},
+	"US": {countryCode: "1", nationalDigits: 10, trunkPrefix: "1", idd: "011"},
+	"VN": {countryCode: "84", nationalDigits: 9, trunkPrefix: "0", idd: "00"},
+	"ZA": {countryCode: "27", nationalDigits: 9, trunkPrefix: "0", idd: "00"},
+}